@@ -1,171 +1,460 @@
-package main
-
-import (
-	apiv1 "FireFlow/internal/api/v1"
-	"FireFlow/internal/core"
-	"FireFlow/internal/model"
-	"FireFlow/internal/repository"
-	"FireFlow/internal/service"
-	"embed"
-	"html/template"
-	"io/fs"
-	"log"
-	"net/http"
-	"os"
-	"path/filepath"
-
-	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
-	"github.com/spf13/viper"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
-	_ "modernc.org/sqlite"
-)
-
-//go:embed web/templates/*
-var templateFS embed.FS
-
-//go:embed web/static
-var staticFS embed.FS
-
-// 默认配置内容
-const defaultConfigContent = `
-server:
-  port: ":9686"
-
-database:
-  path: "./configs/database.db"  # SQLite数据库文件
-`
-
-// createDefaultConfig 创建默认配置文件
-func createDefaultConfig(configPath string) error {
-	// 确保配置文件目录存在
-	configDir := filepath.Dir(configPath)
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return err
-	}
-
-	// 写入默认配置
-	return os.WriteFile(configPath, []byte(defaultConfigContent), 0644)
-}
-
-// setupWebAssets 设置嵌入的模板和静态文件
-func setupWebAssets(r *gin.Engine) {
-	// 加载嵌入的模板
-	tmpl, err := template.ParseFS(templateFS, "web/templates/*")
-	if err != nil {
-		log.Fatalf("Failed to parse embedded templates: %v", err)
-	}
-	r.SetHTMLTemplate(tmpl)
-
-	// 设置嵌入的静态文件
-	staticSubFS, err := fs.Sub(staticFS, "web/static")
-	if err != nil {
-		log.Fatalf("Failed to create static sub filesystem: %v", err)
-	}
-	r.StaticFS("/static", http.FS(staticSubFS))
-}
-
-func main() {
-	// 加载 .env 文件（如果存在）
-	if err := godotenv.Load(); err != nil {
-		// log.Printf("No .env file found, using system environment variables")
-	} else {
-		log.Printf("Loaded environment variables from .env file")
-	}
-
-	// 设置 Gin 模式
-	if os.Getenv("GIN_MODE") == "" {
-		// 如果没有设置环境变量，默认使用 release 模式
-		gin.SetMode(gin.ReleaseMode)
-	}
-
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./configs")
-
-	// 尝试读取配置文件
-	if err := viper.ReadInConfig(); err != nil {
-		// 如果是找不到配置文件的错误，创建默认配置
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			configPath := "./configs/config.yaml"
-			log.Printf("Config file not found, creating default config at %s", configPath)
-
-			if err := createDefaultConfig(configPath); err != nil {
-				log.Fatalf("Failed to create default config file: %v", err)
-			}
-
-			// 重新尝试读取配置
-			if err := viper.ReadInConfig(); err != nil {
-				log.Fatalf("Error reading newly created config file: %v", err)
-			}
-
-			log.Printf("Default config file created successfully at %s", configPath)
-		} else {
-			// 其他读取错误
-			log.Fatalf("Error reading config file: %v", err)
-		}
-	}
-
-	// 确保数据库目录存在
-	dbPath := viper.GetString("database.path")
-	dbDir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		log.Fatalf("Failed to create database directory: %v", err)
-	}
-
-	// 使用纯 Go SQLite 驱动配置
-	db, err := gorm.Open(sqlite.New(sqlite.Config{
-		DriverName: "sqlite",
-		DSN:        dbPath,
-	}), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-	}
-	// Auto-migrate the schema
-	if err := db.AutoMigrate(
-		&model.FirewallRule{},
-		&model.ConfigItem{},
-		&model.CloudProviderConfig{},
-		&model.CronJobConfig{},
-	); err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
-	}
-
-	// Initialize repositories
-	firewallRepo := repository.NewFirewallRepo(db)
-	configRepo := repository.NewConfigRepository(db)
-
-	// Initialize services
-	configService := service.NewConfigService(configRepo)
-	firewallService := service.NewFirewallService(firewallRepo, configService)
-
-	// 初始化定时任务管理器，但不自动启动任务
-	cronManager := core.NewCronManager()
-	cronManager.SetUpdateFunc(func() {
-		firewallService.UpdateAllRules()
-	})
-	cronManager.Start() // 只启动cron引擎，不添加具体任务
-
-	r := gin.Default()
-
-	// Setup web assets (templates and static files)
-	setupWebAssets(r)
-
-	// Base URL for the frontend
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"title": "动态防火墙规则管理",
-		})
-	})
-
-	// Register API v1 routes
-	apiV1Group := r.Group("/api/v1")
-	apiv1.RegisterRoutes(apiV1Group, firewallService, configService, cronManager)
-
-	port := viper.GetString("server.port")
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
+package main
+
+import (
+	apiv1 "FireFlow/internal/api/v1"
+	"FireFlow/internal/core"
+	"FireFlow/internal/logging"
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/internal/secret"
+	"FireFlow/internal/service"
+	"FireFlow/pkg/cloud"
+	"FireFlow/pkg/probe"
+	"context"
+	"database/sql"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed web/templates/*
+var templateFS embed.FS
+
+//go:embed web/static
+var staticFS embed.FS
+
+// 默认配置内容
+const defaultConfigContent = `
+server:
+  port: ":9686"
+  shutdown_timeout: "30s"  # 收到SIGTERM后等待进行中的云端规则变更完成的最长时间
+
+database:
+  path: "./configs/database.db"  # SQLite数据库文件
+
+security:
+  master_key_file: "./configs/master.key"  # 云服务密钥加密主密钥，也可通过 FIREFLOW_MASTER_KEY 环境变量提供
+
+auth:
+  session_ttl: "168h"  # 登录会话有效期
+
+cloud:
+  call_timeout: "30s"  # 单次云服务API调用（含限流等待）的超时
+  # endpoint: "127.0.0.1:8080"  # 替换所有云服务API的地址（如本地模拟服务），为空时使用默认地址
+  # scheme: "HTTP"              # 请求协议，默认HTTPS
+  # recording:
+  #   mode: "record"            # record: 调用真实API并录制请求和响应；replay: 只回放录制的响应，不访问网络
+  #   file: "./data/cloud-recording.json"
+
+probe:
+  timeout: "5s"  # 规则更新后单次连通性探测的超时
+  attempts: 3    # 探测失败时的最多尝试次数，等待云端规则生效
+
+log:
+  level: "info"   # 日志级别：debug、info、warn、error
+  format: "text"  # 日志格式：text 或 json
+
+metrics:
+  enabled: true  # 是否在 /metrics 暴露Prometheus指标
+  token: ""      # 非空时抓取需携带 Authorization: Bearer <token>
+
+health:
+  max_sync_age: "0s"              # /readyz 允许的最长未成功同步时间，0表示按定时任务周期的3倍计算
+  check_credentials: false        # /readyz 是否检查各云服务配置的凭证有效性
+  credential_check_period: "10m"  # 凭证检查结果缓存时间
+`
+
+// fatal 记录错误日志后退出
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// createDefaultConfig 创建默认配置文件
+func createDefaultConfig(configPath string) error {
+	// 确保配置文件目录存在
+	configDir := filepath.Dir(configPath)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return err
+	}
+
+	// 写入默认配置
+	return os.WriteFile(configPath, []byte(defaultConfigContent), 0644)
+}
+
+// setupWebAssets 设置嵌入的模板和静态文件
+func setupWebAssets(r *gin.Engine) {
+	// 加载嵌入的模板
+	tmpl, err := template.ParseFS(templateFS, "web/templates/*")
+	if err != nil {
+		fatal("failed to parse embedded templates", "error", err)
+	}
+	r.SetHTMLTemplate(tmpl)
+
+	// 设置嵌入的静态文件
+	staticSubFS, err := fs.Sub(staticFS, "web/static")
+	if err != nil {
+		fatal("failed to create static sub filesystem", "error", err)
+	}
+	r.StaticFS("/static", http.FS(staticSubFS))
+}
+
+func main() {
+	// 加载 .env 文件（如果存在）
+	if err := godotenv.Load(); err != nil {
+		// log.Printf("No .env file found, using system environment variables")
+	} else {
+		slog.Info("loaded environment variables from .env file")
+	}
+
+	// 设置 Gin 模式
+	if os.Getenv("GIN_MODE") == "" {
+		// 如果没有设置环境变量，默认使用 release 模式
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath("./configs")
+
+	// 尝试读取配置文件
+	if err := viper.ReadInConfig(); err != nil {
+		// 如果是找不到配置文件的错误，创建默认配置
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			configPath := "./configs/config.yaml"
+			slog.Info("config file not found, creating default config", "path", configPath)
+
+			if err := createDefaultConfig(configPath); err != nil {
+				fatal("failed to create default config file", "path", configPath, "error", err)
+			}
+
+			// 重新尝试读取配置
+			if err := viper.ReadInConfig(); err != nil {
+				fatal("failed to read newly created config file", "path", configPath, "error", err)
+			}
+
+			slog.Info("default config file created", "path", configPath)
+		} else {
+			// 其他读取错误
+			fatal("failed to read config file", "error", err)
+		}
+	}
+
+	// 按配置初始化结构化日志
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "text")
+	logger, err := logging.Setup(os.Stderr, viper.GetString("log.level"), viper.GetString("log.format"))
+	if err != nil {
+		fatal("invalid log config", "error", err)
+	}
+
+	// 确保数据库目录存在
+	dbPath := viper.GetString("database.path")
+	dbDir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		fatal("failed to create database directory", "path", dbDir, "error", err)
+	}
+
+	// 使用纯 Go SQLite 驱动配置
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: "sqlite",
+		DSN:        dbPath,
+	}), &gorm.Config{
+		// 数据库日志同样输出为结构化日志，只记录慢查询和错误（不含记录不存在）
+		Logger: gormlogger.NewSlogLogger(logger, gormlogger.Config{
+			LogLevel:                  gormlogger.Warn,
+			SlowThreshold:             200 * time.Millisecond,
+			IgnoreRecordNotFoundError: true,
+		}),
+	})
+	if err != nil {
+		fatal("failed to connect to database", "path", dbPath, "error", err)
+	}
+	// SQLite 不支持并发写入，规则同步并发执行时通过单连接串行化数据库访问
+	sqlDB, err := db.DB()
+	if err != nil {
+		fatal("failed to get database handle", "error", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	// Auto-migrate the schema
+	if err := db.AutoMigrate(
+		&model.FirewallRule{},
+		&model.FirewallRuleTarget{},
+		&model.ConfigItem{},
+		&model.CloudProviderConfig{},
+		&model.CronJobConfig{},
+		&model.User{},
+		&model.Session{},
+		&model.APIToken{},
+		&model.UserCloudScope{},
+		&model.AuditLog{},
+		&model.NotificationChannel{},
+		&model.SyncRun{},
+		&model.RuleOperation{},
+		&model.InstanceSafeguard{},
+		&model.RuleTemplate{},
+		&model.RuleTemplateItem{},
+		&model.RuleGroup{},
+		&model.RuleGroupTarget{},
+		&model.CloudInstance{},
+	); err != nil {
+		fatal("failed to migrate database", "error", err)
+	}
+
+	// 加载主密钥，用于加密存储云服务密钥
+	viper.SetDefault("security.master_key_file", "./configs/master.key")
+	masterKey, keySource, keyCreated, err := secret.LoadMasterKey(viper.GetString("security.master_key_file"))
+	if err != nil {
+		fatal("failed to load master key", "error", err)
+	}
+	if keyCreated {
+		slog.Warn("generated new master key, back it up: encrypted cloud secrets cannot be recovered without it", "source", keySource)
+	}
+	sealer, err := secret.NewSealer(masterKey)
+	if err != nil {
+		fatal("invalid master key", "source", keySource, "error", err)
+	}
+
+	// 单次云服务API调用的超时，避免云端无响应时同步任务一直阻塞
+	viper.SetDefault("cloud.call_timeout", cloud.DefaultCallTimeout)
+	cloud.SetCallTimeout(viper.GetDuration("cloud.call_timeout"))
+
+	// 云服务API地址和录制/回放，用于连接本地模拟服务或离线测试
+	transport := cloud.Transport{
+		Endpoint: viper.GetString("cloud.endpoint"),
+		Scheme:   viper.GetString("cloud.scheme"),
+	}
+	if mode := viper.GetString("cloud.recording.mode"); mode != "" {
+		recorder, err := cloud.NewRecorder(mode, viper.GetString("cloud.recording.file"), nil)
+		if err != nil {
+			fatal("failed to set up cloud API recording", "error", err)
+		}
+		transport.RoundTripper = recorder
+		slog.Warn("cloud API recording enabled", "mode", mode, "file", viper.GetString("cloud.recording.file"))
+	}
+	cloud.SetDefaultTransport(transport)
+
+	// Initialize repositories
+	firewallRepo := repository.NewFirewallRepo(db)
+	configRepo := repository.NewConfigRepository(db, sealer)
+	notificationRepo := repository.NewNotificationRepository(db, sealer)
+
+	// 子命令：轮换主密钥并重新加密所有云服务密钥
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		stores := []secretStore{
+			{name: "cloud configs", rotate: configRepo.RotateSecrets},
+			{name: "notification channels", rotate: notificationRepo.RotateSecrets},
+		}
+		if err := runRotateKey(keySource, os.Args[2:], stores); err != nil {
+			fatal("failed to rotate master key", "error", err)
+		}
+		return
+	}
+
+	// 加密升级前以明文存储的云服务密钥
+	if count, err := configRepo.EncryptPlaintextSecrets(); err != nil {
+		fatal("failed to encrypt stored cloud secrets", "error", err)
+	} else if count > 0 {
+		slog.Info("encrypted secrets of existing cloud configs", "count", count)
+	}
+	if count, err := notificationRepo.EncryptPlaintextSecrets(); err != nil {
+		fatal("failed to encrypt stored notification channel configs", "error", err)
+	} else if count > 0 {
+		slog.Info("encrypted configs of existing notification channels", "count", count)
+	}
+
+	userRepo := repository.NewUserRepository(db)
+
+	// Initialize services
+	configService := service.NewConfigService(configRepo)
+	firewallService := service.NewFirewallService(firewallRepo, configService)
+	// 一个云服务配置（凭证账号）可管理多个实例，旧配置中的单个实例登记为该配置下的实例
+	cloudInstanceService := service.NewCloudInstanceService(repository.NewCloudInstanceRepository(db), configService)
+	if count, err := cloudInstanceService.RegisterDefaultInstances(); err != nil {
+		fatal("failed to migrate instances of cloud configs", "error", err)
+	} else if count > 0 {
+		slog.Info("migrated instances of existing cloud configs", "count", count)
+	}
+	firewallService.SetCloudInstanceService(cloudInstanceService)
+	authService := service.NewAuthService(userRepo, viper.GetDuration("auth.session_ttl"))
+	userService := service.NewUserService(userRepo, configService)
+	auditService := service.NewAuditService(repository.NewAuditRepository(db), configService)
+	firewallService.SetAuditService(auditService)
+	notificationService := service.NewNotificationService(notificationRepo)
+	firewallService.SetNotificationService(notificationService)
+	eventBus := service.NewEventBus()
+	firewallService.SetEventBus(eventBus)
+	firewallService.SetSyncRunRepository(repository.NewSyncRunRepository(db))
+	firewallService.SetRuleOperationRepository(repository.NewRuleOperationRepository(db))
+	// 规则更新后的连通性探测
+	viper.SetDefault("probe.timeout", probe.DefaultTimeout)
+	viper.SetDefault("probe.attempts", 3)
+	firewallService.SetProber(probe.New(viper.GetDuration("probe.timeout"), viper.GetInt("probe.attempts")))
+	safeguardService := service.NewSafeguardService(repository.NewSafeguardRepository(db))
+	firewallService.SetSafeguardService(safeguardService)
+	ruleGroupService := service.NewRuleGroupService(repository.NewRuleTemplateRepository(db), repository.NewRuleGroupRepository(db), configService, firewallService)
+
+	// 按系统配置 audit_retention_days 每天清理过期审计日志
+	auditService.StartRetention(24 * time.Hour)
+
+	// 首次运行：可通过环境变量直接创建管理员，否则需要在Web界面使用初始化令牌完成设置
+	if needsSetup, err := authService.NeedsSetup(); err != nil {
+		fatal("failed to check users", "error", err)
+	} else if needsSetup {
+		if password := os.Getenv("FIREFLOW_ADMIN_PASSWORD"); password != "" {
+			username := os.Getenv("FIREFLOW_ADMIN_USERNAME")
+			if username == "" {
+				username = "admin"
+			}
+			if _, err := authService.BootstrapAdmin(username, password); err != nil {
+				fatal("failed to create admin user", "username", username, "error", err)
+			}
+			slog.Info("created admin user from environment", "username", username)
+		} else {
+			slog.Warn("no users found, open the web UI and complete setup with the setup token", "setup_token", authService.SetupToken())
+		}
+	} else if err := authService.EnsureAdmin(); err != nil {
+		// 从无角色的版本升级时，已有用户默认为只读，需要保留一个管理员
+		fatal("failed to ensure admin user", "error", err)
+	}
+
+	// 初始化定时任务管理器，但不自动启动任务
+	cronManager := core.NewCronManager()
+	cronManager.SetUpdateFunc(func() {
+		if _, err := firewallService.UpdateAllRules(context.Background(), service.CronActor); err != nil {
+			// 上一次同步尚未结束时跳过本次定时任务
+			slog.Warn("scheduled firewall update skipped", "error", err)
+		}
+	})
+	cronManager.Start() // 只启动cron引擎，不添加具体任务
+
+	// 恢复系统设置中已启用的定时同步，否则重启后定时任务不会运行
+	if enabled, _ := configService.GetConfigBool("cron_enabled"); enabled {
+		if interval, err := configService.GetConfigInt("ip_check_interval"); err == nil && interval > 0 {
+			if err := cronManager.StartFirewallUpdateJob(interval); err != nil {
+				slog.Error("failed to restore firewall update job", "error", err)
+			}
+		}
+	}
+
+	// 上次退出时有未完成的同步（如进程被强制终止），调度一次全量同步修复只完成一半的云端变更
+	if run, err := firewallService.ReconcileInterruptedRuns(); err != nil {
+		slog.Error("failed to reconcile interrupted sync runs", "error", err)
+	} else if run != nil {
+		slog.Warn("scheduled reconciliation for interrupted sync runs", "run_id", run.RunID)
+	}
+
+	healthService := service.NewHealthService(sqlDB, configService, cronManager, firewallService, service.HealthOptions{
+		MaxSyncAge:            viper.GetDuration("health.max_sync_age"),
+		CheckCredentials:      viper.GetBool("health.check_credentials"),
+		CredentialCheckPeriod: viper.GetDuration("health.credential_check_period"),
+	})
+
+	// 使用结构化访问日志替代gin默认日志，并为每个请求分配请求ID
+	r := gin.New()
+	r.Use(apiv1.RequestIDMiddleware(), apiv1.AccessLogMiddleware(), gin.Recovery())
+
+	// Setup web assets (templates and static files)
+	setupWebAssets(r)
+
+	// 登录及首次初始化页面
+	r.GET("/login", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "login.html", gin.H{
+			"title": "登录 - FireFlow",
+		})
+	})
+
+	// Base URL for the frontend
+	r.GET("/", apiv1.PageAuthMiddleware(authService), func(c *gin.Context) {
+		c.HTML(http.StatusOK, "index.html", gin.H{
+			"title": "动态防火墙规则管理",
+		})
+	})
+
+	// 存活及就绪检查，供Docker/Kubernetes探针使用，无需认证
+	healthHandler := apiv1.NewHealthHandler(healthService)
+	r.GET("/healthz", healthHandler.Healthz)
+	r.GET("/readyz", healthHandler.Readyz)
+
+	// Prometheus指标，可通过 FIREFLOW_METRICS_TOKEN 环境变量设置抓取令牌
+	viper.SetDefault("metrics.enabled", true)
+	if viper.GetBool("metrics.enabled") {
+		metricsToken := viper.GetString("metrics.token")
+		if envToken := os.Getenv("FIREFLOW_METRICS_TOKEN"); envToken != "" {
+			metricsToken = envToken
+		}
+		r.GET("/metrics", apiv1.MetricsHandler(metricsToken))
+	}
+
+	// Register API v1 routes
+	apiV1Group := r.Group("/api/v1")
+	apiv1.RegisterRoutes(apiV1Group, firewallService, configService, cronManager, authService, userService, auditService, notificationService, safeguardService, ruleGroupService, cloudInstanceService, eventBus)
+
+	// 请求context派生自baseCtx，关闭时取消以结束SSE等长连接
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	port := viper.GetString("server.port")
+	server := &http.Server{
+		Addr:        port,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "port", port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("failed to start server", "error", err)
+	case <-ctx.Done():
+	}
+	// 再次收到信号时按默认行为立即退出
+	stop()
+
+	viper.SetDefault("server.shutdown_timeout", 30*time.Second)
+	shutdown(viper.GetDuration("server.shutdown_timeout"), server, cancelRequests, cronManager, firewallService, sqlDB)
+}
+
+// shutdown 优雅关闭：停止定时任务，等待进行中的云端规则变更完成（最长timeout），
+// 再关闭HTTP服务和数据库。超时仍未完成的同步会被取消，下次启动时自动补偿同步。
+func shutdown(timeout time.Duration, server *http.Server, cancelRequests context.CancelFunc, cronManager *core.CronManager, firewallService *service.FirewallService, sqlDB *sql.DB) {
+	slog.Info("shutting down", "timeout", timeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cronManager.Stop()
+	if err := firewallService.Shutdown(ctx); err != nil {
+		slog.Warn("sync did not stop before shutdown deadline, it will be reconciled on next start", "error", err)
+	}
+
+	cancelRequests()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shut down http server gracefully", "error", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("failed to close database", "error", err)
+	}
+	slog.Info("server stopped")
+}
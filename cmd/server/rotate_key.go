@@ -0,0 +1,85 @@
+package main
+
+import (
+	"FireFlow/internal/secret"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// secretStore 使用主密钥加密存储敏感字段的仓库
+type secretStore struct {
+	name   string
+	rotate func(newSealer *secret.Sealer) (int, error)
+}
+
+// runRotateKey 生成（或读取）新的主密钥，使用新密钥重新加密所有云服务密钥和通知渠道配置。
+// 当前密钥来自文件时，新密钥写回该文件，旧密钥保留为 .old 备份；来自环境变量时输出新密钥供更新环境变量。
+func runRotateKey(keySource string, args []string, stores []secretStore) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	newKeyFile := flags.String("new-key-file", "", "使用指定文件中的主密钥，不指定则随机生成")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var newKey []byte
+	var err error
+	if *newKeyFile != "" {
+		newKey, err = secret.ReadKeyFile(*newKeyFile)
+	} else {
+		newKey, err = secret.GenerateKey()
+	}
+	if err != nil {
+		return err
+	}
+
+	newSealer, err := secret.NewSealer(newKey)
+	if err != nil {
+		return err
+	}
+
+	fromEnv := strings.HasPrefix(keySource, "env:")
+
+	// 先落盘新密钥，避免重新加密后进程异常导致新密钥丢失
+	pendingPath := keySource + ".new"
+	if !fromEnv {
+		if err := secret.WriteKeyFile(pendingPath, newKey); err != nil {
+			return fmt.Errorf("failed to write new key file: %v", err)
+		}
+	}
+
+	for i, store := range stores {
+		count, err := store.rotate(newSealer)
+		if err != nil {
+			if i == 0 {
+				// 尚未修改任何数据
+				if !fromEnv {
+					os.Remove(pendingPath)
+				}
+				return err
+			}
+			// 部分数据已使用新密钥加密，重新执行时已轮换的数据会被跳过
+			if fromEnv {
+				return fmt.Errorf("failed to re-encrypt %s: %v; rerun rotate-key with the same new key:\n%s", store.name, err, secret.EncodeKey(newKey))
+			}
+			return fmt.Errorf("failed to re-encrypt %s: %v; rerun with -new-key-file %s", store.name, err, pendingPath)
+		}
+		slog.Info("re-encrypted secrets", "store", store.name, "count", count, "key_id", newSealer.KeyID())
+	}
+
+	if fromEnv {
+		fmt.Printf("Update %s to the new master key:\n%s\n", secret.EnvMasterKey, secret.EncodeKey(newKey))
+		return nil
+	}
+
+	if err := os.Rename(keySource, keySource+".old"); err != nil {
+		return fmt.Errorf("secrets re-encrypted but failed to back up old key, new key is at %s: %v", pendingPath, err)
+	}
+	if err := os.Rename(pendingPath, keySource); err != nil {
+		return fmt.Errorf("secrets re-encrypted but failed to install new key, new key is at %s: %v", pendingPath, err)
+	}
+	slog.Info("new master key installed", "path", keySource, "old_key", keySource+".old")
+	return nil
+}
@@ -8,6 +8,7 @@ const providerNames = {
     'Aliyun': '阿里云（暂未支持）',
     'AWS': '亚马逊云（暂未支持）',
     'HuaweiCloud': '华为云（暂未支持）',
+    'Fake': '模拟云服务',
 };
 
 // 获取云服务商中文名称
@@ -129,8 +130,15 @@ async function apiRequest(url, options = {}) {
             ...options
         });
         
+        // 未登录或会话过期时跳转到登录页
+        if (response.status === 401) {
+            window.location.href = '/login';
+            throw new Error('未登录或登录已过期');
+        }
+        
         if (!response.ok) {
-            throw new Error(`HTTP error! status: ${response.status}`);
+            const result = await response.json().catch(() => ({}));
+            throw new Error(result.error || result.message || `HTTP error! status: ${response.status}`);
         }
         
         return await response.json();
@@ -150,9 +158,12 @@ async function fetchRules() {
         tableBody.innerHTML = '';
         
         (rules || []).forEach(rule => {
-            const statusBadge = rule.enabled ? 
+            let statusBadge = rule.enabled ? 
                 '<span class="status-badge status-enabled">启用</span>' : 
                 '<span class="status-badge status-disabled">禁用</span>';
+            if (rule.pinned) {
+                statusBadge += ' <span class="status-badge status-disabled" title="回滚后固定，同步时跳过，手动执行后恢复">已固定</span>';
+            }
             
             const row = `
                 <tr>
@@ -160,6 +171,7 @@ async function fetchRules() {
                         <div class="row-container">
                             <select class="action-select" data-rule-id="${rule.ID}">
                                 <option value="execute">执行</option>
+                                <option value="probe">探测</option>
                                 <option value="edit">编辑</option>
                                 <option value="delete">删除</option>
                             </select>
@@ -168,10 +180,12 @@ async function fetchRules() {
                     </td>
                     <td>${rule.remark || ''}</td>
                     <td>${getProviderDisplayName(rule.provider)}</td>
-                    <td>${rule.instance_id || ''}</td>
+                    <td>${ruleTargets(rule)}</td>
                     <td>${rule.port || ''}</td>
                     <td>${rule.protocol || 'TCP'}</td>
-                    <td>${rule.last_ip || '未设置'}</td>
+                    <td>${ruleAction(rule)}</td>
+                    <td>${rule.source || rule.last_ip || '未设置'}</td>
+                    <td>${probeBadge(rule)}</td>
                     <td>${statusBadge}</td>
                     <td>${rule.UpdatedAt ? new Date(rule.UpdatedAt).toLocaleString() : ''}</td>
                 </tr>
@@ -186,6 +200,44 @@ async function fetchRules() {
     }
 }
 
+// 规则的动作和优先级
+function ruleAction(rule) {
+    const action = rule.action === 'DROP' ? '拒绝' : '允许';
+    return rule.priority ? `${action} <span title="优先级">#${rule.priority}</span>` : action;
+}
+
+// 规则的目标实例：实例ID、其他实例和标签选择器
+function ruleTargets(rule) {
+    const targets = [rule.instance_id, rule.instance_ids].filter(Boolean).join(',');
+    if (!rule.instance_selector) {
+        return escapeHtml(targets);
+    }
+    const selector = `<span class="status-badge" title="按标签选择实例">${escapeHtml(rule.instance_selector)}</span>`;
+    return targets ? `${escapeHtml(targets)} ${selector}` : selector;
+}
+
+// 转义HTML特殊字符
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML.replace(/"/g, '&quot;');
+}
+
+// 规则最近一次连通性探测结果
+function probeBadge(rule) {
+    if (!rule.probe_type) {
+        return '-';
+    }
+    if (!rule.probe_status) {
+        return '未探测';
+    }
+    const checkedAt = rule.probed_at ? new Date(rule.probed_at).toLocaleString() : '';
+    if (rule.probe_status === 'healthy') {
+        return `<span class="status-badge status-enabled" title="${checkedAt}">可访问 ${rule.probe_latency_ms}ms</span>`;
+    }
+    return `<span class="status-badge status-disabled" title="${escapeHtml(rule.probe_error || '')} ${checkedAt}">不可访问</span>`;
+}
+
 // 确认执行规则操作
 function confirmRuleAction(ruleId) {
     const selectElement = document.querySelector(`select[data-rule-id="${ruleId}"]`);
@@ -196,6 +248,9 @@ function confirmRuleAction(ruleId) {
         case 'execute':
             executeRule(ruleId);
             break;
+        case 'probe':
+            probeRule(ruleId);
+            break;
         case 'edit':
             editRule(ruleId);
             break;
@@ -239,8 +294,21 @@ async function addRule(event) {
             cloud_config_id: parseInt(cloudConfigId),
             port: port,
             protocol: protocol,
+            action: document.getElementById('action').value,
+            source: document.getElementById('source').value.trim(),
+            priority: parseInt(document.getElementById('priority').value) || 0,
             enabled: document.getElementById('enabled').value === 'true',
+            instance_ids: document.getElementById('instanceIds').value.trim(),
+            instance_selector: document.getElementById('instanceSelector').value.trim(),
+            probe_type: document.getElementById('probeType').value,
+            probe_port: document.getElementById('probePort').value.trim(),
+            probe_path: document.getElementById('probePath').value.trim(),
         };
+        // 未选择实例时新规则使用云服务配置的默认实例，编辑时保留原实例
+        const instanceId = document.getElementById('instanceId').value;
+        if (instanceId) {
+            rule.instance_id = instanceId;
+        }
 
         // 检查是否为编辑模式
         const editId = form.dataset.editId;
@@ -323,6 +391,20 @@ async function executeRule(id) {
     }
 }
 
+async function probeRule(id) {
+    try {
+        const result = await apiRequest(`/api/v1/rules/${id}/probe`, { method: 'POST' });
+        fetchRules();
+        if (result.healthy) {
+            showMessage(`探测成功，耗时 ${result.latency_ms}ms`);
+        } else {
+            showMessage(`探测失败: ${result.error || '无法访问'}`, 'error');
+        }
+    } catch (error) {
+        showMessage(error.message || '探测失败', 'error');
+    }
+}
+
 async function editRule(id) {
     try {
         // 获取规则详情
@@ -340,9 +422,19 @@ async function editRule(id) {
         // 填充表单
         document.getElementById('remark').value = rule.remark || '';
         document.getElementById('cloudConfigId').value = rule.cloud_config_id || '';
+        await loadInstanceOptions();
+        setSelectValue(document.getElementById('instanceId'), rule.instance_id || '');
         document.getElementById('port').value = rule.port || '';
         document.getElementById('protocol').value = rule.protocol || 'TCP';
+        document.getElementById('action').value = rule.action || 'ACCEPT';
+        document.getElementById('source').value = rule.source || '';
+        document.getElementById('priority').value = rule.priority || 0;
         document.getElementById('enabled').value = rule.enabled ? 'true' : 'false';
+        document.getElementById('instanceIds').value = rule.instance_ids || '';
+        document.getElementById('instanceSelector').value = rule.instance_selector || '';
+        document.getElementById('probeType').value = rule.probe_type || '';
+        document.getElementById('probePort').value = rule.probe_port || '';
+        document.getElementById('probePath').value = rule.probe_path || '';
         
         // 更新表单状态为编辑模式
         const form = document.getElementById('addRuleForm');
@@ -499,6 +591,56 @@ async function loadCloudConfigOptions() {
     }
 }
 
+// 加载所选云服务配置的实例清单到规则表单中，refresh为true时重新查询云端
+async function loadInstanceOptions(refresh = false) {
+    const cloudConfigId = document.getElementById('cloudConfigId').value;
+    const select = document.getElementById('instanceId');
+    const current = select.value;
+    select.innerHTML = '<option value="">使用云服务配置的默认实例</option>';
+    if (!cloudConfigId) {
+        return;
+    }
+
+    try {
+        const url = `/api/v1/cloud-configs/${cloudConfigId}/inventory`;
+        const inventory = refresh
+            ? await apiRequest(`${url}/refresh`, { method: 'POST' })
+            : await apiRequest(url);
+
+        // 只列出可以管理防火墙规则的实例
+        (inventory.instances || []).filter(instance => instance.firewall_supported).forEach(instance => {
+            const option = document.createElement('option');
+            option.value = instance.instance_id;
+            const rules = instance.firewall_rule_count == null ? '' : `，${instance.firewall_rule_count}条规则`;
+            option.textContent = `${instance.instance_name || instance.instance_id} - ${instance.region}，${instance.public_ip || '无公网IP'}${rules}`;
+            select.appendChild(option);
+        });
+        if (inventory.errors) {
+            console.warn('部分区域的实例查询失败:', inventory.errors);
+        }
+        if (refresh) {
+            showMessage('实例清单已刷新');
+        }
+    } catch (error) {
+        console.error('加载实例清单失败:', error);
+        if (refresh) {
+            showMessage('刷新实例清单失败: ' + error.message, 'error');
+        }
+    }
+    setSelectValue(select, current);
+}
+
+// 选中下拉框中的值，清单中没有该值时（如实例已不可见）补充一个选项
+function setSelectValue(select, value) {
+    if (value && !Array.from(select.options).some(option => option.value === value)) {
+        const option = document.createElement('option');
+        option.value = value;
+        option.textContent = value;
+        select.appendChild(option);
+    }
+    select.value = value;
+}
+
 async function addCloudConfig(event) {
     event.preventDefault();
     const form = event.target;
@@ -512,6 +654,7 @@ async function addCloudConfig(event) {
             region: document.getElementById('cloud-region').value,
             instance_id: document.getElementById('instance-id').value,
             description: document.getElementById('cloud-description').value,
+            extra: document.getElementById('cloud-extra').value.trim(),
             is_default: document.getElementById('is-default').value === 'true',
             is_enabled: document.getElementById('cloud-enabled').value === 'true',
         };
@@ -598,8 +741,13 @@ async function editCloudConfig(id) {
         document.getElementById('cloud-region').value = config.region || '';
         document.getElementById('instance-id').value = config.instance_id || '';
         document.getElementById('secret-id').value = config.secret_id || '';
-        document.getElementById('secret-key').value = config.secret_key || '';
+        // 接口只返回掩码后的密钥，编辑时留空表示保持不变
+        const secretKeyInput = document.getElementById('secret-key');
+        secretKeyInput.value = '';
+        secretKeyInput.required = false;
+        secretKeyInput.placeholder = '留空则保持原密钥不变';
         document.getElementById('cloud-description').value = config.description || '';
+        document.getElementById('cloud-extra').value = config.extra || '';
         document.getElementById('is-default').value = config.is_default ? 'true' : 'false';
         document.getElementById('cloud-enabled').value = config.is_enabled ? 'true' : 'false';
         
@@ -655,6 +803,10 @@ function cancelEditCloudConfig() {
     form.dataset.currentAction = 'add';
     submitButton.textContent = '保存配置';
     
+    const secretKeyInput = document.getElementById('secret-key');
+    secretKeyInput.required = true;
+    secretKeyInput.placeholder = '访问密钥Secret';
+    
     // 移除取消按钮
     if (cancelButton) {
         cancelButton.remove();
@@ -680,6 +832,14 @@ async function fetchSystemConfig() {
             document.getElementById('currentInterval').textContent = config.ip_check_interval + '分钟';
         }
         
+        if (config.sync_concurrency) {
+            document.getElementById('sync-concurrency').value = config.sync_concurrency;
+        }
+        
+        if (config.audit_retention_days !== undefined) {
+            document.getElementById('audit-retention-days').value = config.audit_retention_days;
+        }
+        
         if (config.cron_enabled !== undefined) {
             document.getElementById('cron-enabled').value = config.cron_enabled;
             const statusEl = document.getElementById('currentStatus');
@@ -698,6 +858,9 @@ async function fetchSystemConfig() {
         
         // 获取当前IP
         fetchCurrentIP();
+        
+        // 获取API令牌
+        fetchTokens();
     } catch (error) {
         console.error('获取系统配置失败:', error);
     }
@@ -713,7 +876,10 @@ async function saveSystemConfig(event) {
             ip_fetch_url: document.getElementById('ip-fetch-url').value,
             ip_check_interval: parseInt(document.getElementById('ip-check-interval').value),
             cron_enabled: document.getElementById('cron-enabled').value,
+            sync_concurrency: parseInt(document.getElementById('sync-concurrency').value) || 4,
         };
+        const retentionDays = parseInt(document.getElementById('audit-retention-days').value);
+        config.audit_retention_days = isNaN(retentionDays) ? 90 : retentionDays;
 
         await apiRequest('/api/v1/system-config/', {
             method: 'PUT',
@@ -743,44 +909,116 @@ function closeModal() {
     currentEditType = null;
 }
 
-// 立即获取并同步IP
+// 立即获取并同步IP：后台启动同步任务，通过事件流实时显示进度
 async function syncIPNow() {
     const button = event.target;
     const originalText = button.textContent;
+    let source = null;
+    
+    const finish = () => {
+        if (source) {
+            source.close();
+        }
+        button.textContent = originalText;
+        button.disabled = false;
+    };
     
     try {
         button.textContent = '同步中...';
         button.disabled = true;
         
-        const response = await fetch('/api/v1/sync-ip/', {
+        // 先订阅事件，避免错过任务开始后立即发布的事件
+        source = await openEventStream();
+        let runID = null;
+        const pending = [];
+        
+        const handle = (type, evt) => {
+            if (evt.run_id !== runID) {
+                return;
+            }
+            const data = evt.data || {};
+            switch (type) {
+                case 'ip.changed':
+                    document.getElementById('currentIP').textContent = data.new_ip;
+                    showMessage(`公网IP已变化：${data.old_ip} → ${data.new_ip}`);
+                    break;
+                case 'rule.progress':
+                    button.textContent = `同步中 ${data.done}/${data.total}`;
+                    if (data.status === 'failed') {
+                        showMessage(`规则 ${data.remark} 同步失败: ${data.error}`, 'error');
+                    }
+                    break;
+                case 'run.finished':
+                    if (data.outcome === 'cancelled' && data.result) {
+                        showMessage(`同步已取消，已更新 ${data.result.updated} 条规则，${data.result.skipped} 条未处理`, 'error');
+                    } else if (data.error) {
+                        showMessage('IP同步失败: ' + data.error, 'error');
+                    } else {
+                        const result = data.result;
+                        document.getElementById('currentIP').textContent = result.current_ip;
+                        const failed = result.failed > 0 ? `，${result.failed} 条失败` : '';
+                        showMessage(`IP同步完成！当前IP: ${result.current_ip}，已更新 ${result.updated} 条规则${failed}`,
+                            result.failed > 0 ? 'error' : 'success');
+                    }
+                    finish();
+                    fetchRules();
+                    break;
+            }
+        };
+        
+        ['ip.changed', 'rule.progress', 'run.finished'].forEach(type => {
+            source.addEventListener(type, e => {
+                const evt = JSON.parse(e.data);
+                if (runID === null) {
+                    pending.push([type, evt]);
+                } else {
+                    handle(type, evt);
+                }
+            });
+        });
+        
+        const response = await fetch('/api/v1/sync-ip/?async=true', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json'
             }
         });
         
-        if (!response.ok) {
-            throw new Error('同步失败');
+        if (response.status === 401) {
+            window.location.href = '/login';
+            return;
         }
         
-        const result = await response.json();
+        const result = await response.json().catch(() => ({}));
         
-        if (result.success) {
-            document.getElementById('currentIP').textContent = result.current_ip;
-            showMessage(`IP同步成功！当前IP: ${result.current_ip}，已更新 ${result.updated_rules} 条规则`);
-        } else {
+        if (!response.ok || !result.success) {
+            // 409 表示已有同步任务正在运行
             throw new Error(result.message || '同步失败');
         }
         
+        runID = result.run_id;
+        pending.splice(0).forEach(([type, evt]) => handle(type, evt));
+        
     } catch (error) {
         console.error('IP同步失败:', error);
         showMessage('IP同步失败: ' + error.message, 'error');
-    } finally {
-        button.textContent = originalText;
-        button.disabled = false;
+        finish();
     }
 }
 
+// 打开同步进度事件流，连接建立后返回
+function openEventStream() {
+    return new Promise((resolve, reject) => {
+        const source = new EventSource('/api/v1/events');
+        source.onopen = () => resolve(source);
+        source.onerror = () => {
+            if (source.readyState === EventSource.CLOSED) {
+                reject(new Error('无法连接事件流'));
+            }
+        };
+    });
+}
+
 // 获取当前IP显示
 async function fetchCurrentIP() {
     try {
@@ -795,6 +1033,92 @@ async function fetchCurrentIP() {
     }
 }
 
+// ============= 登录与API令牌 =============
+
+// 获取当前登录用户
+async function fetchCurrentUser() {
+    try {
+        const user = await apiRequest('/api/v1/auth/me');
+        const roleNames = { admin: '管理员', operator: '运维', viewer: '只读' };
+        const role = roleNames[user.role] ? ` (${roleNames[user.role]})` : '';
+        document.getElementById('currentUser').textContent = (user.username || '') + role;
+    } catch (error) {
+        console.error('获取当前用户失败:', error);
+    }
+}
+
+async function logout() {
+    try {
+        await apiRequest('/api/v1/auth/logout', { method: 'POST' });
+    } finally {
+        window.location.href = '/login';
+    }
+}
+
+async function fetchTokens() {
+    try {
+        const tokens = await apiRequest('/api/v1/auth/tokens');
+        const tableBody = document.querySelector('#tokensTable tbody');
+        tableBody.innerHTML = '';
+        
+        (tokens || []).forEach(token => {
+            const row = `
+                <tr>
+                    <td><button class="btn-confirm" onclick="deleteToken(${token.ID})">删除</button></td>
+                    <td>${token.name || ''}</td>
+                    <td>${token.token_prefix || ''}...</td>
+                    <td>${token.expires_at ? new Date(token.expires_at).toLocaleString() : '永不过期'}</td>
+                    <td>${token.last_used_at ? new Date(token.last_used_at).toLocaleString() : '从未使用'}</td>
+                </tr>
+            `;
+            tableBody.insertAdjacentHTML('beforeend', row);
+        });
+    } catch (error) {
+        console.error('获取API令牌失败:', error);
+    }
+}
+
+async function createToken(event) {
+    event.preventDefault();
+    const form = event.target;
+    setLoading(form);
+    
+    try {
+        const result = await apiRequest('/api/v1/auth/tokens', {
+            method: 'POST',
+            body: JSON.stringify({
+                name: document.getElementById('token-name').value.trim(),
+                expires_in_days: parseInt(document.getElementById('token-expires').value) || 0,
+            })
+        });
+        
+        // 令牌明文只显示一次
+        const tokenValue = document.getElementById('newTokenValue');
+        tokenValue.textContent = `新令牌（仅显示一次，请立即保存）：${result.token}`;
+        tokenValue.style.display = 'block';
+        
+        form.reset();
+        fetchTokens();
+        showMessage('API令牌创建成功！');
+    } catch (error) {
+        showMessage('创建API令牌失败', 'error');
+    } finally {
+        setLoading(form, false);
+    }
+}
+
+async function deleteToken(id) {
+    if (!confirm('确定要删除这个API令牌吗？使用该令牌的脚本将无法继续访问。')) return;
+    
+    try {
+        await apiRequest(`/api/v1/auth/tokens/${id}`, { method: 'DELETE' });
+        fetchTokens();
+        showMessage('API令牌删除成功！');
+    } catch (error) {
+        showMessage('删除API令牌失败', 'error');
+    }
+}
+
 // 点击模态框外部关闭
 window.onclick = function(event) {
     const modal = document.getElementById('editModal');
@@ -810,6 +1134,12 @@ document.addEventListener('DOMContentLoaded', function() {
     document.getElementById('addRuleForm').addEventListener('submit', addRule);
     document.getElementById('addCloudConfigForm').addEventListener('submit', addCloudConfig);
     document.getElementById('systemConfigForm').addEventListener('submit', saveSystemConfig);
+    document.getElementById('createTokenForm').addEventListener('submit', createToken);
+    document.getElementById('cloudConfigId').addEventListener('change', () => loadInstanceOptions());
+    document.getElementById('refreshInventory').addEventListener('click', event => {
+        event.preventDefault();
+        loadInstanceOptions(true);
+    });
     
     // 协议选择变化时的处理逻辑
     document.getElementById('protocol').addEventListener('change', function() {
@@ -833,6 +1163,9 @@ document.addEventListener('DOMContentLoaded', function() {
         }
     });
     
+    // 显示当前登录用户
+    fetchCurrentUser();
+    
     // 初始加载防火墙规则
     fetchRules();
 });
\ No newline at end of file
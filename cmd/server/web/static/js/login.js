@@ -0,0 +1,78 @@
+// 显示错误信息
+function showLoginError(message) {
+    document.getElementById('login-error').textContent = message || '';
+}
+
+// 提交JSON请求，失败时抛出服务端返回的错误信息
+async function postJSON(url, body) {
+    const response = await fetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body)
+    });
+    const result = await response.json().catch(() => ({}));
+    if (!response.ok) {
+        throw new Error(result.error || `HTTP error! status: ${response.status}`);
+    }
+    return result;
+}
+
+async function login(event) {
+    event.preventDefault();
+    showLoginError('');
+
+    try {
+        await postJSON('/api/v1/auth/login', {
+            username: document.getElementById('login-username').value.trim(),
+            password: document.getElementById('login-password').value,
+        });
+        window.location.href = '/';
+    } catch (error) {
+        showLoginError(error.message);
+    }
+}
+
+async function setup(event) {
+    event.preventDefault();
+    showLoginError('');
+
+    const password = document.getElementById('setup-password').value;
+    if (password !== document.getElementById('setup-password-confirm').value) {
+        showLoginError('两次输入的密码不一致');
+        return;
+    }
+
+    try {
+        await postJSON('/api/v1/auth/setup', {
+            setup_token: document.getElementById('setup-token').value.trim(),
+            username: document.getElementById('setup-username').value.trim(),
+            password: password,
+        });
+        window.location.href = '/';
+    } catch (error) {
+        showLoginError(error.message);
+    }
+}
+
+document.addEventListener('DOMContentLoaded', async function() {
+    document.getElementById('loginForm').addEventListener('submit', login);
+    document.getElementById('setupForm').addEventListener('submit', setup);
+
+    try {
+        const response = await fetch('/api/v1/auth/status');
+        const status = await response.json();
+
+        if (status.authenticated) {
+            window.location.href = '/';
+            return;
+        }
+
+        // 尚未创建任何用户时显示初始化表单
+        if (status.setup_required) {
+            document.getElementById('loginForm').style.display = 'none';
+            document.getElementById('setupForm').style.display = 'block';
+        }
+    } catch (error) {
+        console.error('获取认证状态失败:', error);
+    }
+});
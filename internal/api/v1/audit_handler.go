@@ -0,0 +1,82 @@
+package v1
+
+import (
+	"FireFlow/internal/repository"
+	"FireFlow/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditService service.AuditService
+}
+
+func NewAuditHandler(auditService service.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+	}
+}
+
+// GetAuditLogs handles GET /api/v1/audit
+// 支持按 actor_type、actor、action（以*结尾为前缀匹配）、entity_type、entity_id、result、run_id、
+// since/until（RFC3339）过滤，limit/offset 分页，按时间倒序返回。
+func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
+	// 审计日志包含所有云服务配置下的变更
+	if !requireUnrestricted(c) {
+		return
+	}
+
+	filter := repository.AuditFilter{
+		ActorType:  c.Query("actor_type"),
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		Result:     c.Query("result"),
+		RunID:      c.Query("run_id"),
+		RequestID:  c.Query("request_id"),
+	}
+
+	var err error
+	if filter.Since, err = parseTimeQuery(c, "since"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since 必须为RFC3339时间格式"})
+		return
+	}
+	if filter.Until, err = parseTimeQuery(c, "until"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until 必须为RFC3339时间格式"})
+		return
+	}
+	if filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "0")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if filter.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	logs, total, err := h.auditService.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total": total,
+		"items": logs,
+	})
+}
+
+func parseTimeQuery(c *gin.Context, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,243 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AuthHandler struct {
+	authService service.AuthService
+	audit       service.AuditService
+}
+
+func NewAuthHandler(authService service.AuthService) *AuthHandler {
+	return &AuthHandler{
+		authService: authService,
+	}
+}
+
+// SetAuditService 设置审计日志服务
+func (h *AuthHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// LoginRequest 登录请求体
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// SetupRequest 首次运行初始化请求体
+type SetupRequest struct {
+	SetupToken string `json:"setup_token" binding:"required"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+}
+
+// ChangePasswordRequest 修改密码请求体
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// CreateTokenRequest 创建API令牌请求体
+type CreateTokenRequest struct {
+	Name         string `json:"name" binding:"required"`
+	ExpiresInDay int    `json:"expires_in_days"` // 0 表示永不过期
+}
+
+// GetStatus 获取认证状态，用于登录页判断是否需要初始化
+func (h *AuthHandler) GetStatus(c *gin.Context) {
+	needsSetup, err := h.authService.NeedsSetup()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, _ := c.Cookie(SessionCookieName)
+	principal, _ := h.authService.AuthenticateSession(token)
+
+	result := gin.H{
+		"setup_required": needsSetup,
+		"authenticated":  principal != nil,
+	}
+	if principal != nil {
+		result["user"] = principal
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Setup 首次运行时创建管理员账号并登录
+func (h *AuthHandler) Setup(c *gin.Context) {
+	var req SetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	user, err := h.authService.Setup(req.SetupToken, req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSetupCompleted):
+			c.JSON(http.StatusConflict, gin.H{"error": "系统已完成初始化"})
+		case errors.Is(err, service.ErrInvalidSetupToken):
+			c.JSON(http.StatusForbidden, gin.H{"error": "初始化令牌错误，请查看服务启动日志"})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// 初始化请求未登录，以新建的管理员作为操作者
+	if h.audit != nil {
+		actor := service.Actor{Type: model.AuditActorUser, UserID: user.ID, Name: user.Username, SourceIP: c.ClientIP(), RequestID: requestID(c)}
+		h.audit.Record(actor, service.AuditEntry{Action: "user.setup", EntityType: "user", EntityID: fmt.Sprint(user.ID), After: user})
+	}
+
+	h.login(c, req.Username, req.Password, http.StatusCreated)
+}
+
+// Login 用户名密码登录，成功后写入会话Cookie
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	h.login(c, req.Username, req.Password, http.StatusOK)
+}
+
+func (h *AuthHandler) login(c *gin.Context, username, password string, status int) {
+	token, expiresAt, user, err := h.authService.Login(username, password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setSessionCookie(c, token, int(time.Until(expiresAt).Seconds()))
+	c.JSON(status, gin.H{
+		"message":    "登录成功",
+		"user":       user,
+		"expires_at": expiresAt,
+	})
+}
+
+// Logout 注销当前会话
+func (h *AuthHandler) Logout(c *gin.Context) {
+	token, _ := c.Cookie(SessionCookieName)
+	if err := h.authService.Logout(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// GetCurrentUser 获取当前登录用户
+func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, currentPrincipal(c))
+}
+
+// ChangePassword 修改当前用户密码
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	principal := currentPrincipal(c)
+	err := h.authService.ChangePassword(principal.UserID, req.OldPassword, req.NewPassword)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "user.change_password", EntityType: "user", EntityID: fmt.Sprint(principal.UserID), Err: err})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "原密码错误"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "密码修改成功，请重新登录"})
+}
+
+// GetTokens 获取当前用户的API令牌
+func (h *AuthHandler) GetTokens(c *gin.Context) {
+	tokens, err := h.authService.ListAPITokens(currentPrincipal(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateToken 为当前用户创建API令牌，令牌明文只返回一次
+func (h *AuthHandler) CreateToken(c *gin.Context) {
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInDay) * 24 * time.Hour
+	token, record, err := h.authService.CreateAPIToken(currentPrincipal(c).UserID, req.Name, ttl)
+	entry := service.AuditEntry{Action: "api_token.create", EntityType: "api_token", EntityID: req.Name, Err: err}
+	if record != nil {
+		entry.EntityID = fmt.Sprint(record.ID)
+		entry.After = record
+	}
+	recordAudit(c, h.audit, entry)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   token,
+		"record":  record,
+		"message": "请妥善保存令牌，关闭后将无法再次查看",
+	})
+}
+
+// DeleteToken 删除当前用户的API令牌
+func (h *AuthHandler) DeleteToken(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	err = h.authService.DeleteAPIToken(currentPrincipal(c).UserID, uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "api_token.delete", EntityType: "api_token", EntityID: idStr, Err: err})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "令牌不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token deleted successfully"})
+}
+
+// setSessionCookie 写入会话Cookie，maxAge小于0时删除
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookieName, token, maxAge, "/", "", c.Request.TLS != nil, true)
+}
@@ -0,0 +1,135 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SessionCookieName Web界面会话Cookie名称
+	SessionCookieName = "fireflow_session"
+	// principalContextKey 认证信息在gin.Context中的键
+	principalContextKey = "principal"
+)
+
+// AuthMiddleware 要求请求携带有效的会话Cookie或Bearer API令牌
+func AuthMiddleware(authService service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticate(c, authService)
+		if err != nil {
+			status := http.StatusUnauthorized
+			message := "未登录或登录已过期"
+			if !errors.Is(err, service.ErrUnauthenticated) {
+				status = http.StatusInternalServerError
+				message = "认证失败: " + err.Error()
+			}
+			c.AbortWithStatusJSON(status, gin.H{"error": message})
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// PageAuthMiddleware 页面访问认证，未登录时跳转到登录页
+func PageAuthMiddleware(authService service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, _ := c.Cookie(SessionCookieName)
+		principal, err := authService.AuthenticateSession(token)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/login")
+			c.Abort()
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// authenticate 优先使用Authorization头中的API令牌，其次使用会话Cookie
+func authenticate(c *gin.Context, authService service.AuthService) (*service.Principal, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, service.ErrUnauthenticated
+		}
+		return authService.AuthenticateAPIToken(strings.TrimSpace(token))
+	}
+
+	token, err := c.Cookie(SessionCookieName)
+	if err != nil {
+		return nil, service.ErrUnauthenticated
+	}
+	return authService.AuthenticateSession(token)
+}
+
+// currentPrincipal 获取当前请求的认证信息
+func currentPrincipal(c *gin.Context) *service.Principal {
+	value, ok := c.Get(principalContextKey)
+	if !ok {
+		return nil
+	}
+	principal, _ := value.(*service.Principal)
+	return principal
+}
+
+// RequirePermission 要求当前用户的角色拥有指定权限，否则返回403
+func RequirePermission(perm service.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentPrincipal(c).Can(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireCloudConfigAccess 检查当前用户能否访问指定云服务配置，无权访问时写入403响应并返回false
+func requireCloudConfigAccess(c *gin.Context, cloudConfigID uint) bool {
+	if currentPrincipal(c).CanAccessCloudConfig(cloudConfigID) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该云服务配置"})
+	return false
+}
+
+// requireUnrestricted 要求当前用户可访问全部云服务配置，用于影响所有配置的操作
+func requireUnrestricted(c *gin.Context) bool {
+	if currentPrincipal(c).Unrestricted() {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "该操作需要访问全部云服务配置的权限"})
+	return false
+}
+
+// auditActor 将当前请求的认证信息转换为审计日志操作者
+func auditActor(c *gin.Context) service.Actor {
+	actor := service.Actor{SourceIP: c.ClientIP(), RequestID: requestID(c)}
+	principal := currentPrincipal(c)
+	if principal == nil {
+		return actor
+	}
+
+	actor.Type = model.AuditActorUser
+	if principal.AuthMethod == "token" {
+		actor.Type = model.AuditActorToken
+	}
+	actor.UserID = principal.UserID
+	actor.Name = principal.Username
+	actor.TokenID = principal.TokenID
+	return actor
+}
+
+// recordAudit 记录当前请求发起的变更，未设置审计服务时忽略
+func recordAudit(c *gin.Context, audit service.AuditService, entry service.AuditEntry) {
+	if audit != nil {
+		audit.Record(auditActor(c), entry)
+	}
+}
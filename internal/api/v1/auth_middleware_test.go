@@ -0,0 +1,202 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/internal/service"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testPassword = "correct horse battery"
+
+// authTestEnv 规则接口经过真实的认证和授权：会话Cookie、API令牌、角色权限和访问范围
+type authTestEnv struct {
+	*ruleTestEnv
+	auth   service.AuthService
+	users  service.UserService
+	router *gin.Engine
+}
+
+func newAuthTestEnv(t *testing.T) *authTestEnv {
+	t.Helper()
+	rules := newRuleTestEnv(t)
+	userRepo := repository.NewUserRepository(rules.db)
+	env := &authTestEnv{
+		ruleTestEnv: rules,
+		auth:        service.NewAuthService(userRepo, time.Hour),
+		users:       service.NewUserService(userRepo, rules.cloud),
+		router:      gin.New(),
+	}
+
+	authHandler := NewAuthHandler(env.auth)
+	api := env.router.Group("/api/v1")
+	api.POST("/auth/login", authHandler.Login)
+	protected := api.Group("", AuthMiddleware(env.auth))
+	protected.POST("/auth/logout", authHandler.Logout)
+	protected.GET("/rules", RequirePermission(service.PermView), rules.rules.GetRules)
+	protected.POST("/rules", RequirePermission(service.PermManageRules), rules.rules.CreateRule)
+	return env
+}
+
+func (e *authTestEnv) createUser(username, role string, cloudConfigIDs ...uint) *service.UserInfo {
+	e.t.Helper()
+	input := &service.UserInput{Username: username, Password: testPassword, Role: role}
+	if len(cloudConfigIDs) > 0 {
+		input.CloudConfigIDs = &cloudConfigIDs
+	}
+	user, err := e.users.CreateUser(input)
+	if err != nil {
+		e.t.Fatalf("failed to create user %s: %v", username, err)
+	}
+	return user
+}
+
+func (e *authTestEnv) token(userID uint) string {
+	e.t.Helper()
+	token, _, err := e.auth.CreateAPIToken(userID, "test", 0)
+	if err != nil {
+		e.t.Fatalf("failed to create API token: %v", err)
+	}
+	return token
+}
+
+// send 发送请求，authorization 非空时作为Authorization头，session 非空时作为会话Cookie
+func (e *authTestEnv) send(method, path string, body any, authorization, session string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session})
+	}
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// visibleRules 返回调用方可见的规则的云服务配置ID
+func (e *authTestEnv) visibleRules(authorization string) []uint {
+	e.t.Helper()
+	w := e.send(http.MethodGet, "/api/v1/rules", nil, authorization, "")
+	if w.Code != http.StatusOK {
+		e.t.Fatalf("GetRules status %d: %s", w.Code, w.Body.String())
+	}
+	var rules []model.FirewallRule
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		e.t.Fatal(err)
+	}
+	ids := make([]uint, 0, len(rules))
+	for _, rule := range rules {
+		ids = append(ids, rule.CloudConfigID)
+	}
+	return ids
+}
+
+func TestAuthMiddlewareSessionsAndTokens(t *testing.T) {
+	env := newAuthTestEnv(t)
+	admin := env.createUser("admin", model.RoleAdmin)
+
+	if w := env.send(http.MethodGet, "/api/v1/rules", nil, "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("request without credentials status %d", w.Code)
+	}
+	if w := env.send(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "admin", "password": "wrong password"}, "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password status %d", w.Code)
+	}
+
+	w := env.send(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "admin", "password": testPassword}, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login status %d: %s", w.Code, w.Body.String())
+	}
+	var session string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SessionCookieName {
+			session = cookie.Value
+		}
+	}
+	if session == "" {
+		t.Fatal("login did not set a session cookie")
+	}
+	if w := env.send(http.MethodGet, "/api/v1/rules", nil, "", session); w.Code != http.StatusOK {
+		t.Fatalf("request with session status %d", w.Code)
+	}
+	if w := env.send(http.MethodPost, "/api/v1/auth/logout", nil, "", session); w.Code != http.StatusOK {
+		t.Fatalf("logout status %d", w.Code)
+	}
+	if w := env.send(http.MethodGet, "/api/v1/rules", nil, "", session); w.Code != http.StatusUnauthorized {
+		t.Fatalf("request with logged out session status %d", w.Code)
+	}
+
+	token := env.token(admin.ID)
+	for _, authorization := range []string{"Token " + token, "Bearer ff_unknown", "Bearer " + session} {
+		if w := env.send(http.MethodGet, "/api/v1/rules", nil, authorization, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("request with Authorization %q status %d, want 401", authorization, w.Code)
+		}
+	}
+	if w := env.send(http.MethodGet, "/api/v1/rules", nil, "Bearer "+token, ""); w.Code != http.StatusOK {
+		t.Fatalf("request with API token status %d", w.Code)
+	}
+
+	// 停用的用户的令牌失效
+	operator := env.createUser("operator", model.RoleOperator)
+	operatorToken := env.token(operator.ID)
+	disabled := false
+	if _, err := env.users.UpdateUser(admin.ID, operator.ID, &service.UserInput{IsEnabled: &disabled}); err != nil {
+		t.Fatalf("failed to disable user: %v", err)
+	}
+	if w := env.send(http.MethodGet, "/api/v1/rules", nil, "Bearer "+operatorToken, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("request with token of disabled user status %d", w.Code)
+	}
+}
+
+func TestRolePermissionsAndCloudConfigScope(t *testing.T) {
+	env := newAuthTestEnv(t)
+	for i, configID := range env.configs {
+		rule := &model.FirewallRule{CloudConfigID: configID, InstanceID: "lhins-a", Provider: "Fake", Protocol: "TCP", Port: "22", Remark: "ssh", Enabled: true}
+		if i == 1 {
+			rule.InstanceID = "lhins-b"
+		}
+		if err := env.firewall.Create(rule); err != nil {
+			t.Fatalf("failed to create rule: %v", err)
+		}
+	}
+	rule := func(configID uint) map[string]any {
+		return map[string]any{"cloud_config_id": configID, "port": "443", "protocol": "TCP", "remark": "https", "enabled": true}
+	}
+
+	// 查看者可以看到全部规则，但不能修改
+	viewer := "Bearer " + env.token(env.createUser("viewer", model.RoleViewer).ID)
+	if got := env.visibleRules(viewer); len(got) != 2 {
+		t.Errorf("viewer sees rules of configs %v, want both", got)
+	}
+	if w := env.send(http.MethodPost, "/api/v1/rules", rule(env.configs[0]), viewer, ""); w.Code != http.StatusForbidden {
+		t.Errorf("viewer CreateRule status %d, want 403", w.Code)
+	}
+
+	// 限定访问范围的运维用户只能看到和修改范围内的规则
+	operator := "Bearer " + env.token(env.createUser("operator", model.RoleOperator, env.configs[0]).ID)
+	if got := env.visibleRules(operator); len(got) != 1 || got[0] != env.configs[0] {
+		t.Errorf("scoped operator sees rules of configs %v, want [%d]", got, env.configs[0])
+	}
+	if w := env.send(http.MethodPost, "/api/v1/rules", rule(env.configs[1]), operator, ""); w.Code != http.StatusForbidden {
+		t.Errorf("scoped operator CreateRule outside scope status %d, want 403", w.Code)
+	}
+	if w := env.send(http.MethodPost, "/api/v1/rules", rule(env.configs[0]), operator, ""); w.Code != http.StatusCreated {
+		t.Errorf("scoped operator CreateRule in scope status %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -2,7 +2,11 @@ package v1
 
 import (
 	"FireFlow/internal/model"
+	"FireFlow/internal/secret"
 	"FireFlow/internal/service"
+	"FireFlow/pkg/cloud"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -10,7 +14,9 @@ import (
 )
 
 type CloudConfigHandler struct {
-	configService service.ConfigService
+	configService   service.ConfigService
+	instanceService service.CloudInstanceService
+	audit           service.AuditService
 }
 
 func NewCloudConfigHandler(configService service.ConfigService) *CloudConfigHandler {
@@ -19,6 +25,16 @@ func NewCloudConfigHandler(configService service.ConfigService) *CloudConfigHand
 	}
 }
 
+// SetAuditService 设置审计日志服务
+func (h *CloudConfigHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// SetCloudInstanceService 设置实例登记服务，创建或修改配置后登记配置的默认实例
+func (h *CloudConfigHandler) SetCloudInstanceService(instanceService service.CloudInstanceService) {
+	h.instanceService = instanceService
+}
+
 // GetCloudConfigs 获取所有云服务配置
 func (h *CloudConfigHandler) GetCloudConfigs(c *gin.Context) {
 	configs, err := h.configService.GetAllCloudConfigs()
@@ -26,21 +42,42 @@ func (h *CloudConfigHandler) GetCloudConfigs(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, configs)
+	// 只返回当前用户可访问的云服务配置
+	principal := currentPrincipal(c)
+	visible := make([]model.CloudProviderConfig, 0, len(configs))
+	for _, config := range configs {
+		if principal.CanAccessCloudConfig(config.ID) {
+			maskCloudConfig(&config)
+			visible = append(visible, config)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
 }
 
 // CreateCloudConfig 创建云服务配置
 func (h *CloudConfigHandler) CreateCloudConfig(c *gin.Context) {
+	// 受访问范围限制的用户不能新增配置
+	if !requireUnrestricted(c) {
+		return
+	}
+
 	var config model.CloudProviderConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validateProviderExtra(c, &config) {
+		return
+	}
 
-	if err := h.configService.CreateCloudConfig(&config); err != nil {
+	err := h.configService.CreateCloudConfig(&config)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cloud_config.create", EntityType: "cloud_config", EntityID: fmt.Sprint(config.ID), After: &config, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.registerDefaultInstance()
+	maskCloudConfig(&config)
 	c.JSON(http.StatusCreated, config)
 }
 
@@ -52,21 +89,46 @@ func (h *CloudConfigHandler) UpdateCloudConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
+	if !requireCloudConfigAccess(c, uint(id)) {
+		return
+	}
 
 	var config model.CloudProviderConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validateProviderExtra(c, &config) {
+		return
+	}
 
 	config.ID = uint(id)
-	if err := h.configService.UpdateCloudConfig(&config); err != nil {
+	before, _ := h.configService.GetCloudConfigByID(config.ID)
+	err = h.configService.UpdateCloudConfig(&config)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cloud_config.update", EntityType: "cloud_config", EntityID: idStr, Before: before, After: &config, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// 凭证或区域可能已修改，缓存的实例清单属于旧的账号
+	h.invalidateInventory(config.ID)
+	h.registerDefaultInstance()
+	maskCloudConfig(&config)
 	c.JSON(http.StatusOK, config)
 }
 
+// validateProviderExtra 校验云服务商的额外配置，目前只有模拟云服务使用，不合法时返回400
+func validateProviderExtra(c *gin.Context, config *model.CloudProviderConfig) bool {
+	if config.Provider != cloud.ProviderFake {
+		return true
+	}
+	if _, err := cloud.ParseFakeConfig(config.Extra); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
 // DeleteCloudConfig 删除云服务配置
 func (h *CloudConfigHandler) DeleteCloudConfig(c *gin.Context) {
 	idStr := c.Param("id")
@@ -75,11 +137,18 @@ func (h *CloudConfigHandler) DeleteCloudConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
+	if !requireCloudConfigAccess(c, uint(id)) {
+		return
+	}
 
-	if err := h.configService.DeleteCloudConfig(uint(id)); err != nil {
+	before, _ := h.configService.GetCloudConfigByID(uint(id))
+	err = h.configService.DeleteCloudConfig(uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cloud_config.delete", EntityType: "cloud_config", EntityID: idStr, Before: before, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.invalidateInventory(uint(id))
 	c.JSON(http.StatusOK, gin.H{"message": "Cloud config deleted successfully"})
 }
 
@@ -91,8 +160,11 @@ func (h *CloudConfigHandler) TestCloudConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
+	if !requireCloudConfigAccess(c, uint(id)) {
+		return
+	}
 
-	result, err := h.configService.TestCloudConfig(uint(id))
+	result, err := h.configService.TestCloudConfig(c.Request.Context(), uint(id))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -105,3 +177,26 @@ func (h *CloudConfigHandler) TestCloudConfig(c *gin.Context) {
 		"instance_exists": result.InstanceExists,
 	})
 }
+
+// registerDefaultInstance 将配置的默认实例登记为受管理的实例，失败不影响配置的保存
+func (h *CloudConfigHandler) registerDefaultInstance() {
+	if h.instanceService == nil {
+		return
+	}
+	if _, err := h.instanceService.RegisterDefaultInstances(); err != nil {
+		slog.Warn("failed to register default instance of cloud config", "error", err)
+	}
+}
+
+// invalidateInventory 丢弃配置的缓存实例清单
+func (h *CloudConfigHandler) invalidateInventory(id uint) {
+	if h.instanceService != nil {
+		h.instanceService.InvalidateInventory(id)
+	}
+}
+
+// maskCloudConfig 掩码响应中的访问密钥，密钥明文不会通过API返回
+func maskCloudConfig(config *model.CloudProviderConfig) {
+	config.SecretId = secret.Mask(config.SecretId)
+	config.SecretKey = secret.Mask(config.SecretKey)
+}
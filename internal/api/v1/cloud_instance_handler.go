@@ -0,0 +1,189 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CloudInstanceHandler struct {
+	instanceService service.CloudInstanceService
+	audit           service.AuditService
+}
+
+func NewCloudInstanceHandler(instanceService service.CloudInstanceService) *CloudInstanceHandler {
+	return &CloudInstanceHandler{
+		instanceService: instanceService,
+	}
+}
+
+// SetAuditService 设置审计日志服务
+func (h *CloudInstanceHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// GetInstances handles GET /api/v1/cloud-instances?cloud_config_id=
+func (h *CloudInstanceHandler) GetInstances(c *gin.Context) {
+	var cloudConfigID uint64
+	if value := c.Query("cloud_config_id"); value != "" {
+		var err error
+		if cloudConfigID, err = strconv.ParseUint(value, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cloud_config_id"})
+			return
+		}
+	}
+
+	instances, err := h.instanceService.ListInstances(uint(cloudConfigID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 只返回当前用户可访问的云服务配置下的实例
+	principal := currentPrincipal(c)
+	visible := make([]model.CloudInstance, 0, len(instances))
+	for _, instance := range instances {
+		if principal.CanAccessCloudConfig(instance.CloudConfigID) {
+			visible = append(visible, instance)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// CreateInstance handles POST /api/v1/cloud-instances
+func (h *CloudInstanceHandler) CreateInstance(c *gin.Context) {
+	var instance model.CloudInstance
+	if err := c.ShouldBindJSON(&instance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireCloudConfigAccess(c, instance.CloudConfigID) {
+		return
+	}
+
+	err := h.instanceService.CreateInstance(&instance)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cloud_instance.create", EntityType: "cloud_instance", EntityID: fmt.Sprint(instance.ID), After: &instance, Err: err})
+	if err != nil {
+		c.JSON(cloudInstanceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, instance)
+}
+
+// UpdateInstance handles PUT /api/v1/cloud-instances/:id
+func (h *CloudInstanceHandler) UpdateInstance(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	before, ok := h.authorizeInstance(c, uint(id))
+	if !ok {
+		return
+	}
+
+	var instance model.CloudInstance
+	if err := c.ShouldBindJSON(&instance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	instance.ID = uint(id)
+	err = h.instanceService.UpdateInstance(&instance)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cloud_instance.update", EntityType: "cloud_instance", EntityID: idStr, Before: before, After: &instance, Err: err})
+	if err != nil {
+		c.JSON(cloudInstanceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, instance)
+}
+
+// DeleteInstance handles DELETE /api/v1/cloud-instances/:id
+func (h *CloudInstanceHandler) DeleteInstance(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	before, ok := h.authorizeInstance(c, uint(id))
+	if !ok {
+		return
+	}
+
+	err = h.instanceService.DeleteInstance(uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cloud_instance.delete", EntityType: "cloud_instance", EntityID: idStr, Before: before, Err: err})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cloud instance deleted successfully"})
+}
+
+// GetInventory handles GET /api/v1/cloud-configs/:id/inventory
+// 返回配置的凭证在所有区域下可见的实例清单（缓存），用于选择实例
+func (h *CloudInstanceHandler) GetInventory(c *gin.Context) {
+	h.inventory(c, false)
+}
+
+// DiscoverInstances handles GET /api/v1/cloud-configs/:id/instances/discover，返回实例清单
+func (h *CloudInstanceHandler) DiscoverInstances(c *gin.Context) {
+	h.inventory(c, false)
+}
+
+// RefreshInventory handles POST /api/v1/cloud-configs/:id/inventory/refresh
+func (h *CloudInstanceHandler) RefreshInventory(c *gin.Context) {
+	h.inventory(c, true)
+}
+
+func (h *CloudInstanceHandler) inventory(c *gin.Context, refresh bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !requireCloudConfigAccess(c, uint(id)) {
+		return
+	}
+
+	inventory, err := h.instanceService.Inventory(c.Request.Context(), uint(id), refresh)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, inventory)
+}
+
+// authorizeInstance 检查当前用户能否操作指定的实例，失败时写入响应并返回false
+func (h *CloudInstanceHandler) authorizeInstance(c *gin.Context, id uint) (*model.CloudInstance, bool) {
+	instance, err := h.instanceService.GetInstance(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "实例不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	return instance, requireCloudConfigAccess(c, instance.CloudConfigID)
+}
+
+func cloudInstanceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInstanceExists):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
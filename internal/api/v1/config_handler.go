@@ -4,10 +4,9 @@ import (
 	"FireFlow/internal/core"
 	"FireFlow/internal/service"
 	"FireFlow/internal/utils"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"regexp"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -17,6 +16,7 @@ type ConfigHandler struct {
 	configService   service.ConfigService
 	cronManager     *core.CronManager
 	firewallService *service.FirewallService
+	audit           service.AuditService
 }
 
 func NewConfigHandler(configService service.ConfigService, cronManager *core.CronManager) *ConfigHandler {
@@ -32,6 +32,11 @@ func (h *ConfigHandler) SetFirewallService(firewallService *service.FirewallServ
 	h.firewallService = firewallService
 }
 
+// SetAuditService 设置审计日志服务
+func (h *ConfigHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
 // SetConfigRequest 设置配置请求体
 type SetConfigRequest struct {
 	Key         string `json:"key" binding:"required"`
@@ -71,7 +76,16 @@ func (h *ConfigHandler) SetConfig(c *gin.Context) {
 		return
 	}
 
+	before, _ := h.configService.GetConfig(req.Key)
 	err := h.configService.SetConfig(req.Key, req.Value, req.Type, req.Category, req.Description)
+	recordAudit(c, h.audit, service.AuditEntry{
+		Action:     "config.update",
+		EntityType: "config",
+		EntityID:   req.Key,
+		Before:     map[string]string{req.Key: before},
+		After:      map[string]string{req.Key: req.Value},
+		Err:        err,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存配置失败: " + err.Error()})
 		return
@@ -126,6 +140,12 @@ func (h *ConfigHandler) GetSystemConfig(c *gin.Context) {
 	if _, exists := result["cron_enabled"]; !exists {
 		result["cron_enabled"] = "false" // 默认禁用
 	}
+	if _, exists := result["sync_concurrency"]; !exists {
+		result["sync_concurrency"] = 4 // 默认同时同步4个实例
+	}
+	if _, exists := result["audit_retention_days"]; !exists {
+		result["audit_retention_days"] = 90 // 默认保留90天审计日志
+	}
 
 	c.JSON(http.StatusOK, result)
 }
@@ -142,13 +162,30 @@ func (h *ConfigHandler) SetSystemConfig(c *gin.Context) {
 	var cronEnabled bool
 	var intervalMinutes int
 
+	// 记录修改前后的系统配置
+	before := make(map[string]string)
+	after := make(map[string]string)
+	if existing, err := h.configService.GetConfigsByCategory("system"); err == nil {
+		for _, item := range existing {
+			if _, ok := configMap[item.ConfigKey]; ok {
+				before[item.ConfigKey] = item.ConfigValue
+			}
+		}
+	}
+	var saveErr error
+	defer func() {
+		recordAudit(c, h.audit, service.AuditEntry{Action: "system_config.update", EntityType: "system_config", EntityID: "system", Before: before, After: after, Err: saveErr})
+	}()
+
 	for key, value := range configMap {
 		valueStr := fmt.Sprintf("%v", value)
 		err := h.configService.SetConfig(key, valueStr, "string", "system", "系统配置")
 		if err != nil {
+			saveErr = err
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存配置 %s 失败: %v", key, err)})
 			return
 		}
+		after[key] = valueStr
 
 		// 提取定时任务配置
 		if key == "cron_enabled" {
@@ -167,6 +204,7 @@ func (h *ConfigHandler) SetSystemConfig(c *gin.Context) {
 	if cronEnabled && intervalMinutes > 0 {
 		err := h.cronManager.StartFirewallUpdateJob(intervalMinutes)
 		if err != nil {
+			saveErr = err
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("启动定时任务失败: %v", err)})
 			return
 		}
@@ -186,7 +224,7 @@ func (h *ConfigHandler) GetCurrentIP(c *gin.Context) {
 	}
 
 	// 获取当前IP
-	currentIP, err := utils.GetPublicIPWithURL(ipFetchURL)
+	currentIP, err := utils.GetPublicIPWithURL(c.Request.Context(), ipFetchURL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":      "获取IP失败",
@@ -224,6 +262,11 @@ func (h *ConfigHandler) GetCurrentIP(c *gin.Context) {
 
 // SyncIPNow 立即获取并同步IP到防火墙规则
 func (h *ConfigHandler) SyncIPNow(c *gin.Context) {
+	// 全量同步会修改所有云服务配置下的规则
+	if !requireUnrestricted(c) {
+		return
+	}
+
 	// 检查防火墙服务是否可用
 	if h.firewallService == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -233,63 +276,170 @@ func (h *ConfigHandler) SyncIPNow(c *gin.Context) {
 		return
 	}
 
-	// 获取IP获取URL配置
-	ipFetchURL, err := h.configService.GetConfig("ip_fetch_url")
-	if err != nil || ipFetchURL == "" {
-		ipFetchURL = "https://4.ipw.cn"
+	// async=true 时在后台执行，立即返回任务ID，进度通过 /api/v1/events 推送
+	if c.Query("async") == "true" {
+		run, err := h.firewallService.StartAllRules(auditActor(c))
+		if err != nil {
+			if !respondRunInProgress(c, err) && !respondShuttingDown(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			}
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"success":    true,
+			"run_id":     run.RunID,
+			"started_at": run.StartedAt,
+			"message":    "同步任务已开始",
+		})
+		return
 	}
 
-	// 获取当前IP
-	currentIP, err := utils.GetPublicIPWithURL(ipFetchURL)
+	// 执行防火墙规则更新（IP获取与合法性校验在服务内完成），客户端断开连接时中止
+	result, err := h.firewallService.UpdateAllRules(c.Request.Context(), auditActor(c))
 	if err != nil {
+		if respondRunInProgress(c, err) || respondShuttingDown(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrRunCancelled) {
+			response := gin.H{"success": false, "cancelled": true, "message": "同步任务已取消"}
+			if result != nil {
+				response["run_id"] = result.RunID
+				response["updated_rules"] = result.Updated
+				response["failed_rules"] = result.Failed
+				response["skipped_rules"] = result.Skipped
+				response["message"] = fmt.Sprintf("同步任务已取消，已更新 %d 条规则，%d 条未处理", result.Updated, result.Skipped)
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": fmt.Sprintf("获取IP失败: %v", err),
+			"message": fmt.Sprintf("IP同步失败: %v", err),
 		})
 		return
 	}
 
-	// 检查IP合法性（只允许IPv4，禁止IPv6、JSON、报错信息、内容过长等）
-	if len(currentIP) > 40 || strings.Contains(currentIP, ":") || strings.ContainsAny(currentIP, "[{") || strings.Contains(strings.ToLower(currentIP), "error") || strings.Contains(strings.ToLower(currentIP), "html") {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"message": "获取到的IP地址不合法，未触发规则更新。",
-		})
+	message := fmt.Sprintf("IP同步成功，当前IP: %s，已更新 %d 条规则", result.CurrentIP, result.Updated)
+	if result.Pinned > 0 {
+		message += fmt.Sprintf("，%d 条回滚后固定的规则未同步", result.Pinned)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"run_id":        result.RunID,
+		"current_ip":    result.CurrentIP,
+		"updated_rules": result.Updated,
+		"failed_rules":  result.Failed,
+		"pinned_rules":  result.Pinned,
+		"message":       message,
+	})
+}
+
+// respondShuttingDown 服务正在关闭时返回503，返回是否已处理
+func respondShuttingDown(c *gin.Context, err error) bool {
+	if !errors.Is(err, service.ErrShuttingDown) {
+		return false
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"success": false,
+		"error":   "服务正在关闭，请稍后再试",
+		"message": "服务正在关闭，请稍后再试",
+	})
+	return true
+}
+
+// respondRunInProgress 已有同步任务在运行时返回409，返回是否已处理
+func respondRunInProgress(c *gin.Context, err error) bool {
+	var inProgress *service.RunInProgressError
+	if !errors.As(err, &inProgress) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"success": false,
+		"run_id":  inProgress.RunID,
+		"message": fmt.Sprintf("已有同步任务正在运行（%s），请稍后再试", inProgress.RunID),
+	})
+	return true
+}
+
+// CancelRun 取消正在运行的全量同步
+func (h *ConfigHandler) CancelRun(c *gin.Context) {
+	// 与触发全量同步的权限要求一致
+	if !requireUnrestricted(c) {
 		return
 	}
-	// 严格正则校验IPv4
-	ipv4Pattern := `^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$`
-	matched, _ := regexp.MatchString(ipv4Pattern, currentIP)
-	if !matched {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"message": "获取到的IP地址不是合法IPv4，未触发规则更新。",
-		})
+
+	if h.firewallService == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "防火墙服务不可用"})
 		return
 	}
 
-	// 执行防火墙规则更新
-	h.firewallService.UpdateAllRules()
+	runID := c.Param("id")
+	if err := h.firewallService.CancelRun(runID, auditActor(c)); err != nil {
+		if errors.Is(err, service.ErrRunNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "同步任务不存在或已结束"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"run_id":  runID,
+		"message": "已请求取消同步任务",
+	})
+}
+
+// RollbackRun 将指定同步任务修改过的规则恢复为该任务执行前的来源
+func (h *ConfigHandler) RollbackRun(c *gin.Context) {
+	// 同步任务可能修改了所有云服务配置下的规则
+	if !requireUnrestricted(c) {
+		return
+	}
 
-	// 获取所有启用的规则数量作为更新计数
-	updatedRules, err := h.getEnabledRulesCount()
+	if h.firewallService == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "防火墙服务不可用"})
+		return
+	}
+
+	runID := c.Param("id")
+	result, err := h.firewallService.RollbackRun(c.Request.Context(), runID, auditActor(c))
 	if err != nil {
-		log.Printf("获取启用规则数量失败: %v", err)
-		updatedRules = 0 // 如果获取失败，返回0
+		if respondRunInProgress(c, err) || respondShuttingDown(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrNoRollbackTarget) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该同步任务没有已生效的规则变更"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": result})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":       true,
-		"current_ip":    currentIP,
-		"updated_rules": updatedRules,
-		"message":       fmt.Sprintf("IP同步成功，当前IP: %s，已更新 %d 条规则", currentIP, updatedRules),
-	})
+	status := http.StatusOK
+	if result.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, result)
 }
 
-// getEnabledRulesCount 获取启用的规则数量
-func (h *ConfigHandler) getEnabledRulesCount() (int, error) {
+// GetActiveRun 获取正在运行的同步任务
+func (h *ConfigHandler) GetActiveRun(c *gin.Context) {
 	if h.firewallService == nil {
-		return 0, fmt.Errorf("防火墙服务不可用")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "防火墙服务不可用"})
+		return
+	}
+
+	run, running := h.firewallService.ActiveRun()
+	if !running {
+		c.JSON(http.StatusOK, gin.H{"running": false})
+		return
 	}
-	return h.firewallService.GetEnabledRulesCount()
+
+	c.JSON(http.StatusOK, gin.H{
+		"running":    true,
+		"run_id":     run.RunID,
+		"trigger":    run.Trigger,
+		"started_at": run.StartedAt,
+	})
 }
@@ -3,6 +3,7 @@ package v1
 import (
 	"FireFlow/internal/model"
 	"FireFlow/internal/service"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -11,6 +12,7 @@ import (
 
 type CronJobHandler struct {
 	configService service.ConfigService
+	audit         service.AuditService
 }
 
 func NewCronJobHandler(configService service.ConfigService) *CronJobHandler {
@@ -19,6 +21,25 @@ func NewCronJobHandler(configService service.ConfigService) *CronJobHandler {
 	}
 }
 
+// SetAuditService 设置审计日志服务
+func (h *CronJobHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// findCronJob 按ID查找定时任务，用于记录变更前的内容
+func (h *CronJobHandler) findCronJob(id uint) *model.CronJobConfig {
+	jobs, err := h.configService.GetAllCronJobs()
+	if err != nil {
+		return nil
+	}
+	for i := range jobs {
+		if jobs[i].ID == id {
+			return &jobs[i]
+		}
+	}
+	return nil
+}
+
 // GetCronJobs 获取所有定时任务
 func (h *CronJobHandler) GetCronJobs(c *gin.Context) {
 	jobs, err := h.configService.GetAllCronJobs()
@@ -37,7 +58,9 @@ func (h *CronJobHandler) CreateCronJob(c *gin.Context) {
 		return
 	}
 
-	if err := h.configService.CreateCronJob(&job); err != nil {
+	err := h.configService.CreateCronJob(&job)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cron_job.create", EntityType: "cron_job", EntityID: fmt.Sprint(job.ID), After: &job, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -60,7 +83,10 @@ func (h *CronJobHandler) UpdateCronJob(c *gin.Context) {
 	}
 
 	job.ID = uint(id)
-	if err := h.configService.UpdateCronJob(&job); err != nil {
+	before := h.findCronJob(job.ID)
+	err = h.configService.UpdateCronJob(&job)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cron_job.update", EntityType: "cron_job", EntityID: idStr, Before: before, After: &job, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -76,7 +102,10 @@ func (h *CronJobHandler) DeleteCronJob(c *gin.Context) {
 		return
 	}
 
-	if err := h.configService.DeleteCronJob(uint(id)); err != nil {
+	before := h.findCronJob(uint(id))
+	err = h.configService.DeleteCronJob(uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cron_job.delete", EntityType: "cron_job", EntityID: idStr, Before: before, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -92,7 +121,9 @@ func (h *CronJobHandler) RunCronJob(c *gin.Context) {
 		return
 	}
 
-	if err := h.configService.RunCronJob(uint(id)); err != nil {
+	err = h.configService.RunCronJob(uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "cron_job.run", EntityType: "cron_job", EntityID: idStr, Err: err})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
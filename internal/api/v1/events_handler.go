@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"FireFlow/internal/service"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SSE连接的心跳间隔，避免代理因连接空闲而断开
+const sseHeartbeatInterval = 15 * time.Second
+
+type EventsHandler struct {
+	events *service.EventBus
+}
+
+func NewEventsHandler(events *service.EventBus) *EventsHandler {
+	return &EventsHandler{
+		events: events,
+	}
+}
+
+// Stream 以Server-Sent Events推送同步进度事件。
+// 断线重连时浏览器携带 Last-Event-ID，会补发该ID之后仍保留的事件；
+// 受访问范围限制的用户只会收到范围内云服务配置的规则事件。
+func (h *EventsHandler) Stream(c *gin.Context) {
+	principal := currentPrincipal(c)
+
+	lastID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	events, cancel := h.events.Subscribe(lastID)
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 禁止Nginx缓冲
+	c.Status(http.StatusOK)
+	// 立即发送注释行，让客户端确认连接已建立
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.CloudConfigID != nil && !principal.CanAccessCloudConfig(*event.CloudConfigID) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to encode event", "event", event.Type, "error", err)
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			c.Writer.Flush()
+		}
+	}
+}
@@ -1,153 +1,349 @@
-package v1
-
-import (
-	"FireFlow/internal/model"
-	"FireFlow/internal/service"
-	"fmt"
-	"net/http"
-	"strconv"
-	"strings"
-
-	"github.com/gin-gonic/gin"
-)
-
-type FirewallHandler struct {
-	service       *service.FirewallService
-	configService service.ConfigService
-}
-
-func NewFirewallHandler(s *service.FirewallService) *FirewallHandler {
-	return &FirewallHandler{
-		service:       s,
-		configService: nil, // 将在需要时设置
-	}
-}
-
-// SetConfigService 设置配置服务
-func (h *FirewallHandler) SetConfigService(configService service.ConfigService) {
-	h.configService = configService
-}
-
-// GetRules handles GET /api/v1/rules
-func (h *FirewallHandler) GetRules(c *gin.Context) {
-	rules, err := h.service.GetAllRules()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, rules)
-}
-
-// CreateRule handles POST /api/v1/rules
-func (h *FirewallHandler) CreateRule(c *gin.Context) {
-	var rule model.FirewallRule
-	if err := c.ShouldBindJSON(&rule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// 验证必填字段
-	if strings.TrimSpace(rule.Remark) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "备注为必填项"})
-		return
-	}
-
-	// 如果提供了CloudConfigID，从云服务配置中获取Provider和InstanceID
-	if rule.CloudConfigID != 0 && h.configService != nil {
-		cloudConfig, err := h.getCloudConfigByID(rule.CloudConfigID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的云服务配置ID: " + err.Error()})
-			return
-		}
-
-		// 自动填充Provider和InstanceID
-		rule.Provider = cloudConfig.Provider
-		rule.InstanceID = cloudConfig.InstanceId
-	}
-
-	// 设置协议默认值
-	if rule.Protocol == "" {
-		rule.Protocol = "TCP"
-	}
-
-	// 当协议为ICMP时，强制端口为ALL
-	if rule.Protocol == "ICMP" || rule.Protocol == "ALL" {
-		rule.Port = "ALL"
-	}
-
-	if err := h.service.CreateRule(&rule); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusCreated, rule)
-}
-
-// getCloudConfigByID 根据ID获取云服务配置
-func (h *FirewallHandler) getCloudConfigByID(id uint) (*model.CloudProviderConfig, error) {
-	if h.configService == nil {
-		return nil, fmt.Errorf("配置服务不可用")
-	}
-
-	return h.configService.GetCloudConfigByID(id)
-}
-
-// DeleteRule handles DELETE /api/v1/rules/:id
-func (h *FirewallHandler) DeleteRule(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
-		return
-	}
-
-	if err := h.service.DeleteRule(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
-}
-
-// UpdateRule handles PUT /api/v1/rules/:id
-func (h *FirewallHandler) UpdateRule(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
-		return
-	}
-
-	var rule model.FirewallRule
-	if err := c.ShouldBindJSON(&rule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	rule.ID = uint(id)
-
-	// 当协议为ICMP或ALL时，强制端口为ALL
-	if rule.Protocol == "ICMP" || rule.Protocol == "ALL" {
-		rule.Port = "ALL"
-	}
-
-	if err := h.service.UpdateRule(&rule); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, rule)
-}
-
-// ExecuteRule handles POST /api/v1/rules/:id/execute
-func (h *FirewallHandler) ExecuteRule(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
-		return
-	}
-
-	if err := h.service.ExecuteRule(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "Rule executed successfully"})
-}
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type FirewallHandler struct {
+	service       *service.FirewallService
+	configService service.ConfigService
+}
+
+func NewFirewallHandler(s *service.FirewallService) *FirewallHandler {
+	return &FirewallHandler{
+		service:       s,
+		configService: nil, // 将在需要时设置
+	}
+}
+
+// SetConfigService 设置配置服务
+func (h *FirewallHandler) SetConfigService(configService service.ConfigService) {
+	h.configService = configService
+}
+
+// GetRules handles GET /api/v1/rules
+func (h *FirewallHandler) GetRules(c *gin.Context) {
+	rules, err := h.service.GetAllRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 只返回当前用户可访问的云服务配置下的规则
+	principal := currentPrincipal(c)
+	visible := make([]model.FirewallRule, 0, len(rules))
+	for _, rule := range rules {
+		if principal.CanAccessCloudConfig(rule.CloudConfigID) {
+			visible = append(visible, rule)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// CreateRuleRequest 创建规则时可提交的字段，与修改规则相同。云端规则ID、当前IP、固定状态、
+// 规则组和探测结果等由服务端维护，请求中的这些字段被忽略
+type CreateRuleRequest struct {
+	// Provider 只在不指定云服务配置、使用配置文件中的腾讯云客户端时需要，否则跟随云服务配置
+	Provider string `json:"provider"`
+	UpdateRuleRequest
+}
+
+// CreateRule handles POST /api/v1/rules
+func (h *FirewallHandler) CreateRule(c *gin.Context) {
+	var req CreateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := model.FirewallRule{Provider: req.Provider}
+	req.apply(&rule)
+
+	if !requireCloudConfigAccess(c, rule.CloudConfigID) {
+		return
+	}
+
+	// 如果提供了CloudConfigID，从云服务配置中获取Provider和InstanceID
+	if rule.CloudConfigID != 0 && h.configService != nil {
+		cloudConfig, err := h.getCloudConfigByID(rule.CloudConfigID)
+		if err != nil {
+			respondRuleError(c, service.NewFieldError("cloud_config_id", fmt.Sprintf("cloud config %d does not exist", rule.CloudConfigID)))
+			return
+		}
+
+		// 自动填充Provider和InstanceID；按标签选择实例或已指定实例时不使用配置中的实例
+		rule.Provider = cloudConfig.Provider
+		if !rule.MultiInstance() || (rule.InstanceID == "" && rule.InstanceSelector == "") {
+			rule.InstanceID = cloudConfig.InstanceId
+		}
+	}
+
+	// 协议、端口等字段由服务层规范化并校验
+	if err := h.service.CreateRule(&rule, auditActor(c)); err != nil {
+		respondRuleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// respondRuleError 写入保存规则失败的响应，校验失败时返回400和各字段的错误
+func respondRuleError(c *gin.Context, err error) {
+	var verr *service.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+	case errors.Is(err, service.ErrRuleManagedByGroup):
+		c.JSON(http.StatusConflict, gin.H{"error": "该规则由规则组维护，请修改规则组或模板"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// getCloudConfigByID 根据ID获取云服务配置
+func (h *FirewallHandler) getCloudConfigByID(id uint) (*model.CloudProviderConfig, error) {
+	if h.configService == nil {
+		return nil, fmt.Errorf("配置服务不可用")
+	}
+
+	return h.configService.GetCloudConfigByID(id)
+}
+
+// authorizeRule 检查当前用户能否操作指定规则，失败时写入响应并返回false
+func (h *FirewallHandler) authorizeRule(c *gin.Context, id uint) bool {
+	rule, err := h.service.GetRule(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "规则不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return false
+	}
+	return requireCloudConfigAccess(c, rule.CloudConfigID)
+}
+
+// DeleteRule handles DELETE /api/v1/rules/:id
+func (h *FirewallHandler) DeleteRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if !h.authorizeRule(c, uint(id)) {
+		return
+	}
+
+	if err := h.service.DeleteRule(uint(id), auditActor(c)); err != nil {
+		if errors.Is(err, service.ErrRuleManagedByGroup) {
+			c.JSON(http.StatusConflict, gin.H{"error": "该规则由规则组维护，请修改规则组或模板"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+}
+
+// UpdateRuleRequest 修改规则时可编辑的字段。规则ID、当前IP、云端规则ID和探测结果等由服务端维护，
+// 不接受客户端提交的值
+type UpdateRuleRequest struct {
+	CloudConfigID    uint   `json:"cloud_config_id"`
+	InstanceID       string `json:"instance_id"`
+	Port             string `json:"port"`
+	Protocol         string `json:"protocol"`
+	Enabled          bool   `json:"enabled"`
+	Remark           string `json:"remark"`
+	Action           string `json:"action"`
+	Source           string `json:"source"`
+	Priority         int    `json:"priority"`
+	InstanceIDs      string `json:"instance_ids"`
+	InstanceSelector string `json:"instance_selector"`
+	ProbeType        string `json:"probe_type"`
+	ProbePort        string `json:"probe_port"`
+	ProbePath        string `json:"probe_path"`
+}
+
+// apply 把可编辑的字段复制到已保存的规则上
+func (req *UpdateRuleRequest) apply(rule *model.FirewallRule) {
+	rule.CloudConfigID = req.CloudConfigID
+	rule.InstanceID = req.InstanceID
+	rule.Port = req.Port
+	rule.Protocol = req.Protocol
+	rule.Enabled = req.Enabled
+	rule.Remark = req.Remark
+	rule.Action = req.Action
+	rule.Source = req.Source
+	rule.Priority = req.Priority
+	rule.InstanceIDs = req.InstanceIDs
+	rule.InstanceSelector = req.InstanceSelector
+	rule.ProbeType = req.ProbeType
+	rule.ProbePort = req.ProbePort
+	rule.ProbePath = req.ProbePath
+}
+
+// UpdateRule handles PUT /api/v1/rules/:id
+func (h *FirewallHandler) UpdateRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req UpdateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 原规则和修改后的云服务配置都需要在访问范围内
+	if !h.authorizeRule(c, uint(id)) || !requireCloudConfigAccess(c, req.CloudConfigID) {
+		return
+	}
+	rule, err := h.service.GetRule(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req.apply(rule)
+
+	// 云服务商跟随云服务配置，未指定实例时使用配置中的实例
+	if rule.CloudConfigID != 0 && h.configService != nil {
+		cloudConfig, err := h.getCloudConfigByID(rule.CloudConfigID)
+		if err != nil {
+			respondRuleError(c, service.NewFieldError("cloud_config_id", fmt.Sprintf("cloud config %d does not exist", rule.CloudConfigID)))
+			return
+		}
+		rule.Provider = cloudConfig.Provider
+		if rule.InstanceID == "" && rule.InstanceSelector == "" {
+			rule.InstanceID = cloudConfig.InstanceId
+		}
+	}
+
+	if err := h.service.UpdateRule(rule, auditActor(c)); err != nil {
+		respondRuleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// ExecuteRule handles POST /api/v1/rules/:id/execute
+func (h *FirewallHandler) ExecuteRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if !h.authorizeRule(c, uint(id)) {
+		return
+	}
+
+	if err := h.service.ExecuteRule(c.Request.Context(), uint(id), auditActor(c)); err != nil {
+		if respondShuttingDown(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrProbeFailed) {
+			c.JSON(http.StatusOK, gin.H{"message": "Rule executed, but the connectivity probe failed", "probe_error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rule executed successfully"})
+}
+
+// GetRuleHistory handles GET /api/v1/rules/:id/history
+// 按时间倒序返回规则的来源变更记录，可通过 limit 参数限制数量
+func (h *FirewallHandler) GetRuleHistory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if !h.authorizeRule(c, uint(id)) {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	history, err := h.service.GetRuleHistory(uint(id), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// RollbackRule handles POST /api/v1/rules/:id/rollback
+// 请求体可选 {"operation_id": N}，恢复为该次变更之前的来源；未指定时回滚最近一次生效的变更
+func (h *FirewallHandler) RollbackRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if !h.authorizeRule(c, uint(id)) {
+		return
+	}
+
+	var req struct {
+		OperationID uint `json:"operation_id"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	result, err := h.service.RollbackRule(c.Request.Context(), uint(id), req.OperationID, auditActor(c))
+	if err != nil {
+		if respondRunInProgress(c, err) || respondShuttingDown(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrNoRollbackTarget) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有可回滚的历史来源"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ProbeRule handles POST /api/v1/rules/:id/probe
+// 立即探测规则端口能否从本机访问，结果保存在规则的 probe_* 字段中
+func (h *FirewallHandler) ProbeRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if !h.authorizeRule(c, uint(id)) {
+		return
+	}
+
+	result, err := h.service.ProbeRule(c.Request.Context(), uint(id))
+	switch {
+	case errors.Is(err, service.ErrProbeNotConfigured):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "规则未配置连通性探测"})
+	case err != nil && result == nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		// 探测失败也返回200，结果中 healthy 为 false
+		c.JSON(http.StatusOK, result)
+	}
+}
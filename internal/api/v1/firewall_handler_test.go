@@ -0,0 +1,231 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/internal/service"
+	"FireFlow/pkg/cloud"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+// ruleTestEnv 规则、模板和规则组接口的测试环境：SQLite数据库、两个模拟云服务配置和以principal身份调用的路由
+type ruleTestEnv struct {
+	t         *testing.T
+	db        *gorm.DB
+	router    *gin.Engine
+	configs   []uint
+	firewall  repository.FirewallRepository
+	cloud     service.ConfigService
+	rules     *FirewallHandler
+	groups    service.RuleGroupService
+	principal *service.Principal // 默认为管理员
+}
+
+var adminPrincipal = &service.Principal{UserID: 1, Username: "admin", Role: model.RoleAdmin}
+
+func newRuleTestEnv(t *testing.T) *ruleTestEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: "sqlite",
+		DSN:        filepath.Join(t.TempDir(), "fireflow.db"),
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&model.FirewallRule{}, &model.FirewallRuleTarget{}, &model.ConfigItem{}, &model.CloudProviderConfig{},
+		&model.RuleOperation{}, &model.CloudInstance{}, &model.RuleTemplate{}, &model.RuleTemplateItem{},
+		&model.RuleGroup{}, &model.RuleGroupTarget{}, &model.User{}, &model.UserCloudScope{}, &model.Session{}, &model.APIToken{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	env := &ruleTestEnv{t: t, db: db, firewall: repository.NewFirewallRepo(db), principal: adminPrincipal}
+	configService := service.NewConfigService(repository.NewConfigRepository(db, nil))
+	env.cloud = configService
+	for _, instanceID := range []string{"lhins-a", "lhins-b"} {
+		config := &model.CloudProviderConfig{
+			Provider:   cloud.ProviderFake,
+			SecretId:   t.Name() + "/" + instanceID,
+			SecretKey:  "secret",
+			Region:     "ap-test",
+			InstanceId: instanceID,
+			IsEnabled:  true,
+		}
+		if err := configService.CreateCloudConfig(config); err != nil {
+			t.Fatalf("failed to create cloud config: %v", err)
+		}
+		env.configs = append(env.configs, config.ID)
+	}
+
+	firewallService := service.NewFirewallService(env.firewall, configService)
+	handler := NewFirewallHandler(firewallService)
+	handler.SetConfigService(configService)
+	env.rules = handler
+	env.groups = service.NewRuleGroupService(repository.NewRuleTemplateRepository(db), repository.NewRuleGroupRepository(db), configService, firewallService)
+	groupHandler := NewRuleGroupHandler(env.groups)
+
+	env.router = gin.New()
+	env.router.Use(func(c *gin.Context) {
+		c.Set(principalContextKey, env.principal)
+		c.Next()
+	})
+	env.router.POST("/api/v1/rules", handler.CreateRule)
+	env.router.PUT("/api/v1/rules/:id", handler.UpdateRule)
+	env.router.POST("/api/v1/rule-templates", groupHandler.CreateTemplate)
+	env.router.DELETE("/api/v1/rule-templates/:id", groupHandler.DeleteTemplate)
+	env.router.POST("/api/v1/rule-groups", groupHandler.CreateGroup)
+	env.router.PUT("/api/v1/rule-groups/:id", groupHandler.UpdateGroup)
+	return env
+}
+
+// do 发送JSON请求，返回状态码和解析后的响应
+func (e *ruleTestEnv) do(method, path string, body any) (int, map[string]any) {
+	e.t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		e.t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+func (e *ruleTestEnv) onlyRule() model.FirewallRule {
+	e.t.Helper()
+	rules, err := e.firewall.GetAll()
+	if err != nil {
+		e.t.Fatalf("failed to list rules: %v", err)
+	}
+	if len(rules) != 1 {
+		e.t.Fatalf("got %d rules, want 1", len(rules))
+	}
+	return rules[0]
+}
+
+func TestCreateRuleIgnoresServerManagedFields(t *testing.T) {
+	env := newRuleTestEnv(t)
+
+	code, resp := env.do(http.MethodPost, "/api/v1/rules", map[string]any{
+		"cloud_config_id":  env.configs[0],
+		"port":             "22",
+		"protocol":         "TCP",
+		"remark":           "ssh",
+		"enabled":          true,
+		"rule_id":          "lh-forged",
+		"last_ip":          "203.0.113.9",
+		"pinned":           true,
+		"group_id":         7,
+		"template_item_id": 9,
+		"probe_status":     model.ProbeHealthy,
+		"probe_error":      "forged",
+		"probe_latency_ms": 12,
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("CreateRule status %d: %v", code, resp)
+	}
+
+	rule := env.onlyRule()
+	if rule.RuleID != "" || rule.LastIP != "" || rule.Pinned || rule.GroupID != 0 || rule.TemplateItemID != 0 {
+		t.Errorf("server managed fields were stored: rule_id %q, last_ip %q, pinned %v, group_id %d, template_item_id %d",
+			rule.RuleID, rule.LastIP, rule.Pinned, rule.GroupID, rule.TemplateItemID)
+	}
+	if rule.ProbeStatus != "" || rule.ProbeError != "" || rule.ProbeLatencyMs != 0 {
+		t.Errorf("probe results were stored: %q %q %d", rule.ProbeStatus, rule.ProbeError, rule.ProbeLatencyMs)
+	}
+	// 云服务商和实例跟随云服务配置
+	if rule.Provider != cloud.ProviderFake || rule.InstanceID != "lhins-a" {
+		t.Errorf("rule provider %q, instance %q", rule.Provider, rule.InstanceID)
+	}
+}
+
+func (e *ruleTestEnv) cloudConfigCount() int64 {
+	e.t.Helper()
+	var count int64
+	if err := e.db.Model(&model.CloudProviderConfig{}).Count(&count).Error; err != nil {
+		e.t.Fatalf("failed to count cloud configs: %v", err)
+	}
+	return count
+}
+
+// 嵌套的 cloud_config 不能把规则改到调用方无权访问的配置，也不能创建新的云服务配置
+func TestRuleCloudConfigAssociationIsNotWritable(t *testing.T) {
+	tests := []struct {
+		name        string
+		cloudConfig func(env *ruleTestEnv) map[string]any
+	}{
+		{
+			name: "existing config outside scope",
+			cloudConfig: func(env *ruleTestEnv) map[string]any {
+				return map[string]any{"ID": env.configs[1]}
+			},
+		},
+		{
+			name: "new config",
+			cloudConfig: func(env *ruleTestEnv) map[string]any {
+				return map[string]any{"provider": cloud.ProviderFake, "secret_id": "injected", "secret_key": "injected", "region": "ap-test"}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newRuleTestEnv(t)
+			// 只能访问第一个云服务配置的运维用户
+			env.principal = &service.Principal{UserID: 2, Username: "operator", Role: model.RoleOperator, CloudConfigIDs: []uint{env.configs[0]}}
+
+			body := map[string]any{
+				"cloud_config_id": env.configs[0],
+				"port":            "22",
+				"protocol":        "TCP",
+				"remark":          "ssh",
+				"enabled":         true,
+				"cloud_config":    tt.cloudConfig(env),
+			}
+			code, resp := env.do(http.MethodPost, "/api/v1/rules", body)
+			if code != http.StatusCreated {
+				t.Fatalf("CreateRule status %d: %v", code, resp)
+			}
+			rule := env.onlyRule()
+			if rule.CloudConfigID != env.configs[0] {
+				t.Errorf("created rule cloud_config_id = %d, want %d", rule.CloudConfigID, env.configs[0])
+			}
+
+			body["port"] = "2222"
+			code, resp = env.do(http.MethodPut, "/api/v1/rules/"+strconv.Itoa(int(rule.ID)), body)
+			if code != http.StatusOK {
+				t.Fatalf("UpdateRule status %d: %v", code, resp)
+			}
+			if rule := env.onlyRule(); rule.CloudConfigID != env.configs[0] {
+				t.Errorf("updated rule cloud_config_id = %d, want %d", rule.CloudConfigID, env.configs[0])
+			}
+			if count := env.cloudConfigCount(); count != int64(len(env.configs)) {
+				t.Errorf("got %d cloud configs, want %d", count, len(env.configs))
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package v1
+
+import (
+	"FireFlow/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	healthService service.HealthService
+}
+
+func NewHealthHandler(healthService service.HealthService) *HealthHandler {
+	return &HealthHandler{
+		healthService: healthService,
+	}
+}
+
+// Healthz 存活检查，进程能处理请求即返回200
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":         service.HealthOK,
+		"uptime_seconds": int64(h.healthService.Uptime().Seconds()),
+	})
+}
+
+// Readyz 就绪检查，任一依赖降级时返回503
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	report := h.healthService.Ready(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != service.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,66 @@
+package v1
+
+import (
+	"FireFlow/internal/logging"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDContextKey 请求ID在gin.Context中的键
+const requestIDContextKey = "request_id"
+
+// RequestIDMiddleware 沿用客户端传入的 X-Request-ID（格式不合法时重新生成），
+// 写入响应头并放入请求context，供后续日志和审计记录关联
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(logging.RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// AccessLogMiddleware 使用结构化日志记录每个请求，5xx记为error，4xx记为warn
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", path,
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if principal := currentPrincipal(c); principal != nil {
+			attrs = append(attrs, "user", principal.Username)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		slog.Log(c.Request.Context(), level, "http request", attrs...)
+	}
+}
+
+// requestID 返回当前请求的请求ID
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
@@ -0,0 +1,26 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsHandler 暴露Prometheus指标。token不为空时要求请求携带 Authorization: Bearer <token>，
+// 与用户API令牌无关，便于在Prometheus的抓取配置中单独设置。
+func MetricsHandler(token string) gin.HandlerFunc {
+	handler := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token != "" {
+			provided, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "指标令牌无效"})
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,149 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type NotificationHandler struct {
+	notificationService service.NotificationService
+	audit               service.AuditService
+}
+
+func NewNotificationHandler(notificationService service.NotificationService) *NotificationHandler {
+	return &NotificationHandler{
+		notificationService: notificationService,
+	}
+}
+
+// SetAuditService 设置审计日志服务
+func (h *NotificationHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// GetChannels handles GET /api/v1/notification-channels
+func (h *NotificationHandler) GetChannels(c *gin.Context) {
+	channels, err := h.notificationService.ListChannels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range channels {
+		channels[i].Config = service.MaskChannelConfig(channels[i].Config)
+	}
+	c.JSON(http.StatusOK, channels)
+}
+
+// GetTypes handles GET /api/v1/notification-channels/types
+func (h *NotificationHandler) GetTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, h.notificationService.TypeInfo())
+}
+
+// CreateChannel handles POST /api/v1/notification-channels
+func (h *NotificationHandler) CreateChannel(c *gin.Context) {
+	var channel model.NotificationChannel
+	if err := c.ShouldBindJSON(&channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.notificationService.CreateChannel(&channel)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "notification_channel.create", EntityType: "notification_channel", EntityID: fmt.Sprint(channel.ID), After: maskedChannel(&channel), Err: err})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channel.Config = service.MaskChannelConfig(channel.Config)
+	c.JSON(http.StatusCreated, channel)
+}
+
+// UpdateChannel handles PUT /api/v1/notification-channels/:id
+func (h *NotificationHandler) UpdateChannel(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var channel model.NotificationChannel
+	if err := c.ShouldBindJSON(&channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel.ID = uint(id)
+	before, _ := h.notificationService.GetChannel(channel.ID)
+	err = h.notificationService.UpdateChannel(&channel)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "notification_channel.update", EntityType: "notification_channel", EntityID: idStr, Before: maskedChannel(before), After: maskedChannel(&channel), Err: err})
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	channel.Config = service.MaskChannelConfig(channel.Config)
+	c.JSON(http.StatusOK, channel)
+}
+
+// DeleteChannel handles DELETE /api/v1/notification-channels/:id
+func (h *NotificationHandler) DeleteChannel(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	before, _ := h.notificationService.GetChannel(uint(id))
+	err = h.notificationService.DeleteChannel(uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "notification_channel.delete", EntityType: "notification_channel", EntityID: idStr, Before: maskedChannel(before), Err: err})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification channel deleted successfully"})
+}
+
+// TestChannel handles POST /api/v1/notification-channels/:id/test
+func (h *NotificationHandler) TestChannel(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := h.notificationService.SendTest(uint(id)); err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"success": false,
+			"error":   "发送测试通知失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "测试通知已发送",
+	})
+}
+
+// maskedChannel 返回掩码了敏感配置的副本，用于审计日志
+func maskedChannel(channel *model.NotificationChannel) *model.NotificationChannel {
+	if channel == nil {
+		return nil
+	}
+	masked := *channel
+	masked.Config = service.MaskChannelConfig(channel.Config)
+	return &masked
+}
+
+func channelErrorStatus(err error) int {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -1,67 +1,197 @@
-package v1
-
-import (
-	"FireFlow/internal/core"
-	"FireFlow/internal/service"
-
-	"github.com/gin-gonic/gin"
-)
-
-// RegisterRoutes registers all v1 API routes.
-func RegisterRoutes(router *gin.RouterGroup, firewallService *service.FirewallService, configService service.ConfigService, cronManager *core.CronManager) {
-	firewallHandler := NewFirewallHandler(firewallService)
-	firewallHandler.SetConfigService(configService) // 设置配置服务
-	configHandler := NewConfigHandler(configService, cronManager)
-	configHandler.SetFirewallService(firewallService) // 设置防火墙服务
-	cloudConfigHandler := NewCloudConfigHandler(configService)
-	cronJobHandler := NewCronJobHandler(configService)
-
-	// 防火墙规则路由
-	ruleRoutes := router.Group("/rules")
-	{
-		ruleRoutes.GET("/", firewallHandler.GetRules)
-		ruleRoutes.POST("/", firewallHandler.CreateRule)
-		ruleRoutes.PUT("/:id", firewallHandler.UpdateRule)
-		ruleRoutes.DELETE("/:id", firewallHandler.DeleteRule)
-		ruleRoutes.POST("/:id/execute", firewallHandler.ExecuteRule)
-	}
-
-	// 云服务配置路由
-	cloudConfigRoutes := router.Group("/cloud-configs")
-	{
-		cloudConfigRoutes.GET("/", cloudConfigHandler.GetCloudConfigs)
-		cloudConfigRoutes.POST("/", cloudConfigHandler.CreateCloudConfig)
-		cloudConfigRoutes.PUT("/:id", cloudConfigHandler.UpdateCloudConfig)
-		cloudConfigRoutes.DELETE("/:id", cloudConfigHandler.DeleteCloudConfig)
-		cloudConfigRoutes.POST("/:id/test", cloudConfigHandler.TestCloudConfig)
-	}
-
-	// 定时任务路由
-	cronJobRoutes := router.Group("/cron-jobs")
-	{
-		cronJobRoutes.GET("/", cronJobHandler.GetCronJobs)
-		cronJobRoutes.POST("/", cronJobHandler.CreateCronJob)
-		cronJobRoutes.PUT("/:id", cronJobHandler.UpdateCronJob)
-		cronJobRoutes.DELETE("/:id", cronJobHandler.DeleteCronJob)
-		cronJobRoutes.POST("/:id/run", cronJobHandler.RunCronJob)
-	}
-
-	// 配置路由
-	configRoutes := router.Group("/config")
-	{
-		configRoutes.GET("/:key", configHandler.GetConfig)
-		configRoutes.POST("/", configHandler.SetConfig)
-		configRoutes.GET("/category/:category", configHandler.GetConfigsByCategory)
-	}
-
-	// 系统配置路由
-	systemRoutes := router.Group("/system-config")
-	{
-		systemRoutes.GET("/", configHandler.GetSystemConfig)
-		systemRoutes.PUT("/", configHandler.SetSystemConfig)
-	}
-
-	// IP同步路由
-	router.POST("/sync-ip/", configHandler.SyncIPNow)
-	router.GET("/current-ip/", configHandler.GetCurrentIP)
-}
+package v1
+
+import (
+	"FireFlow/internal/core"
+	"FireFlow/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes registers all v1 API routes.
+// 除登录、初始化接口外，所有路由都需要会话Cookie或Bearer API令牌认证。
+// 各路由按角色权限授权，受访问范围限制的用户只能操作范围内云服务配置下的规则。
+func RegisterRoutes(router *gin.RouterGroup, firewallService *service.FirewallService, configService service.ConfigService, cronManager *core.CronManager, authService service.AuthService, userService service.UserService, auditService service.AuditService, notificationService service.NotificationService, safeguardService service.SafeguardService, ruleGroupService service.RuleGroupService, cloudInstanceService service.CloudInstanceService, eventBus *service.EventBus) {
+	firewallHandler := NewFirewallHandler(firewallService)
+	firewallHandler.SetConfigService(configService) // 设置配置服务
+	configHandler := NewConfigHandler(configService, cronManager)
+	configHandler.SetFirewallService(firewallService) // 设置防火墙服务
+	cloudConfigHandler := NewCloudConfigHandler(configService)
+	cloudConfigHandler.SetCloudInstanceService(cloudInstanceService)
+	cloudInstanceHandler := NewCloudInstanceHandler(cloudInstanceService)
+	cronJobHandler := NewCronJobHandler(configService)
+	authHandler := NewAuthHandler(authService)
+	userHandler := NewUserHandler(userService)
+	auditHandler := NewAuditHandler(auditService)
+	notificationHandler := NewNotificationHandler(notificationService)
+	eventsHandler := NewEventsHandler(eventBus)
+	safeguardHandler := NewSafeguardHandler(safeguardService)
+	ruleGroupHandler := NewRuleGroupHandler(ruleGroupService)
+
+	// 各处理器记录变更审计日志
+	configHandler.SetAuditService(auditService)
+	cloudConfigHandler.SetAuditService(auditService)
+	cronJobHandler.SetAuditService(auditService)
+	authHandler.SetAuditService(auditService)
+	userHandler.SetAuditService(auditService)
+	notificationHandler.SetAuditService(auditService)
+	safeguardHandler.SetAuditService(auditService)
+	cloudInstanceHandler.SetAuditService(auditService)
+
+	view := RequirePermission(service.PermView)
+	execute := RequirePermission(service.PermExecute)
+	manageRules := RequirePermission(service.PermManageRules)
+	manageCloud := RequirePermission(service.PermManageCloud)
+	manageSystem := RequirePermission(service.PermManageSystem)
+
+	// 认证路由（无需登录）
+	authRoutes := router.Group("/auth")
+	{
+		authRoutes.GET("/status", authHandler.GetStatus)
+		authRoutes.POST("/setup", authHandler.Setup)
+		authRoutes.POST("/login", authHandler.Login)
+	}
+
+	// 以下路由均需认证
+	protected := router.Group("", AuthMiddleware(authService))
+
+	// 当前用户及API令牌路由
+	accountRoutes := protected.Group("/auth")
+	{
+		accountRoutes.POST("/logout", authHandler.Logout)
+		accountRoutes.GET("/me", authHandler.GetCurrentUser)
+		accountRoutes.PUT("/password", authHandler.ChangePassword)
+		accountRoutes.GET("/tokens", authHandler.GetTokens)
+		accountRoutes.POST("/tokens", authHandler.CreateToken)
+		accountRoutes.DELETE("/tokens/:id", authHandler.DeleteToken)
+	}
+
+	// 防火墙规则路由
+	ruleRoutes := protected.Group("/rules")
+	{
+		ruleRoutes.GET("/", view, firewallHandler.GetRules)
+		ruleRoutes.POST("/", manageRules, firewallHandler.CreateRule)
+		ruleRoutes.PUT("/:id", manageRules, firewallHandler.UpdateRule)
+		ruleRoutes.DELETE("/:id", manageRules, firewallHandler.DeleteRule)
+		ruleRoutes.POST("/:id/execute", execute, firewallHandler.ExecuteRule)
+		ruleRoutes.POST("/:id/probe", execute, firewallHandler.ProbeRule)
+		ruleRoutes.GET("/:id/history", view, firewallHandler.GetRuleHistory)
+		ruleRoutes.POST("/:id/rollback", manageRules, firewallHandler.RollbackRule)
+	}
+
+	// 规则模板路由
+	templateRoutes := protected.Group("/rule-templates")
+	{
+		templateRoutes.GET("/", view, ruleGroupHandler.GetTemplates)
+		templateRoutes.POST("/", manageRules, ruleGroupHandler.CreateTemplate)
+		templateRoutes.PUT("/:id", manageRules, ruleGroupHandler.UpdateTemplate)
+		templateRoutes.DELETE("/:id", manageRules, ruleGroupHandler.DeleteTemplate)
+	}
+
+	// 规则组路由
+	groupRoutes := protected.Group("/rule-groups")
+	{
+		groupRoutes.GET("/", view, ruleGroupHandler.GetGroups)
+		groupRoutes.GET("/:id", view, ruleGroupHandler.GetGroup)
+		groupRoutes.POST("/", manageRules, ruleGroupHandler.CreateGroup)
+		groupRoutes.PUT("/:id", manageRules, ruleGroupHandler.UpdateGroup)
+		groupRoutes.DELETE("/:id", manageRules, ruleGroupHandler.DeleteGroup)
+		groupRoutes.POST("/:id/enable", manageRules, ruleGroupHandler.EnableGroup)
+		groupRoutes.POST("/:id/disable", manageRules, ruleGroupHandler.DisableGroup)
+		groupRoutes.POST("/:id/sync", execute, ruleGroupHandler.SyncGroup)
+	}
+
+	// 云服务配置路由
+	cloudConfigRoutes := protected.Group("/cloud-configs")
+	{
+		cloudConfigRoutes.GET("/", view, cloudConfigHandler.GetCloudConfigs)
+		cloudConfigRoutes.POST("/", manageCloud, cloudConfigHandler.CreateCloudConfig)
+		cloudConfigRoutes.PUT("/:id", manageCloud, cloudConfigHandler.UpdateCloudConfig)
+		cloudConfigRoutes.DELETE("/:id", manageCloud, cloudConfigHandler.DeleteCloudConfig)
+		cloudConfigRoutes.POST("/:id/test", manageCloud, cloudConfigHandler.TestCloudConfig)
+		cloudConfigRoutes.GET("/:id/inventory", view, cloudInstanceHandler.GetInventory)
+		cloudConfigRoutes.GET("/:id/instances/discover", manageCloud, cloudInstanceHandler.DiscoverInstances)
+		cloudConfigRoutes.POST("/:id/inventory/refresh", manageCloud, cloudInstanceHandler.RefreshInventory)
+	}
+
+	// 云服务配置下管理的实例路由
+	cloudInstanceRoutes := protected.Group("/cloud-instances")
+	{
+		cloudInstanceRoutes.GET("/", view, cloudInstanceHandler.GetInstances)
+		cloudInstanceRoutes.POST("/", manageCloud, cloudInstanceHandler.CreateInstance)
+		cloudInstanceRoutes.PUT("/:id", manageCloud, cloudInstanceHandler.UpdateInstance)
+		cloudInstanceRoutes.DELETE("/:id", manageCloud, cloudInstanceHandler.DeleteInstance)
+	}
+
+	// 实例防锁死保护路由
+	safeguardRoutes := protected.Group("/safeguards")
+	{
+		safeguardRoutes.GET("/", view, safeguardHandler.GetSafeguards)
+		safeguardRoutes.POST("/", manageCloud, safeguardHandler.CreateSafeguard)
+		safeguardRoutes.PUT("/:id", manageCloud, safeguardHandler.UpdateSafeguard)
+		safeguardRoutes.DELETE("/:id", manageCloud, safeguardHandler.DeleteSafeguard)
+	}
+
+	// 定时任务路由
+	cronJobRoutes := protected.Group("/cron-jobs")
+	{
+		cronJobRoutes.GET("/", view, cronJobHandler.GetCronJobs)
+		cronJobRoutes.POST("/", manageSystem, cronJobHandler.CreateCronJob)
+		cronJobRoutes.PUT("/:id", manageSystem, cronJobHandler.UpdateCronJob)
+		cronJobRoutes.DELETE("/:id", manageSystem, cronJobHandler.DeleteCronJob)
+		cronJobRoutes.POST("/:id/run", manageSystem, cronJobHandler.RunCronJob)
+	}
+
+	// 配置路由
+	configRoutes := protected.Group("/config")
+	{
+		configRoutes.GET("/:key", view, configHandler.GetConfig)
+		configRoutes.POST("/", manageSystem, configHandler.SetConfig)
+		configRoutes.GET("/category/:category", view, configHandler.GetConfigsByCategory)
+	}
+
+	// 系统配置路由
+	systemRoutes := protected.Group("/system-config")
+	{
+		systemRoutes.GET("/", view, configHandler.GetSystemConfig)
+		systemRoutes.PUT("/", manageSystem, configHandler.SetSystemConfig)
+	}
+
+	// IP同步路由
+	protected.POST("/sync-ip/", execute, configHandler.SyncIPNow)
+	protected.GET("/current-ip/", view, configHandler.GetCurrentIP)
+
+	// 同步任务路由
+	runRoutes := protected.Group("/runs")
+	{
+		runRoutes.GET("/active", view, configHandler.GetActiveRun)
+		runRoutes.DELETE("/:id", execute, configHandler.CancelRun)
+		runRoutes.POST("/:id/rollback", manageRules, configHandler.RollbackRun)
+	}
+
+	// 同步进度事件流（SSE）
+	protected.GET("/events", view, eventsHandler.Stream)
+
+	// 用户与角色管理路由（仅管理员）
+	userRoutes := protected.Group("/users", RequirePermission(service.PermManageUsers))
+	{
+		userRoutes.GET("/", userHandler.GetUsers)
+		userRoutes.GET("/roles", userHandler.GetRoles)
+		userRoutes.POST("/", userHandler.CreateUser)
+		userRoutes.PUT("/:id", userHandler.UpdateUser)
+		userRoutes.DELETE("/:id", userHandler.DeleteUser)
+	}
+
+	// 通知渠道路由
+	notificationRoutes := protected.Group("/notification-channels", manageSystem)
+	{
+		notificationRoutes.GET("/", notificationHandler.GetChannels)
+		notificationRoutes.GET("/types", notificationHandler.GetTypes)
+		notificationRoutes.POST("/", notificationHandler.CreateChannel)
+		notificationRoutes.PUT("/:id", notificationHandler.UpdateChannel)
+		notificationRoutes.DELETE("/:id", notificationHandler.DeleteChannel)
+		notificationRoutes.POST("/:id/test", notificationHandler.TestChannel)
+	}
+
+	// 审计日志路由
+	protected.GET("/audit", RequirePermission(service.PermViewAudit), auditHandler.GetAuditLogs)
+}
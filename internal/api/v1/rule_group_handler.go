@@ -0,0 +1,328 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RuleGroupHandler struct {
+	groupService service.RuleGroupService
+}
+
+func NewRuleGroupHandler(groupService service.RuleGroupService) *RuleGroupHandler {
+	return &RuleGroupHandler{
+		groupService: groupService,
+	}
+}
+
+// GetTemplates handles GET /api/v1/rule-templates
+func (h *RuleGroupHandler) GetTemplates(c *gin.Context) {
+	templates, err := h.groupService.ListTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// CreateTemplate handles POST /api/v1/rule-templates
+func (h *RuleGroupHandler) CreateTemplate(c *gin.Context) {
+	var template model.RuleTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.groupService.CreateTemplate(&template, auditActor(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, template)
+}
+
+// UpdateTemplate handles PUT /api/v1/rule-templates/:id
+// 修改会重新应用到使用该模板的所有规则组，因此要求能访问全部云服务配置
+func (h *RuleGroupHandler) UpdateTemplate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !requireUnrestricted(c) {
+		return
+	}
+
+	var template model.RuleTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template.ID = uint(id)
+	if err := h.groupService.UpdateTemplate(&template, auditActor(c)); err != nil {
+		c.JSON(ruleGroupErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// DeleteTemplate handles DELETE /api/v1/rule-templates/:id
+// 与修改模板相同，要求能访问全部云服务配置
+func (h *RuleGroupHandler) DeleteTemplate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !requireUnrestricted(c) {
+		return
+	}
+
+	if err := h.groupService.DeleteTemplate(uint(id), auditActor(c)); err != nil {
+		if errors.Is(err, service.ErrTemplateInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": "模板仍被规则组使用，请先删除相关规则组"})
+			return
+		}
+		c.JSON(ruleGroupErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rule template deleted successfully"})
+}
+
+// GetGroups handles GET /api/v1/rule-groups
+func (h *RuleGroupHandler) GetGroups(c *gin.Context) {
+	groups, err := h.groupService.ListGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 只返回所有实例都在当前用户访问范围内的规则组
+	principal := currentPrincipal(c)
+	visible := make([]model.RuleGroup, 0, len(groups))
+	for _, group := range groups {
+		if canAccessGroup(principal, &group) {
+			visible = append(visible, group)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// GetGroup handles GET /api/v1/rule-groups/:id，返回规则组及其成员规则
+func (h *RuleGroupHandler) GetGroup(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	group, err := h.groupService.GetGroup(uint(id))
+	if err != nil {
+		c.JSON(ruleGroupErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !requireGroupAccess(c, &group.RuleGroup) {
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// RuleGroupRequest 创建和修改规则组时可提交的字段。启用状态通过启用/停用接口修改；
+// 实例只能按云服务配置和实例ID指定，由服务端新建实例记录，不能引用已有的记录
+type RuleGroupRequest struct {
+	Name       string                   `json:"name"`
+	TemplateID uint                     `json:"template_id"`
+	Remark     string                   `json:"remark"`
+	Targets    []RuleGroupTargetRequest `json:"targets"`
+}
+
+// RuleGroupTargetRequest 规则组应用的实例，InstanceID为空时使用云服务配置中的实例
+type RuleGroupTargetRequest struct {
+	CloudConfigID uint   `json:"cloud_config_id"`
+	InstanceID    string `json:"instance_id"`
+}
+
+// group 转换为待保存的规则组
+func (req *RuleGroupRequest) group() model.RuleGroup {
+	group := model.RuleGroup{
+		Name:       req.Name,
+		TemplateID: req.TemplateID,
+		Remark:     req.Remark,
+		Targets:    make([]model.RuleGroupTarget, 0, len(req.Targets)),
+	}
+	for _, target := range req.Targets {
+		group.Targets = append(group.Targets, model.RuleGroupTarget{CloudConfigID: target.CloudConfigID, InstanceID: target.InstanceID})
+	}
+	return group
+}
+
+// CreateGroup handles POST /api/v1/rule-groups
+func (h *RuleGroupHandler) CreateGroup(c *gin.Context) {
+	var req RuleGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	group := req.group()
+	if !requireGroupAccess(c, &group) {
+		return
+	}
+
+	if err := h.groupService.CreateGroup(&group, auditActor(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, group)
+}
+
+// UpdateGroup handles PUT /api/v1/rule-groups/:id
+func (h *RuleGroupHandler) UpdateGroup(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !h.authorizeGroup(c, uint(id)) {
+		return
+	}
+
+	var req RuleGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	group := req.group()
+	// 不能把规则组应用到无权访问的云服务配置
+	if !requireGroupAccess(c, &group) {
+		return
+	}
+
+	group.ID = uint(id)
+	if err := h.groupService.UpdateGroup(&group, auditActor(c)); err != nil {
+		c.JSON(ruleGroupErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// DeleteGroup handles DELETE /api/v1/rule-groups/:id
+func (h *RuleGroupHandler) DeleteGroup(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !h.authorizeGroup(c, uint(id)) {
+		return
+	}
+
+	if err := h.groupService.DeleteGroup(uint(id), auditActor(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rule group deleted successfully"})
+}
+
+// EnableGroup handles POST /api/v1/rule-groups/:id/enable
+func (h *RuleGroupHandler) EnableGroup(c *gin.Context) {
+	h.setGroupEnabled(c, true)
+}
+
+// DisableGroup handles POST /api/v1/rule-groups/:id/disable
+func (h *RuleGroupHandler) DisableGroup(c *gin.Context) {
+	h.setGroupEnabled(c, false)
+}
+
+func (h *RuleGroupHandler) setGroupEnabled(c *gin.Context, enabled bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !h.authorizeGroup(c, uint(id)) {
+		return
+	}
+
+	if err := h.groupService.SetGroupEnabled(uint(id), enabled, auditActor(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "enabled": enabled})
+}
+
+// SyncGroup handles POST /api/v1/rule-groups/:id/sync
+func (h *RuleGroupHandler) SyncGroup(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if !h.authorizeGroup(c, uint(id)) {
+		return
+	}
+
+	result, err := h.groupService.SyncGroup(c.Request.Context(), uint(id), auditActor(c))
+	if err != nil {
+		if respondRunInProgress(c, err) || respondShuttingDown(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrRuleGroupDisabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": "规则组已停用"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+
+	status := http.StatusOK
+	if result.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, result)
+}
+
+// authorizeGroup 检查当前用户能否操作指定规则组，失败时写入响应并返回false
+func (h *RuleGroupHandler) authorizeGroup(c *gin.Context, id uint) bool {
+	group, err := h.groupService.GetGroup(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "规则组不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return false
+	}
+	return requireGroupAccess(c, &group.RuleGroup)
+}
+
+// canAccessGroup 规则组的所有实例是否都在访问范围内
+func canAccessGroup(principal *service.Principal, group *model.RuleGroup) bool {
+	for _, target := range group.Targets {
+		if !principal.CanAccessCloudConfig(target.CloudConfigID) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireGroupAccess 要求当前用户能访问规则组的所有云服务配置，无权访问时写入403响应并返回false
+func requireGroupAccess(c *gin.Context, group *model.RuleGroup) bool {
+	if canAccessGroup(currentPrincipal(c), group) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "无权访问规则组中的云服务配置"})
+	return false
+}
+
+func ruleGroupErrorStatus(err error) int {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
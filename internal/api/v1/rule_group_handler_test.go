@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// scopedOperator 只能访问第一个云服务配置的运维用户
+func (e *ruleTestEnv) scopedOperator() *service.Principal {
+	return &service.Principal{UserID: 2, Username: "operator", Role: model.RoleOperator, CloudConfigIDs: []uint{e.configs[0]}}
+}
+
+func (e *ruleTestEnv) createTemplate(name string) *model.RuleTemplate {
+	e.t.Helper()
+	template := &model.RuleTemplate{
+		Name:  name,
+		Items: []model.RuleTemplateItem{{Protocol: "TCP", Port: "22", Action: "ACCEPT", Remark: "{group} ssh"}},
+	}
+	if err := e.groups.CreateTemplate(template, service.SystemActor); err != nil {
+		e.t.Fatalf("failed to create template: %v", err)
+	}
+	return template
+}
+
+func (e *ruleTestEnv) getGroup(id uint) *model.RuleGroup {
+	e.t.Helper()
+	group, err := e.groups.GetGroup(id)
+	if err != nil {
+		e.t.Fatalf("failed to get group %d: %v", id, err)
+	}
+	return &group.RuleGroup
+}
+
+func TestDeleteTemplateRequiresUnrestrictedAccess(t *testing.T) {
+	env := newRuleTestEnv(t)
+	template := env.createTemplate("base")
+
+	env.principal = env.scopedOperator()
+	code, resp := env.do(http.MethodDelete, "/api/v1/rule-templates/"+strconv.Itoa(int(template.ID)), nil)
+	if code != http.StatusForbidden {
+		t.Fatalf("DeleteTemplate by scoped operator status %d: %v", code, resp)
+	}
+	if _, err := env.groups.GetTemplate(template.ID); err != nil {
+		t.Fatalf("template was deleted: %v", err)
+	}
+
+	env.principal = adminPrincipal
+	if code, resp := env.do(http.MethodDelete, "/api/v1/rule-templates/"+strconv.Itoa(int(template.ID)), nil); code != http.StatusOK {
+		t.Fatalf("DeleteTemplate by admin status %d: %v", code, resp)
+	}
+}
+
+// 提交的实例ID和模板规则ID不能改写其他规则组和模板的记录
+func TestGroupAndTemplateIgnoreSubmittedChildIDs(t *testing.T) {
+	env := newRuleTestEnv(t)
+	template := env.createTemplate("base")
+	// 运维用户无权访问的规则组
+	other := &model.RuleGroup{
+		Name:       "other",
+		TemplateID: template.ID,
+		Targets:    []model.RuleGroupTarget{{CloudConfigID: env.configs[1]}},
+	}
+	if err := env.groups.CreateGroup(other, service.SystemActor); err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	otherTarget := other.Targets[0].ID
+	otherItem := template.Items[0].ID
+
+	env.principal = env.scopedOperator()
+	target := map[string]any{"id": otherTarget, "group_id": other.ID, "cloud_config_id": env.configs[0]}
+	code, resp := env.do(http.MethodPost, "/api/v1/rule-groups", map[string]any{
+		"name":        "mine",
+		"template_id": template.ID,
+		"enabled":     false,
+		"targets":     []any{target},
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("CreateGroup status %d: %v", code, resp)
+	}
+	mine := uint(resp["id"].(float64))
+	if group := env.getGroup(mine); !group.Enabled || len(group.Targets) != 1 || group.Targets[0].ID == otherTarget {
+		t.Errorf("created group enabled %v, targets %+v", group.Enabled, group.Targets)
+	}
+
+	code, resp = env.do(http.MethodPut, "/api/v1/rule-groups/"+strconv.Itoa(int(mine)), map[string]any{
+		"name":        "mine",
+		"template_id": template.ID,
+		"targets":     []any{target},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("UpdateGroup status %d: %v", code, resp)
+	}
+
+	code, resp = env.do(http.MethodPost, "/api/v1/rule-templates", map[string]any{
+		"name":  "copy",
+		"items": []any{map[string]any{"id": otherItem, "template_id": template.ID, "protocol": "TCP", "port": "80", "action": "ACCEPT", "remark": "{group} http"}},
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("CreateTemplate status %d: %v", code, resp)
+	}
+
+	if group := env.getGroup(other.ID); len(group.Targets) != 1 || group.Targets[0].ID != otherTarget || group.Targets[0].CloudConfigID != env.configs[1] {
+		t.Errorf("other group's targets were changed: %+v", group.Targets)
+	}
+	stored, err := env.groups.GetTemplate(template.ID)
+	if err != nil {
+		t.Fatalf("failed to get template: %v", err)
+	}
+	if len(stored.Items) != 1 || stored.Items[0].ID != otherItem || stored.Items[0].Port != "22" {
+		t.Errorf("other template's items were changed: %+v", stored.Items)
+	}
+}
@@ -0,0 +1,146 @@
+package v1
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SafeguardHandler struct {
+	safeguardService service.SafeguardService
+	audit            service.AuditService
+}
+
+func NewSafeguardHandler(safeguardService service.SafeguardService) *SafeguardHandler {
+	return &SafeguardHandler{
+		safeguardService: safeguardService,
+	}
+}
+
+// SetAuditService 设置审计日志服务
+func (h *SafeguardHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// GetSafeguards handles GET /api/v1/safeguards
+func (h *SafeguardHandler) GetSafeguards(c *gin.Context) {
+	safeguards, err := h.safeguardService.ListSafeguards()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 只返回当前用户可访问的云服务配置下的实例
+	principal := currentPrincipal(c)
+	visible := make([]model.InstanceSafeguard, 0, len(safeguards))
+	for _, safeguard := range safeguards {
+		if principal.CanAccessCloudConfig(safeguard.CloudConfigID) {
+			visible = append(visible, safeguard)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// CreateSafeguard handles POST /api/v1/safeguards
+func (h *SafeguardHandler) CreateSafeguard(c *gin.Context) {
+	var safeguard model.InstanceSafeguard
+	if err := c.ShouldBindJSON(&safeguard); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireCloudConfigAccess(c, safeguard.CloudConfigID) {
+		return
+	}
+
+	err := h.safeguardService.CreateSafeguard(&safeguard)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "safeguard.create", EntityType: "safeguard", EntityID: fmt.Sprint(safeguard.ID), After: &safeguard, Err: err})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, safeguard)
+}
+
+// UpdateSafeguard handles PUT /api/v1/safeguards/:id
+func (h *SafeguardHandler) UpdateSafeguard(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	before, ok := h.authorizeSafeguard(c, uint(id))
+	if !ok {
+		return
+	}
+
+	var safeguard model.InstanceSafeguard
+	if err := c.ShouldBindJSON(&safeguard); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 不能把保护配置移到无权访问的云服务配置下
+	if !requireCloudConfigAccess(c, safeguard.CloudConfigID) {
+		return
+	}
+
+	safeguard.ID = uint(id)
+	err = h.safeguardService.UpdateSafeguard(&safeguard)
+	recordAudit(c, h.audit, service.AuditEntry{Action: "safeguard.update", EntityType: "safeguard", EntityID: idStr, Before: before, After: &safeguard, Err: err})
+	if err != nil {
+		c.JSON(safeguardErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, safeguard)
+}
+
+// DeleteSafeguard handles DELETE /api/v1/safeguards/:id
+func (h *SafeguardHandler) DeleteSafeguard(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	before, ok := h.authorizeSafeguard(c, uint(id))
+	if !ok {
+		return
+	}
+
+	err = h.safeguardService.DeleteSafeguard(uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "safeguard.delete", EntityType: "safeguard", EntityID: idStr, Before: before, Err: err})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Safeguard deleted successfully"})
+}
+
+// authorizeSafeguard 检查当前用户能否操作指定的保护配置，失败时写入响应并返回false
+func (h *SafeguardHandler) authorizeSafeguard(c *gin.Context, id uint) (*model.InstanceSafeguard, bool) {
+	safeguard, err := h.safeguardService.GetSafeguard(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "保护配置不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	return safeguard, requireCloudConfigAccess(c, safeguard.CloudConfigID)
+}
+
+func safeguardErrorStatus(err error) int {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
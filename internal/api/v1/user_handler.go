@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"FireFlow/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserHandler struct {
+	userService service.UserService
+	audit       service.AuditService
+}
+
+func NewUserHandler(userService service.UserService) *UserHandler {
+	return &UserHandler{
+		userService: userService,
+	}
+}
+
+// SetAuditService 设置审计日志服务
+func (h *UserHandler) SetAuditService(audit service.AuditService) {
+	h.audit = audit
+}
+
+// GetUsers handles GET /api/v1/users
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	users, err := h.userService.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser handles POST /api/v1/users
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var input service.UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.CreateUser(&input)
+	entry := service.AuditEntry{Action: "user.create", EntityType: "user", EntityID: input.Username, Err: err}
+	if user != nil {
+		entry.EntityID = fmt.Sprint(user.ID)
+		entry.After = user
+	}
+	recordAudit(c, h.audit, entry)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser handles PUT /api/v1/users/:id
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var input service.UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, _ := h.userService.GetUser(uint(id))
+	user, err := h.userService.UpdateUser(currentPrincipal(c).UserID, uint(id), &input)
+	entry := service.AuditEntry{Action: "user.update", EntityType: "user", EntityID: c.Param("id"), Before: before, Err: err}
+	if user != nil {
+		entry.After = user
+	}
+	if input.Password != "" {
+		// 重置密码只记录发生了修改
+		entry.Action = "user.update_password"
+	}
+	recordAudit(c, h.audit, entry)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser handles DELETE /api/v1/users/:id
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	before, _ := h.userService.GetUser(uint(id))
+	err = h.userService.DeleteUser(currentPrincipal(c).UserID, uint(id))
+	recordAudit(c, h.audit, service.AuditEntry{Action: "user.delete", EntityType: "user", EntityID: c.Param("id"), Before: before, Err: err})
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// GetRoles handles GET /api/v1/users/roles，返回各角色及其权限
+func (h *UserHandler) GetRoles(c *gin.Context) {
+	c.JSON(http.StatusOK, service.RolePermissions())
+}
+
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrLastAdmin), errors.Is(err, service.ErrCannotEditSelf):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
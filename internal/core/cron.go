@@ -1,84 +1,103 @@
-package core
-
-import (
-	"fmt"
-	"log"
-
-	"github.com/robfig/cron/v3"
-)
-
-// CronManager 管理定时任务
-type CronManager struct {
-	cron          *cron.Cron
-	firewallJobID cron.EntryID
-	updateFunc    func()
-	isRunning     bool
-}
-
-// NewCronManager 创建新的定时任务管理器
-func NewCronManager() *CronManager {
-	return &CronManager{
-		cron:          cron.New(cron.WithSeconds()), // 支持包含秒的6字段格式
-		firewallJobID: 0,
-		isRunning:     false,
-	}
-}
-
-// SetUpdateFunc 设置更新函数
-func (cm *CronManager) SetUpdateFunc(updateFunc func()) {
-	cm.updateFunc = updateFunc
-}
-
-// StartFirewallUpdateJob 根据配置启动防火墙更新任务
-func (cm *CronManager) StartFirewallUpdateJob(intervalMinutes int) error {
-	if cm.updateFunc == nil {
-		return fmt.Errorf("update function not set")
-	}
-
-	// 如果已经有任务在运行，先停止
-	if cm.firewallJobID != 0 {
-		cm.cron.Remove(cm.firewallJobID)
-		cm.firewallJobID = 0
-	}
-
-	// 创建cron表达式：每N分钟执行一次
-	cronExpr := fmt.Sprintf("0 */%d * * * *", intervalMinutes)
-
-	// 添加新任务
-	jobID, err := cm.cron.AddFunc(cronExpr, cm.updateFunc)
-	if err != nil {
-		return err
-	}
-
-	cm.firewallJobID = jobID
-	cm.isRunning = true
-	log.Printf("Firewall update job scheduled with expression: %s (every %d minutes)", cronExpr, intervalMinutes)
-	return nil
-}
-
-// StopFirewallUpdateJob 停止防火墙更新任务
-func (cm *CronManager) StopFirewallUpdateJob() {
-	if cm.firewallJobID != 0 {
-		cm.cron.Remove(cm.firewallJobID)
-		cm.firewallJobID = 0
-		cm.isRunning = false
-		log.Println("Firewall update job stopped")
-	}
-}
-
-// IsRunning 检查防火墙更新任务是否正在运行
-func (cm *CronManager) IsRunning() bool {
-	return cm.isRunning
-}
-
-// Start 启动定时任务
-func (cm *CronManager) Start() {
-	cm.cron.Start()
-	log.Println("Cron manager started")
-}
-
-// Stop 停止定时任务
-func (cm *CronManager) Stop() {
-	cm.cron.Stop()
-	log.Println("Cron manager stopped")
-}
+package core
+
+import (
+	"FireFlow/internal/metrics"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// 防火墙更新任务在指标中的名称
+const firewallJobName = "firewall_update"
+
+// CronManager 管理定时任务
+type CronManager struct {
+	cron          *cron.Cron
+	firewallJobID cron.EntryID
+	updateFunc    func()
+	isRunning     bool
+}
+
+// NewCronManager 创建新的定时任务管理器
+func NewCronManager() *CronManager {
+	metrics.CronJobScheduled.WithLabelValues(firewallJobName).Set(0)
+	return &CronManager{
+		cron:          cron.New(cron.WithSeconds()), // 支持包含秒的6字段格式
+		firewallJobID: 0,
+		isRunning:     false,
+	}
+}
+
+// SetUpdateFunc 设置更新函数
+func (cm *CronManager) SetUpdateFunc(updateFunc func()) {
+	cm.updateFunc = updateFunc
+}
+
+// StartFirewallUpdateJob 根据配置启动防火墙更新任务
+func (cm *CronManager) StartFirewallUpdateJob(intervalMinutes int) error {
+	if cm.updateFunc == nil {
+		return fmt.Errorf("update function not set")
+	}
+
+	// 如果已经有任务在运行，先停止
+	if cm.firewallJobID != 0 {
+		cm.cron.Remove(cm.firewallJobID)
+		cm.firewallJobID = 0
+	}
+
+	// 创建cron表达式：每N分钟执行一次
+	cronExpr := fmt.Sprintf("0 */%d * * * *", intervalMinutes)
+
+	// 添加新任务
+	jobID, err := cm.cron.AddFunc(cronExpr, cm.runFirewallUpdate)
+	if err != nil {
+		return err
+	}
+
+	cm.firewallJobID = jobID
+	cm.isRunning = true
+	metrics.CronJobScheduled.WithLabelValues(firewallJobName).Set(1)
+	slog.Info("firewall update job scheduled", "job", firewallJobName, "expression", cronExpr, "interval_minutes", intervalMinutes)
+	return nil
+}
+
+// StopFirewallUpdateJob 停止防火墙更新任务
+func (cm *CronManager) StopFirewallUpdateJob() {
+	if cm.firewallJobID != 0 {
+		cm.cron.Remove(cm.firewallJobID)
+		cm.firewallJobID = 0
+		cm.isRunning = false
+		metrics.CronJobScheduled.WithLabelValues(firewallJobName).Set(0)
+		slog.Info("firewall update job stopped", "job", firewallJobName)
+	}
+}
+
+// runFirewallUpdate 执行防火墙更新并记录执行指标
+func (cm *CronManager) runFirewallUpdate() {
+	start := time.Now()
+	slog.Debug("running scheduled job", "job", firewallJobName)
+	cm.updateFunc()
+
+	metrics.CronJobRuns.WithLabelValues(firewallJobName).Inc()
+	metrics.ObserveSince(metrics.CronJobDuration.WithLabelValues(firewallJobName), start)
+	metrics.CronJobLastRun.WithLabelValues(firewallJobName).SetToCurrentTime()
+}
+
+// IsRunning 检查防火墙更新任务是否正在运行
+func (cm *CronManager) IsRunning() bool {
+	return cm.isRunning
+}
+
+// Start 启动定时任务
+func (cm *CronManager) Start() {
+	cm.cron.Start()
+	slog.Info("cron manager started")
+}
+
+// Stop 停止定时任务
+func (cm *CronManager) Stop() {
+	cm.cron.Stop()
+	slog.Info("cron manager stopped")
+}
@@ -0,0 +1,122 @@
+// Package metrics 定义FireFlow暴露给Prometheus的业务指标，注册在默认Registry中
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "fireflow"
+
+// 规则更新结果
+const (
+	RuleCreated   = "created"   // 云端不存在匹配规则，已创建
+	RuleUpdated   = "updated"   // 来源IP已替换
+	RuleUnchanged = "unchanged" // 来源IP已是最新
+	RuleFailed    = "failed"
+)
+
+// 全量同步结果
+const (
+	SyncSuccess   = "success"   // 所有规则同步成功
+	SyncPartial   = "partial"   // 部分规则失败
+	SyncFailed    = "failed"    // 同步未能执行（如获取IP失败）
+	SyncSkipped   = "skipped"   // 已有同步在运行
+	SyncCancelled = "cancelled" // 同步被取消或中止
+)
+
+// 公网IP获取失败原因
+const (
+	IPFetchRequest = "request" // 请求失败或响应异常
+	IPFetchInvalid = "invalid" // 返回内容不是合法IPv4
+)
+
+var (
+	SyncRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_runs_total",
+		Help:      "Full sync runs by trigger and result.",
+	}, []string{"trigger", "result"})
+
+	SyncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_run_duration_seconds",
+		Help:      "Duration of full sync runs.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"trigger"})
+
+	RuleUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_updates_total",
+		Help:      "Per-rule update outcomes.",
+	}, []string{"outcome"})
+
+	IPFetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ip_fetch_duration_seconds",
+		Help:      "Latency of public IP lookups.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	})
+
+	IPFetchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ip_fetch_failures_total",
+		Help:      "Failed public IP lookups by reason.",
+	}, []string{"reason"})
+
+	IPLastChange = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "public_ip_last_change_timestamp_seconds",
+		Help:      "Unix time when the public IP was last observed to change.",
+	})
+
+	RulesEnabled = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rules_enabled",
+		Help:      "Number of enabled firewall rules.",
+	})
+
+	RulesDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rules_degraded",
+		Help:      "Number of enabled firewall rules whose last update failed.",
+	})
+
+	RuleProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_probes_total",
+		Help:      "Post-update connectivity probes by type and result.",
+	}, []string{"type", "result"})
+
+	CronJobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cron_job_runs_total",
+		Help:      "Scheduled job executions.",
+	}, []string{"job"})
+
+	CronJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cron_job_duration_seconds",
+		Help:      "Duration of scheduled job executions.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"job"})
+
+	CronJobLastRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cron_job_last_run_timestamp_seconds",
+		Help:      "Unix time when the scheduled job last finished.",
+	}, []string{"job"})
+
+	CronJobScheduled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cron_job_scheduled",
+		Help:      "Whether the scheduled job is active (1) or stopped (0).",
+	}, []string{"job"})
+)
+
+// ObserveSince 记录从start到现在经过的秒数
+func ObserveSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
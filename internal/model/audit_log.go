@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+)
+
+// 审计日志操作者类型
+const (
+	AuditActorUser   = "user"   // 通过Web界面会话操作
+	AuditActorToken  = "token"  // 通过API令牌操作
+	AuditActorCron   = "cron"   // 定时任务
+	AuditActorSystem = "system" // 服务自身（启动、迁移等）
+)
+
+// 审计日志结果
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditLog 审计日志，只追加不修改，仅按保留期限清理
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `gorm:"index;comment:发生时间" json:"created_at"`
+	ActorType  string    `gorm:"type:varchar(20);index;comment:操作者类型(user,token,cron,system)" json:"actor_type"`
+	ActorID    uint      `gorm:"comment:操作用户ID" json:"actor_id,omitempty"`
+	ActorName  string    `gorm:"type:varchar(100);index;comment:操作者名称" json:"actor_name"`
+	TokenID    uint      `gorm:"comment:使用的API令牌ID" json:"token_id,omitempty"`
+	SourceIP   string    `gorm:"type:varchar(64);comment:来源IP" json:"source_ip,omitempty"`
+	RequestID  string    `gorm:"type:varchar(64);index;comment:HTTP请求ID" json:"request_id,omitempty"`
+	Action     string    `gorm:"type:varchar(50);index;comment:操作(如rule.update、cloud.create)" json:"action"`
+	EntityType string    `gorm:"type:varchar(50);index:idx_audit_entity;comment:目标对象类型" json:"entity_type"`
+	EntityID   string    `gorm:"type:varchar(100);index:idx_audit_entity;comment:目标对象ID" json:"entity_id"`
+	RunID      string    `gorm:"type:varchar(50);index;comment:关联的同步任务ID" json:"run_id,omitempty"`
+	Before     string    `gorm:"type:text;comment:变更前的字段(JSON,敏感字段已脱敏)" json:"before,omitempty"`
+	After      string    `gorm:"type:text;comment:变更后的字段(JSON,敏感字段已脱敏)" json:"after,omitempty"`
+	Result     string    `gorm:"type:varchar(20);index;comment:结果(success,failure)" json:"result"`
+	Error      string    `gorm:"type:text;comment:失败原因或云服务返回的错误" json:"error,omitempty"`
+}
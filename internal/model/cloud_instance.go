@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// CloudInstance 云服务配置（凭证账号）下管理的实例。同一账号的多个实例共用一份凭证，
+// 每个实例记录所在区域，对该实例的API调用使用对应区域的客户端。
+type CloudInstance struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	CloudConfigID uint      `gorm:"not null;uniqueIndex:idx_cloud_instance;comment:提供凭证的云服务配置ID" json:"cloud_config_id"`
+	InstanceID    string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_cloud_instance;comment:实例ID" json:"instance_id"`
+	Region        string    `gorm:"type:varchar(100);not null;comment:实例所在区域" json:"region"`
+	Name          string    `gorm:"type:varchar(255);comment:实例名称" json:"name"`
+	PublicIP      string    `gorm:"type:varchar(100);comment:公网IP" json:"public_ip"`
+	Remark        string    `gorm:"type:varchar(255);comment:备注" json:"remark"`
+}
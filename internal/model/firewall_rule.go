@@ -1,19 +1,71 @@
-package model
-
-import (
-	gorm "gorm.io/gorm"
-)
-
-type FirewallRule struct {
-	gorm.Model
-	Provider      string              `gorm:"type:varchar(50);not null;comment:云厂商 (e.g., 'TencentCloud', 'Aliyun')" json:"provider"`
-	CloudConfigID uint                `gorm:"comment:关联的云服务配置ID" json:"cloud_config_id"`
-	InstanceID    string              `gorm:"type:varchar(100);not null;comment:服务器实例ID" json:"instance_id"`
-	Port          string              `gorm:"type:varchar(20);not null;comment:需要开放的端口 (e.g., '80', '22')" json:"port"`
-	Protocol      string              `gorm:"type:varchar(10);default:'TCP';comment:协议类型 (ICMP, TCP, UDP, ALL)" json:"protocol"`
-	RuleID        string              `gorm:"type:varchar(100);comment:防火墙规则ID" json:"rule_id"`
-	LastIP        string              `gorm:"type:varchar(50);comment:上一次更新的IP" json:"last_ip"`
-	Enabled       bool                `gorm:"default:true;comment:是否启用" json:"enabled"`
-	Remark        string              `gorm:"type:varchar(255);not null;comment:备注(必填)" json:"remark"`
-	CloudConfig   CloudProviderConfig `gorm:"foreignKey:CloudConfigID" json:"cloud_config"`
-}
+package model
+
+import (
+	"time"
+
+	gorm "gorm.io/gorm"
+)
+
+// 连通性探测结果
+const (
+	ProbeHealthy   = "healthy"   // 更新后端口可从本机访问
+	ProbeUnhealthy = "unhealthy" // 探测失败
+)
+
+type FirewallRule struct {
+	gorm.Model
+	Provider      string `gorm:"type:varchar(50);not null;comment:云厂商 (e.g., 'TencentCloud', 'Aliyun', 'Fake')" json:"provider"`
+	CloudConfigID uint   `gorm:"comment:关联的云服务配置ID" json:"cloud_config_id"`
+	InstanceID    string `gorm:"type:varchar(100);not null;comment:服务器实例ID" json:"instance_id"`
+	Port          string `gorm:"type:varchar(20);not null;comment:需要开放的端口 (e.g., '80', '22')" json:"port"`
+	Protocol      string `gorm:"type:varchar(10);default:'TCP';comment:协议类型 (ICMP, TCP, UDP, ALL)" json:"protocol"`
+	RuleID        string `gorm:"type:varchar(100);comment:防火墙规则ID" json:"rule_id"`
+	LastIP        string `gorm:"type:varchar(50);comment:上一次更新的IP" json:"last_ip"`
+	Enabled       bool   `gorm:"default:true;comment:是否启用" json:"enabled"`
+	// Pinned 规则回滚后固定为回滚后的来源，同步时跳过，直到下一次手动执行
+	Pinned      bool                `gorm:"default:false;comment:回滚后固定，同步时跳过" json:"pinned"`
+	Remark      string              `gorm:"type:varchar(255);not null;comment:备注(必填)" json:"remark"`
+	CloudConfig CloudProviderConfig `gorm:"foreignKey:CloudConfigID;<-:false" json:"-"` // 只读关联：凭证只能经配置仓库加密保存，保存规则时不写入，也不出现在API中
+
+	// 动作和顺序：Source为空时来源为当前公网IP，否则为固定来源；Priority小的规则在云端排在前面
+	Action   string `gorm:"type:varchar(10);default:'ACCEPT';comment:动作(ACCEPT,DROP)" json:"action"`
+	Source   string `gorm:"type:varchar(50);comment:固定来源CIDR，为空时使用当前公网IP" json:"source"`
+	Priority int    `gorm:"default:0;comment:优先级，数值小的规则排在前面" json:"priority"`
+
+	// 多实例目标：同步时将InstanceID、InstanceIDs和按标签选择器匹配的实例合并，逐个实例同步
+	InstanceIDs      string `gorm:"type:text;comment:其他实例ID，逗号分隔" json:"instance_ids"`
+	InstanceSelector string `gorm:"type:varchar(255);comment:实例标签选择器(如 env=dev,role=web)" json:"instance_selector"`
+	// Targets 规则在各目标实例上的云端状态，由同步维护，只读
+	Targets []FirewallRuleTarget `gorm:"foreignKey:RuleID;<-:false" json:"targets,omitempty"`
+
+	// 由规则组创建的成员规则，GroupID为0表示独立规则
+	GroupID        uint `gorm:"index;comment:所属规则组ID" json:"group_id"`
+	TemplateItemID uint `gorm:"comment:对应的模板规则ID" json:"template_item_id"`
+
+	// 规则更新后的连通性探测，ProbeType为空时不探测
+	ProbeType      string     `gorm:"type:varchar(10);comment:探测类型(tcp,http,icmp)" json:"probe_type"`
+	ProbePort      string     `gorm:"type:varchar(10);comment:探测端口，为空时使用规则端口" json:"probe_port"`
+	ProbePath      string     `gorm:"type:varchar(255);comment:HTTP探测路径" json:"probe_path"`
+	ProbeStatus    string     `gorm:"type:varchar(20);comment:最近一次探测结果(healthy,unhealthy)" json:"probe_status"`
+	ProbeError     string     `gorm:"type:text;comment:最近一次探测失败原因" json:"probe_error"`
+	ProbeLatencyMs int64      `gorm:"comment:最近一次探测耗时(毫秒)" json:"probe_latency_ms"`
+	ProbedAt       *time.Time `gorm:"comment:最近一次探测时间" json:"probed_at"`
+}
+
+// MultiInstance 规则是否指定了多个实例或标签选择器
+func (r *FirewallRule) MultiInstance() bool {
+	return r.InstanceIDs != "" || r.InstanceSelector != ""
+}
+
+// FirewallRuleTarget 规则在一个实例上的云端状态。多实例规则的每个目标实例各有一条记录，
+// 云端规则ID和来源按实例保存，互不覆盖；实例不再是规则的目标时，同步会删除该实例上的云端规则和这条记录
+type FirewallRuleTarget struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	RuleID        uint      `gorm:"not null;uniqueIndex:idx_rule_target;comment:所属规则ID" json:"rule_id"`
+	CloudConfigID uint      `gorm:"not null;uniqueIndex:idx_rule_target;comment:云服务配置ID" json:"cloud_config_id"`
+	Provider      string    `gorm:"type:varchar(50);not null;comment:云厂商" json:"provider"`
+	InstanceID    string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_rule_target;comment:实例ID" json:"instance_id"`
+	CloudRuleID   string    `gorm:"type:varchar(100);comment:云端防火墙规则ID" json:"cloud_rule_id"`
+	LastIP        string    `gorm:"type:varchar(50);comment:该实例上一次更新的IP" json:"last_ip"`
+}
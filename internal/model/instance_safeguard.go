@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// InstanceSafeguard 实例的防锁死保护配置：
+// 受保护的来源规则不会被 FireFlow 删除或修改；每次变更实例规则后，
+// 关键端口必须仍有ACCEPT规则，否则中止并回滚本次变更。
+type InstanceSafeguard struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	CloudConfigID    uint      `gorm:"not null;uniqueIndex:idx_safeguard_instance;comment:云服务配置ID" json:"cloud_config_id"`
+	InstanceID       string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_safeguard_instance;comment:实例ID" json:"instance_id"`
+	ProtectedSources string    `gorm:"type:text;comment:受保护的来源，逗号分隔，格式为CIDR或CIDR:端口" json:"protected_sources"`
+	CriticalPorts    string    `gorm:"type:varchar(255);comment:关键端口，逗号分隔，格式为端口或协议:端口(默认TCP)" json:"critical_ports"`
+	Remark           string    `gorm:"type:varchar(255);comment:备注" json:"remark"`
+}
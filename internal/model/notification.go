@@ -0,0 +1,29 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// 通知事件类型
+const (
+	EventIPChanged           = "ip_changed"           // 公网IP变化
+	EventRuleFailed          = "rule_failed"          // 规则更新失败
+	EventInstanceUnreachable = "instance_unreachable" // 无法访问实例（查询规则失败等）
+	EventCredentialInvalid   = "credential_invalid"   // 云服务凭证无效
+	EventTest                = "test"                 // 测试通知
+)
+
+// NotificationEvents 可订阅的通知事件
+var NotificationEvents = []string{EventIPChanged, EventRuleFailed, EventInstanceUnreachable, EventCredentialInvalid}
+
+// NotificationChannel 通知渠道配置
+type NotificationChannel struct {
+	gorm.Model
+	Name          string `gorm:"type:varchar(100);not null;comment:渠道名称" json:"name"`
+	Type          string `gorm:"type:varchar(20);not null;comment:渠道类型(webhook,smtp,telegram,dingtalk,wecom,feishu,slack,ntfy,bark)" json:"type"`
+	Config        string `gorm:"type:text;comment:渠道配置(JSON格式，加密存储)" json:"config"`
+	Events        string `gorm:"type:varchar(255);comment:订阅的事件，逗号分隔" json:"events"`
+	TitleTemplate string `gorm:"type:varchar(255);comment:标题模板(为空使用默认模板)" json:"title_template"`
+	BodyTemplate  string `gorm:"type:text;comment:内容模板(为空使用默认模板)" json:"body_template"`
+	IsEnabled     bool   `gorm:"default:true;comment:是否启用" json:"is_enabled"`
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+)
+
+// RuleTemplate 规则模板：一组协议/端口/动作/来源/备注，规则组按模板在多个实例上创建规则
+type RuleTemplate struct {
+	ID          uint               `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	Name        string             `gorm:"type:varchar(100);not null;uniqueIndex;comment:模板名称" json:"name"`
+	Description string             `gorm:"type:varchar(255);comment:模板描述" json:"description"`
+	Items       []RuleTemplateItem `gorm:"foreignKey:TemplateID" json:"items"`
+}
+
+// RuleTemplateItem 模板中的一条规则。Remark为备注模板，可使用
+// {group}、{instance}、{protocol}、{port} 占位符，同一实例上展开后必须唯一。
+type RuleTemplateItem struct {
+	ID         uint   `gorm:"primarykey" json:"id"`
+	TemplateID uint   `gorm:"index;not null;comment:所属模板ID" json:"template_id"`
+	Protocol   string `gorm:"type:varchar(10);default:'TCP';comment:协议类型 (ICMP, TCP, UDP, ALL)" json:"protocol"`
+	Port       string `gorm:"type:varchar(20);comment:端口，ICMP和ALL时为ALL" json:"port"`
+	Action     string `gorm:"type:varchar(10);default:'ACCEPT';comment:动作(ACCEPT,DROP)" json:"action"`
+	Source     string `gorm:"type:varchar(50);comment:固定来源CIDR，为空时使用当前公网IP" json:"source"`
+	Priority   int    `gorm:"default:0;comment:优先级，数值小的规则排在前面" json:"priority"`
+	Remark     string `gorm:"type:varchar(255);not null;comment:备注模板" json:"remark"`
+}
+
+// RuleGroup 规则组：将模板应用到一组云服务配置/实例，自动创建并维护成员规则
+type RuleGroup struct {
+	ID         uint              `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Name       string            `gorm:"type:varchar(100);not null;uniqueIndex;comment:规则组名称" json:"name"`
+	TemplateID uint              `gorm:"index;not null;comment:使用的模板ID" json:"template_id"`
+	Enabled    bool              `gorm:"default:true;comment:是否启用，同时控制所有成员规则" json:"enabled"`
+	Remark     string            `gorm:"type:varchar(255);comment:备注" json:"remark"`
+	Targets    []RuleGroupTarget `gorm:"foreignKey:GroupID" json:"targets"`
+}
+
+// RuleGroupTarget 规则组应用的实例，InstanceID为空时使用云服务配置中的实例
+type RuleGroupTarget struct {
+	ID            uint   `gorm:"primarykey" json:"id"`
+	GroupID       uint   `gorm:"index;not null;comment:所属规则组ID" json:"group_id"`
+	CloudConfigID uint   `gorm:"not null;comment:云服务配置ID" json:"cloud_config_id"`
+	InstanceID    string `gorm:"type:varchar(100);comment:实例ID，为空时使用云服务配置中的实例" json:"instance_id"`
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"time"
+)
+
+// 云端规则操作日志的状态，按 pending_create → created → pending_delete → done 推进
+const (
+	RuleOpPendingCreate = "pending_create" // 即将创建新规则
+	RuleOpCreated       = "created"        // 新规则已创建，旧规则尚未删除
+	RuleOpPendingDelete = "pending_delete" // 即将删除旧规则
+	RuleOpDone          = "done"           // 更新完成
+	RuleOpRolledBack    = "rolled_back"    // 新规则未创建，云端保持原状
+	RuleOpAbandoned     = "abandoned"      // 规则已删除或已改到其他实例，不再恢复
+)
+
+// RuleOperation 云端规则更新的预写日志。每次调用云服务API前先持久化将要执行的操作，
+// 更新失败或服务中断后，下次同步该规则时据此继续完成或回滚。
+type RuleOperation struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	RuleID        uint      `gorm:"index;comment:本地规则ID" json:"rule_id"`
+	RunID         string    `gorm:"type:varchar(50);index;comment:所属同步任务ID" json:"run_id"`
+	CloudConfigID uint      `json:"cloud_config_id"`
+	Provider      string    `gorm:"type:varchar(50)" json:"provider"`
+	InstanceID    string    `gorm:"type:varchar(100)" json:"instance_id"`
+	Protocol      string    `gorm:"type:varchar(10)" json:"protocol"`
+	Port          string    `gorm:"type:varchar(50)" json:"port"`
+	Action        string    `gorm:"type:varchar(10)" json:"action"`
+	OldAction     string    `gorm:"type:varchar(10);comment:旧规则的动作，为空表示与新规则相同" json:"old_action,omitempty"`
+	Description   string    `gorm:"type:varchar(255);comment:云端规则备注" json:"description"`
+	OldCidrBlock  string    `gorm:"type:varchar(64);comment:待删除的旧来源，为空表示仅创建" json:"old_cidr_block,omitempty"`
+	NewCidrBlock  string    `gorm:"type:varchar(64);comment:新规则的来源，为空表示仅删除" json:"new_cidr_block,omitempty"`
+	CloudRuleID   string    `gorm:"type:varchar(100);comment:新规则的云端ID" json:"cloud_rule_id,omitempty"`
+	State         string    `gorm:"type:varchar(20);index;comment:操作状态" json:"state"`
+	Error         string    `gorm:"type:text;comment:最近一次失败原因" json:"error,omitempty"`
+}
+
+// PreviousAction 旧规则的动作，早期的操作日志没有记录时与新规则相同
+func (op *RuleOperation) PreviousAction() string {
+	if op.OldAction != "" {
+		return op.OldAction
+	}
+	return op.Action
+}
+
+// Finished 操作是否已结束，无需再恢复
+func (op *RuleOperation) Finished() bool {
+	switch op.State {
+	case RuleOpDone, RuleOpRolledBack, RuleOpAbandoned:
+		return true
+	default:
+		return false
+	}
+}
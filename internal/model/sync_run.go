@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+)
+
+// 同步任务类型
+const (
+	SyncRunFull = "full" // 全量同步
+	SyncRunRule = "rule" // 单条规则执行
+
+	SyncRunRollback = "rollback" // 恢复规则到之前的来源
+	SyncRunGroup    = "group"    // 同步规则组的成员规则
+)
+
+// 同步任务状态
+const (
+	SyncRunRunning     = "running"
+	SyncRunSuccess     = "success"
+	SyncRunPartial     = "partial"     // 部分规则失败
+	SyncRunFailed      = "failed"      // 同步未能执行
+	SyncRunCancelled   = "cancelled"   // 被手动取消
+	SyncRunInterrupted = "interrupted" // 服务退出导致同步未完成
+)
+
+// SyncRun 同步任务记录。服务启动时仍为running或未调度补偿同步的interrupted任务
+// 视为被中断，需要重新同步以修复可能只完成了一半的云端规则变更。
+type SyncRun struct {
+	ID           string     `gorm:"primarykey;type:varchar(50)" json:"id"`
+	Kind         string     `gorm:"type:varchar(20);comment:任务类型(full,rule,rollback,group)" json:"kind"`
+	RuleID       uint       `gorm:"comment:单条规则执行时的规则ID" json:"rule_id,omitempty"`
+	GroupID      uint       `gorm:"comment:同步规则组时的规则组ID" json:"group_id,omitempty"`
+	Trigger      string     `gorm:"type:varchar(20);comment:触发来源(api,cron,system)" json:"trigger"`
+	Status       string     `gorm:"type:varchar(20);index;comment:任务状态" json:"status"`
+	CurrentIP    string     `gorm:"type:varchar(64);comment:同步使用的公网IP" json:"current_ip,omitempty"`
+	Updated      int        `json:"updated"`
+	Failed       int        `json:"failed"`
+	Skipped      int        `json:"skipped"`
+	Error        string     `gorm:"type:text" json:"error,omitempty"`
+	ReconciledBy string     `gorm:"type:varchar(50);comment:中断后调度的补偿同步任务ID" json:"reconciled_by,omitempty"`
+	StartedAt    time.Time  `gorm:"index" json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
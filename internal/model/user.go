@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 用户角色
+const (
+	RoleAdmin    = "admin"    // 管理员：全部权限，包括用户与系统配置管理
+	RoleOperator = "operator" // 运维：管理云服务凭证和防火墙规则
+	RoleViewer   = "viewer"   // 只读：查看规则、配置和同步记录，不能发起任何云端变更
+)
+
+// User 本地用户，密码以bcrypt哈希存储
+type User struct {
+	gorm.Model
+	Username     string           `gorm:"type:varchar(100);uniqueIndex;not null;comment:用户名" json:"username"`
+	PasswordHash string           `gorm:"type:varchar(255);not null;comment:密码哈希(bcrypt)" json:"-"`
+	Role         string           `gorm:"type:varchar(20);default:'viewer';comment:角色(admin,operator,viewer)" json:"role"`
+	IsEnabled    bool             `gorm:"default:true;comment:是否启用" json:"is_enabled"`
+	LastLoginAt  *time.Time       `gorm:"comment:上次登录时间" json:"last_login_at"`
+	CloudScopes  []UserCloudScope `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// UserCloudScope 用户可访问的云服务配置，用户没有任何范围记录时可访问全部配置
+type UserCloudScope struct {
+	ID            uint `gorm:"primarykey" json:"id"`
+	UserID        uint `gorm:"uniqueIndex:idx_user_cloud_scope;not null;comment:用户ID" json:"user_id"`
+	CloudConfigID uint `gorm:"uniqueIndex:idx_user_cloud_scope;not null;comment:云服务配置ID" json:"cloud_config_id"`
+}
+
+// Session Web界面登录会话，只保存令牌的SHA-256哈希
+type Session struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null;comment:用户ID" json:"user_id"`
+	TokenHash string    `gorm:"type:varchar(64);uniqueIndex;not null;comment:会话令牌哈希" json:"-"`
+	ExpiresAt time.Time `gorm:"index;comment:过期时间" json:"expires_at"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// APIToken 供脚本调用REST API的Bearer令牌，只保存令牌的SHA-256哈希
+type APIToken struct {
+	gorm.Model
+	UserID      uint       `gorm:"index;not null;comment:所属用户ID" json:"user_id"`
+	Name        string     `gorm:"type:varchar(100);not null;comment:令牌名称" json:"name"`
+	TokenHash   string     `gorm:"type:varchar(64);uniqueIndex;not null;comment:令牌哈希" json:"-"`
+	TokenPrefix string     `gorm:"type:varchar(20);comment:令牌前缀(用于识别)" json:"token_prefix"`
+	ExpiresAt   *time.Time `gorm:"comment:过期时间(为空表示永不过期)" json:"expires_at"`
+	LastUsedAt  *time.Time `gorm:"comment:上次使用时间" json:"last_used_at"`
+	User        User       `gorm:"foreignKey:UserID" json:"-"`
+}
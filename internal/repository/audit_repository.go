@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditFilter 审计日志查询条件，空值表示不过滤
+type AuditFilter struct {
+	ActorType  string
+	Actor      string
+	Action     string // 以 * 结尾时按前缀匹配，如 rule.*
+	EntityType string
+	EntityID   string
+	Result     string
+	RunID      string
+	RequestID  string
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int
+	Offset     int
+}
+
+// AuditRepository 审计日志只提供追加、查询和按保留期限清理
+type AuditRepository interface {
+	Create(entry *model.AuditLog) error
+	List(filter AuditFilter) ([]model.AuditLog, int64, error)
+	DeleteBefore(t time.Time) (int64, error)
+}
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) Create(entry *model.AuditLog) error {
+	return r.db.Create(entry).Error
+}
+
+func (r *auditRepository) List(filter AuditFilter) ([]model.AuditLog, int64, error) {
+	query := r.db.Model(&model.AuditLog{})
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor_name = ?", filter.Actor)
+	}
+	if prefix, ok := strings.CutSuffix(filter.Action, "*"); ok {
+		query = query.Where("action LIKE ?", prefix+"%")
+	} else if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Result != "" {
+		query = query.Where("result = ?", filter.Result)
+	}
+	if filter.RunID != "" {
+		query = query.Where("run_id = ?", filter.RunID)
+	}
+	if filter.RequestID != "" {
+		query = query.Where("request_id = ?", filter.RequestID)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var logs []model.AuditLog
+	err := query.Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&logs).Error
+	return logs, total, err
+}
+
+func (r *auditRepository) DeleteBefore(t time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", t).Delete(&model.AuditLog{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// CloudInstanceRepository 云服务配置下管理的实例
+type CloudInstanceRepository interface {
+	// List 返回实例列表，cloudConfigID为0时返回所有配置下的实例
+	List(cloudConfigID uint) ([]model.CloudInstance, error)
+	GetByID(id uint) (*model.CloudInstance, error)
+	// GetByInstance 返回指定实例，未登记时返回 gorm.ErrRecordNotFound
+	GetByInstance(cloudConfigID uint, instanceID string) (*model.CloudInstance, error)
+	Create(instance *model.CloudInstance) error
+	Update(instance *model.CloudInstance) error
+	Delete(id uint) error
+}
+
+type cloudInstanceRepository struct {
+	db *gorm.DB
+}
+
+func NewCloudInstanceRepository(db *gorm.DB) CloudInstanceRepository {
+	return &cloudInstanceRepository{db: db}
+}
+
+func (r *cloudInstanceRepository) List(cloudConfigID uint) ([]model.CloudInstance, error) {
+	var instances []model.CloudInstance
+	query := r.db.Order("cloud_config_id, id")
+	if cloudConfigID != 0 {
+		query = query.Where("cloud_config_id = ?", cloudConfigID)
+	}
+	err := query.Find(&instances).Error
+	return instances, err
+}
+
+func (r *cloudInstanceRepository) GetByID(id uint) (*model.CloudInstance, error) {
+	var instance model.CloudInstance
+	if err := r.db.First(&instance, id).Error; err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+func (r *cloudInstanceRepository) GetByInstance(cloudConfigID uint, instanceID string) (*model.CloudInstance, error) {
+	var instance model.CloudInstance
+	err := r.db.
+		Where("cloud_config_id = ? AND instance_id = ?", cloudConfigID, instanceID).
+		First(&instance).Error
+	if err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+func (r *cloudInstanceRepository) Create(instance *model.CloudInstance) error {
+	return r.db.Create(instance).Error
+}
+
+func (r *cloudInstanceRepository) Update(instance *model.CloudInstance) error {
+	return r.db.Save(instance).Error
+}
+
+func (r *cloudInstanceRepository) Delete(id uint) error {
+	return r.db.Delete(&model.CloudInstance{}, id).Error
+}
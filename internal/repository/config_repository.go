@@ -1,185 +1,350 @@
-package repository
-
-import (
-	"FireFlow/internal/model"
-	"encoding/json"
-
-	"gorm.io/gorm"
-)
-
-type ConfigRepository interface {
-	// 通用配置项
-	GetConfigValue(key string) (string, error)
-	SetConfigValue(key, value, configType, category, description string) error
-	GetConfigsByCategory(category string) ([]model.ConfigItem, error)
-
-	// 云服务商配置
-	GetCloudProviderConfig(provider string) (*model.CloudProviderConfig, error)
-	GetCloudProviderConfigByID(id uint, config *model.CloudProviderConfig) error
-	SetCloudProviderConfig(config *model.CloudProviderConfig) error
-	GetDefaultCloudProvider() (*model.CloudProviderConfig, error)
-	ListCloudProviders() ([]model.CloudProviderConfig, error)
-	UpdateCloudProviderConfig(config *model.CloudProviderConfig) error
-	DeleteCloudProviderConfig(id uint) error
-
-	// 定时任务配置
-	GetCronJobConfig(jobName string) (*model.CronJobConfig, error)
-	SetCronJobConfig(config *model.CronJobConfig) error
-	ListCronJobs() ([]model.CronJobConfig, error)
-	UpdateCronJobStatus(jobName string, isEnabled bool) error
-	UpdateCronJobConfig(config *model.CronJobConfig) error
-	DeleteCronJobConfig(id uint) error
-}
-
-type configRepository struct {
-	db *gorm.DB
-}
-
-func NewConfigRepository(db *gorm.DB) ConfigRepository {
-	return &configRepository{db: db}
-}
-
-// 通用配置项方法
-func (r *configRepository) GetConfigValue(key string) (string, error) {
-	var config model.ConfigItem
-	result := r.db.Where("config_key = ? AND is_enabled = ?", key, true).First(&config)
-	if result.Error != nil {
-		return "", result.Error
-	}
-	return config.ConfigValue, nil
-}
-
-func (r *configRepository) SetConfigValue(key, value, configType, category, description string) error {
-	config := model.ConfigItem{
-		ConfigKey:   key,
-		ConfigValue: value,
-		ConfigType:  configType,
-		Category:    category,
-		Description: description,
-		IsEnabled:   true,
-	}
-
-	result := r.db.Where("config_key = ?", key).FirstOrCreate(&config)
-	if result.Error != nil {
-		return result.Error
-	}
-
-	// 更新值
-	return r.db.Model(&config).Updates(map[string]interface{}{
-		"config_value": value,
-		"config_type":  configType,
-		"category":     category,
-		"description":  description,
-	}).Error
-}
-
-func (r *configRepository) GetConfigsByCategory(category string) ([]model.ConfigItem, error) {
-	var configs []model.ConfigItem
-	result := r.db.Where("category = ? AND is_enabled = ?", category, true).Find(&configs)
-	return configs, result.Error
-}
-
-// 云服务商配置方法
-func (r *configRepository) GetCloudProviderConfig(provider string) (*model.CloudProviderConfig, error) {
-	var config model.CloudProviderConfig
-	result := r.db.Where("provider = ? AND is_enabled = ?", provider, true).First(&config)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return &config, nil
-}
-
-func (r *configRepository) GetCloudProviderConfigByID(id uint, config *model.CloudProviderConfig) error {
-	result := r.db.First(config, id)
-	return result.Error
-}
-
-func (r *configRepository) SetCloudProviderConfig(config *model.CloudProviderConfig) error {
-	// 如果设置为默认配置，先取消其他默认配置
-	if config.IsDefault {
-		r.db.Model(&model.CloudProviderConfig{}).Where("provider = ? AND id != ?", config.Provider, config.ID).Update("is_default", false)
-	}
-
-	// 直接创建新记录，不使用FirstOrCreate
-	result := r.db.Create(config)
-	return result.Error
-}
-
-func (r *configRepository) GetDefaultCloudProvider() (*model.CloudProviderConfig, error) {
-	var config model.CloudProviderConfig
-	result := r.db.Where("is_default = ? AND is_enabled = ?", true, true).First(&config)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return &config, nil
-}
-
-func (r *configRepository) ListCloudProviders() ([]model.CloudProviderConfig, error) {
-	var configs []model.CloudProviderConfig
-	result := r.db.Where("is_enabled = ?", true).Find(&configs)
-	return configs, result.Error
-}
-
-func (r *configRepository) UpdateCloudProviderConfig(config *model.CloudProviderConfig) error {
-	// 如果设置为默认配置，先取消其他默认配置
-	if config.IsDefault {
-		r.db.Model(&model.CloudProviderConfig{}).Where("id != ?", config.ID).Update("is_default", false)
-	}
-	return r.db.Save(config).Error
-}
-
-func (r *configRepository) DeleteCloudProviderConfig(id uint) error {
-	return r.db.Delete(&model.CloudProviderConfig{}, id).Error
-}
-
-// 定时任务配置方法
-func (r *configRepository) GetCronJobConfig(jobName string) (*model.CronJobConfig, error) {
-	var config model.CronJobConfig
-	result := r.db.Where("job_name = ?", jobName).First(&config)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return &config, nil
-}
-
-func (r *configRepository) SetCronJobConfig(config *model.CronJobConfig) error {
-	result := r.db.Where("job_name = ?", config.JobName).FirstOrCreate(config)
-	if result.Error != nil {
-		return result.Error
-	}
-
-	// 更新配置
-	return r.db.Model(config).Updates(config).Error
-}
-
-func (r *configRepository) ListCronJobs() ([]model.CronJobConfig, error) {
-	var configs []model.CronJobConfig
-	result := r.db.Find(&configs)
-	return configs, result.Error
-}
-
-func (r *configRepository) UpdateCronJobStatus(jobName string, isEnabled bool) error {
-	return r.db.Model(&model.CronJobConfig{}).Where("job_name = ?", jobName).Update("is_enabled", isEnabled).Error
-}
-
-func (r *configRepository) UpdateCronJobConfig(config *model.CronJobConfig) error {
-	return r.db.Save(config).Error
-}
-
-func (r *configRepository) DeleteCronJobConfig(id uint) error {
-	return r.db.Delete(&model.CronJobConfig{}, id).Error
-}
-
-// 辅助方法：将结构体转换为JSON字符串
-func StructToJSON(v interface{}) (string, error) {
-	data, err := json.Marshal(v)
-	if err != nil {
-		return "", err
-	}
-	return string(data), nil
-}
-
-// 辅助方法：从JSON字符串解析到结构体
-func JSONToStruct(jsonStr string, v interface{}) error {
-	return json.Unmarshal([]byte(jsonStr), v)
-}
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/secret"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type ConfigRepository interface {
+	// 通用配置项
+	GetConfigValue(key string) (string, error)
+	SetConfigValue(key, value, configType, category, description string) error
+	GetConfigsByCategory(category string) ([]model.ConfigItem, error)
+
+	// 云服务商配置
+	GetCloudProviderConfig(provider string) (*model.CloudProviderConfig, error)
+	GetCloudProviderConfigByID(id uint, config *model.CloudProviderConfig) error
+	SetCloudProviderConfig(config *model.CloudProviderConfig) error
+	GetDefaultCloudProvider() (*model.CloudProviderConfig, error)
+	ListCloudProviders() ([]model.CloudProviderConfig, error)
+	UpdateCloudProviderConfig(config *model.CloudProviderConfig) error
+	DeleteCloudProviderConfig(id uint) error
+	EncryptPlaintextSecrets() (int, error)
+	RotateSecrets(newSealer *secret.Sealer) (int, error)
+
+	// 定时任务配置
+	GetCronJobConfig(jobName string) (*model.CronJobConfig, error)
+	SetCronJobConfig(config *model.CronJobConfig) error
+	ListCronJobs() ([]model.CronJobConfig, error)
+	UpdateCronJobStatus(jobName string, isEnabled bool) error
+	UpdateCronJobConfig(config *model.CronJobConfig) error
+	DeleteCronJobConfig(id uint) error
+}
+
+type configRepository struct {
+	db     *gorm.DB
+	sealer *secret.Sealer // 为nil时敏感字段以明文存储
+}
+
+// NewConfigRepository 创建配置仓库，云服务商配置中的敏感字段使用sealer透明加解密
+func NewConfigRepository(db *gorm.DB, sealer *secret.Sealer) ConfigRepository {
+	return &configRepository{db: db, sealer: sealer}
+}
+
+// 通用配置项方法
+func (r *configRepository) GetConfigValue(key string) (string, error) {
+	var config model.ConfigItem
+	result := r.db.Where("config_key = ? AND is_enabled = ?", key, true).First(&config)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	return config.ConfigValue, nil
+}
+
+func (r *configRepository) SetConfigValue(key, value, configType, category, description string) error {
+	config := model.ConfigItem{
+		ConfigKey:   key,
+		ConfigValue: value,
+		ConfigType:  configType,
+		Category:    category,
+		Description: description,
+		IsEnabled:   true,
+	}
+
+	result := r.db.Where("config_key = ?", key).FirstOrCreate(&config)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 更新值
+	return r.db.Model(&config).Updates(map[string]interface{}{
+		"config_value": value,
+		"config_type":  configType,
+		"category":     category,
+		"description":  description,
+	}).Error
+}
+
+func (r *configRepository) GetConfigsByCategory(category string) ([]model.ConfigItem, error) {
+	var configs []model.ConfigItem
+	result := r.db.Where("category = ? AND is_enabled = ?", category, true).Find(&configs)
+	return configs, result.Error
+}
+
+// 云服务商配置方法
+func (r *configRepository) GetCloudProviderConfig(provider string) (*model.CloudProviderConfig, error) {
+	var config model.CloudProviderConfig
+	result := r.db.Where("provider = ? AND is_enabled = ?", provider, true).First(&config)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if err := r.decryptSecrets(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (r *configRepository) GetCloudProviderConfigByID(id uint, config *model.CloudProviderConfig) error {
+	result := r.db.First(config, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	return r.decryptSecrets(config)
+}
+
+func (r *configRepository) SetCloudProviderConfig(config *model.CloudProviderConfig) error {
+	// 如果设置为默认配置，先取消其他默认配置
+	if config.IsDefault {
+		r.db.Model(&model.CloudProviderConfig{}).Where("provider = ? AND id != ?", config.Provider, config.ID).Update("is_default", false)
+	}
+
+	// 直接创建新记录，不使用FirstOrCreate
+	return r.saveWithEncryptedSecrets(config, func(c *model.CloudProviderConfig) error {
+		return r.db.Create(c).Error
+	})
+}
+
+func (r *configRepository) GetDefaultCloudProvider() (*model.CloudProviderConfig, error) {
+	var config model.CloudProviderConfig
+	result := r.db.Where("is_default = ? AND is_enabled = ?", true, true).First(&config)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if err := r.decryptSecrets(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (r *configRepository) ListCloudProviders() ([]model.CloudProviderConfig, error) {
+	var configs []model.CloudProviderConfig
+	result := r.db.Where("is_enabled = ?", true).Find(&configs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for i := range configs {
+		if err := r.decryptSecrets(&configs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+func (r *configRepository) UpdateCloudProviderConfig(config *model.CloudProviderConfig) error {
+	// 如果设置为默认配置，先取消其他默认配置
+	if config.IsDefault {
+		r.db.Model(&model.CloudProviderConfig{}).Where("id != ?", config.ID).Update("is_default", false)
+	}
+	return r.saveWithEncryptedSecrets(config, func(c *model.CloudProviderConfig) error {
+		return r.db.Save(c).Error
+	})
+}
+
+// DeleteCloudProviderConfig 删除云服务配置及其下登记的实例
+func (r *configRepository) DeleteCloudProviderConfig(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cloud_config_id = ?", id).Delete(&model.CloudInstance{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.CloudProviderConfig{}, id).Error
+	})
+}
+
+// EncryptPlaintextSecrets 加密历史遗留的明文敏感字段，返回处理的记录数
+func (r *configRepository) EncryptPlaintextSecrets() (int, error) {
+	if r.sealer == nil {
+		return 0, nil
+	}
+
+	var configs []model.CloudProviderConfig
+	if err := r.db.Unscoped().Find(&configs).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range configs {
+		config := &configs[i]
+		if secretsEncrypted(config) {
+			continue
+		}
+		if err := r.encryptSecrets(config); err != nil {
+			return count, err
+		}
+		if err := r.updateSecretColumns(r.db, config); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RotateSecrets 使用当前主密钥解密所有敏感字段并用新主密钥重新加密，在一个事务内完成。
+// 已由新主密钥加密的字段保持不变，轮换中断后可使用同一新密钥重新执行。
+func (r *configRepository) RotateSecrets(newSealer *secret.Sealer) (int, error) {
+	if r.sealer == nil || newSealer == nil {
+		return 0, fmt.Errorf("both current and new master keys are required")
+	}
+
+	count := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var configs []model.CloudProviderConfig
+		if err := tx.Unscoped().Find(&configs).Error; err != nil {
+			return err
+		}
+
+		for i := range configs {
+			config := &configs[i]
+
+			var err error
+			if config.SecretId, err = reseal(r.sealer, newSealer, config.SecretId); err != nil {
+				return fmt.Errorf("failed to re-encrypt secret_id of cloud config %d: %v", config.ID, err)
+			}
+			if config.SecretKey, err = reseal(r.sealer, newSealer, config.SecretKey); err != nil {
+				return fmt.Errorf("failed to re-encrypt secret_key of cloud config %d: %v", config.ID, err)
+			}
+			if err := r.updateSecretColumns(tx, config); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	r.sealer = newSealer
+	return count, nil
+}
+
+// saveWithEncryptedSecrets 加密敏感字段后保存，保存完成后恢复调用方结构体中的明文
+func (r *configRepository) saveWithEncryptedSecrets(config *model.CloudProviderConfig, save func(*model.CloudProviderConfig) error) error {
+	secretID, secretKey := config.SecretId, config.SecretKey
+	defer func() {
+		config.SecretId, config.SecretKey = secretID, secretKey
+	}()
+
+	if err := r.encryptSecrets(config); err != nil {
+		return err
+	}
+	return save(config)
+}
+
+func (r *configRepository) encryptSecrets(config *model.CloudProviderConfig) error {
+	if r.sealer == nil {
+		return nil
+	}
+
+	var err error
+	if config.SecretId, err = r.sealer.Encrypt(config.SecretId); err != nil {
+		return err
+	}
+	config.SecretKey, err = r.sealer.Encrypt(config.SecretKey)
+	return err
+}
+
+func (r *configRepository) decryptSecrets(config *model.CloudProviderConfig) error {
+	if r.sealer == nil {
+		return nil
+	}
+
+	var err error
+	if config.SecretId, err = r.sealer.Decrypt(config.SecretId); err != nil {
+		return fmt.Errorf("failed to decrypt secret_id of cloud config %d: %v", config.ID, err)
+	}
+	if config.SecretKey, err = r.sealer.Decrypt(config.SecretKey); err != nil {
+		return fmt.Errorf("failed to decrypt secret_key of cloud config %d: %v", config.ID, err)
+	}
+	return nil
+}
+
+// updateSecretColumns 只更新敏感字段的存储形式，明文未变化因此不修改UpdatedAt
+func (r *configRepository) updateSecretColumns(db *gorm.DB, config *model.CloudProviderConfig) error {
+	return db.Unscoped().Model(&model.CloudProviderConfig{}).Where("id = ?", config.ID).UpdateColumns(map[string]interface{}{
+		"secret_id":  config.SecretId,
+		"secret_key": config.SecretKey,
+	}).Error
+}
+
+// reseal 将当前主密钥加密的值改用新主密钥加密，已由新主密钥加密的值原样返回
+func reseal(current, next *secret.Sealer, value string) (string, error) {
+	if next.Owns(value) {
+		return value, nil
+	}
+	plaintext, err := current.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return next.Encrypt(plaintext)
+}
+
+func secretsEncrypted(config *model.CloudProviderConfig) bool {
+	return (config.SecretId == "" || secret.IsEncrypted(config.SecretId)) &&
+		(config.SecretKey == "" || secret.IsEncrypted(config.SecretKey))
+}
+
+// 定时任务配置方法
+func (r *configRepository) GetCronJobConfig(jobName string) (*model.CronJobConfig, error) {
+	var config model.CronJobConfig
+	result := r.db.Where("job_name = ?", jobName).First(&config)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &config, nil
+}
+
+func (r *configRepository) SetCronJobConfig(config *model.CronJobConfig) error {
+	result := r.db.Where("job_name = ?", config.JobName).FirstOrCreate(config)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 更新配置
+	return r.db.Model(config).Updates(config).Error
+}
+
+func (r *configRepository) ListCronJobs() ([]model.CronJobConfig, error) {
+	var configs []model.CronJobConfig
+	result := r.db.Find(&configs)
+	return configs, result.Error
+}
+
+func (r *configRepository) UpdateCronJobStatus(jobName string, isEnabled bool) error {
+	return r.db.Model(&model.CronJobConfig{}).Where("job_name = ?", jobName).Update("is_enabled", isEnabled).Error
+}
+
+func (r *configRepository) UpdateCronJobConfig(config *model.CronJobConfig) error {
+	return r.db.Save(config).Error
+}
+
+func (r *configRepository) DeleteCronJobConfig(id uint) error {
+	return r.db.Delete(&model.CronJobConfig{}, id).Error
+}
+
+// 辅助方法：将结构体转换为JSON字符串
+func StructToJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// 辅助方法：从JSON字符串解析到结构体
+func JSONToStruct(jsonStr string, v interface{}) error {
+	return json.Unmarshal([]byte(jsonStr), v)
+}
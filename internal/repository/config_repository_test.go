@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/secret"
+	"strings"
+	"testing"
+)
+
+func newTestSealer(t *testing.T) *secret.Sealer {
+	t.Helper()
+	key, err := secret.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := secret.NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer
+}
+
+// storedSecrets 数据库中敏感字段的存储形式
+func storedSecrets(t *testing.T, repo *configRepository, id uint) (string, string) {
+	t.Helper()
+	var row model.CloudProviderConfig
+	if err := repo.db.Unscoped().First(&row, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return row.SecretId, row.SecretKey
+}
+
+func TestCloudConfigSecretsEncryptedAtRest(t *testing.T) {
+	sealer := newTestSealer(t)
+	repo := NewConfigRepository(newTestDB(t), sealer).(*configRepository)
+
+	config := &model.CloudProviderConfig{Provider: "Fake", SecretId: "AKIDexample", SecretKey: "secret-key", IsEnabled: true}
+	if err := repo.SetCloudProviderConfig(config); err != nil {
+		t.Fatalf("SetCloudProviderConfig: %v", err)
+	}
+	if config.SecretId != "AKIDexample" || config.SecretKey != "secret-key" {
+		t.Errorf("caller's plaintext was replaced: %q %q", config.SecretId, config.SecretKey)
+	}
+
+	id, key := storedSecrets(t, repo, config.ID)
+	if !sealer.Owns(id) || !sealer.Owns(key) || strings.Contains(id+key, "AKIDexample") || strings.Contains(id+key, "secret-key") {
+		t.Fatalf("secrets stored as %q, %q", id, key)
+	}
+
+	var loaded model.CloudProviderConfig
+	if err := repo.GetCloudProviderConfigByID(config.ID, &loaded); err != nil {
+		t.Fatalf("GetCloudProviderConfigByID: %v", err)
+	}
+	if loaded.SecretId != "AKIDexample" || loaded.SecretKey != "secret-key" {
+		t.Errorf("decrypted secrets %q, %q", loaded.SecretId, loaded.SecretKey)
+	}
+
+	// 另一个主密钥无法解密
+	other := NewConfigRepository(repo.db, newTestSealer(t))
+	if err := other.GetCloudProviderConfigByID(config.ID, &loaded); err == nil {
+		t.Error("secrets decrypted with a different master key")
+	}
+}
+
+func TestEncryptPlaintextSecrets(t *testing.T) {
+	db := newTestDB(t)
+	plain := NewConfigRepository(db, nil)
+	config := &model.CloudProviderConfig{Provider: "Fake", SecretId: "AKIDlegacy", SecretKey: "legacy-key", IsEnabled: true}
+	if err := plain.SetCloudProviderConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	sealer := newTestSealer(t)
+	repo := NewConfigRepository(db, sealer).(*configRepository)
+	for _, want := range []int{1, 0} {
+		count, err := repo.EncryptPlaintextSecrets()
+		if err != nil {
+			t.Fatalf("EncryptPlaintextSecrets: %v", err)
+		}
+		if count != want {
+			t.Errorf("EncryptPlaintextSecrets encrypted %d configs, want %d", count, want)
+		}
+	}
+	if id, key := storedSecrets(t, repo, config.ID); !sealer.Owns(id) || !sealer.Owns(key) {
+		t.Errorf("secrets stored as %q, %q", id, key)
+	}
+}
+
+func TestRotateSecrets(t *testing.T) {
+	db := newTestDB(t)
+	oldSealer := newTestSealer(t)
+	repo := NewConfigRepository(db, oldSealer).(*configRepository)
+	config := &model.CloudProviderConfig{Provider: "Fake", SecretId: "AKIDrotate", SecretKey: "rotate-key", IsEnabled: true}
+	if err := repo.SetCloudProviderConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	newSealer := newTestSealer(t)
+	if count, err := repo.RotateSecrets(newSealer); err != nil || count != 1 {
+		t.Fatalf("RotateSecrets = %d, %v", count, err)
+	}
+	if id, key := storedSecrets(t, repo, config.ID); !newSealer.Owns(id) || !newSealer.Owns(key) {
+		t.Fatalf("secrets not re-encrypted with the new key: %q, %q", id, key)
+	}
+
+	// 轮换后仓库使用新主密钥，只持有新主密钥的仓库也能解密
+	for _, r := range []ConfigRepository{repo, NewConfigRepository(db, newSealer)} {
+		var loaded model.CloudProviderConfig
+		if err := r.GetCloudProviderConfigByID(config.ID, &loaded); err != nil {
+			t.Fatalf("GetCloudProviderConfigByID: %v", err)
+		}
+		if loaded.SecretId != "AKIDrotate" || loaded.SecretKey != "rotate-key" {
+			t.Errorf("decrypted secrets %q, %q", loaded.SecretId, loaded.SecretKey)
+		}
+	}
+	if err := NewConfigRepository(db, oldSealer).GetCloudProviderConfigByID(config.ID, &model.CloudProviderConfig{}); err == nil {
+		t.Error("old master key still decrypts rotated secrets")
+	}
+
+	// 中断后使用同一新主密钥重新执行，已轮换的字段保持不变
+	id, key := storedSecrets(t, repo, config.ID)
+	if _, err := NewConfigRepository(db, oldSealer).RotateSecrets(newSealer); err != nil {
+		t.Fatalf("re-running RotateSecrets: %v", err)
+	}
+	if gotID, gotKey := storedSecrets(t, repo, config.ID); gotID != id || gotKey != key {
+		t.Error("re-running the rotation changed already rotated secrets")
+	}
+}
+
+// 云服务配置只能经配置仓库加密后保存：直接保存带关联配置的规则也不会写入明文凭证
+func TestRuleCannotPersistCloudConfig(t *testing.T) {
+	db := newTestDB(t)
+	rule := &model.FirewallRule{
+		Provider:    "Fake",
+		InstanceID:  "lhins-a",
+		Port:        "22",
+		Remark:      "ssh",
+		CloudConfig: model.CloudProviderConfig{Provider: "Fake", SecretId: "AKIDplain", SecretKey: "plain-key"},
+	}
+	if err := db.Create(rule).Error; err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	if err := db.Save(rule).Error; err != nil {
+		t.Fatalf("failed to save rule: %v", err)
+	}
+
+	var count int64
+	if err := db.Model(&model.CloudProviderConfig{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("saving a rule created %d cloud configs", count)
+	}
+}
@@ -1,63 +1,130 @@
-package repository
-
-import (
-	"FireFlow/internal/model"
-
-	"gorm.io/gorm"
-)
-
-type FirewallRepository interface {
-	GetAllEnabled() ([]model.FirewallRule, error)
-	GetAll() ([]model.FirewallRule, error)
-	GetByID(id uint) (*model.FirewallRule, error)
-	Create(rule *model.FirewallRule) error
-	Update(rule *model.FirewallRule) error
-	UpdateIP(id uint, ip string) error
-	Delete(id uint) error
-}
-
-type firewallRepo struct {
-	db *gorm.DB
-}
-
-// NewFirewallRepo creates a new repository.
-func NewFirewallRepo(db *gorm.DB) FirewallRepository {
-	return &firewallRepo{db: db}
-}
-
-func (r *firewallRepo) GetAllEnabled() ([]model.FirewallRule, error) {
-	var rules []model.FirewallRule
-	err := r.db.Where("enabled = ?", true).Find(&rules).Error
-	return rules, err
-}
-
-func (r *firewallRepo) GetAll() ([]model.FirewallRule, error) {
-	var rules []model.FirewallRule
-	err := r.db.Find(&rules).Error
-	return rules, err
-}
-
-func (r *firewallRepo) GetByID(id uint) (*model.FirewallRule, error) {
-	var rule model.FirewallRule
-	err := r.db.First(&rule, id).Error
-	if err != nil {
-		return nil, err
-	}
-	return &rule, nil
-}
-
-func (r *firewallRepo) Create(rule *model.FirewallRule) error {
-	return r.db.Create(rule).Error
-}
-
-func (r *firewallRepo) Update(rule *model.FirewallRule) error {
-	return r.db.Save(rule).Error
-}
-
-func (r *firewallRepo) UpdateIP(id uint, ip string) error {
-	return r.db.Model(&model.FirewallRule{}).Where("id = ?", id).Update("last_ip", ip).Error
-}
-
-func (r *firewallRepo) Delete(id uint) error {
-	return r.db.Unscoped().Delete(&model.FirewallRule{}, id).Error
-}
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FirewallRepository interface {
+	GetAllEnabled() ([]model.FirewallRule, error)
+	GetAll() ([]model.FirewallRule, error)
+	GetByID(id uint) (*model.FirewallRule, error)
+	// GetByGroup 返回规则组的所有成员规则
+	GetByGroup(groupID uint) ([]model.FirewallRule, error)
+	Create(rule *model.FirewallRule) error
+	Update(rule *model.FirewallRule) error
+	UpdateIP(id uint, ip string) error
+	// UpdateCloudState 只更新云端规则ID和上次同步的IP，不影响规则的其他字段
+	UpdateCloudState(id uint, ruleID, lastIP string) error
+	UpdateProbeResult(id uint, status, probeErr string, latencyMs int64, at time.Time) error
+	// SetPinned 固定或解除固定规则，不影响规则的其他字段
+	SetPinned(id uint, pinned bool) error
+	// Delete 删除规则及其各实例的云端状态
+	Delete(id uint) error
+	// ListTargets 返回规则在各实例上的云端状态
+	ListTargets(ruleID uint) ([]model.FirewallRuleTarget, error)
+	// SaveTarget 按规则、云服务配置和实例保存云端状态，已存在时覆盖
+	SaveTarget(target *model.FirewallRuleTarget) error
+	// DeleteTarget 删除规则在一个实例上的云端状态
+	DeleteTarget(ruleID, cloudConfigID uint, instanceID string) error
+}
+
+type firewallRepo struct {
+	db *gorm.DB
+}
+
+// NewFirewallRepo creates a new repository.
+func NewFirewallRepo(db *gorm.DB) FirewallRepository {
+	return &firewallRepo{db: db}
+}
+
+func (r *firewallRepo) GetAllEnabled() ([]model.FirewallRule, error) {
+	var rules []model.FirewallRule
+	err := r.db.Preload("Targets").Where("enabled = ?", true).Find(&rules).Error
+	return rules, err
+}
+
+func (r *firewallRepo) GetAll() ([]model.FirewallRule, error) {
+	var rules []model.FirewallRule
+	err := r.db.Preload("Targets").Find(&rules).Error
+	return rules, err
+}
+
+func (r *firewallRepo) GetByID(id uint) (*model.FirewallRule, error) {
+	var rule model.FirewallRule
+	err := r.db.Preload("Targets").First(&rule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *firewallRepo) GetByGroup(groupID uint) ([]model.FirewallRule, error) {
+	var rules []model.FirewallRule
+	err := r.db.Preload("Targets").Where("group_id = ?", groupID).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// Create 只写入规则本身，不级联写入关联的云服务配置
+func (r *firewallRepo) Create(rule *model.FirewallRule) error {
+	return r.db.Omit(clause.Associations).Create(rule).Error
+}
+
+// Update 只写入规则本身，不级联写入关联的云服务配置
+func (r *firewallRepo) Update(rule *model.FirewallRule) error {
+	return r.db.Omit(clause.Associations).Save(rule).Error
+}
+
+func (r *firewallRepo) UpdateIP(id uint, ip string) error {
+	return r.db.Model(&model.FirewallRule{}).Where("id = ?", id).Update("last_ip", ip).Error
+}
+
+func (r *firewallRepo) UpdateCloudState(id uint, ruleID, lastIP string) error {
+	return r.db.Model(&model.FirewallRule{}).Where("id = ?", id).Updates(map[string]any{
+		"rule_id": ruleID,
+		"last_ip": lastIP,
+	}).Error
+}
+
+func (r *firewallRepo) SetPinned(id uint, pinned bool) error {
+	return r.db.Model(&model.FirewallRule{}).Where("id = ?", id).Update("pinned", pinned).Error
+}
+
+func (r *firewallRepo) UpdateProbeResult(id uint, status, probeErr string, latencyMs int64, at time.Time) error {
+	return r.db.Model(&model.FirewallRule{}).Where("id = ?", id).Updates(map[string]any{
+		"probe_status":     status,
+		"probe_error":      probeErr,
+		"probe_latency_ms": latencyMs,
+		"probed_at":        at,
+	}).Error
+}
+
+func (r *firewallRepo) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", id).Delete(&model.FirewallRuleTarget{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.FirewallRule{}, id).Error
+	})
+}
+
+func (r *firewallRepo) ListTargets(ruleID uint) ([]model.FirewallRuleTarget, error) {
+	var targets []model.FirewallRuleTarget
+	err := r.db.Where("rule_id = ?", ruleID).Order("id").Find(&targets).Error
+	return targets, err
+}
+
+func (r *firewallRepo) SaveTarget(target *model.FirewallRuleTarget) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "rule_id"}, {Name: "cloud_config_id"}, {Name: "instance_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"provider", "cloud_rule_id", "last_ip", "updated_at"}),
+	}).Create(target).Error
+}
+
+func (r *firewallRepo) DeleteTarget(ruleID, cloudConfigID uint, instanceID string) error {
+	return r.db.Where("rule_id = ? AND cloud_config_id = ? AND instance_id = ?", ruleID, cloudConfigID, instanceID).
+		Delete(&model.FirewallRuleTarget{}).Error
+}
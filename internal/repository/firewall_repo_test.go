@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: "sqlite",
+		DSN:        filepath.Join(t.TempDir(), "fireflow.db"),
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&model.FirewallRule{}, &model.FirewallRuleTarget{}, &model.CloudProviderConfig{}, &model.CloudInstance{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// 保存规则时不写入关联的云服务配置：既不能改变规则所属的配置，也不能绕过配置仓库创建配置
+func TestFirewallRepoDoesNotWriteCloudConfig(t *testing.T) {
+	db := newTestDB(t)
+	configs := NewConfigRepository(db, nil)
+	for _, instanceID := range []string{"lhins-a", "lhins-b"} {
+		if err := configs.SetCloudProviderConfig(&model.CloudProviderConfig{Provider: "Fake", SecretId: "id", SecretKey: "key", InstanceId: instanceID, IsEnabled: true}); err != nil {
+			t.Fatalf("failed to create cloud config: %v", err)
+		}
+	}
+	countConfigs := func() int64 {
+		var count int64
+		if err := db.Model(&model.CloudProviderConfig{}).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	repo := NewFirewallRepo(db)
+	rule := &model.FirewallRule{
+		Provider:      "Fake",
+		CloudConfigID: 1,
+		InstanceID:    "lhins-a",
+		Port:          "22",
+		Remark:        "ssh",
+		CloudConfig:   model.CloudProviderConfig{Model: gorm.Model{ID: 2}, Provider: "Fake", SecretKey: "changed"},
+	}
+	if err := repo.Create(rule); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	rule.CloudConfig = model.CloudProviderConfig{Provider: "Fake", SecretId: "injected", SecretKey: "plaintext"}
+	rule.Port = "2222"
+	if err := repo.Update(rule); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	stored, err := repo.GetByID(rule.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if stored.CloudConfigID != 1 || stored.Port != "2222" {
+		t.Errorf("stored rule cloud_config_id %d, port %q", stored.CloudConfigID, stored.Port)
+	}
+	if count := countConfigs(); count != 2 {
+		t.Errorf("got %d cloud configs, want 2", count)
+	}
+	var second model.CloudProviderConfig
+	if err := configs.GetCloudProviderConfigByID(2, &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.SecretKey != "key" {
+		t.Errorf("cloud config 2 secret key was overwritten with %q", second.SecretKey)
+	}
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/secret"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type NotificationRepository interface {
+	ListChannels() ([]model.NotificationChannel, error)
+	ListEnabledChannels() ([]model.NotificationChannel, error)
+	GetChannelByID(id uint) (*model.NotificationChannel, error)
+	CreateChannel(channel *model.NotificationChannel) error
+	UpdateChannel(channel *model.NotificationChannel) error
+	DeleteChannel(id uint) error
+
+	// 渠道配置中包含机器人令牌、邮箱密码等，使用主密钥加密存储
+	EncryptPlaintextSecrets() (int, error)
+	RotateSecrets(newSealer *secret.Sealer) (int, error)
+}
+
+type notificationRepository struct {
+	db     *gorm.DB
+	sealer *secret.Sealer // 为nil时渠道配置以明文存储
+}
+
+// NewNotificationRepository 创建通知渠道仓库，渠道配置使用sealer透明加解密
+func NewNotificationRepository(db *gorm.DB, sealer *secret.Sealer) NotificationRepository {
+	return &notificationRepository{db: db, sealer: sealer}
+}
+
+func (r *notificationRepository) ListChannels() ([]model.NotificationChannel, error) {
+	var channels []model.NotificationChannel
+	if err := r.db.Order("id").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	return channels, r.decryptAll(channels)
+}
+
+func (r *notificationRepository) ListEnabledChannels() ([]model.NotificationChannel, error) {
+	var channels []model.NotificationChannel
+	if err := r.db.Where("is_enabled = ?", true).Order("id").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	return channels, r.decryptAll(channels)
+}
+
+func (r *notificationRepository) GetChannelByID(id uint) (*model.NotificationChannel, error) {
+	var channel model.NotificationChannel
+	if err := r.db.First(&channel, id).Error; err != nil {
+		return nil, err
+	}
+	if err := r.decrypt(&channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (r *notificationRepository) CreateChannel(channel *model.NotificationChannel) error {
+	return r.saveEncrypted(channel, func(c *model.NotificationChannel) error {
+		return r.db.Create(c).Error
+	})
+}
+
+func (r *notificationRepository) UpdateChannel(channel *model.NotificationChannel) error {
+	return r.saveEncrypted(channel, func(c *model.NotificationChannel) error {
+		return r.db.Save(c).Error
+	})
+}
+
+func (r *notificationRepository) DeleteChannel(id uint) error {
+	return r.db.Delete(&model.NotificationChannel{}, id).Error
+}
+
+// EncryptPlaintextSecrets 加密以明文存储的渠道配置，返回处理的记录数
+func (r *notificationRepository) EncryptPlaintextSecrets() (int, error) {
+	if r.sealer == nil {
+		return 0, nil
+	}
+
+	var channels []model.NotificationChannel
+	if err := r.db.Unscoped().Find(&channels).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, channel := range channels {
+		if channel.Config == "" || secret.IsEncrypted(channel.Config) {
+			continue
+		}
+		encrypted, err := r.sealer.Encrypt(channel.Config)
+		if err != nil {
+			return count, err
+		}
+		if err := r.updateConfigColumn(r.db, channel.ID, encrypted); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RotateSecrets 使用新主密钥重新加密所有渠道配置，在一个事务内完成，已由新主密钥加密的配置保持不变
+func (r *notificationRepository) RotateSecrets(newSealer *secret.Sealer) (int, error) {
+	if r.sealer == nil || newSealer == nil {
+		return 0, fmt.Errorf("both current and new master keys are required")
+	}
+
+	count := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var channels []model.NotificationChannel
+		if err := tx.Unscoped().Find(&channels).Error; err != nil {
+			return err
+		}
+
+		for _, channel := range channels {
+			encrypted, err := reseal(r.sealer, newSealer, channel.Config)
+			if err != nil {
+				return fmt.Errorf("failed to re-encrypt notification channel %d: %v", channel.ID, err)
+			}
+			if err := r.updateConfigColumn(tx, channel.ID, encrypted); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	r.sealer = newSealer
+	return count, nil
+}
+
+// saveEncrypted 加密渠道配置后保存，保存完成后恢复调用方结构体中的明文
+func (r *notificationRepository) saveEncrypted(channel *model.NotificationChannel, save func(*model.NotificationChannel) error) error {
+	plaintext := channel.Config
+	defer func() {
+		channel.Config = plaintext
+	}()
+
+	if r.sealer != nil {
+		encrypted, err := r.sealer.Encrypt(channel.Config)
+		if err != nil {
+			return err
+		}
+		channel.Config = encrypted
+	}
+	return save(channel)
+}
+
+func (r *notificationRepository) decrypt(channel *model.NotificationChannel) error {
+	if r.sealer == nil {
+		return nil
+	}
+	plaintext, err := r.sealer.Decrypt(channel.Config)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt config of notification channel %d: %v", channel.ID, err)
+	}
+	channel.Config = plaintext
+	return nil
+}
+
+func (r *notificationRepository) decryptAll(channels []model.NotificationChannel) error {
+	for i := range channels {
+		if err := r.decrypt(&channels[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateConfigColumn 只更新配置的存储形式，明文未变化因此不修改UpdatedAt
+func (r *notificationRepository) updateConfigColumn(db *gorm.DB, id uint, config string) error {
+	return db.Unscoped().Model(&model.NotificationChannel{}).Where("id = ?", id).UpdateColumn("config", config).Error
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// RuleGroupRepository 规则组及其应用的实例
+type RuleGroupRepository interface {
+	List() ([]model.RuleGroup, error)
+	ListByTemplate(templateID uint) ([]model.RuleGroup, error)
+	GetByID(id uint) (*model.RuleGroup, error)
+	Create(group *model.RuleGroup) error
+	// Update 保存规则组并将实例列表替换为group.Targets
+	Update(group *model.RuleGroup) error
+	SetEnabled(id uint, enabled bool) error
+	Delete(id uint) error
+}
+
+type ruleGroupRepository struct {
+	db *gorm.DB
+}
+
+func NewRuleGroupRepository(db *gorm.DB) RuleGroupRepository {
+	return &ruleGroupRepository{db: db}
+}
+
+func (r *ruleGroupRepository) List() ([]model.RuleGroup, error) {
+	var groups []model.RuleGroup
+	err := r.db.Preload("Targets").Order("id").Find(&groups).Error
+	return groups, err
+}
+
+func (r *ruleGroupRepository) ListByTemplate(templateID uint) ([]model.RuleGroup, error) {
+	var groups []model.RuleGroup
+	err := r.db.Preload("Targets").Where("template_id = ?", templateID).Order("id").Find(&groups).Error
+	return groups, err
+}
+
+func (r *ruleGroupRepository) GetByID(id uint) (*model.RuleGroup, error) {
+	var group model.RuleGroup
+	if err := r.db.Preload("Targets").First(&group, id).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// Create 保存规则组并新建其实例记录，忽略传入的实例ID，不会改写其他规则组的实例
+func (r *ruleGroupRepository) Create(group *model.RuleGroup) error {
+	for i := range group.Targets {
+		group.Targets[i].ID = 0
+		group.Targets[i].GroupID = 0
+	}
+	return r.db.Create(group).Error
+}
+
+func (r *ruleGroupRepository) Update(group *model.RuleGroup) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Targets").Save(group).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&model.RuleGroupTarget{}).Error; err != nil {
+			return err
+		}
+		for i := range group.Targets {
+			group.Targets[i].ID = 0
+			group.Targets[i].GroupID = group.ID
+		}
+		if len(group.Targets) == 0 {
+			return nil
+		}
+		return tx.Create(&group.Targets).Error
+	})
+}
+
+func (r *ruleGroupRepository) SetEnabled(id uint, enabled bool) error {
+	return r.db.Model(&model.RuleGroup{}).Where("id = ?", id).Update("enabled", enabled).Error
+}
+
+func (r *ruleGroupRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&model.RuleGroupTarget{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.RuleGroup{}, id).Error
+	})
+}
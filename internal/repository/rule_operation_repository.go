@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 已结束、无需恢复的操作状态
+var finishedRuleOpStates = []string{model.RuleOpDone, model.RuleOpRolledBack, model.RuleOpAbandoned}
+
+// 新来源已在云端生效的操作状态
+var appliedRuleOpStates = []string{model.RuleOpCreated, model.RuleOpPendingDelete, model.RuleOpDone}
+
+// RuleOperationRepository 云端规则操作日志
+type RuleOperationRepository interface {
+	Create(op *model.RuleOperation) error
+	Update(op *model.RuleOperation) error
+	// ListUnfinished 按创建顺序返回规则尚未结束的操作
+	ListUnfinished(ruleID uint) ([]model.RuleOperation, error)
+	// AbandonUnfinished 将规则尚未结束的操作标记为放弃，用于规则被删除时
+	AbandonUnfinished(ruleID uint) error
+	GetByID(id uint) (*model.RuleOperation, error)
+	// ListByRule 按时间倒序返回规则最近的操作，limit<=0 时不限制数量
+	ListByRule(ruleID uint, limit int) ([]model.RuleOperation, error)
+	// ListAppliedByRun 按执行顺序返回同步任务中已在云端生效的操作
+	ListAppliedByRun(runID string) ([]model.RuleOperation, error)
+}
+
+type ruleOperationRepository struct {
+	db *gorm.DB
+}
+
+func NewRuleOperationRepository(db *gorm.DB) RuleOperationRepository {
+	return &ruleOperationRepository{db: db}
+}
+
+func (r *ruleOperationRepository) Create(op *model.RuleOperation) error {
+	return r.db.Create(op).Error
+}
+
+func (r *ruleOperationRepository) Update(op *model.RuleOperation) error {
+	return r.db.Save(op).Error
+}
+
+func (r *ruleOperationRepository) ListUnfinished(ruleID uint) ([]model.RuleOperation, error) {
+	var ops []model.RuleOperation
+	err := r.db.
+		Where("rule_id = ? AND state NOT IN ?", ruleID, finishedRuleOpStates).
+		Order("id").
+		Find(&ops).Error
+	return ops, err
+}
+
+func (r *ruleOperationRepository) AbandonUnfinished(ruleID uint) error {
+	return r.db.Model(&model.RuleOperation{}).
+		Where("rule_id = ? AND state NOT IN ?", ruleID, finishedRuleOpStates).
+		Update("state", model.RuleOpAbandoned).Error
+}
+
+func (r *ruleOperationRepository) GetByID(id uint) (*model.RuleOperation, error) {
+	var op model.RuleOperation
+	if err := r.db.First(&op, id).Error; err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+func (r *ruleOperationRepository) ListByRule(ruleID uint, limit int) ([]model.RuleOperation, error) {
+	var ops []model.RuleOperation
+	query := r.db.Where("rule_id = ?", ruleID).Order("id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&ops).Error
+	return ops, err
+}
+
+func (r *ruleOperationRepository) ListAppliedByRun(runID string) ([]model.RuleOperation, error) {
+	var ops []model.RuleOperation
+	err := r.db.
+		Where("run_id = ? AND state IN ?", runID, appliedRuleOpStates).
+		Order("id").
+		Find(&ops).Error
+	return ops, err
+}
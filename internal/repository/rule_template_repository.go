@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// RuleTemplateRepository 规则模板及其规则
+type RuleTemplateRepository interface {
+	List() ([]model.RuleTemplate, error)
+	GetByID(id uint) (*model.RuleTemplate, error)
+	Create(template *model.RuleTemplate) error
+	// Update 保存模板并将模板规则替换为template.Items：ID为0的新建，缺少的删除
+	Update(template *model.RuleTemplate) error
+	Delete(id uint) error
+}
+
+type ruleTemplateRepository struct {
+	db *gorm.DB
+}
+
+func NewRuleTemplateRepository(db *gorm.DB) RuleTemplateRepository {
+	return &ruleTemplateRepository{db: db}
+}
+
+func (r *ruleTemplateRepository) List() ([]model.RuleTemplate, error) {
+	var templates []model.RuleTemplate
+	err := r.db.Preload("Items").Order("id").Find(&templates).Error
+	return templates, err
+}
+
+func (r *ruleTemplateRepository) GetByID(id uint) (*model.RuleTemplate, error) {
+	var template model.RuleTemplate
+	if err := r.db.Preload("Items").First(&template, id).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// Create 保存模板并新建其规则，忽略传入的规则ID，不会改写其他模板的规则
+func (r *ruleTemplateRepository) Create(template *model.RuleTemplate) error {
+	for i := range template.Items {
+		template.Items[i].ID = 0
+		template.Items[i].TemplateID = 0
+	}
+	return r.db.Create(template).Error
+}
+
+func (r *ruleTemplateRepository) Update(template *model.RuleTemplate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Items").Save(template).Error; err != nil {
+			return err
+		}
+
+		// 只有模板已有的规则按ID更新，其他ID视为新规则，不会改写其他模板的规则
+		var existing []uint
+		if err := tx.Model(&model.RuleTemplateItem{}).Where("template_id = ?", template.ID).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		for i := range template.Items {
+			if !slices.Contains(existing, template.Items[i].ID) {
+				template.Items[i].ID = 0
+			}
+		}
+
+		keep := []uint{0}
+		for _, item := range template.Items {
+			if item.ID != 0 {
+				keep = append(keep, item.ID)
+			}
+		}
+		if err := tx.Where("template_id = ? AND id NOT IN ?", template.ID, keep).Delete(&model.RuleTemplateItem{}).Error; err != nil {
+			return err
+		}
+		for i := range template.Items {
+			template.Items[i].TemplateID = template.ID
+			if err := tx.Save(&template.Items[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *ruleTemplateRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", id).Delete(&model.RuleTemplateItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.RuleTemplate{}, id).Error
+	})
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// SafeguardRepository 实例防锁死保护配置
+type SafeguardRepository interface {
+	List() ([]model.InstanceSafeguard, error)
+	GetByID(id uint) (*model.InstanceSafeguard, error)
+	// GetByInstance 返回实例的保护配置，未配置时返回 gorm.ErrRecordNotFound
+	GetByInstance(cloudConfigID uint, instanceID string) (*model.InstanceSafeguard, error)
+	Create(safeguard *model.InstanceSafeguard) error
+	Update(safeguard *model.InstanceSafeguard) error
+	Delete(id uint) error
+}
+
+type safeguardRepository struct {
+	db *gorm.DB
+}
+
+func NewSafeguardRepository(db *gorm.DB) SafeguardRepository {
+	return &safeguardRepository{db: db}
+}
+
+func (r *safeguardRepository) List() ([]model.InstanceSafeguard, error) {
+	var safeguards []model.InstanceSafeguard
+	err := r.db.Order("id").Find(&safeguards).Error
+	return safeguards, err
+}
+
+func (r *safeguardRepository) GetByID(id uint) (*model.InstanceSafeguard, error) {
+	var safeguard model.InstanceSafeguard
+	if err := r.db.First(&safeguard, id).Error; err != nil {
+		return nil, err
+	}
+	return &safeguard, nil
+}
+
+func (r *safeguardRepository) GetByInstance(cloudConfigID uint, instanceID string) (*model.InstanceSafeguard, error) {
+	var safeguard model.InstanceSafeguard
+	err := r.db.
+		Where("cloud_config_id = ? AND instance_id = ?", cloudConfigID, instanceID).
+		First(&safeguard).Error
+	if err != nil {
+		return nil, err
+	}
+	return &safeguard, nil
+}
+
+func (r *safeguardRepository) Create(safeguard *model.InstanceSafeguard) error {
+	return r.db.Create(safeguard).Error
+}
+
+func (r *safeguardRepository) Update(safeguard *model.InstanceSafeguard) error {
+	return r.db.Save(safeguard).Error
+}
+
+func (r *safeguardRepository) Delete(id uint) error {
+	return r.db.Delete(&model.InstanceSafeguard{}, id).Error
+}
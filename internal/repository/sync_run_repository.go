@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// SyncRunRepository 同步任务记录
+type SyncRunRepository interface {
+	Create(run *model.SyncRun) error
+	Update(run *model.SyncRun) error
+	GetByID(id string) (*model.SyncRun, error)
+	// ListInterrupted 返回仍为running，或已中断但尚未调度补偿同步的任务
+	ListInterrupted() ([]model.SyncRun, error)
+}
+
+type syncRunRepository struct {
+	db *gorm.DB
+}
+
+func NewSyncRunRepository(db *gorm.DB) SyncRunRepository {
+	return &syncRunRepository{db: db}
+}
+
+func (r *syncRunRepository) Create(run *model.SyncRun) error {
+	return r.db.Create(run).Error
+}
+
+func (r *syncRunRepository) Update(run *model.SyncRun) error {
+	return r.db.Save(run).Error
+}
+
+func (r *syncRunRepository) GetByID(id string) (*model.SyncRun, error) {
+	var run model.SyncRun
+	if err := r.db.First(&run, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *syncRunRepository) ListInterrupted() ([]model.SyncRun, error) {
+	var runs []model.SyncRun
+	err := r.db.
+		Where("status = ? OR (status = ? AND reconciled_by = ?)", model.SyncRunRunning, model.SyncRunInterrupted, "").
+		Order("started_at").
+		Find(&runs).Error
+	return runs, err
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"FireFlow/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserRepository interface {
+	// 用户
+	CountUsers() (int64, error)
+	GetUserByID(id uint) (*model.User, error)
+	GetUserByUsername(username string) (*model.User, error)
+	ListUsers() ([]model.User, error)
+	CreateUser(user *model.User) error
+	UpdateUser(user *model.User) error
+	DeleteUser(id uint) error
+	CountUsersByRole(role string) (int64, error)
+
+	// 用户可访问的云服务配置范围
+	ListUserScopes(userID uint) ([]uint, error)
+	SetUserScopes(userID uint, cloudConfigIDs []uint) error
+
+	// 会话
+	CreateSession(session *model.Session) error
+	GetSessionByTokenHash(tokenHash string) (*model.Session, error)
+	DeleteSessionByTokenHash(tokenHash string) error
+	DeleteSessionsByUser(userID uint) error
+	DeleteExpiredSessions(now time.Time) error
+
+	// API令牌
+	CreateAPIToken(token *model.APIToken) error
+	GetAPITokenByHash(tokenHash string) (*model.APIToken, error)
+	ListAPITokens(userID uint) ([]model.APIToken, error)
+	DeleteAPIToken(userID, id uint) error
+	TouchAPIToken(id uint, usedAt time.Time) error
+}
+
+type userRepository struct {
+	db *gorm.DB
+}
+
+func NewUserRepository(db *gorm.DB) UserRepository {
+	return &userRepository{db: db}
+}
+
+// 用户方法
+func (r *userRepository) CountUsers() (int64, error) {
+	var count int64
+	err := r.db.Model(&model.User{}).Count(&count).Error
+	return count, err
+}
+
+func (r *userRepository) GetUserByID(id uint) (*model.User, error) {
+	var user model.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) GetUserByUsername(username string) (*model.User, error) {
+	var user model.User
+	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) ListUsers() ([]model.User, error) {
+	var users []model.User
+	err := r.db.Order("id").Find(&users).Error
+	return users, err
+}
+
+func (r *userRepository) CreateUser(user *model.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *userRepository) UpdateUser(user *model.User) error {
+	return r.db.Save(user).Error
+}
+
+func (r *userRepository) DeleteUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&model.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&model.APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.UserCloudScope{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.User{}, id).Error
+	})
+}
+
+// CountUsersByRole 统计指定角色的已启用用户数
+func (r *userRepository) CountUsersByRole(role string) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.User{}).Where("role = ? AND is_enabled = ?", role, true).Count(&count).Error
+	return count, err
+}
+
+// 用户云服务配置范围方法
+func (r *userRepository) ListUserScopes(userID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&model.UserCloudScope{}).Where("user_id = ?", userID).Order("cloud_config_id").Pluck("cloud_config_id", &ids).Error
+	return ids, err
+}
+
+func (r *userRepository) SetUserScopes(userID uint, cloudConfigIDs []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.UserCloudScope{}).Error; err != nil {
+			return err
+		}
+		for _, id := range cloudConfigIDs {
+			scope := &model.UserCloudScope{UserID: userID, CloudConfigID: id}
+			if err := tx.Create(scope).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 会话方法
+func (r *userRepository) CreateSession(session *model.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *userRepository) GetSessionByTokenHash(tokenHash string) (*model.Session, error) {
+	var session model.Session
+	if err := r.db.Preload("User").Where("token_hash = ?", tokenHash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *userRepository) DeleteSessionByTokenHash(tokenHash string) error {
+	return r.db.Unscoped().Where("token_hash = ?", tokenHash).Delete(&model.Session{}).Error
+}
+
+func (r *userRepository) DeleteSessionsByUser(userID uint) error {
+	return r.db.Unscoped().Where("user_id = ?", userID).Delete(&model.Session{}).Error
+}
+
+func (r *userRepository) DeleteExpiredSessions(now time.Time) error {
+	return r.db.Unscoped().Where("expires_at < ?", now).Delete(&model.Session{}).Error
+}
+
+// API令牌方法
+func (r *userRepository) CreateAPIToken(token *model.APIToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *userRepository) GetAPITokenByHash(tokenHash string) (*model.APIToken, error) {
+	var token model.APIToken
+	if err := r.db.Preload("User").Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *userRepository) ListAPITokens(userID uint) ([]model.APIToken, error) {
+	var tokens []model.APIToken
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *userRepository) DeleteAPIToken(userID, id uint) error {
+	result := r.db.Unscoped().Where("user_id = ?", userID).Delete(&model.APIToken{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) TouchAPIToken(id uint, usedAt time.Time) error {
+	return r.db.Model(&model.APIToken{}).Where("id = ?", id).UpdateColumn("last_used_at", usedAt).Error
+}
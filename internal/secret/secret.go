@@ -0,0 +1,254 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 加密字段格式：enc:v1:<主密钥ID>:<被主密钥加密的数据密钥>:<被数据密钥加密的内容>
+const (
+	encryptedPrefix = "enc:v1:"
+	masterKeySize   = 32
+)
+
+// 主密钥来源的环境变量
+const (
+	EnvMasterKey     = "FIREFLOW_MASTER_KEY"      // base64编码的32字节主密钥
+	EnvMasterKeyFile = "FIREFLOW_MASTER_KEY_FILE" // 主密钥文件路径
+)
+
+// ErrKeyMismatch 数据由其他主密钥加密
+var ErrKeyMismatch = errors.New("secret was encrypted with a different master key")
+
+// Sealer 使用信封加密保护敏感字段：每个值使用随机数据密钥(DEK)加密，数据密钥再由主密钥(KEK)加密
+type Sealer struct {
+	kek   cipher.AEAD
+	keyID string
+}
+
+// NewSealer 使用32字节主密钥创建Sealer
+func NewSealer(masterKey []byte) (*Sealer, error) {
+	if len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(masterKey))
+	}
+
+	kek, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(masterKey)
+	return &Sealer{
+		kek:   kek,
+		keyID: hex.EncodeToString(sum[:4]),
+	}, nil
+}
+
+// KeyID 主密钥标识，用于识别数据由哪个主密钥加密
+func (s *Sealer) KeyID() string {
+	return s.keyID
+}
+
+// Encrypt 加密明文，空字符串和已加密的值原样返回
+func (s *Sealer) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+
+	dek := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %v", err)
+	}
+
+	wrappedKey, err := seal(s.kek, dek, []byte(s.keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %v", err)
+	}
+
+	dataCipher, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataCipher, []byte(plaintext), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	return encryptedPrefix + s.keyID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt 解密由Encrypt生成的值，未加密的历史明文原样返回
+func (s *Sealer) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	if parts[0] != s.keyID {
+		return "", ErrKeyMismatch
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key: %v", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %v", err)
+	}
+
+	dek, err := open(s.kek, wrappedKey, []byte(s.keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	dataCipher, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataCipher, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// Owns 判断值是否已由当前主密钥加密
+func (s *Sealer) Owns(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix+s.keyID+":")
+}
+
+// IsEncrypted 判断值是否为加密格式
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Mask 掩码敏感字段用于展示，只保留首尾4个字符
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) <= 8 {
+		return "****"
+	}
+	return value[:4] + "****" + value[len(value)-4:]
+}
+
+// IsMasked 判断值是否为Mask生成的掩码（前端未修改敏感字段时原样提交）
+func IsMasked(value string) bool {
+	return strings.Contains(value, "****")
+}
+
+// GenerateKey 生成新的随机主密钥
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %v", err)
+	}
+	return key, nil
+}
+
+// EncodeKey 将主密钥编码为base64文本
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// DecodeKey 解析base64编码的主密钥
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %v", err)
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// ReadKeyFile 从文件读取base64编码的主密钥
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeKey(string(data))
+}
+
+// WriteKeyFile 将主密钥写入文件，仅所有者可读写
+func WriteKeyFile(path string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(EncodeKey(key)+"\n"), 0600)
+}
+
+// LoadMasterKey 按顺序从环境变量、密钥文件加载主密钥；
+// 均未配置且密钥文件不存在时生成新密钥并写入defaultFile，created为true。
+func LoadMasterKey(defaultFile string) (key []byte, source string, created bool, err error) {
+	if encoded := os.Getenv(EnvMasterKey); encoded != "" {
+		key, err = DecodeKey(encoded)
+		return key, "env:" + EnvMasterKey, false, err
+	}
+
+	path := defaultFile
+	if envPath := os.Getenv(EnvMasterKeyFile); envPath != "" {
+		path = envPath
+	}
+
+	key, err = ReadKeyFile(path)
+	if err == nil {
+		return key, path, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, path, false, fmt.Errorf("failed to read master key file %s: %v", path, err)
+	}
+
+	key, err = GenerateKey()
+	if err != nil {
+		return nil, path, false, err
+	}
+	if err := WriteKeyFile(path, key); err != nil {
+		return nil, path, false, fmt.Errorf("failed to write master key file %s: %v", path, err)
+	}
+	return key, path, true, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal 加密并将随机nonce放在密文前
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
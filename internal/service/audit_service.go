@@ -0,0 +1,248 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"time"
+)
+
+const (
+	// 默认审计日志保留天数
+	defaultAuditRetentionDays = 90
+	// 单次查询最多返回的审计日志条数
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+	// 脱敏后的占位符
+	redactedValue = "[REDACTED]"
+)
+
+// 审计日志中需要脱敏的字段
+var sensitiveAuditFields = map[string]bool{
+	"secret_id":     true,
+	"secret_key":    true,
+	"password":      true,
+	"old_password":  true,
+	"new_password":  true,
+	"password_hash": true,
+	"token":         true,
+	"setup_token":   true,
+}
+
+// 审计对比时忽略的字段
+var ignoredAuditFields = map[string]bool{
+	"CreatedAt": true,
+	"UpdatedAt": true,
+	"DeletedAt": true,
+}
+
+// Actor 变更的发起者
+type Actor struct {
+	Type      string // model.AuditActorUser 等
+	UserID    uint
+	Name      string
+	TokenID   uint
+	SourceIP  string
+	RequestID string // 发起变更的HTTP请求ID，用于关联服务日志
+}
+
+var (
+	// CronActor 定时任务触发的变更
+	CronActor = Actor{Type: model.AuditActorCron, Name: "cron"}
+	// SystemActor 服务自身发起的变更
+	SystemActor = Actor{Type: model.AuditActorSystem, Name: "system"}
+)
+
+// trigger 同步任务的触发来源
+func (a Actor) trigger() string {
+	switch a.Type {
+	case model.AuditActorCron, model.AuditActorSystem:
+		return a.Type
+	default:
+		return "api"
+	}
+}
+
+// logAttrs 服务日志中标识操作者的属性
+func (a Actor) logAttrs() []any {
+	attrs := []any{"actor", a.Name}
+	if a.RequestID != "" {
+		attrs = append(attrs, "request_id", a.RequestID)
+	}
+	return attrs
+}
+
+// AuditEntry 一条待记录的变更，Before/After 为变更前后的对象，创建时Before为nil，删除时After为nil
+type AuditEntry struct {
+	Action     string
+	EntityType string
+	EntityID   string
+	RunID      string
+	Before     any
+	After      any
+	Err        error
+}
+
+type AuditService interface {
+	Record(actor Actor, entry AuditEntry)
+	List(filter repository.AuditFilter) ([]model.AuditLog, int64, error)
+	PurgeExpired() (int64, error)
+	StartRetention(interval time.Duration)
+}
+
+type auditService struct {
+	repo          repository.AuditRepository
+	configService ConfigService
+}
+
+func NewAuditService(repo repository.AuditRepository, configService ConfigService) AuditService {
+	return &auditService{
+		repo:          repo,
+		configService: configService,
+	}
+}
+
+// Record 记录一条审计日志，写入失败只记录到服务日志，不影响业务操作
+func (s *auditService) Record(actor Actor, entry AuditEntry) {
+	before, after := auditDiff(entry.Before, entry.After)
+
+	record := &model.AuditLog{
+		ActorType:  actor.Type,
+		ActorID:    actor.UserID,
+		ActorName:  actor.Name,
+		TokenID:    actor.TokenID,
+		SourceIP:   actor.SourceIP,
+		RequestID:  actor.RequestID,
+		Action:     entry.Action,
+		EntityType: entry.EntityType,
+		EntityID:   entry.EntityID,
+		RunID:      entry.RunID,
+		Before:     before,
+		After:      after,
+		Result:     model.AuditResultSuccess,
+	}
+	if entry.Err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = entry.Err.Error()
+	}
+
+	if err := s.repo.Create(record); err != nil {
+		slog.Error("failed to write audit log", append(actor.logAttrs(), "action", entry.Action, "entity_type", entry.EntityType, "entity_id", entry.EntityID, "error", err)...)
+	}
+}
+
+func (s *auditService) List(filter repository.AuditFilter) ([]model.AuditLog, int64, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditPageSize
+	}
+	if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	return s.repo.List(filter)
+}
+
+// PurgeExpired 删除超过保留期限（系统配置 audit_retention_days，0表示永久保留）的审计日志
+func (s *auditService) PurgeExpired() (int64, error) {
+	days := defaultAuditRetentionDays
+	if s.configService != nil {
+		if value, err := s.configService.GetConfigInt("audit_retention_days"); err == nil {
+			days = value
+		}
+	}
+	if days <= 0 {
+		return 0, nil
+	}
+	return s.repo.DeleteBefore(time.Now().AddDate(0, 0, -days))
+}
+
+// StartRetention 立即清理一次过期审计日志，之后按interval定期清理
+func (s *auditService) StartRetention(interval time.Duration) {
+	purge := func() {
+		if count, err := s.PurgeExpired(); err != nil {
+			slog.Error("failed to purge expired audit logs", "error", err)
+		} else if count > 0 {
+			slog.Info("purged expired audit logs", "count", count)
+		}
+	}
+
+	purge()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purge()
+		}
+	}()
+}
+
+// auditDiff 将变更前后的对象转换为JSON，更新时只保留发生变化的字段，敏感字段脱敏
+func auditDiff(before, after any) (string, string) {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	if beforeFields != nil && afterFields != nil {
+		for key, value := range beforeFields {
+			if other, ok := afterFields[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeFields, key)
+				delete(afterFields, key)
+			}
+		}
+	}
+
+	return encodeAuditFields(beforeFields), encodeAuditFields(afterFields)
+}
+
+// auditFields 将对象转换为字段表，忽略时间戳字段和关联对象
+func auditFields(value any) map[string]any {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil()) {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return map[string]any{"error": err.Error()}
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// 非对象类型（如字符串、数字）
+		var raw any
+		json.Unmarshal(data, &raw)
+		return map[string]any{"value": raw}
+	}
+	for key, field := range fields {
+		if ignoredAuditFields[key] {
+			delete(fields, key)
+			continue
+		}
+		// 关联对象（如规则的CloudConfig）通过外键字段记录即可
+		if nested, ok := field.(map[string]any); ok {
+			if _, isModel := nested["ID"]; isModel {
+				delete(fields, key)
+			}
+		}
+	}
+	return fields
+}
+
+// encodeAuditFields 脱敏后编码为JSON
+func encodeAuditFields(fields map[string]any) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	for key, value := range fields {
+		if sensitiveAuditFields[key] && value != nil && value != "" {
+			fields[key] = redactedValue
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,388 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// API令牌前缀，便于在日志和密钥扫描中识别
+	apiTokenPrefix = "ff_"
+	// 密码最小长度
+	minPasswordLength = 8
+	// 默认会话有效期
+	defaultSessionTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrSetupCompleted     = errors.New("initial setup has already been completed")
+	ErrInvalidSetupToken  = errors.New("invalid setup token")
+)
+
+// Principal 已认证的调用方
+type Principal struct {
+	UserID         uint   `json:"user_id"`
+	Username       string `json:"username"`
+	Role           string `json:"role"`
+	CloudConfigIDs []uint `json:"cloud_config_ids"` // 可访问的云服务配置，为空表示不限制
+	AuthMethod     string `json:"auth_method"`      // session 或 token
+	TokenID        uint   `json:"token_id,omitempty"`
+}
+
+type AuthService interface {
+	// 首次运行初始化
+	NeedsSetup() (bool, error)
+	SetupToken() string
+	Setup(setupToken, username, password string) (*model.User, error)
+	BootstrapAdmin(username, password string) (*model.User, error)
+	EnsureAdmin() error
+
+	// 登录会话
+	Login(username, password string) (token string, expiresAt time.Time, user *model.User, err error)
+	Logout(sessionToken string) error
+	AuthenticateSession(sessionToken string) (*Principal, error)
+	ChangePassword(userID uint, oldPassword, newPassword string) error
+
+	// API令牌
+	AuthenticateAPIToken(token string) (*Principal, error)
+	CreateAPIToken(userID uint, name string, ttl time.Duration) (token string, record *model.APIToken, err error)
+	ListAPITokens(userID uint) ([]model.APIToken, error)
+	DeleteAPIToken(userID, id uint) error
+}
+
+type authService struct {
+	userRepo   repository.UserRepository
+	sessionTTL time.Duration
+
+	// 首次运行时生成的一次性初始化令牌，防止他人抢先创建管理员
+	setupMu    sync.Mutex
+	setupToken string
+}
+
+func NewAuthService(userRepo repository.UserRepository, sessionTTL time.Duration) AuthService {
+	if sessionTTL <= 0 {
+		sessionTTL = defaultSessionTTL
+	}
+	return &authService{
+		userRepo:   userRepo,
+		sessionTTL: sessionTTL,
+	}
+}
+
+// 首次运行初始化
+func (s *authService) NeedsSetup() (bool, error) {
+	count, err := s.userRepo.CountUsers()
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// SetupToken 返回初始化令牌，尚无用户时按需生成，已完成初始化时返回空
+func (s *authService) SetupToken() string {
+	needsSetup, err := s.NeedsSetup()
+	if err != nil || !needsSetup {
+		return ""
+	}
+
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+	if s.setupToken == "" {
+		token, err := randomToken(12)
+		if err != nil {
+			return ""
+		}
+		s.setupToken = token
+	}
+	return s.setupToken
+}
+
+func (s *authService) Setup(setupToken, username, password string) (*model.User, error) {
+	expected := s.SetupToken()
+	if expected == "" {
+		return nil, ErrSetupCompleted
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(setupToken)), []byte(expected)) != 1 {
+		return nil, ErrInvalidSetupToken
+	}
+
+	user, err := s.BootstrapAdmin(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	s.setupMu.Lock()
+	s.setupToken = ""
+	s.setupMu.Unlock()
+	return user, nil
+}
+
+// BootstrapAdmin 在没有任何用户时创建管理员账号
+func (s *authService) BootstrapAdmin(username, password string) (*model.User, error) {
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+
+	needsSetup, err := s.NeedsSetup()
+	if err != nil {
+		return nil, err
+	}
+	if !needsSetup {
+		return nil, ErrSetupCompleted
+	}
+
+	return s.createUser(username, password, model.RoleAdmin)
+}
+
+// EnsureAdmin 已有用户但没有管理员时（如从无角色的版本升级），将最早创建的用户设为管理员
+func (s *authService) EnsureAdmin() error {
+	admins, err := s.userRepo.CountUsersByRole(model.RoleAdmin)
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	users, err := s.userRepo.ListUsers()
+	if err != nil || len(users) == 0 {
+		return err
+	}
+
+	first := users[0]
+	first.Role = model.RoleAdmin
+	return s.userRepo.UpdateUser(&first)
+}
+
+func (s *authService) createUser(username, password, role string) (*model.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		IsEnabled:    true,
+	}
+	if err := s.userRepo.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// 登录会话
+func (s *authService) Login(username, password string) (string, time.Time, *model.User, error) {
+	user, err := s.userRepo.GetUserByUsername(strings.TrimSpace(username))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 用户不存在时同样执行一次bcrypt比较，避免通过响应时间枚举用户名
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			return "", time.Time{}, nil, ErrInvalidCredentials
+		}
+		return "", time.Time{}, nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || !user.IsEnabled {
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	now := time.Now()
+	session := &model.Session{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	if err := s.userRepo.CreateSession(session); err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	user.LastLoginAt = &now
+	if err := s.userRepo.UpdateUser(user); err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	// 顺便清理过期会话
+	s.userRepo.DeleteExpiredSessions(now)
+
+	return token, session.ExpiresAt, user, nil
+}
+
+func (s *authService) Logout(sessionToken string) error {
+	if sessionToken == "" {
+		return nil
+	}
+	return s.userRepo.DeleteSessionByTokenHash(hashToken(sessionToken))
+}
+
+func (s *authService) AuthenticateSession(sessionToken string) (*Principal, error) {
+	if sessionToken == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	session, err := s.userRepo.GetSessionByTokenHash(hashToken(sessionToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) || session.User.ID == 0 || !session.User.IsEnabled {
+		return nil, ErrUnauthenticated
+	}
+
+	return s.newPrincipal(&session.User, "session", 0)
+}
+
+func (s *authService) ChangePassword(userID uint, oldPassword, newPassword string) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(oldPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	if err := s.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	// 修改密码后使所有已登录会话失效
+	return s.userRepo.DeleteSessionsByUser(userID)
+}
+
+// API令牌
+func (s *authService) AuthenticateAPIToken(token string) (*Principal, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrUnauthenticated
+	}
+
+	record, err := s.userRepo.GetAPITokenByHash(hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, ErrUnauthenticated
+	}
+	if record.User.ID == 0 || !record.User.IsEnabled {
+		return nil, ErrUnauthenticated
+	}
+	s.userRepo.TouchAPIToken(record.ID, now)
+
+	return s.newPrincipal(&record.User, "token", record.ID)
+}
+
+// newPrincipal 根据用户的角色和访问范围构建认证信息
+func (s *authService) newPrincipal(user *model.User, method string, tokenID uint) (*Principal, error) {
+	scopes, err := s.userRepo.ListUserScopes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		UserID:         user.ID,
+		Username:       user.Username,
+		Role:           user.Role,
+		CloudConfigIDs: scopes,
+		AuthMethod:     method,
+		TokenID:        tokenID,
+	}, nil
+}
+
+// CreateAPIToken 创建API令牌，令牌明文只在创建时返回一次
+func (s *authService) CreateAPIToken(userID uint, name string, ttl time.Duration) (string, *model.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("token name is required")
+	}
+
+	random, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + random
+
+	record := &model.APIToken{
+		UserID:      userID,
+		Name:        name,
+		TokenHash:   hashToken(token),
+		TokenPrefix: token[:len(apiTokenPrefix)+6],
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		record.ExpiresAt = &expiresAt
+	}
+	if err := s.userRepo.CreateAPIToken(record); err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+func (s *authService) ListAPITokens(userID uint) ([]model.APIToken, error) {
+	return s.userRepo.ListAPITokens(userID)
+}
+
+func (s *authService) DeleteAPIToken(userID, id uint) error {
+	return s.userRepo.DeleteAPIToken(userID, id)
+}
+
+// 辅助方法
+
+// 用户不存在时用于比较的bcrypt哈希
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("fireflow-dummy-password"), bcrypt.DefaultCost)
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// randomToken 生成URL安全的随机令牌
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken 令牌只以SHA-256哈希存储
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
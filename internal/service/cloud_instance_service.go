@@ -0,0 +1,149 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ErrInstanceExists 实例已在该云服务配置下登记
+var ErrInstanceExists = errors.New("instance is already managed by this cloud config")
+
+type CloudInstanceService interface {
+	// ListInstances 返回实例列表，cloudConfigID为0时返回所有配置下的实例
+	ListInstances(cloudConfigID uint) ([]model.CloudInstance, error)
+	GetInstance(id uint) (*model.CloudInstance, error)
+	CreateInstance(instance *model.CloudInstance) error
+	UpdateInstance(instance *model.CloudInstance) error
+	DeleteInstance(id uint) error
+	// InstanceRegion 返回实例所在区域，未登记的实例返回空字符串，即使用配置的默认区域
+	InstanceRegion(cloudConfigID uint, instanceID string) (string, error)
+	// Inventory 返回配置的凭证在所有区域下可见的实例清单。清单会缓存，refresh为true时重新查询
+	Inventory(ctx context.Context, cloudConfigID uint, refresh bool) (*Inventory, error)
+	// InvalidateInventory 丢弃配置的缓存清单，云服务配置修改或删除后调用
+	InvalidateInventory(cloudConfigID uint)
+	// RegisterDefaultInstances 将云服务配置中的默认实例登记为 CloudInstance，返回新登记的实例数。
+	// 可重复执行：启动时用于迁移旧配置，创建或修改云服务配置后再次调用。
+	RegisterDefaultInstances() (int, error)
+}
+
+type cloudInstanceService struct {
+	repo          repository.CloudInstanceRepository
+	configService ConfigService
+
+	// 按云服务配置缓存的实例清单
+	inventoryMu sync.Mutex
+	inventories map[uint]*Inventory
+	// 同一时间只刷新一份清单，避免并发请求重复查询云端
+	refreshMu sync.Mutex
+}
+
+func NewCloudInstanceService(repo repository.CloudInstanceRepository, configService ConfigService) CloudInstanceService {
+	return &cloudInstanceService{
+		repo:          repo,
+		configService: configService,
+		inventories:   make(map[uint]*Inventory),
+	}
+}
+
+func (s *cloudInstanceService) ListInstances(cloudConfigID uint) ([]model.CloudInstance, error) {
+	return s.repo.List(cloudConfigID)
+}
+
+func (s *cloudInstanceService) GetInstance(id uint) (*model.CloudInstance, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *cloudInstanceService) CreateInstance(instance *model.CloudInstance) error {
+	instance.InstanceID = strings.TrimSpace(instance.InstanceID)
+	if instance.CloudConfigID == 0 || instance.InstanceID == "" {
+		return fmt.Errorf("cloud_config_id and instance_id are required")
+	}
+	config, err := s.configService.GetCloudConfigByID(instance.CloudConfigID)
+	if err != nil {
+		return fmt.Errorf("failed to get cloud config: %v", err)
+	}
+	if _, err := s.repo.GetByInstance(instance.CloudConfigID, instance.InstanceID); err == nil {
+		return ErrInstanceExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	instance.Region = strings.TrimSpace(instance.Region)
+	if instance.Region == "" {
+		instance.Region = config.Region
+	}
+	return s.repo.Create(instance)
+}
+
+// UpdateInstance 更新实例的区域、名称等信息，所属配置和实例ID不可修改
+func (s *cloudInstanceService) UpdateInstance(instance *model.CloudInstance) error {
+	existing, err := s.repo.GetByID(instance.ID)
+	if err != nil {
+		return err
+	}
+	instance.CloudConfigID = existing.CloudConfigID
+	instance.InstanceID = existing.InstanceID
+	instance.CreatedAt = existing.CreatedAt
+	instance.Region = strings.TrimSpace(instance.Region)
+	if instance.Region == "" {
+		return fmt.Errorf("region is required")
+	}
+	return s.repo.Update(instance)
+}
+
+func (s *cloudInstanceService) DeleteInstance(id uint) error {
+	return s.repo.Delete(id)
+}
+
+func (s *cloudInstanceService) InstanceRegion(cloudConfigID uint, instanceID string) (string, error) {
+	instance, err := s.repo.GetByInstance(cloudConfigID, instanceID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load cloud instance: %v", err)
+	}
+	return instance.Region, nil
+}
+
+func (s *cloudInstanceService) RegisterDefaultInstances() (int, error) {
+	configs, err := s.configService.GetAllCloudConfigs()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, config := range configs {
+		instanceID := strings.TrimSpace(config.InstanceId)
+		if instanceID == "" {
+			continue
+		}
+		_, err := s.repo.GetByInstance(config.ID, instanceID)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return count, err
+		}
+		instance := &model.CloudInstance{
+			CloudConfigID: config.ID,
+			InstanceID:    instanceID,
+			Region:        config.Region,
+			Remark:        "云服务配置的默认实例",
+		}
+		if err := s.repo.Create(instance); err != nil {
+			return count, fmt.Errorf("failed to register instance of cloud config %d: %v", config.ID, err)
+		}
+		slog.Info("registered default instance of cloud config", "cloud_config_id", config.ID, "instance_id", instanceID, "region", config.Region)
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// 事件总线上的事件类型
+const (
+	EventRunStarted   = "run.started"   // 全量同步开始
+	EventRuleProgress = "rule.progress" // 单条规则同步完成（成功或失败）
+	EventIPChanged    = "ip.changed"    // 公网IP与上次同步时不同
+	EventRunFinished  = "run.finished"  // 全量同步结束
+)
+
+const (
+	// 每个订阅者的缓冲事件数，消费过慢时丢弃新事件
+	subscriberBuffer = 256
+	// 保留的最近事件数，用于断线重连后补发
+	eventHistorySize = 512
+)
+
+// Event 事件总线上的一条事件
+type Event struct {
+	ID            uint64    `json:"id"`
+	Type          string    `json:"type"`
+	RunID         string    `json:"run_id,omitempty"`
+	CloudConfigID *uint     `json:"cloud_config_id,omitempty"` // 与具体云服务配置相关的事件，用于按访问范围过滤
+	Time          time.Time `json:"time"`
+	Data          any       `json:"data,omitempty"`
+}
+
+// EventBus 进程内的事件发布订阅，发布不会因订阅者阻塞
+type EventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish 发布事件并分配递增的事件ID
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("dropping event for slow subscriber", "event", event.Type, "event_id", event.ID)
+		}
+	}
+}
+
+// Subscribe 订阅事件，lastID大于0时先补发该ID之后仍保留的事件。
+// 返回的cancel用于取消订阅，取消后通道会被关闭。
+func (b *EventBus) Subscribe(lastID uint64) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	if lastID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastID && len(ch) < cap(ch) {
+				ch <- event
+			}
+		}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
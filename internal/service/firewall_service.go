@@ -1,372 +1,1531 @@
-package service
-
-import (
-	"FireFlow/internal/model"
-	"FireFlow/internal/repository"
-	"FireFlow/internal/utils"
-	"FireFlow/pkg/cloud"
-	"fmt"
-	"log"
-	"regexp"
-	"strings"
-
-	"github.com/spf13/viper"
-)
-
-type FirewallService struct {
-	repo          repository.FirewallRepository
-	tencentClient *cloud.TencentClient
-	configService ConfigService
-}
-
-func NewFirewallService(repo repository.FirewallRepository, configService ConfigService) *FirewallService {
-	// Initialize Tencent Cloud client from config
-	tencentConfig := cloud.TencentConfig{
-		SecretId:  viper.GetString("cloud.tencent.secret_id"),
-		SecretKey: viper.GetString("cloud.tencent.secret_key"),
-		Region:    viper.GetString("cloud.tencent.region"),
-	}
-
-	var tencentClient *cloud.TencentClient
-	if tencentConfig.SecretId != "" && tencentConfig.SecretKey != "" {
-		var err error
-		tencentClient, err = cloud.NewTencentClient(tencentConfig)
-		if err != nil {
-			log.Printf("Failed to initialize Tencent Cloud client: %v", err)
-		} else {
-			log.Println("Successfully initialized Tencent Cloud client")
-		}
-	}
-
-	return &FirewallService{
-		repo:          repo,
-		tencentClient: tencentClient,
-		configService: configService,
-	}
-}
-
-// UpdateAllRules is the main logic executed by the cron job.
-func (s *FirewallService) UpdateAllRules() {
-	log.Println("Starting firewall update job...")
-
-	// 1. Get current public IP using configured URL
-	var currentIP string
-	var err error
-
-	if s.configService != nil {
-		// 获取配置的IP查询URL
-		ipFetchURL, configErr := s.configService.GetConfig("ip_fetch_url")
-		if configErr != nil || ipFetchURL == "" {
-			ipFetchURL = "https://4.ipw.cn" // 默认URL
-		}
-		currentIP, err = utils.GetPublicIPWithURL(ipFetchURL)
-	} else {
-		// 降级到默认方法
-		currentIP, err = utils.GetPublicIP()
-	}
-
-	if err != nil {
-		log.Printf("Error getting public IP: %v", err)
-		return
-	}
-	log.Printf("Current public IP is: %s", currentIP)
-
-	// 检查IP合法性（只允许IPv4，禁止IPv6、JSON、报错信息、内容过长等）
-	if len(currentIP) > 40 || strings.Contains(currentIP, ":") || strings.ContainsAny(currentIP, "[{") || strings.Contains(strings.ToLower(currentIP), "error") || strings.Contains(strings.ToLower(currentIP), "html") {
-		log.Printf("获取到的IP地址不合法，未触发规则更新。")
-		return
-	}
-	// 严格正则校验IPv4
-	ipv4Pattern := `^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$`
-	matched, _ := regexp.MatchString(ipv4Pattern, currentIP)
-	if !matched {
-		log.Printf("获取到的IP地址不是合法IPv4，未触发规则更新。")
-		return
-	}
-
-	// 2. Get all enabled rules from the database
-	rules, err := s.repo.GetAllEnabled()
-	if err != nil {
-		log.Printf("Error getting firewall rules: %v", err)
-		return
-	}
-
-	// 3. Iterate and update each rule (无论IP是否变化都要执行)
-	for _, rule := range rules {
-		// 只处理有备注的规则
-		if rule.Remark == "" {
-			log.Printf("Skipping rule %d: no remark provided", rule.ID)
-			continue
-		}
-
-		log.Printf("Processing rule %d (%s) - Current IP: %s, Last IP: %s", rule.ID, rule.Remark, currentIP, rule.LastIP)
-
-		var updateErr error
-		switch rule.Provider {
-		case "TencentCloud":
-			// 使用getTencentClient方法获取客户端，而不是检查全局客户端
-			updateErr = s.updateTencentFirewallRule(&rule, currentIP)
-		case "Aliyun":
-			updateErr = fmt.Errorf("Aliyun provider not implemented yet")
-		default:
-			updateErr = fmt.Errorf("unsupported provider: %s", rule.Provider)
-		}
-
-		if updateErr != nil {
-			log.Printf("Failed to update rule %d: %v", rule.ID, updateErr)
-		} else {
-			// 4. If update succeeds, save the new IP to the database
-			if err := s.repo.UpdateIP(rule.ID, currentIP); err != nil {
-				log.Printf("Failed to update IP in database for rule %d: %v", rule.ID, err)
-			} else {
-				log.Printf("Successfully updated rule %d to IP %s", rule.ID, currentIP)
-			}
-		}
-	}
-	log.Println("Firewall update job finished.")
-}
-
-// createAndUpdateTencentFirewallRule 创建新的防火墙规则并更新数据库
-func (s *FirewallService) createAndUpdateTencentFirewallRule(rule *model.FirewallRule, currentIP string) error {
-	// 获取腾讯云客户端
-	tencentClient, err := s.getTencentClient(rule.CloudConfigID)
-	if err != nil {
-		return fmt.Errorf("failed to get Tencent Cloud client: %v", err)
-	}
-
-	// 构建CIDR块
-	cidrBlock := fmt.Sprintf("%s/32", currentIP)
-
-	// 构建防火墙规则规格
-	ruleSpec := &cloud.FirewallRuleSpec{
-		Protocol:    rule.Protocol,
-		Port:        rule.Port,
-		CidrBlock:   cidrBlock,
-		Action:      "ACCEPT", // 默认允许
-		Description: rule.Remark,
-	}
-
-	// 在云服务上创建防火墙规则
-	result, err := tencentClient.CreateFirewallRule(rule.InstanceID, ruleSpec)
-	if err != nil {
-		return fmt.Errorf("failed to create firewall rule: %v", err)
-	}
-
-	// 更新数据库中的规则信息
-	rule.RuleID = result.RuleID
-	rule.LastIP = currentIP
-	err = s.repo.Update(rule)
-	if err != nil {
-		log.Printf("Warning: Rule created in cloud but failed to update database: %v", err)
-	}
-
-	log.Printf("Successfully created and executed firewall rule %s for instance %s", result.RuleID, rule.InstanceID)
-	return nil
-}
-
-// updateTencentFirewallRule updates a firewall rule in Tencent Cloud
-func (s *FirewallService) updateTencentFirewallRule(rule *model.FirewallRule, newIP string) error {
-	// 获取腾讯云客户端
-	tencentClient, err := s.getTencentClient(rule.CloudConfigID)
-	if err != nil {
-		return fmt.Errorf("failed to get Tencent Cloud client: %v", err)
-	}
-
-	// 构建规则规格，用于匹配云端规则
-	ruleSpec := &cloud.FirewallRuleSpec{
-		Protocol:    rule.Protocol,
-		Port:        rule.Port,
-		CidrBlock:   fmt.Sprintf("%s/32", newIP), // 新的CIDR
-		Action:      "ACCEPT",                    // 默认为ACCEPT
-		Description: rule.Remark,                 // 使用备注作为描述
-	}
-
-	// 使用规则规格来更新规则
-	updatedRule, err := tencentClient.UpdateFirewallRule(rule.InstanceID, rule.RuleID, ruleSpec, newIP)
-	if err != nil {
-		// 如果更新失败且错误信息表明规则不存在，尝试重新创建规则
-		if strings.Contains(err.Error(), "not found") {
-			log.Printf("Rule not found in cloud, attempting to recreate it")
-			return s.createAndUpdateTencentFirewallRule(rule, newIP)
-		}
-		return err
-	}
-
-	// 更新数据库中的规则信息
-	if updatedRule != nil {
-		rule.RuleID = updatedRule.RuleID
-		rule.LastIP = newIP
-		if err := s.repo.Update(rule); err != nil {
-			log.Printf("Warning: Failed to update rule in database: %v", err)
-		}
-	}
-
-	return nil
-}
-
-// getTencentClient 根据CloudConfigID获取腾讯云客户端
-func (s *FirewallService) getTencentClient(cloudConfigID uint) (*cloud.TencentClient, error) {
-	// 如果有全局客户端且CloudConfigID为0，使用全局客户端
-	if cloudConfigID == 0 && s.tencentClient != nil {
-		return s.tencentClient, nil
-	}
-
-	// 根据CloudConfigID获取云服务配置
-	if s.configService == nil {
-		return nil, fmt.Errorf("config service not available")
-	}
-
-	cloudConfig, err := s.configService.GetCloudConfigByID(cloudConfigID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get cloud config: %v", err)
-	}
-
-	// 构建腾讯云配置
-	tencentConfig := cloud.TencentConfig{
-		SecretId:   cloudConfig.SecretId,
-		SecretKey:  cloudConfig.SecretKey,
-		Region:     cloudConfig.Region,
-		InstanceId: cloudConfig.InstanceId,
-	}
-
-	// 创建腾讯云客户端
-	return cloud.NewTencentClient(tencentConfig)
-}
-
-// The following methods are for the API
-func (s *FirewallService) GetAllRules() ([]model.FirewallRule, error) {
-	return s.repo.GetAll()
-}
-
-// GetEnabledRulesCount 获取启用规则的数量
-func (s *FirewallService) GetEnabledRulesCount() (int, error) {
-	enabledRules, err := s.repo.GetAllEnabled()
-	if err != nil {
-		return 0, err
-	}
-	return len(enabledRules), nil
-}
-
-func (s *FirewallService) CreateRule(rule *model.FirewallRule) error {
-	return s.repo.Create(rule)
-}
-
-func (s *FirewallService) DeleteRule(id uint) error {
-	return s.repo.Delete(id)
-}
-
-func (s *FirewallService) UpdateRule(rule *model.FirewallRule) error {
-	return s.repo.Update(rule)
-}
-
-func (s *FirewallService) ExecuteRule(id uint) error {
-	// 获取规则
-	rule, err := s.repo.GetByID(id)
-	if err != nil {
-		return fmt.Errorf("failed to get rule: %v", err)
-	}
-
-	// 获取当前公网IP
-	var currentIP string
-	if s.configService != nil {
-		// 获取配置的IP查询URL
-		ipFetchURL, configErr := s.configService.GetConfig("ip_fetch_url")
-		if configErr != nil || ipFetchURL == "" {
-			ipFetchURL = "https://4.ipw.cn" // 默认URL
-		}
-		currentIP, err = utils.GetPublicIPWithURL(ipFetchURL)
-	} else {
-		// 降级到默认方法
-		currentIP, err = utils.GetPublicIP()
-	}
-	if err != nil {
-		return fmt.Errorf("failed to get current IP: %v", err)
-	}
-
-	// 执行规则更新
-	switch rule.Provider {
-	case "TencentCloud":
-		// 如果规则ID为空，需要先创建规则
-		if rule.RuleID == "" {
-			return s.createAndUpdateTencentFirewallRule(rule, currentIP)
-		} else {
-			return s.updateTencentFirewallRule(rule, currentIP)
-		}
-	case "Aliyun":
-		// TODO: 实现阿里云规则更新
-		return fmt.Errorf("Aliyun provider not implemented yet")
-	default:
-		return fmt.Errorf("unsupported provider: %s", rule.Provider)
-	}
-}
-
-// CreateTencentFirewallRule creates a new firewall rule in Tencent Cloud and saves it to database
-func (s *FirewallService) CreateTencentFirewallRule(instanceID, port, cidrBlock, protocol, description string) error {
-	if s.tencentClient == nil {
-		return fmt.Errorf("TencentCloud client not initialized")
-	}
-
-	// 创建防火墙规则规格
-	ruleSpec := &cloud.FirewallRuleSpec{
-		Port:        port,
-		Protocol:    protocol,
-		CidrBlock:   cidrBlock,
-		Action:      "ACCEPT",
-		Description: description,
-	}
-
-	// 在腾讯云创建规则
-	result, err := s.tencentClient.CreateFirewallRule(instanceID, ruleSpec)
-	if err != nil {
-		return fmt.Errorf("failed to create firewall rule in Tencent Cloud: %v", err)
-	}
-
-	// 保存到数据库
-	rule := &model.FirewallRule{
-		Provider:   "TencentCloud",
-		InstanceID: instanceID,
-		Port:       port,
-		RuleID:     result.RuleID,
-		LastIP:     cidrBlock,
-		Enabled:    true,
-		Remark:     description,
-	}
-
-	return s.repo.Create(rule)
-}
-
-// SyncTencentFirewallRules synchronizes firewall rules from Tencent Cloud with local database
-func (s *FirewallService) SyncTencentFirewallRules(instanceID string) error {
-	if s.tencentClient == nil {
-		return fmt.Errorf("TencentCloud client not initialized")
-	}
-
-	// 从腾讯云获取防火墙规则
-	rules, err := s.tencentClient.ListFirewallRules(instanceID)
-	if err != nil {
-		return fmt.Errorf("failed to list firewall rules from Tencent Cloud: %v", err)
-	}
-
-	log.Printf("Found %d firewall rules for instance %s", len(rules), instanceID)
-
-	// 这里可以添加同步逻辑，比如：
-	// 1. 比较云端和本地的规则
-	// 2. 添加云端存在但本地不存在的规则
-	// 3. 标记本地存在但云端不存在的规则为失效
-
-	for _, rule := range rules {
-		log.Printf("Rule: %s, Port: %s, Protocol: %s, CIDR: %s",
-			rule.RuleID, rule.Port, rule.Protocol, rule.CidrBlock)
-	}
-
-	return nil
-}
-
-// GetInstanceInfo gets information about a cloud instance
-func (s *FirewallService) GetInstanceInfo(instanceID string) (*cloud.InstanceInfo, error) {
-	if s.tencentClient == nil {
-		return nil, fmt.Errorf("TencentCloud client not initialized")
-	}
-
-	return s.tencentClient.GetInstance(instanceID)
-}
+package service
+
+import (
+	"FireFlow/internal/metrics"
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/internal/utils"
+	"FireFlow/pkg/cloud"
+	"FireFlow/pkg/probe"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// 同步时并发处理实例的默认及最大worker数量
+const (
+	defaultSyncConcurrency = 4
+	maxSyncConcurrency     = 32
+)
+
+// 保存上次同步时公网IP的配置键，用于检测IP变化
+const lastPublicIPKey = "last_public_ip"
+
+// 保存上次全部规则同步成功时间的配置键，用于就绪检查
+const lastSuccessfulSyncKey = "last_successful_sync"
+
+type FirewallService struct {
+	repo          repository.FirewallRepository
+	tencentClient *cloud.TencentClient
+	configService ConfigService
+	runs          *RunCoordinator
+	audit         AuditService
+	notifier      NotificationService
+	events        *EventBus
+	runStore      repository.SyncRunRepository
+	journal       repository.RuleOperationRepository
+	safeguards    SafeguardService
+	instances     CloudInstanceService
+	prober        *probe.Prober
+	probeAddress  ProbeAddressResolver
+
+	// 按CloudProviderConfig和区域缓存的云服务客户端
+	providersMu sync.Mutex
+	providers   map[providerKey]cachedProvider
+	// 按标签选择实例时查询到的未登记实例所在区域
+	discoveredRegions map[instanceKey]string
+
+	// 最近一次更新失败的规则ID，用于统计降级规则数
+	degradedMu sync.Mutex
+	degraded   map[uint]struct{}
+}
+
+// providerKey 云服务客户端的缓存键，同一凭证在不同区域使用不同的客户端
+type providerKey struct {
+	cloudConfigID uint
+	region        string
+}
+
+// cachedProvider 缓存的云服务客户端，配置更新后失效
+type cachedProvider struct {
+	provider  cloud.CloudProvider
+	updatedAt time.Time
+}
+
+// SyncResult 一次全量同步的结果
+type SyncResult struct {
+	RunID      string    `json:"run_id"`
+	Trigger    string    `json:"trigger"`
+	CurrentIP  string    `json:"current_ip"`
+	Instances  int       `json:"instances"`
+	Updated    int       `json:"updated"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"` // 同步取消时尚未处理的规则数
+	Pinned     int       `json:"pinned"`  // 回滚后固定、本次未同步的规则数
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// runProgress 全量同步的规则进度，由各worker并发更新
+type runProgress struct {
+	runID string
+	total int
+	done  atomic.Int64
+
+	// 本次同步中失败的规则，多实例规则在任一实例上失败都视为降级
+	failedMu sync.Mutex
+	failed   map[uint]bool
+}
+
+// instanceKey 同一云服务配置下的同一实例
+type instanceKey struct {
+	cloudConfigID uint
+	provider      string
+	instanceID    string
+}
+
+// instanceRules 需要在同一实例上同步的规则
+type instanceRules struct {
+	key   instanceKey
+	rules []model.FirewallRule
+}
+
+func NewFirewallService(repo repository.FirewallRepository, configService ConfigService) *FirewallService {
+	// Initialize Tencent Cloud client from config
+	tencentConfig := cloud.TencentConfig{
+		SecretId:  viper.GetString("cloud.tencent.secret_id"),
+		SecretKey: viper.GetString("cloud.tencent.secret_key"),
+		Region:    viper.GetString("cloud.tencent.region"),
+	}
+
+	var tencentClient *cloud.TencentClient
+	if tencentConfig.SecretId != "" && tencentConfig.SecretKey != "" {
+		var err error
+		tencentClient, err = cloud.NewTencentClient(tencentConfig)
+		if err != nil {
+			slog.Error("failed to initialize Tencent Cloud client", "error", err)
+		} else {
+			slog.Info("initialized Tencent Cloud client from config file")
+		}
+	}
+
+	s := &FirewallService{
+		repo:          repo,
+		tencentClient: tencentClient,
+		configService: configService,
+		runs:          NewRunCoordinator(),
+		providers:     make(map[providerKey]cachedProvider),
+		degraded:      make(map[uint]struct{}),
+
+		discoveredRegions: make(map[instanceKey]string),
+	}
+	s.refreshRuleGauges()
+	return s
+}
+
+// markRuleResult 记录规则更新结果，失败的规则计入降级规则数
+func (s *FirewallService) markRuleResult(ruleID uint, err error) {
+	s.setRuleDegraded(ruleID, err != nil)
+	if err != nil {
+		metrics.RuleUpdates.WithLabelValues(metrics.RuleFailed).Inc()
+	}
+}
+
+// markTargetResult 记录规则在一个实例上的同步结果。多实例规则在同一次同步中
+// 任一实例失败即为降级，之后其他实例成功不会清除。
+func (s *FirewallService) markTargetResult(progress *runProgress, ruleID uint, err error) {
+	progress.failedMu.Lock()
+	defer progress.failedMu.Unlock()
+	if err != nil {
+		if progress.failed == nil {
+			progress.failed = make(map[uint]bool)
+		}
+		progress.failed[ruleID] = true
+	}
+	s.setRuleDegraded(ruleID, progress.failed[ruleID])
+}
+
+// setRuleDegraded 标记规则是否降级（更新失败或更新后探测失败）
+func (s *FirewallService) setRuleDegraded(ruleID uint, degraded bool) {
+	s.degradedMu.Lock()
+	defer s.degradedMu.Unlock()
+	if degraded {
+		s.degraded[ruleID] = struct{}{}
+	} else {
+		delete(s.degraded, ruleID)
+	}
+}
+
+// refreshRuleGauges 更新启用规则数和降级规则数指标，已删除或停用的规则不再计为降级
+func (s *FirewallService) refreshRuleGauges() {
+	rules, err := s.repo.GetAllEnabled()
+	if err != nil {
+		slog.Warn("failed to count enabled rules for metrics", "error", err)
+		return
+	}
+
+	enabled := make(map[uint]struct{}, len(rules))
+	for _, rule := range rules {
+		enabled[rule.ID] = struct{}{}
+	}
+
+	s.degradedMu.Lock()
+	for id := range s.degraded {
+		if _, ok := enabled[id]; !ok {
+			delete(s.degraded, id)
+		}
+	}
+	degraded := len(s.degraded)
+	s.degradedMu.Unlock()
+
+	metrics.RulesEnabled.Set(float64(len(rules)))
+	metrics.RulesDegraded.Set(float64(degraded))
+}
+
+// SetAuditService 设置审计日志服务
+func (s *FirewallService) SetAuditService(audit AuditService) {
+	s.audit = audit
+}
+
+// record 记录审计日志，未设置审计服务时忽略
+func (s *FirewallService) record(actor Actor, entry AuditEntry) {
+	if s.audit != nil {
+		s.audit.Record(actor, entry)
+	}
+}
+
+// SetNotificationService 设置通知服务
+func (s *FirewallService) SetNotificationService(notifier NotificationService) {
+	s.notifier = notifier
+}
+
+// notify 发送事件通知，未设置通知服务时忽略
+func (s *FirewallService) notify(event NotificationEvent) {
+	if s.notifier != nil {
+		s.notifier.Notify(event)
+	}
+}
+
+// SetSyncRunRepository 设置同步任务记录存储，用于启动时检测被中断的同步
+func (s *FirewallService) SetSyncRunRepository(runStore repository.SyncRunRepository) {
+	s.runStore = runStore
+}
+
+// saveRun 保存同步任务记录，未设置存储时忽略
+func (s *FirewallService) saveRun(run *model.SyncRun, create bool) {
+	if s.runStore == nil {
+		return
+	}
+	save := s.runStore.Update
+	if create {
+		save = s.runStore.Create
+	}
+	if err := save(run); err != nil {
+		slog.Error("failed to save sync run", "run_id", run.ID, "status", run.Status, "error", err)
+	}
+}
+
+// finishRun 根据同步结果更新任务记录
+func (s *FirewallService) finishRun(run *model.SyncRun, outcome string, result *SyncResult, err error) {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Status = outcome
+	if errors.Is(err, ErrShuttingDown) {
+		run.Status = model.SyncRunInterrupted
+	}
+	if result != nil {
+		run.CurrentIP = result.CurrentIP
+		run.Updated = result.Updated
+		run.Failed = result.Failed
+		run.Skipped = result.Skipped
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	s.saveRun(run, false)
+}
+
+// SetEventBus 设置事件总线，用于发布同步进度
+func (s *FirewallService) SetEventBus(events *EventBus) {
+	s.events = events
+}
+
+// publish 发布事件，未设置事件总线时忽略
+func (s *FirewallService) publish(event Event) {
+	if s.events != nil {
+		s.events.Publish(event)
+	}
+}
+
+// ruleProgress 发布单条规则的同步结果
+func (s *FirewallService) ruleProgress(progress *runProgress, rule *model.FirewallRule, err error) {
+	if s.events == nil || progress == nil {
+		return
+	}
+
+	cloudConfigID := rule.CloudConfigID
+	data := map[string]any{
+		"rule_id":     rule.ID,
+		"remark":      rule.Remark,
+		"provider":    rule.Provider,
+		"instance_id": rule.InstanceID,
+		"status":      "synced",
+		"done":        progress.done.Add(1),
+		"total":       progress.total,
+	}
+	if err != nil {
+		data["status"] = "failed"
+		data["error"] = err.Error()
+	}
+	s.publish(Event{Type: EventRuleProgress, RunID: progress.runID, CloudConfigID: &cloudConfigID, Data: data})
+}
+
+// notifyInstanceError 实例级错误通知：凭证错误通知 credential_invalid，其他错误通知 instance_unreachable
+func (s *FirewallService) notifyInstanceError(key instanceKey, err error, runID string) {
+	data := map[string]any{
+		"cloud_config_id": key.cloudConfigID,
+		"provider":        key.provider,
+		"instance_id":     key.instanceID,
+		"error":           err.Error(),
+		"run_id":          runID,
+	}
+	if cloud.IsCredentialError(err) {
+		s.notify(NotificationEvent{Type: model.EventCredentialInvalid, Data: data, DedupKey: fmt.Sprint(key.cloudConfigID)})
+		return
+	}
+	s.notify(NotificationEvent{Type: model.EventInstanceUnreachable, Data: data, DedupKey: key.provider + "/" + key.instanceID})
+}
+
+// checkIPChanged 与上次同步时的公网IP比较，变化时发送 ip_changed 通知
+func (s *FirewallService) checkIPChanged(currentIP, runID string) {
+	if s.configService == nil {
+		return
+	}
+
+	previousIP, _ := s.configService.GetConfig(lastPublicIPKey)
+	if previousIP == currentIP {
+		return
+	}
+	metrics.IPLastChange.SetToCurrentTime()
+	if previousIP != "" {
+		slog.Info("public IP changed", "run_id", runID, "old_ip", previousIP, "new_ip", currentIP)
+		s.publish(Event{Type: EventIPChanged, RunID: runID, Data: map[string]any{"old_ip": previousIP, "new_ip": currentIP}})
+		s.notify(NotificationEvent{
+			Type: model.EventIPChanged,
+			Data: map[string]any{"old_ip": previousIP, "new_ip": currentIP, "run_id": runID},
+		})
+	}
+	if err := s.configService.SetConfig(lastPublicIPKey, currentIP, "string", "state", "上次同步时的公网IP"); err != nil {
+		slog.Error("failed to save last public IP", "run_id", runID, "error", err)
+	}
+}
+
+// UpdateAllRules is the main logic executed by the cron job.
+// actor 为触发者（定时任务或API调用方），已有全量同步在运行时返回 RunInProgressError。
+// ctx结束或同步被 CancelRun 取消时，尚未处理的规则不再同步。
+func (s *FirewallService) UpdateAllRules(ctx context.Context, actor Actor) (*SyncResult, error) {
+	scope := s.fullSyncScope()
+	run, runCtx, err := s.beginSync(ctx, actor, scope)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeSync(runCtx, run, actor, scope)
+}
+
+// syncGroup 立即同步规则组中启用的成员规则，与全量同步互斥
+func (s *FirewallService) syncGroup(ctx context.Context, groupID uint, actor Actor) (*SyncResult, error) {
+	scope := s.groupSyncScope(groupID)
+	run, runCtx, err := s.beginSync(ctx, actor, scope)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeSync(runCtx, run, actor, scope)
+}
+
+// StartAllRules 在后台执行全量同步并立即返回同步任务信息，进度通过事件总线发布。
+// 已有全量同步在运行时返回 RunInProgressError。
+func (s *FirewallService) StartAllRules(actor Actor) (RunInfo, error) {
+	// 后台同步不随发起请求结束，只能通过 CancelRun 取消
+	scope := s.fullSyncScope()
+	run, runCtx, err := s.beginSync(context.Background(), actor, scope)
+	if err != nil {
+		return RunInfo{}, err
+	}
+	go s.completeSync(runCtx, run, actor, scope)
+	return *run, nil
+}
+
+// CancelRun 取消正在运行的全量同步，同步不存在或已结束时返回 ErrRunNotFound
+func (s *FirewallService) CancelRun(runID string, actor Actor) error {
+	err := s.runs.CancelFullSync(runID)
+	if err == nil {
+		runLogger(actor, runID).Info("sync run cancellation requested")
+	}
+	s.record(actor, AuditEntry{Action: "sync.cancel", EntityType: "sync_run", EntityID: runID, RunID: runID, Err: err})
+	return err
+}
+
+// syncScope 一次同步处理的规则范围
+type syncScope struct {
+	kind    string // model.SyncRunFull 或 model.SyncRunGroup
+	groupID uint
+	rules   func() ([]model.FirewallRule, error) // 需要同步的启用规则
+}
+
+// fullSyncScope 全量同步：所有启用的规则
+func (s *FirewallService) fullSyncScope() syncScope {
+	return syncScope{kind: model.SyncRunFull, rules: s.repo.GetAllEnabled}
+}
+
+// groupSyncScope 规则组同步：规则组中启用的成员规则
+func (s *FirewallService) groupSyncScope(groupID uint) syncScope {
+	return syncScope{kind: model.SyncRunGroup, groupID: groupID, rules: func() ([]model.FirewallRule, error) {
+		members, err := s.repo.GetByGroup(groupID)
+		if err != nil {
+			return nil, err
+		}
+		enabled := members[:0]
+		for _, rule := range members {
+			if rule.Enabled {
+				enabled = append(enabled, rule)
+			}
+		}
+		return enabled, nil
+	}}
+}
+
+// beginSync 登记一次同步并发布 run.started 事件。规则组同步同样占用全量同步，
+// 避免与全量同步同时修改规则。
+func (s *FirewallService) beginSync(ctx context.Context, actor Actor, scope syncScope) (*RunInfo, context.Context, error) {
+	trigger := actor.trigger()
+	run, runCtx, err := s.runs.BeginFullSync(ctx, trigger)
+	if err != nil {
+		metrics.SyncRuns.WithLabelValues(trigger, metrics.SyncSkipped).Inc()
+		return nil, nil, err
+	}
+
+	started := map[string]any{
+		"trigger":    run.Trigger,
+		"kind":       scope.kind,
+		"started_at": run.StartedAt,
+	}
+	if scope.groupID != 0 {
+		started["group_id"] = scope.groupID
+	}
+	s.publish(Event{Type: EventRunStarted, RunID: run.RunID, Data: started})
+	s.saveRun(&model.SyncRun{
+		ID:        run.RunID,
+		Kind:      scope.kind,
+		GroupID:   scope.groupID,
+		Trigger:   run.Trigger,
+		Status:    model.SyncRunRunning,
+		StartedAt: run.StartedAt,
+	}, true)
+	return run, runCtx, nil
+}
+
+// completeSync 执行已登记的同步，记录指标和审计日志并发布 run.finished 事件
+func (s *FirewallService) completeSync(ctx context.Context, run *RunInfo, actor Actor, scope syncScope) (*SyncResult, error) {
+	defer s.runs.EndFullSync(run.RunID)
+
+	trigger := run.Trigger
+	result, err := s.runSync(ctx, run, actor, scope)
+	outcome := syncRunResult(result, err)
+	metrics.SyncRuns.WithLabelValues(trigger, outcome).Inc()
+	metrics.ObserveSince(metrics.SyncDuration.WithLabelValues(trigger), run.StartedAt)
+	s.refreshRuleGauges()
+	if outcome == metrics.SyncSuccess && scope.kind == model.SyncRunFull {
+		s.saveLastSuccessfulSync(result.FinishedAt)
+	}
+	s.finishRun(&model.SyncRun{
+		ID:        run.RunID,
+		Kind:      scope.kind,
+		GroupID:   scope.groupID,
+		Trigger:   trigger,
+		StartedAt: run.StartedAt,
+	}, outcome, result, err)
+	entry := AuditEntry{
+		Action:     "sync.run",
+		EntityType: "sync_run",
+		EntityID:   run.RunID,
+		RunID:      run.RunID,
+		Err:        err,
+	}
+	if scope.kind == model.SyncRunGroup {
+		entry.Action = "rule_group.sync"
+		entry.EntityType = "rule_group"
+		entry.EntityID = fmt.Sprint(scope.groupID)
+	}
+	if result != nil {
+		entry.After = result
+	}
+	s.record(actor, entry)
+
+	finished := map[string]any{"trigger": trigger, "kind": scope.kind, "outcome": outcome}
+	if result != nil {
+		finished["result"] = result
+	}
+	if err != nil {
+		finished["error"] = err.Error()
+	}
+	s.publish(Event{Type: EventRunFinished, RunID: run.RunID, Data: finished})
+	return result, err
+}
+
+// saveLastSuccessfulSync 记录全部规则同步成功的时间
+func (s *FirewallService) saveLastSuccessfulSync(at time.Time) {
+	if s.configService == nil {
+		return
+	}
+	if err := s.configService.SetConfig(lastSuccessfulSyncKey, at.UTC().Format(time.RFC3339), "string", "state", "上次全部规则同步成功的时间"); err != nil {
+		slog.Error("failed to save last successful sync time", "error", err)
+	}
+}
+
+// LastSuccessfulSync 返回上次全部规则同步成功的时间，从未成功时返回false
+func (s *FirewallService) LastSuccessfulSync() (time.Time, bool) {
+	if s.configService == nil {
+		return time.Time{}, false
+	}
+	value, err := s.configService.GetConfig(lastSuccessfulSyncKey)
+	if err != nil || value == "" {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// runLogger 附加同步任务ID和操作者的日志
+func runLogger(actor Actor, runID string) *slog.Logger {
+	return slog.With(append(actor.logAttrs(), "run_id", runID)...)
+}
+
+// syncRunResult 全量同步在指标中的结果分类
+func syncRunResult(result *SyncResult, err error) string {
+	switch {
+	case errors.Is(err, ErrRunCancelled), errors.Is(err, ErrShuttingDown), errors.Is(err, context.Canceled):
+		return metrics.SyncCancelled
+	case err != nil:
+		return metrics.SyncFailed
+	case result.Failed > 0:
+		return metrics.SyncPartial
+	default:
+		return metrics.SyncSuccess
+	}
+}
+
+// runSync 同步scope范围内的规则。ctx结束时停止分派新的实例，
+// 返回已处理部分的结果以及取消原因。
+func (s *FirewallService) runSync(ctx context.Context, run *RunInfo, actor Actor, scope syncScope) (*SyncResult, error) {
+	trigger := run.Trigger
+
+	logger := runLogger(actor, run.RunID).With("trigger", trigger, "kind", scope.kind)
+	if scope.groupID != 0 {
+		logger = logger.With("group_id", scope.groupID)
+	}
+	logger.Info("starting firewall update job")
+	result := &SyncResult{
+		RunID:     run.RunID,
+		Trigger:   trigger,
+		StartedAt: run.StartedAt,
+	}
+
+	// 1. Get current public IP using configured URL
+	currentIP, err := s.fetchCurrentIP(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, fmt.Errorf("failed to get public IP: %v", err)
+	}
+	logger.Info("fetched current public IP", "ip", currentIP)
+
+	// 检查IP合法性（只允许IPv4，禁止IPv6、JSON、报错信息、内容过长等）
+	if len(currentIP) > 40 || strings.Contains(currentIP, ":") || strings.ContainsAny(currentIP, "[{") || strings.Contains(strings.ToLower(currentIP), "error") || strings.Contains(strings.ToLower(currentIP), "html") {
+		metrics.IPFetchFailures.WithLabelValues(metrics.IPFetchInvalid).Inc()
+		return nil, fmt.Errorf("获取到的IP地址不合法，未触发规则更新")
+	}
+	// 严格正则校验IPv4
+	ipv4Pattern := `^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$`
+	matched, _ := regexp.MatchString(ipv4Pattern, currentIP)
+	if !matched {
+		metrics.IPFetchFailures.WithLabelValues(metrics.IPFetchInvalid).Inc()
+		return nil, fmt.Errorf("获取到的IP地址不是合法IPv4，未触发规则更新")
+	}
+	result.CurrentIP = currentIP
+	s.checkIPChanged(currentIP, run.RunID)
+
+	// 2. Get all enabled rules in scope from the database
+	rules, err := scope.rules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firewall rules: %v", err)
+	}
+
+	// 回滚后固定的规则保持回滚后的来源，手动执行后才恢复同步
+	rules = slices.DeleteFunc(rules, func(rule model.FirewallRule) bool {
+		if rule.Pinned {
+			logger.Info("skipping pinned rule", "rule_id", rule.ID)
+			result.Pinned++
+		}
+		return rule.Pinned
+	})
+
+	// 3. 多实例规则展开为每个目标实例一条，无法解析目标实例的规则计为失败
+	scoped := rules
+	rules, unresolved := s.expandTargets(ctx, rules)
+	progress := &runProgress{runID: run.RunID, total: len(unresolved)}
+	for i := range unresolved {
+		u := &unresolved[i]
+		logger.Error("failed to resolve rule targets", "rule_id", u.rule.ID, "error", u.err)
+		if ctx.Err() == nil {
+			s.notifyRuleFailed(&u.rule, u.err, run.RunID)
+		}
+		s.markTargetResult(progress, u.rule.ID, u.err)
+		s.ruleProgress(progress, &u.rule, u.err)
+	}
+
+	// 4. 按云服务配置+实例分组，每个实例只查询一次规则列表（无论IP是否变化都要执行）
+	groups := groupRulesByInstance(rules)
+	result.Instances = len(groups)
+	for _, group := range groups {
+		progress.total += len(group.rules)
+	}
+
+	// 5. 使用有限数量的worker并发处理各实例，实例内的规则顺序执行
+	workers := s.syncConcurrency()
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+
+	var updated int64
+	failed := int64(len(unresolved))
+	jobs := make(chan instanceRules)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				ok, bad := s.syncInstanceRules(ctx, group, currentIP, actor, progress)
+				atomic.AddInt64(&updated, int64(ok))
+				atomic.AddInt64(&failed, int64(bad))
+			}
+		}()
+	}
+dispatch:
+	for _, group := range groups {
+		select {
+		case jobs <- group:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// 6. 删除规则在不再是其目标的实例上的云端规则
+	if ctx.Err() == nil {
+		s.pruneTargets(ctx, scoped, rules, unresolved, actor, run.RunID)
+	}
+
+	result.Updated = int(updated)
+	result.Failed = int(failed)
+	result.Skipped = progress.total - result.Updated - result.Failed
+	result.FinishedAt = time.Now()
+	var interrupted error
+	switch {
+	case ctx.Err() != nil:
+		interrupted = context.Cause(ctx)
+	case result.Skipped > 0 && s.runs.Draining():
+		// 服务关闭时剩余规则不再变更
+		interrupted = ErrShuttingDown
+	}
+	if interrupted != nil {
+		logger.Warn("firewall update job interrupted",
+			"updated", result.Updated,
+			"failed", result.Failed,
+			"skipped", result.Skipped,
+			"cause", interrupted)
+		return result, interrupted
+	}
+	logger.Info("firewall update job finished",
+		"instances", result.Instances,
+		"updated", result.Updated,
+		"failed", result.Failed,
+		"duration_ms", result.FinishedAt.Sub(result.StartedAt).Milliseconds())
+	return result, nil
+}
+
+// ActiveRun 返回当前正在运行的全量同步
+func (s *FirewallService) ActiveRun() (RunInfo, bool) {
+	return s.runs.ActiveRun()
+}
+
+// Shutdown 停止接受新的同步和规则变更，等待进行中的云端变更完成，
+// 避免先建后删的更新在中途被打断。ctx结束时取消仍在运行的同步并返回错误。
+func (s *FirewallService) Shutdown(ctx context.Context) error {
+	if run, ok := s.runs.ActiveRun(); ok {
+		slog.Info("waiting for running sync to stop", "run_id", run.RunID)
+	}
+	return s.runs.Drain(ctx)
+}
+
+// ReconcileInterruptedRuns 检测服务上次退出时未完成的同步任务，标记为中断，
+// 并在后台调度一次全量同步，修复可能只完成了一半的云端变更（如新规则已创建、旧规则未删除）。
+// 没有被中断的任务时返回nil。
+func (s *FirewallService) ReconcileInterruptedRuns() (*RunInfo, error) {
+	if s.runStore == nil {
+		return nil, nil
+	}
+
+	runs, err := s.runStore.ListInterrupted()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interrupted sync runs: %v", err)
+	}
+	if len(runs) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	for i := range runs {
+		run := &runs[i]
+		slog.Warn("detected interrupted sync run", "run_id", run.ID, "kind", run.Kind, "status", run.Status, "started_at", run.StartedAt)
+		if run.Status == model.SyncRunRunning {
+			run.Status = model.SyncRunInterrupted
+			run.FinishedAt = &now
+			run.Error = "服务在同步过程中退出"
+			s.record(SystemActor, AuditEntry{Action: "sync.interrupted", EntityType: "sync_run", EntityID: run.ID, RunID: run.ID, After: run})
+		}
+	}
+
+	reconcile, err := s.StartAllRules(SystemActor)
+	if err != nil {
+		// 未能调度补偿同步时保留待处理状态，下次启动再试
+		for i := range runs {
+			s.saveRun(&runs[i], false)
+		}
+		return nil, fmt.Errorf("failed to start reconciliation: %v", err)
+	}
+	for i := range runs {
+		runs[i].ReconciledBy = reconcile.RunID
+		s.saveRun(&runs[i], false)
+	}
+	return &reconcile, nil
+}
+
+// groupRulesByInstance 按云服务配置和实例对规则分组，保持规则原有顺序
+func groupRulesByInstance(rules []model.FirewallRule) []instanceRules {
+	var groups []instanceRules
+	index := make(map[instanceKey]int)
+
+	for _, rule := range rules {
+		// 只处理有备注的规则
+		if rule.Remark == "" {
+			slog.Warn("skipping rule without remark", "rule_id", rule.ID)
+			continue
+		}
+
+		key := instanceKey{
+			cloudConfigID: rule.CloudConfigID,
+			provider:      rule.Provider,
+			instanceID:    rule.InstanceID,
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, instanceRules{key: key})
+		}
+		groups[i].rules = append(groups[i].rules, rule)
+	}
+
+	return groups
+}
+
+// syncInstanceRules 同步单个实例上的所有规则，返回成功和失败的规则数。
+// ctx结束后不再处理剩余规则，这些规则既不计为成功也不计为失败。
+func (s *FirewallService) syncInstanceRules(ctx context.Context, group instanceRules, currentIP string, actor Actor, progress *runProgress) (int, int) {
+	runID := progress.runID
+	logger := runLogger(actor, runID).With(
+		"provider", group.key.provider,
+		"cloud_config_id", group.key.cloudConfigID,
+		"instance_id", group.key.instanceID,
+	)
+
+	provider, err := s.instanceProvider(group.key.provider, group.key.cloudConfigID, group.key.instanceID)
+	if err != nil {
+		logger.Error("failed to get cloud client", "error", err)
+		s.notifyInstanceError(group.key, err, runID)
+		s.markGroupFailed(group, err, progress)
+		return 0, len(group.rules)
+	}
+
+	guard, err := s.instanceGuard(group.key.cloudConfigID, group.key.instanceID)
+	if err != nil {
+		logger.Error("failed to load instance safeguard", "error", err)
+		s.markGroupFailed(group, err, progress)
+		return 0, len(group.rules)
+	}
+
+	// 与单条规则执行互斥，避免Lighthouse先建后删的更新交叉执行
+	unlock, err := s.runs.LockInstance(ctx, group.key.provider, group.key.instanceID)
+	if err != nil {
+		logger.Error("failed to lock instance", "error", err)
+		s.markGroupFailed(group, err, progress)
+		return 0, len(group.rules)
+	}
+	defer unlock()
+
+	before, err := provider.ListFirewallRules(ctx, group.key.instanceID)
+	if err != nil {
+		if ctx.Err() != nil {
+			// 同步被取消，不视为实例故障
+			return 0, 0
+		}
+		logger.Error("failed to list firewall rules", "error", err)
+		s.notifyInstanceError(group.key, err, runID)
+		s.markGroupFailed(group, err, progress)
+		return 0, len(group.rules)
+	}
+	// 受保护的云端规则不参与匹配，不会被删除或修改
+	existing := guard.unprotected(before)
+
+	var updated, failed int
+	synced := make(map[uint]*model.FirewallRule)
+	for i := range group.rules {
+		if ctx.Err() != nil {
+			break
+		}
+		// 服务关闭时不再开始新的规则变更，剩余规则计为未处理
+		done, err := s.runs.BeginMutation()
+		if err != nil {
+			break
+		}
+		rule := &group.rules[i]
+		ruleLogger := logger.With("rule_id", rule.ID)
+		ruleLogger.Debug("processing rule", "remark", rule.Remark, "current_ip", currentIP, "last_ip", rule.LastIP)
+
+		source := ruleSource(rule, currentIP)
+		existing, err = s.reconcileRule(ctx, provider, rule, source, existing, actor, runID)
+		done()
+		if err != nil {
+			ruleLogger.Error("failed to update rule", "error", err)
+			if ctx.Err() == nil {
+				s.notifyRuleFailed(rule, err, runID)
+			}
+			s.markTargetResult(progress, rule.ID, err)
+			s.ruleProgress(progress, rule, err)
+			failed++
+			continue
+		}
+
+		// 保存规则实际生效的来源：固定来源的规则保存其CIDR，而不是当前公网IP
+		lastIP := strings.TrimSuffix(source, "/32")
+		rule.LastIP = lastIP
+		if err := s.saveCloudState(rule); err != nil {
+			ruleLogger.Error("failed to save rule IP", "error", err)
+			s.markTargetResult(progress, rule.ID, err)
+			s.ruleProgress(progress, rule, err)
+			failed++
+			continue
+		}
+		ruleLogger.Info("rule synced", "ip", lastIP)
+		s.markTargetResult(progress, rule.ID, nil)
+		s.ruleProgress(progress, rule, nil)
+		synced[rule.ID] = rule
+		updated++
+	}
+
+	// 按优先级调整规则顺序，失败时规则本身已同步，下次同步时重试
+	if ctx.Err() == nil {
+		if err := s.orderInstanceRules(ctx, provider, group, guard, actor, runID); err != nil {
+			logger.Error("failed to order firewall rules", "error", err)
+		}
+	}
+
+	// 关键端口失去放行规则时，本次在该实例上的变更已回滚，回滚的规则计为失败
+	rolledBack, err := s.verifyCriticalPorts(ctx, provider, group.key, guard, before, actor, runID)
+	if err != nil {
+		logger.Error("critical port verification failed", "error", err)
+		for _, id := range rolledBack {
+			if rule, ok := synced[id]; ok {
+				s.markTargetResult(progress, id, err)
+				s.notifyRuleFailed(rule, err, runID)
+				delete(synced, id)
+				updated--
+				failed++
+			}
+		}
+	}
+
+	// 探测更新后的规则能否从本机访问，探测失败的规则标记为降级但仍计为已同步
+	for i := range group.rules {
+		rule := &group.rules[i]
+		if synced[rule.ID] == nil || ctx.Err() != nil {
+			continue
+		}
+		if _, err := s.probeRule(ctx, provider, rule); err != nil {
+			logger.Warn("rule probe failed", "rule_id", rule.ID, "error", err)
+			s.markTargetResult(progress, rule.ID, err)
+			s.notifyRuleFailed(rule, err, runID)
+		}
+	}
+
+	return updated, failed
+}
+
+// markGroupFailed 实例级错误导致该实例上的所有规则失败
+func (s *FirewallService) markGroupFailed(group instanceRules, err error, progress *runProgress) {
+	for i := range group.rules {
+		s.markTargetResult(progress, group.rules[i].ID, err)
+		s.ruleProgress(progress, &group.rules[i], err)
+	}
+}
+
+// notifyRuleFailed 规则更新失败通知，同一规则持续失败时在冷却时间内只通知一次
+func (s *FirewallService) notifyRuleFailed(rule *model.FirewallRule, err error, runID string) {
+	if cloud.IsCredentialError(err) {
+		s.notifyInstanceError(instanceKey{rule.CloudConfigID, rule.Provider, rule.InstanceID}, err, runID)
+		return
+	}
+	s.notify(NotificationEvent{
+		Type: model.EventRuleFailed,
+		Data: map[string]any{
+			"rule_id":     rule.ID,
+			"remark":      rule.Remark,
+			"provider":    rule.Provider,
+			"instance_id": rule.InstanceID,
+			"protocol":    rule.Protocol,
+			"port":        rule.Port,
+			"error":       err.Error(),
+			"run_id":      runID,
+		},
+		DedupKey: fmt.Sprint(rule.ID),
+	})
+}
+
+// ruleSource 规则在云端的来源：配置了固定来源时使用固定来源，否则为当前公网IP
+func ruleSource(rule *model.FirewallRule, currentIP string) string {
+	if rule.Source != "" {
+		return rule.Source
+	}
+	return hostCIDR(currentIP)
+}
+
+// hostCIDR 单个IPv4地址对应的CIDR
+func hostCIDR(ip string) string {
+	return ip + "/32"
+}
+
+// matchesRule 云端规则是否属于本地规则：通过备注、协议、端口匹配，而不是依赖RuleID
+func matchesRule(r *cloud.FirewallRuleResult, rule *model.FirewallRule) bool {
+	return strings.EqualFold(r.Protocol, rule.Protocol) &&
+		r.Port == rule.Port &&
+		r.Description == rule.Remark
+}
+
+// reconcileRule 根据已查询的云端规则列表，将规则的来源更新为cidrBlock（通常为当前公网IP/32），动作更新为规则的动作。
+// 云端没有匹配规则时创建新规则；来源或动作不同时先创建新规则再删除旧规则（Lighthouse不支持直接修改）。
+// 返回更新后的云端规则列表，供同一实例的后续规则复用。每次云端变更都记录审计日志。
+func (s *FirewallService) reconcileRule(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule, cidrBlock string, existing []*cloud.FirewallRuleResult, actor Actor, runID string) ([]*cloud.FirewallRuleResult, error) {
+	logger := runLogger(actor, runID).With("rule_id", rule.ID, "provider", rule.Provider, "instance_id", rule.InstanceID)
+
+	// 先完成或回滚此前中断的更新
+	existing, err := s.resumeOperations(ctx, provider, rule, existing, actor, runID)
+	if err != nil {
+		return existing, err
+	}
+
+	action := normalizeAction(rule.Action)
+	upToDate := func(r *cloud.FirewallRuleResult) bool {
+		return r.CidrBlock == cidrBlock && strings.EqualFold(r.Action, action)
+	}
+
+	// 先建后删的更新被中断时云端可能留下多条匹配规则：优先保留来源和动作已是目标值的一条，其余删除
+	var target *cloud.FirewallRuleResult
+	var duplicates []*cloud.FirewallRuleResult
+	for _, r := range existing {
+		if !matchesRule(r, rule) {
+			continue
+		}
+		switch {
+		case target == nil:
+			target = r
+		case upToDate(r) && !upToDate(target):
+			duplicates = append(duplicates, target)
+			target = r
+		default:
+			duplicates = append(duplicates, r)
+		}
+	}
+	for _, duplicate := range duplicates {
+		err := provider.DeleteFirewallRuleBySpec(ctx, rule.InstanceID, duplicate)
+		s.record(actor, cloudAuditEntry("cloud.delete", rule, runID, cloudRuleAudit(rule, nil, duplicate), nil, err))
+		if err != nil {
+			logger.Warn("failed to delete duplicate cloud rule", "cidr_block", duplicate.CidrBlock, "error", err)
+			continue
+		}
+		logger.Info("deleted duplicate cloud rule", "cidr_block", duplicate.CidrBlock)
+		existing = removeCloudRule(existing, duplicate)
+	}
+
+	// 如果来源和动作已是目标值，就不需要更新
+	if target != nil && upToDate(target) {
+		logger.Debug("rule already has the target source", "cidr_block", cidrBlock, "action", action)
+		metrics.RuleUpdates.WithLabelValues(metrics.RuleUnchanged).Inc()
+		if rule.RuleID != target.RuleID {
+			rule.RuleID = target.RuleID
+			if err := s.saveCloudState(rule); err != nil {
+				logger.Warn("failed to save cloud rule ID", "error", err)
+			}
+		}
+		return existing, nil
+	}
+
+	ruleSpec := &cloud.FirewallRuleSpec{
+		Protocol:    rule.Protocol,
+		Port:        rule.Port,
+		CidrBlock:   cidrBlock,
+		Action:      action,
+		Description: rule.Remark,
+	}
+	if target != nil {
+		// 沿用云端规则的协议和端口，仅替换来源和动作
+		ruleSpec.Protocol = target.Protocol
+		ruleSpec.Port = target.Port
+	} else {
+		logger.Info("rule not found in cloud, creating it")
+	}
+
+	// 在云服务上创建防火墙规则，调用前先记录操作日志，中断后可据此恢复
+	op := newRuleOperation(rule, runID, ruleSpec, target)
+	if err := s.journalOperation(op); err != nil {
+		return existing, err
+	}
+	result, err := provider.CreateFirewallRule(ctx, rule.InstanceID, ruleSpec)
+	s.record(actor, cloudAuditEntry("cloud.create", rule, runID, nil, cloudRuleAudit(rule, ruleSpec, result), err))
+	if err != nil {
+		// 保持 pending_create：超时等情况下规则可能已创建，下次同步时按云端状态继续或回滚
+		op.Error = err.Error()
+		if journalErr := s.journalOperation(op); journalErr != nil {
+			logger.Error("failed to record operation error", "error", journalErr)
+		}
+		if rule.InstanceSelector != "" && strings.Contains(err.Error(), "FirewallRulesLimitExceeded") {
+			// 标签选择器匹配的实例保存规则时无法确定，没有经过规则数上限的检查
+			return existing, fmt.Errorf("failed to create firewall rule: instance %s has reached its firewall rule limit; instances matched by instance_selector are not checked against the limit when the rule is saved: %v", rule.InstanceID, err)
+		}
+		return existing, fmt.Errorf("failed to create firewall rule: %v", err)
+	}
+	existing = append(existing, result)
+	op.State = model.RuleOpCreated
+	op.CloudRuleID = result.RuleID
+	if err := s.journalOperation(op); err != nil {
+		return existing, err
+	}
+
+	// 删除旧规则。新规则已创建，即使同步被取消也要完成删除，避免新旧规则同时生效
+	var deleteErr error
+	if target != nil {
+		op.State = model.RuleOpPendingDelete
+		if err := s.journalOperation(op); err != nil {
+			return existing, err
+		}
+		deleteErr = provider.DeleteFirewallRuleBySpec(context.WithoutCancel(ctx), rule.InstanceID, target)
+		s.record(actor, cloudAuditEntry("cloud.delete", rule, runID, cloudRuleAudit(rule, nil, target), nil, deleteErr))
+		if deleteErr == nil {
+			existing = removeCloudRule(existing, target)
+		}
+	}
+	if deleteErr != nil {
+		// 保持 pending_delete，下次同步时重试删除旧规则
+		op.Error = deleteErr.Error()
+	} else {
+		op.State = model.RuleOpDone
+	}
+	if err := s.journalOperation(op); err != nil {
+		logger.Error("failed to record operation result", "state", op.State, "error", err)
+	}
+
+	// 更新数据库中的规则信息（新规则已生效）
+	rule.RuleID = result.RuleID
+	rule.LastIP = strings.TrimSuffix(cidrBlock, "/32")
+	if err := s.saveCloudState(rule); err != nil {
+		logger.Warn("rule updated in cloud but failed to update database", "error", err)
+	}
+	if deleteErr != nil {
+		return existing, fmt.Errorf("created new rule but failed to delete old rule %s, will retry on next sync: %v", target.CidrBlock, deleteErr)
+	}
+
+	if target != nil {
+		metrics.RuleUpdates.WithLabelValues(metrics.RuleUpdated).Inc()
+	} else {
+		metrics.RuleUpdates.WithLabelValues(metrics.RuleCreated).Inc()
+	}
+	logger.Info("firewall rule updated in cloud", "cloud_rule_id", result.RuleID, "cidr_block", cidrBlock)
+	return existing, nil
+}
+
+// removeCloudRule 从已查询的云端规则列表中移除已删除的规则
+func removeCloudRule(rules []*cloud.FirewallRuleResult, removed *cloud.FirewallRuleResult) []*cloud.FirewallRuleResult {
+	for i, r := range rules {
+		if r == removed {
+			return append(rules[:i], rules[i+1:]...)
+		}
+	}
+	return rules
+}
+
+// cloudAuditEntry 云端规则变更的审计记录，目标对象为本地规则
+func cloudAuditEntry(action string, rule *model.FirewallRule, runID string, before, after any, err error) AuditEntry {
+	return AuditEntry{
+		Action:     action,
+		EntityType: "firewall_rule",
+		EntityID:   fmt.Sprint(rule.ID),
+		RunID:      runID,
+		Before:     before,
+		After:      after,
+		Err:        err,
+	}
+}
+
+// cloudRuleAudit 审计日志中记录的云端规则信息，创建失败时使用请求的规则内容
+func cloudRuleAudit(rule *model.FirewallRule, spec *cloud.FirewallRuleSpec, result *cloud.FirewallRuleResult) map[string]any {
+	fields := map[string]any{
+		"provider":        rule.Provider,
+		"cloud_config_id": rule.CloudConfigID,
+		"instance_id":     rule.InstanceID,
+	}
+	if result != nil {
+		fields["cloud_rule_id"] = result.RuleID
+		fields["protocol"] = result.Protocol
+		fields["port"] = result.Port
+		fields["cidr_block"] = result.CidrBlock
+		fields["action"] = result.Action
+		fields["description"] = result.Description
+	} else if spec != nil {
+		fields["protocol"] = spec.Protocol
+		fields["port"] = spec.Port
+		fields["cidr_block"] = spec.CidrBlock
+		fields["action"] = spec.Action
+		fields["description"] = spec.Description
+	}
+	return fields
+}
+
+// fetchCurrentIP 使用配置的URL获取当前公网IP
+func (s *FirewallService) fetchCurrentIP(ctx context.Context) (string, error) {
+	if s.configService == nil {
+		// 降级到默认方法
+		return utils.GetPublicIP(ctx)
+	}
+
+	// 获取配置的IP查询URL
+	ipFetchURL, configErr := s.configService.GetConfig("ip_fetch_url")
+	if configErr != nil || ipFetchURL == "" {
+		ipFetchURL = "https://4.ipw.cn" // 默认URL
+	}
+	return utils.GetPublicIPWithURL(ctx, ipFetchURL)
+}
+
+// syncConcurrency 读取同步并发数配置
+func (s *FirewallService) syncConcurrency() int {
+	if s.configService == nil {
+		return defaultSyncConcurrency
+	}
+
+	n, err := s.configService.GetConfigInt("sync_concurrency")
+	if err != nil || n <= 0 {
+		return defaultSyncConcurrency
+	}
+	if n > maxSyncConcurrency {
+		return maxSyncConcurrency
+	}
+	return n
+}
+
+// regionProvider 获取云服务配置在指定区域的客户端，region为空时使用配置的默认区域。
+// 同一配置和区域复用已创建的客户端。
+func (s *FirewallService) regionProvider(providerName string, cloudConfigID uint, region string) (cloud.CloudProvider, error) {
+	// 如果有全局客户端且CloudConfigID为0，使用全局客户端
+	if cloudConfigID == 0 && s.tencentClient != nil && providerName == "TencentCloud" {
+		return s.tencentClient, nil
+	}
+
+	// 根据CloudConfigID获取云服务配置
+	if s.configService == nil {
+		return nil, fmt.Errorf("config service not available")
+	}
+
+	cloudConfig, err := s.configService.GetCloudConfigByID(cloudConfigID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cloud config: %v", err)
+	}
+
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+
+	// 配置未修改时复用缓存的客户端
+	key := providerKey{cloudConfigID: cloudConfigID, region: region}
+	if cached, ok := s.providers[key]; ok && cached.updatedAt.Equal(cloudConfig.UpdatedAt) {
+		return cached.provider, nil
+	}
+
+	provider, err := newCloudProvider(cloudConfig, region)
+	if err != nil {
+		return nil, err
+	}
+
+	s.providers[key] = cachedProvider{
+		provider:  provider,
+		updatedAt: cloudConfig.UpdatedAt,
+	}
+	return provider, nil
+}
+
+// newCloudProvider 根据云服务配置创建指定区域的客户端，region为空时使用配置的默认区域
+func newCloudProvider(cloudConfig *model.CloudProviderConfig, region string) (cloud.CloudProvider, error) {
+	if region == "" {
+		region = cloudConfig.Region
+	}
+	switch cloudConfig.Provider {
+	case "TencentCloud":
+		// 构建腾讯云配置
+		tencentConfig := cloud.TencentConfig{
+			SecretId:   cloudConfig.SecretId,
+			SecretKey:  cloudConfig.SecretKey,
+			Region:     region,
+			InstanceId: cloudConfig.InstanceId,
+		}
+		return cloud.NewTencentClient(tencentConfig)
+	case cloud.ProviderFake:
+		fakeConfig, err := cloud.ParseFakeConfig(cloudConfig.Extra)
+		if err != nil {
+			return nil, err
+		}
+		// 云服务配置的默认实例总是存在，未指定区域的实例属于默认区域
+		if cloudConfig.InstanceId != "" && !slices.ContainsFunc(fakeConfig.Instances, func(i cloud.FakeInstance) bool {
+			return i.InstanceID == cloudConfig.InstanceId
+		}) {
+			fakeConfig.Instances = append(fakeConfig.Instances, cloud.FakeInstance{InstanceID: cloudConfig.InstanceId})
+		}
+		for i := range fakeConfig.Instances {
+			if fakeConfig.Instances[i].Region == "" {
+				fakeConfig.Instances[i].Region = cloudConfig.Region
+			}
+		}
+		// 相同访问密钥的配置视为同一账号，共享实例和防火墙规则
+		return cloud.NewFakeProvider(cloudConfig.SecretId, region, fakeConfig), nil
+	case "Aliyun":
+		return nil, fmt.Errorf("Aliyun provider not implemented yet")
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", cloudConfig.Provider)
+	}
+}
+
+// The following methods are for the API
+func (s *FirewallService) GetAllRules() ([]model.FirewallRule, error) {
+	return s.repo.GetAll()
+}
+
+// GetEnabledRulesCount 获取启用规则的数量
+func (s *FirewallService) GetEnabledRulesCount() (int, error) {
+	enabledRules, err := s.repo.GetAllEnabled()
+	if err != nil {
+		return 0, err
+	}
+	return len(enabledRules), nil
+}
+
+func (s *FirewallService) GetRule(id uint) (*model.FirewallRule, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *FirewallService) CreateRule(rule *model.FirewallRule, actor Actor) error {
+	// 成员规则只能由规则组创建
+	rule.GroupID = 0
+	rule.TemplateItemID = 0
+	if err := s.ValidateRule(rule); err != nil {
+		return err
+	}
+	return s.createRule(rule, actor)
+}
+
+func (s *FirewallService) createRule(rule *model.FirewallRule, actor Actor) error {
+	err := s.repo.Create(rule)
+	s.record(actor, AuditEntry{Action: "rule.create", EntityType: "firewall_rule", EntityID: fmt.Sprint(rule.ID), After: rule, Err: err})
+	s.refreshRuleGauges()
+	return err
+}
+
+func (s *FirewallService) DeleteRule(id uint, actor Actor) error {
+	before, _ := s.repo.GetByID(id)
+	if before != nil && before.GroupID != 0 {
+		return ErrRuleManagedByGroup
+	}
+	return s.deleteRule(id, before, actor)
+}
+
+func (s *FirewallService) deleteRule(id uint, before *model.FirewallRule, actor Actor) error {
+	err := s.repo.Delete(id)
+	if err == nil && s.journal != nil {
+		if err := s.journal.AbandonUnfinished(id); err != nil {
+			slog.Warn("failed to abandon unfinished operations of deleted rule", "rule_id", id, "error", err)
+		}
+	}
+	s.record(actor, AuditEntry{Action: "rule.delete", EntityType: "firewall_rule", EntityID: fmt.Sprint(id), Before: before, Err: err})
+	s.refreshRuleGauges()
+	return err
+}
+
+func (s *FirewallService) UpdateRule(rule *model.FirewallRule, actor Actor) error {
+	before, _ := s.repo.GetByID(rule.ID)
+	if before != nil && before.GroupID != 0 {
+		return ErrRuleManagedByGroup
+	}
+	rule.GroupID = 0
+	rule.TemplateItemID = 0
+	if err := s.ValidateRule(rule); err != nil {
+		return err
+	}
+	return s.updateRule(rule, before, actor)
+}
+
+func (s *FirewallService) updateRule(rule, before *model.FirewallRule, actor Actor) error {
+	err := s.repo.Update(rule)
+	s.record(actor, AuditEntry{Action: "rule.update", EntityType: "firewall_rule", EntityID: fmt.Sprint(rule.ID), Before: before, After: rule, Err: err})
+	s.refreshRuleGauges()
+	return err
+}
+
+// ExecuteRule 立即将单条规则同步为当前公网IP，ctx结束时中止
+func (s *FirewallService) ExecuteRule(ctx context.Context, id uint, actor Actor) error {
+	runID := newRunID()
+	run := &model.SyncRun{
+		ID:        runID,
+		Kind:      model.SyncRunRule,
+		RuleID:    id,
+		Trigger:   actor.trigger(),
+		Status:    model.SyncRunRunning,
+		StartedAt: time.Now(),
+	}
+	s.saveRun(run, true)
+	err := s.executeRule(ctx, id, actor, runID)
+	outcome := model.SyncRunSuccess
+	if err != nil && !errors.Is(err, ErrProbeFailed) {
+		outcome = model.SyncRunFailed
+	}
+	s.finishRun(run, outcome, nil, err)
+	s.record(actor, AuditEntry{Action: "rule.execute", EntityType: "firewall_rule", EntityID: fmt.Sprint(id), RunID: runID, Err: err})
+	if errors.Is(err, ErrProbeFailed) {
+		// 规则已更新，只是更新后无法访问
+		s.setRuleDegraded(id, true)
+	} else {
+		s.markRuleResult(id, err)
+	}
+	s.refreshRuleGauges()
+	return err
+}
+
+func (s *FirewallService) executeRule(ctx context.Context, id uint, actor Actor, runID string) error {
+	// 获取规则
+	rule, err := s.repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("failed to get rule: %v", err)
+	}
+
+	// 获取当前公网IP
+	currentIP, err := s.fetchCurrentIP(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current IP: %v", err)
+	}
+
+	targets, err := s.newTargetResolver().expand(ctx, rule)
+	if err != nil {
+		return fmt.Errorf("failed to resolve rule targets: %v", err)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("rule matches no instances")
+	}
+
+	// 多实例规则逐个实例更新，某个实例失败不影响其他实例；探测失败只在没有其他错误时返回
+	var failures []error
+	var probeErr error
+	reconciled := 0
+	lockedOut := false
+	for i := range targets {
+		err := s.executeRuleOnInstance(ctx, &targets[i], currentIP, actor, runID)
+		if errors.Is(err, ErrLockoutPrevented) {
+			lockedOut = true
+		}
+		switch {
+		case err == nil:
+			reconciled++
+		case errors.Is(err, ErrProbeFailed):
+			// 规则已更新，只是更新后无法访问
+			reconciled++
+			if probeErr == nil {
+				probeErr = err
+			}
+		case rule.MultiInstance():
+			failures = append(failures, fmt.Errorf("instance %s: %w", targets[i].InstanceID, err))
+		default:
+			failures = append(failures, err)
+		}
+	}
+
+	// 删除规则在不再是其目标的实例上的云端规则
+	if ctx.Err() == nil {
+		s.pruneTargets(ctx, []model.FirewallRule{*rule}, targets, nil, actor, runID)
+	}
+
+	// 至少一个实例已更新为当前来源后才解除回滚后的固定，之后的同步恢复更新该规则；
+	// 全部失败时保持固定，避免下一次同步把回滚后的来源改回当前公网IP。
+	// 防锁死保护回滚了某个实例时，规则已重新固定，不再解除
+	if rule.Pinned && reconciled > 0 && !lockedOut {
+		if err := s.repo.SetPinned(rule.ID, false); err != nil {
+			failures = append(failures, fmt.Errorf("failed to unpin rule: %v", err))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Join(failures...)
+	}
+	return probeErr
+}
+
+// executeRuleOnInstance 将规则在一个实例上的来源更新为当前公网IP，验证关键端口并探测连通性
+func (s *FirewallService) executeRuleOnInstance(ctx context.Context, rule *model.FirewallRule, currentIP string, actor Actor, runID string) error {
+	provider, err := s.instanceProvider(rule.Provider, rule.CloudConfigID, rule.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get cloud client: %v", err)
+	}
+
+	guard, err := s.instanceGuard(rule.CloudConfigID, rule.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	// 同一实例上正在进行的变更完成后再执行
+	unlock, err := s.runs.LockInstance(ctx, rule.Provider, rule.InstanceID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	done, err := s.runs.BeginMutation()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	existing, err := provider.ListFirewallRules(ctx, rule.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to list existing rules: %v", err)
+	}
+
+	_, err = s.reconcileRule(ctx, provider, rule, ruleSource(rule, currentIP), guard.unprotected(existing), actor, runID)
+	key := instanceKey{rule.CloudConfigID, rule.Provider, rule.InstanceID}
+	if _, verifyErr := s.verifyCriticalPorts(ctx, provider, key, guard, existing, actor, runID); verifyErr != nil {
+		return verifyErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// 更新成功后探测连通性，失败时返回 ErrProbeFailed
+	_, err = s.probeRule(ctx, provider, rule)
+	return err
+}
+
+// CreateTencentFirewallRule creates a new firewall rule in Tencent Cloud and saves it to database
+func (s *FirewallService) CreateTencentFirewallRule(ctx context.Context, instanceID, port, cidrBlock, protocol, action, description string) error {
+	if s.tencentClient == nil {
+		return fmt.Errorf("TencentCloud client not initialized")
+	}
+
+	// 创建防火墙规则规格
+	ruleSpec := &cloud.FirewallRuleSpec{
+		Port:        port,
+		Protocol:    protocol,
+		CidrBlock:   cidrBlock,
+		Action:      normalizeAction(action),
+		Description: description,
+	}
+
+	// 在腾讯云创建规则
+	result, err := s.tencentClient.CreateFirewallRule(ctx, instanceID, ruleSpec)
+	if err != nil {
+		return fmt.Errorf("failed to create firewall rule in Tencent Cloud: %v", err)
+	}
+
+	// 保存到数据库
+	rule := &model.FirewallRule{
+		Provider:   "TencentCloud",
+		InstanceID: instanceID,
+		Port:       port,
+		Protocol:   protocol,
+		Action:     ruleSpec.Action,
+		RuleID:     result.RuleID,
+		LastIP:     cidrBlock,
+		Enabled:    true,
+		Remark:     description,
+	}
+
+	return s.repo.Create(rule)
+}
+
+// SyncTencentFirewallRules synchronizes firewall rules from Tencent Cloud with local database
+func (s *FirewallService) SyncTencentFirewallRules(ctx context.Context, instanceID string) error {
+	if s.tencentClient == nil {
+		return fmt.Errorf("TencentCloud client not initialized")
+	}
+
+	// 从腾讯云获取防火墙规则
+	rules, err := s.tencentClient.ListFirewallRules(ctx, instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to list firewall rules from Tencent Cloud: %v", err)
+	}
+
+	slog.Info("listed Tencent Cloud firewall rules", "instance_id", instanceID, "count", len(rules))
+
+	// 这里可以添加同步逻辑，比如：
+	// 1. 比较云端和本地的规则
+	// 2. 添加云端存在但本地不存在的规则
+	// 3. 标记本地存在但云端不存在的规则为失效
+
+	for _, rule := range rules {
+		slog.Debug("cloud firewall rule", "instance_id", instanceID, "cloud_rule_id", rule.RuleID, "port", rule.Port, "protocol", rule.Protocol, "cidr_block", rule.CidrBlock)
+	}
+
+	return nil
+}
+
+// GetInstanceInfo gets information about a cloud instance
+func (s *FirewallService) GetInstanceInfo(ctx context.Context, instanceID string) (*cloud.InstanceInfo, error) {
+	if s.tencentClient == nil {
+		return nil, fmt.Errorf("TencentCloud client not initialized")
+	}
+
+	return s.tencentClient.GetInstance(ctx, instanceID)
+}
@@ -0,0 +1,714 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/pkg/cloud"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	testRegion   = "ap-test"
+	testInstance = "lhins-test"
+	firstIP      = "198.51.100.7"
+	secondIP     = "198.51.100.8"
+)
+
+// syncEnv 使用内存中的模拟云服务和SQLite数据库执行同步
+type syncEnv struct {
+	t       *testing.T
+	service *FirewallService
+	config  ConfigService
+	journal repository.RuleOperationRepository
+	guards  SafeguardService
+	cloudID uint
+	account string
+	rule    *model.FirewallRule
+
+	ipMu sync.Mutex
+	ip   string
+}
+
+func newSyncEnv(t *testing.T) *syncEnv {
+	t.Helper()
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: "sqlite",
+		DSN:        filepath.Join(t.TempDir(), "fireflow.db"),
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&model.FirewallRule{}, &model.FirewallRuleTarget{}, &model.ConfigItem{}, &model.CloudProviderConfig{},
+		&model.SyncRun{}, &model.RuleOperation{}, &model.CloudInstance{}, &model.InstanceSafeguard{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	env := &syncEnv{t: t, ip: firstIP, account: t.Name()}
+	ipServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env.ipMu.Lock()
+		defer env.ipMu.Unlock()
+		w.Write([]byte(env.ip))
+	}))
+	t.Cleanup(ipServer.Close)
+
+	repo := repository.NewFirewallRepo(db)
+	env.config = NewConfigService(repository.NewConfigRepository(db, nil))
+	if err := env.config.SetConfig("ip_fetch_url", ipServer.URL, "string", "system", "IP查询地址"); err != nil {
+		t.Fatalf("failed to set ip_fetch_url: %v", err)
+	}
+	env.journal = repository.NewRuleOperationRepository(db)
+	env.service = NewFirewallService(repo, env.config)
+	env.service.SetRuleOperationRepository(env.journal)
+	env.service.SetSyncRunRepository(repository.NewSyncRunRepository(db))
+	env.guards = NewSafeguardService(repository.NewSafeguardRepository(db))
+	env.service.SetSafeguardService(env.guards)
+
+	// 每个测试使用独立的模拟账号，云端规则不会互相影响
+	cloudConfig := &model.CloudProviderConfig{
+		Provider:   cloud.ProviderFake,
+		SecretId:   env.account,
+		SecretKey:  "secret",
+		Region:     testRegion,
+		InstanceId: testInstance,
+		IsEnabled:  true,
+	}
+	if err := env.config.CreateCloudConfig(cloudConfig); err != nil {
+		t.Fatalf("failed to create cloud config: %v", err)
+	}
+	env.cloudID = cloudConfig.ID
+
+	env.rule = &model.FirewallRule{
+		CloudConfigID: env.cloudID,
+		Provider:      cloud.ProviderFake,
+		InstanceID:    testInstance,
+		Protocol:      "TCP",
+		Port:          "22",
+		Action:        "ACCEPT",
+		Remark:        "ssh",
+		Enabled:       true,
+	}
+	if err := repo.Create(env.rule); err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	return env
+}
+
+func (e *syncEnv) setIP(ip string) {
+	e.ipMu.Lock()
+	defer e.ipMu.Unlock()
+	e.ip = ip
+}
+
+// setFaults 修改云服务配置的故障注入
+func (e *syncEnv) setFaults(faults cloud.FakeFaults) {
+	e.t.Helper()
+	e.updateFakeConfig(func(fake *cloud.FakeConfig) { fake.Faults = faults })
+}
+
+// setInstances 修改模拟账号中除默认实例外的实例及其标签
+func (e *syncEnv) setInstances(instances ...cloud.FakeInstance) {
+	e.t.Helper()
+	e.updateFakeConfig(func(fake *cloud.FakeConfig) { fake.Instances = instances })
+}
+
+// updateFakeConfig 修改云服务配置的 Extra，配置更新后同步使用新的客户端
+func (e *syncEnv) updateFakeConfig(update func(fake *cloud.FakeConfig)) {
+	e.t.Helper()
+	config, err := e.config.GetCloudConfigByID(e.cloudID)
+	if err != nil {
+		e.t.Fatalf("failed to get cloud config: %v", err)
+	}
+	fake, err := cloud.ParseFakeConfig(config.Extra)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	update(fake)
+	extra, err := json.Marshal(fake)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	config.Extra = string(extra)
+	if err := e.config.UpdateCloudConfig(config); err != nil {
+		e.t.Fatalf("failed to update cloud config: %v", err)
+	}
+}
+
+func (e *syncEnv) sync() *SyncResult {
+	e.t.Helper()
+	result, err := e.service.UpdateAllRules(context.Background(), SystemActor)
+	if err != nil {
+		e.t.Fatalf("UpdateAllRules: %v", err)
+	}
+	return result
+}
+
+// cloudSources 实例上云端规则的来源
+func (e *syncEnv) cloudSources() []string {
+	e.t.Helper()
+	var sources []string
+	for _, r := range e.cloudRules(testInstance) {
+		sources = append(sources, r.CidrBlock)
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+// cloudRules 实例上的云端规则
+func (e *syncEnv) cloudRules(instanceID string) []*cloud.FirewallRuleResult {
+	e.t.Helper()
+	rules, err := cloud.NewFakeProvider(e.account, testRegion, &cloud.FakeConfig{}).ListFirewallRules(context.Background(), instanceID)
+	if err != nil {
+		e.t.Fatalf("failed to list cloud rules of %s: %v", instanceID, err)
+	}
+	return rules
+}
+
+// operations 规则的操作日志，按执行顺序
+func (e *syncEnv) operations() []model.RuleOperation {
+	e.t.Helper()
+	ops, err := e.journal.ListByRule(e.rule.ID, 0)
+	if err != nil {
+		e.t.Fatalf("failed to list operations: %v", err)
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+func (e *syncEnv) operationStates() []string {
+	var states []string
+	for _, op := range e.operations() {
+		states = append(states, op.State)
+	}
+	return states
+}
+
+func (e *syncEnv) storedRule() *model.FirewallRule {
+	e.t.Helper()
+	rule, err := e.service.GetRule(e.rule.ID)
+	if err != nil {
+		e.t.Fatalf("failed to get rule: %v", err)
+	}
+	return rule
+}
+
+func TestUpdateAllRulesCreatesThenDeletes(t *testing.T) {
+	env := newSyncEnv(t)
+
+	result := env.sync()
+	if result.Updated != 1 || result.Failed != 0 {
+		t.Fatalf("first sync: updated %d, failed %d", result.Updated, result.Failed)
+	}
+	if got, want := env.cloudSources(), []string{firstIP + "/32"}; !slices.Equal(got, want) {
+		t.Fatalf("cloud sources after first sync = %v, want %v", got, want)
+	}
+
+	env.setIP(secondIP)
+	result = env.sync()
+	if result.Updated != 1 || result.Failed != 0 {
+		t.Fatalf("second sync: updated %d, failed %d", result.Updated, result.Failed)
+	}
+	if got, want := env.cloudSources(), []string{secondIP + "/32"}; !slices.Equal(got, want) {
+		t.Fatalf("cloud sources after second sync = %v, want %v", got, want)
+	}
+
+	ops := env.operations()
+	if len(ops) != 2 {
+		t.Fatalf("got %d operations, want 2", len(ops))
+	}
+	// 首次同步只创建规则，第二次先建新规则再删除旧规则
+	if ops[0].State != model.RuleOpDone || ops[0].OldCidrBlock != "" || ops[0].NewCidrBlock != firstIP+"/32" {
+		t.Errorf("first operation = %s %q -> %q", ops[0].State, ops[0].OldCidrBlock, ops[0].NewCidrBlock)
+	}
+	if ops[1].State != model.RuleOpDone || ops[1].OldCidrBlock != firstIP+"/32" || ops[1].NewCidrBlock != secondIP+"/32" {
+		t.Errorf("second operation = %s %q -> %q", ops[1].State, ops[1].OldCidrBlock, ops[1].NewCidrBlock)
+	}
+	if ops[1].RunID != result.RunID {
+		t.Errorf("second operation run ID = %s, want %s", ops[1].RunID, result.RunID)
+	}
+	if rule := env.storedRule(); rule.LastIP != secondIP || rule.RuleID == "" {
+		t.Errorf("stored rule last IP %q, rule ID %q", rule.LastIP, rule.RuleID)
+	}
+}
+
+func TestUpdateAllRulesFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults cloud.FakeFaults
+		// 注入故障的同步之后
+		wantSources []string
+		wantStates  []string
+		wantLastIP  string
+		// 清除故障后再次同步
+		wantRecoveredStates []string
+	}{
+		{
+			name:                "list fails",
+			faults:              cloud.FakeFaults{Operations: []string{"ListFirewallRules"}},
+			wantSources:         []string{firstIP + "/32"},
+			wantStates:          []string{model.RuleOpDone},
+			wantLastIP:          firstIP,
+			wantRecoveredStates: []string{model.RuleOpDone, model.RuleOpDone},
+		},
+		{
+			// 新规则未创建，下次同步时回滚该操作并重新更新
+			name:                "create fails",
+			faults:              cloud.FakeFaults{Operations: []string{"CreateFirewallRule"}},
+			wantSources:         []string{firstIP + "/32"},
+			wantStates:          []string{model.RuleOpDone, model.RuleOpPendingCreate},
+			wantLastIP:          firstIP,
+			wantRecoveredStates: []string{model.RuleOpDone, model.RuleOpRolledBack, model.RuleOpDone},
+		},
+		{
+			// 超时但云端已创建，下次同步时继续删除旧规则
+			name:                "create times out after applying",
+			faults:              cloud.FakeFaults{Operations: []string{"CreateFirewallRule"}, ApplyBeforeError: true},
+			wantSources:         []string{firstIP + "/32", secondIP + "/32"},
+			wantStates:          []string{model.RuleOpDone, model.RuleOpPendingCreate},
+			wantLastIP:          firstIP,
+			wantRecoveredStates: []string{model.RuleOpDone, model.RuleOpDone},
+		},
+		{
+			name:                "delete fails",
+			faults:              cloud.FakeFaults{Operations: []string{"DeleteFirewallRule"}},
+			wantSources:         []string{firstIP + "/32", secondIP + "/32"},
+			wantStates:          []string{model.RuleOpDone, model.RuleOpPendingDelete},
+			wantLastIP:          secondIP,
+			wantRecoveredStates: []string{model.RuleOpDone, model.RuleOpDone},
+		},
+		{
+			// 旧规则已删除，恢复时不再删除
+			name:                "delete times out after applying",
+			faults:              cloud.FakeFaults{Operations: []string{"DeleteFirewallRule"}, ApplyBeforeError: true},
+			wantSources:         []string{secondIP + "/32"},
+			wantStates:          []string{model.RuleOpDone, model.RuleOpPendingDelete},
+			wantLastIP:          secondIP,
+			wantRecoveredStates: []string{model.RuleOpDone, model.RuleOpDone},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newSyncEnv(t)
+			env.sync()
+
+			faults := tt.faults
+			faults.ErrorRate = 1
+			env.setFaults(faults)
+			env.setIP(secondIP)
+			result := env.sync()
+			if result.Updated != 0 || result.Failed != 1 {
+				t.Fatalf("faulty sync: updated %d, failed %d", result.Updated, result.Failed)
+			}
+			if got := env.cloudSources(); !slices.Equal(got, tt.wantSources) {
+				t.Errorf("cloud sources after faulty sync = %v, want %v", got, tt.wantSources)
+			}
+			if got := env.operationStates(); !slices.Equal(got, tt.wantStates) {
+				t.Errorf("operation states after faulty sync = %v, want %v", got, tt.wantStates)
+			}
+			if ops := env.operations(); len(ops) > 1 && ops[len(ops)-1].Error == "" {
+				t.Errorf("unfinished operation has no error")
+			}
+			// 新规则创建前失败时保留上次生效的来源，创建后失败时新来源已生效
+			if rule := env.storedRule(); rule.LastIP != tt.wantLastIP {
+				t.Errorf("stored rule last IP after faulty sync = %q, want %q", rule.LastIP, tt.wantLastIP)
+			}
+
+			env.setFaults(cloud.FakeFaults{})
+			result = env.sync()
+			if result.Updated != 1 || result.Failed != 0 {
+				t.Fatalf("recovery sync: updated %d, failed %d", result.Updated, result.Failed)
+			}
+			if got, want := env.cloudSources(), []string{secondIP + "/32"}; !slices.Equal(got, want) {
+				t.Errorf("cloud sources after recovery = %v, want %v", got, want)
+			}
+			if got := env.operationStates(); !slices.Equal(got, tt.wantRecoveredStates) {
+				t.Errorf("operation states after recovery = %v, want %v", got, tt.wantRecoveredStates)
+			}
+			if rule := env.storedRule(); rule.LastIP != secondIP {
+				t.Errorf("stored rule last IP after recovery = %q, want %q", rule.LastIP, secondIP)
+			}
+		})
+	}
+}
+
+func TestRollbackAfterSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		rollback func(env *syncEnv, runID string) (*RollbackResult, error)
+		faults   *cloud.FakeFaults
+		// 回滚之后
+		wantErr     bool
+		wantPinned  bool
+		wantSources []string
+		wantStates  []string
+	}{
+		{
+			name: "rule",
+			rollback: func(env *syncEnv, _ string) (*RollbackResult, error) {
+				return env.service.RollbackRule(context.Background(), env.rule.ID, 0, SystemActor)
+			},
+			wantPinned:  true,
+			wantSources: []string{firstIP + "/32"},
+			wantStates:  []string{model.RuleOpDone, model.RuleOpDone, model.RuleOpDone},
+		},
+		{
+			name: "run",
+			rollback: func(env *syncEnv, runID string) (*RollbackResult, error) {
+				return env.service.RollbackRun(context.Background(), runID, SystemActor)
+			},
+			wantPinned:  true,
+			wantSources: []string{firstIP + "/32"},
+			wantStates:  []string{model.RuleOpDone, model.RuleOpDone, model.RuleOpDone},
+		},
+		{
+			// 恢复旧来源时新规则已创建，删除当前规则失败，操作保持 pending_delete 且规则不固定
+			name: "rule with delete failing",
+			rollback: func(env *syncEnv, _ string) (*RollbackResult, error) {
+				return env.service.RollbackRule(context.Background(), env.rule.ID, 0, SystemActor)
+			},
+			faults:      &cloud.FakeFaults{ErrorRate: 1, Operations: []string{"DeleteFirewallRule"}},
+			wantErr:     true,
+			wantSources: []string{firstIP + "/32", secondIP + "/32"},
+			wantStates:  []string{model.RuleOpDone, model.RuleOpDone, model.RuleOpPendingDelete},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newSyncEnv(t)
+			env.sync()
+			env.setIP(secondIP)
+			runID := env.sync().RunID
+			if tt.faults != nil {
+				env.setFaults(*tt.faults)
+			}
+
+			result, err := tt.rollback(env, runID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rollback error = %v, want error %v", err, tt.wantErr)
+			}
+			if result == nil || len(result.Rules) != 1 {
+				t.Fatalf("rollback result = %+v", result)
+			}
+			rollback := result.Rules[0]
+			if rollback.ToCidrBlock != firstIP+"/32" || rollback.Pinned != tt.wantPinned {
+				t.Errorf("rollback to %q, pinned %v", rollback.ToCidrBlock, rollback.Pinned)
+			}
+			if got := env.cloudSources(); !slices.Equal(got, tt.wantSources) {
+				t.Errorf("cloud sources after rollback = %v, want %v", got, tt.wantSources)
+			}
+			if got := env.operationStates(); !slices.Equal(got, tt.wantStates) {
+				t.Errorf("operation states after rollback = %v, want %v", got, tt.wantStates)
+			}
+			if rule := env.storedRule(); rule.Pinned != tt.wantPinned {
+				t.Errorf("stored rule pinned = %v, want %v", rule.Pinned, tt.wantPinned)
+			}
+			if !tt.wantPinned {
+				return
+			}
+
+			// 固定的规则不会被同步改回当前公网IP
+			sync := env.sync()
+			if sync.Pinned != 1 || sync.Updated != 0 {
+				t.Errorf("sync after rollback: pinned %d, updated %d", sync.Pinned, sync.Updated)
+			}
+			if got := env.cloudSources(); !slices.Equal(got, tt.wantSources) {
+				t.Errorf("cloud sources after sync = %v, want %v", got, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestRollbackFirstCreationHasNoTarget(t *testing.T) {
+	env := newSyncEnv(t)
+	env.sync()
+
+	// 首次创建之前没有来源可恢复
+	if _, err := env.service.RollbackRule(context.Background(), env.rule.ID, 0, SystemActor); !errors.Is(err, ErrNoRollbackTarget) {
+		t.Fatalf("RollbackRule error = %v, want ErrNoRollbackTarget", err)
+	}
+}
+
+func TestExecuteRuleUnpinsOnlyAfterUpdate(t *testing.T) {
+	env := newSyncEnv(t)
+	env.sync()
+	env.setIP(secondIP)
+	env.sync()
+	if _, err := env.service.RollbackRule(context.Background(), env.rule.ID, 0, SystemActor); err != nil {
+		t.Fatalf("RollbackRule: %v", err)
+	}
+
+	// 更新失败时保持固定，下一次同步不会把回滚后的来源改回当前公网IP
+	env.setFaults(cloud.FakeFaults{ErrorRate: 1, Operations: []string{"CreateFirewallRule"}})
+	if err := env.service.ExecuteRule(context.Background(), env.rule.ID, SystemActor); err == nil {
+		t.Fatal("ExecuteRule succeeded with create failing")
+	}
+	if !env.storedRule().Pinned {
+		t.Fatal("failed execution unpinned the rule")
+	}
+	env.setFaults(cloud.FakeFaults{})
+	if sync := env.sync(); sync.Pinned != 1 {
+		t.Errorf("sync after failed execution: pinned %d", sync.Pinned)
+	}
+	if got, want := env.cloudSources(), []string{firstIP + "/32"}; !slices.Equal(got, want) {
+		t.Errorf("cloud sources after failed execution = %v, want %v", got, want)
+	}
+
+	if err := env.service.ExecuteRule(context.Background(), env.rule.ID, SystemActor); err != nil {
+		t.Fatalf("ExecuteRule: %v", err)
+	}
+	if env.storedRule().Pinned {
+		t.Error("successful execution left the rule pinned")
+	}
+	if got, want := env.cloudSources(), []string{secondIP + "/32"}; !slices.Equal(got, want) {
+		t.Errorf("cloud sources after execution = %v, want %v", got, want)
+	}
+}
+
+// 同步运行期间不能回滚规则，否则同步已读取的未固定规则会覆盖回滚结果
+func TestRollbackRuleExcludesFullSync(t *testing.T) {
+	env := newSyncEnv(t)
+	env.sync()
+	env.setIP(secondIP)
+	env.sync()
+
+	info, _, err := env.service.runs.BeginFullSync(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.service.RollbackRule(context.Background(), env.rule.ID, 0, SystemActor)
+	env.service.runs.EndFullSync(info.RunID)
+	var inProgress *RunInProgressError
+	if !errors.As(err, &inProgress) {
+		t.Fatalf("RollbackRule during a sync: %v, want RunInProgressError", err)
+	}
+	if env.storedRule().Pinned {
+		t.Error("rule pinned although the rollback was refused")
+	}
+	if got, want := env.cloudSources(), []string{secondIP + "/32"}; !slices.Equal(got, want) {
+		t.Errorf("cloud sources = %v, want %v", got, want)
+	}
+}
+
+// 等待实例锁时同步结束，实例上的规则计为失败，而不是既不算更新也不算失败
+func TestUpdateAllRulesCountsRulesWhenInstanceLockFails(t *testing.T) {
+	env := newSyncEnv(t)
+	unlock, err := env.service.runs.LockInstance(context.Background(), cloud.ProviderFake, testInstance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err := env.service.UpdateAllRules(ctx, SystemActor)
+	if err == nil {
+		t.Fatal("UpdateAllRules succeeded while the instance was locked")
+	}
+	if result == nil || result.Updated != 0 || result.Failed != 1 || result.Skipped != 0 {
+		t.Fatalf("result = %+v, want the rule counted as failed", result)
+	}
+	if got := env.cloudSources(); len(got) != 0 {
+		t.Errorf("cloud sources = %v, want none", got)
+	}
+}
+
+// 多实例规则的云端规则ID和来源按实例保存；实例不再匹配标签选择器后，
+// 其上的云端规则被删除，未完成的操作被放弃
+func TestMultiInstanceRuleTargets(t *testing.T) {
+	env := newSyncEnv(t)
+	web := map[string]string{"role": "web"}
+	env.setInstances(
+		cloud.FakeInstance{InstanceID: "lhins-web1", Tags: web},
+		cloud.FakeInstance{InstanceID: "lhins-web2", Tags: web},
+	)
+	env.rule.InstanceSelector = "role=web"
+	if err := env.service.repo.Update(env.rule); err != nil {
+		t.Fatalf("failed to update rule: %v", err)
+	}
+
+	result := env.sync()
+	if result.Updated != 3 || result.Failed != 0 {
+		t.Fatalf("first sync: updated %d, failed %d", result.Updated, result.Failed)
+	}
+	rule := env.storedRule()
+	if len(rule.Targets) != 3 {
+		t.Fatalf("got %d targets, want 3: %+v", len(rule.Targets), rule.Targets)
+	}
+	for _, target := range rule.Targets {
+		cloudRules := env.cloudRules(target.InstanceID)
+		if len(cloudRules) != 1 {
+			t.Fatalf("instance %s has %d cloud rules, want 1", target.InstanceID, len(cloudRules))
+		}
+		if target.CloudRuleID != cloudRules[0].RuleID || target.LastIP != firstIP {
+			t.Errorf("target %s: cloud rule ID %q, last IP %q; want %q, %q",
+				target.InstanceID, target.CloudRuleID, target.LastIP, cloudRules[0].RuleID, firstIP)
+		}
+	}
+	// 各实例的状态不写入共享的规则记录
+	if rule.RuleID != "" {
+		t.Errorf("shared rule ID = %q, want empty", rule.RuleID)
+	}
+
+	// lhins-web2 上留有一个中断的更新
+	interrupted := &model.RuleOperation{
+		RuleID:        env.rule.ID,
+		CloudConfigID: env.cloudID,
+		Provider:      cloud.ProviderFake,
+		InstanceID:    "lhins-web2",
+		Protocol:      "TCP",
+		Port:          "22",
+		Action:        "ACCEPT",
+		Description:   "ssh",
+		NewCidrBlock:  secondIP + "/32",
+		State:         model.RuleOpPendingCreate,
+	}
+	if err := env.journal.Create(interrupted); err != nil {
+		t.Fatalf("failed to create operation: %v", err)
+	}
+
+	// lhins-web2 不再匹配标签选择器
+	env.setInstances(
+		cloud.FakeInstance{InstanceID: "lhins-web1", Tags: web},
+		cloud.FakeInstance{InstanceID: "lhins-web2", Tags: map[string]string{"role": "db"}},
+	)
+	result = env.sync()
+	if result.Updated != 2 || result.Failed != 0 {
+		t.Fatalf("second sync: updated %d, failed %d", result.Updated, result.Failed)
+	}
+	if got := env.cloudRules("lhins-web2"); len(got) != 0 {
+		t.Errorf("instance no longer targeted still has %d cloud rules", len(got))
+	}
+	if got := env.cloudRules("lhins-web1"); len(got) != 1 {
+		t.Errorf("lhins-web1 has %d cloud rules, want 1", len(got))
+	}
+	var instances []string
+	for _, target := range env.storedRule().Targets {
+		instances = append(instances, target.InstanceID)
+	}
+	slices.Sort(instances)
+	if want := []string{testInstance, "lhins-web1"}; !slices.Equal(instances, want) {
+		t.Errorf("targets = %v, want %v", instances, want)
+	}
+	op, err := env.journal.GetByID(interrupted.ID)
+	if err != nil {
+		t.Fatalf("failed to get operation: %v", err)
+	}
+	if op.State != model.RuleOpAbandoned {
+		t.Errorf("operation on instance no longer targeted is %s, want %s", op.State, model.RuleOpAbandoned)
+	}
+}
+
+// 防锁死保护回滚的规则被固定，之后的同步不再重复修改再回滚
+func TestLockoutRollbackPinsRule(t *testing.T) {
+	env := newSyncEnv(t)
+	if err := env.guards.CreateSafeguard(&model.InstanceSafeguard{
+		CloudConfigID: env.cloudID,
+		InstanceID:    testInstance,
+		CriticalPorts: "22",
+	}); err != nil {
+		t.Fatalf("failed to create safeguard: %v", err)
+	}
+	if result := env.sync(); result.Updated != 1 || result.Failed != 0 {
+		t.Fatalf("first sync: updated %d, failed %d", result.Updated, result.Failed)
+	}
+
+	// 改为DROP后22端口不再有ACCEPT规则放行
+	env.rule.Action = "DROP"
+	if err := env.service.repo.Update(env.rule); err != nil {
+		t.Fatalf("failed to update rule: %v", err)
+	}
+	if result := env.sync(); result.Updated != 0 || result.Failed != 1 {
+		t.Fatalf("second sync: updated %d, failed %d", result.Updated, result.Failed)
+	}
+	if !env.storedRule().Pinned {
+		t.Fatal("rule rolled back by the safeguard is not pinned")
+	}
+	assertAccept := func(when string) {
+		t.Helper()
+		rules := env.cloudRules(testInstance)
+		if len(rules) != 1 || rules[0].Action != "ACCEPT" || rules[0].CidrBlock != firstIP+"/32" {
+			t.Fatalf("cloud rules %s = %+v, want one ACCEPT from %s", when, rules, firstIP)
+		}
+	}
+	assertAccept("after rollback")
+	ops := len(env.operations())
+
+	result := env.sync()
+	if result.Pinned != 1 || result.Updated != 0 || result.Failed != 0 {
+		t.Fatalf("third sync: pinned %d, updated %d, failed %d", result.Pinned, result.Updated, result.Failed)
+	}
+	assertAccept("after the next sync")
+	if got := len(env.operations()); got != ops {
+		t.Errorf("pinned rule was changed again: %d operations, want %d", got, ops)
+	}
+}
+
+// 实例规则数上限的检查包括云端不由 FireFlow 管理的规则，已同步的规则不重复计数
+func TestValidateRuleCountsUnmanagedCloudRules(t *testing.T) {
+	env := newSyncEnv(t)
+	if result := env.sync(); result.Updated != 1 {
+		t.Fatalf("sync updated %d rules, want 1", result.Updated)
+	}
+
+	// 控制台创建的规则：加上已同步的规则共99条
+	console := cloud.NewFakeProvider(env.account, testRegion, &cloud.FakeConfig{})
+	addConsoleRule := func(port int) {
+		t.Helper()
+		_, err := console.CreateFirewallRule(context.Background(), testInstance, &cloud.FirewallRuleSpec{
+			Protocol:    "TCP",
+			Port:        fmt.Sprint(port),
+			CidrBlock:   "10.0.0.1/32",
+			Action:      "ACCEPT",
+			Description: "console",
+		})
+		if err != nil {
+			t.Fatalf("failed to create console rule: %v", err)
+		}
+	}
+	for port := 1000; port < 1098; port++ {
+		addConsoleRule(port)
+	}
+
+	newRule := func() *model.FirewallRule {
+		return &model.FirewallRule{CloudConfigID: env.cloudID, InstanceID: testInstance, Protocol: "TCP", Port: "443", Remark: "https"}
+	}
+	if err := env.service.ValidateRule(newRule()); err != nil {
+		t.Fatalf("rule within the limit rejected: %v", err)
+	}
+
+	addConsoleRule(1098)
+	err := env.service.ValidateRule(newRule())
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ValidateRule at the limit = %v, want a validation error", err)
+	}
+	if len(verr.Fields) != 1 || verr.Fields[0].Field != "instance_id" {
+		t.Fatalf("validation fields = %+v, want instance_id", verr.Fields)
+	}
+	if want := "100 rules (99 not managed by FireFlow)"; !strings.Contains(verr.Fields[0].Message, want) {
+		t.Errorf("message %q does not mention %q", verr.Fields[0].Message, want)
+	}
+}
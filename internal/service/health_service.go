@@ -0,0 +1,232 @@
+package service
+
+import (
+	"FireFlow/internal/core"
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 健康检查状态
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthSkipped  = "skipped" // 未启用或无需检查
+)
+
+// 数据库检查超时时间
+const databaseCheckTimeout = 2 * time.Second
+
+// 未配置最大同步间隔时，允许的同步间隔为定时任务周期的倍数
+const syncAgeIntervalFactor = 3
+
+// HealthCheck 单项检查结果
+type HealthCheck struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// HealthReport 就绪检查结果，任一检查降级时整体为降级
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Time   time.Time              `json:"time"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// HealthOptions 就绪检查选项
+type HealthOptions struct {
+	MaxSyncAge            time.Duration // 上次成功同步的最大间隔，0表示按定时任务周期推算
+	CheckCredentials      bool          // 是否检查各云服务配置的凭证有效性
+	CredentialCheckPeriod time.Duration // 凭证检查结果的缓存时间，避免探针频繁调用云服务API
+}
+
+// Pinger 数据库连接检查，*sql.DB 实现了该接口
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+type HealthService interface {
+	// Uptime 返回进程已运行时间
+	Uptime() time.Duration
+	// Ready 检查数据库连接、定时任务、上次成功同步时间及（可选）云服务凭证
+	Ready(ctx context.Context) *HealthReport
+}
+
+type healthService struct {
+	db              Pinger
+	configService   ConfigService
+	cronManager     *core.CronManager
+	firewallService *FirewallService
+	options         HealthOptions
+	startedAt       time.Time
+
+	credentialsMu      sync.Mutex
+	credentials        HealthCheck
+	credentialsChecked time.Time
+}
+
+func NewHealthService(db Pinger, configService ConfigService, cronManager *core.CronManager, firewallService *FirewallService, options HealthOptions) HealthService {
+	if options.CredentialCheckPeriod <= 0 {
+		options.CredentialCheckPeriod = 10 * time.Minute
+	}
+	return &healthService{
+		db:              db,
+		configService:   configService,
+		cronManager:     cronManager,
+		firewallService: firewallService,
+		options:         options,
+		startedAt:       time.Now(),
+	}
+}
+
+func (s *healthService) Uptime() time.Duration {
+	return time.Since(s.startedAt)
+}
+
+func (s *healthService) Ready(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		Status: HealthOK,
+		Time:   time.Now(),
+		Checks: map[string]HealthCheck{
+			"database":    s.checkDatabase(ctx),
+			"cron":        s.checkCron(),
+			"last_sync":   s.checkLastSync(),
+			"credentials": s.checkCredentials(ctx),
+		},
+	}
+	for _, check := range report.Checks {
+		if check.Status == HealthDegraded {
+			report.Status = HealthDegraded
+			break
+		}
+	}
+	return report
+}
+
+func (s *healthService) checkDatabase(ctx context.Context) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, databaseCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.db.PingContext(ctx); err != nil {
+		return HealthCheck{Status: HealthDegraded, Message: err.Error()}
+	}
+	return HealthCheck{
+		Status:  HealthOK,
+		Details: map[string]any{"latency_ms": time.Since(start).Milliseconds()},
+	}
+}
+
+// checkCron 系统设置启用定时同步时，要求防火墙更新任务已在定时任务引擎中运行
+func (s *healthService) checkCron() HealthCheck {
+	enabled, interval := s.cronSettings()
+	details := map[string]any{
+		"enabled":          enabled,
+		"running":          s.cronManager.IsRunning(),
+		"interval_minutes": interval,
+	}
+	if !enabled {
+		return HealthCheck{Status: HealthSkipped, Message: "定时同步未启用", Details: details}
+	}
+	if !s.cronManager.IsRunning() {
+		return HealthCheck{Status: HealthDegraded, Message: "定时同步已启用但任务未运行", Details: details}
+	}
+	return HealthCheck{Status: HealthOK, Details: details}
+}
+
+// checkLastSync 检查上次全部规则同步成功距今的时间，从未成功时按进程运行时间计算
+func (s *healthService) checkLastSync() HealthCheck {
+	maxAge := s.maxSyncAge()
+	details := map[string]any{}
+	if maxAge > 0 {
+		details["max_age_seconds"] = int64(maxAge.Seconds())
+	}
+
+	last, ok := s.firewallService.LastSuccessfulSync()
+	since := s.startedAt
+	if ok {
+		details["last_success"] = last
+		details["age_seconds"] = int64(time.Since(last).Seconds())
+		since = last
+	}
+
+	if maxAge <= 0 {
+		return HealthCheck{Status: HealthSkipped, Message: "未启用定时同步且未配置最大同步间隔", Details: details}
+	}
+	if time.Since(since) > maxAge {
+		message := fmt.Sprintf("超过 %s 没有成功完成同步", maxAge)
+		if !ok {
+			message = fmt.Sprintf("启动后 %s 内没有成功完成同步", maxAge)
+		}
+		return HealthCheck{Status: HealthDegraded, Message: message, Details: details}
+	}
+	return HealthCheck{Status: HealthOK, Details: details}
+}
+
+// maxSyncAge 优先使用配置的最大同步间隔，否则在启用定时同步时按任务周期推算
+func (s *healthService) maxSyncAge() time.Duration {
+	if s.options.MaxSyncAge > 0 {
+		return s.options.MaxSyncAge
+	}
+	enabled, interval := s.cronSettings()
+	if !enabled || interval <= 0 {
+		return 0
+	}
+	return time.Duration(interval*syncAgeIntervalFactor) * time.Minute
+}
+
+// cronSettings 读取系统设置中的定时同步开关和周期（分钟）
+func (s *healthService) cronSettings() (bool, int) {
+	enabled, _ := s.configService.GetConfigBool("cron_enabled")
+	interval, _ := s.configService.GetConfigInt("ip_check_interval")
+	return enabled, interval
+}
+
+// checkCredentials 检查所有启用的云服务配置的凭证，结果在 CredentialCheckPeriod 内复用
+func (s *healthService) checkCredentials(ctx context.Context) HealthCheck {
+	if !s.options.CheckCredentials {
+		return HealthCheck{Status: HealthSkipped, Message: "未启用凭证检查"}
+	}
+
+	s.credentialsMu.Lock()
+	defer s.credentialsMu.Unlock()
+
+	if !s.credentialsChecked.IsZero() && time.Since(s.credentialsChecked) < s.options.CredentialCheckPeriod {
+		return s.credentials
+	}
+
+	check := s.verifyCredentials(ctx)
+	if ctx.Err() != nil {
+		// 请求已取消，检查结果不完整，不缓存
+		return check
+	}
+	s.credentials = check
+	s.credentialsChecked = time.Now()
+	return s.credentials
+}
+
+func (s *healthService) verifyCredentials(ctx context.Context) HealthCheck {
+	configs, err := s.configService.ListCloudConfigs()
+	if err != nil {
+		return HealthCheck{Status: HealthDegraded, Message: err.Error()}
+	}
+
+	check := HealthCheck{Status: HealthOK, Details: map[string]any{"checked_at": time.Now()}}
+	results := make([]map[string]any, 0, len(configs))
+	for _, config := range configs {
+		result := map[string]any{"id": config.ID, "description": config.Description, "provider": config.Provider, "status": HealthOK}
+		// 只有凭证错误视为降级，实例不存在等其他错误不影响凭证有效性
+		if _, err := s.configService.TestCloudConfig(ctx, config.ID); err != nil && cloud.IsCredentialError(err) {
+			result["status"] = HealthDegraded
+			result["error"] = err.Error()
+			check.Status = HealthDegraded
+			check.Message = "部分云服务配置的凭证无效"
+		}
+		results = append(results, result)
+	}
+	check.Details["configs"] = results
+	return check
+}
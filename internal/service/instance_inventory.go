@@ -0,0 +1,179 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 实例清单的缓存时间，超过后下次查看时重新查询
+const inventoryCacheTTL = 30 * time.Minute
+
+// 刷新实例清单时同时查询的区域数
+const inventoryConcurrency = 4
+
+// InventoryInstance 实例清单中的实例
+type InventoryInstance struct {
+	cloud.InstanceInfo
+	CloudConfigID uint `json:"cloud_config_id"`
+	// Managed 是否已登记为该配置下的实例
+	Managed bool `json:"managed"`
+	// FirewallRuleCount 云端防火墙规则数，不支持管理防火墙或查询失败时为null
+	FirewallRuleCount *int   `json:"firewall_rule_count"`
+	FirewallRuleError string `json:"firewall_rule_error,omitempty"`
+}
+
+// Inventory 云服务配置的实例清单，个别区域查询失败不影响其他区域
+type Inventory struct {
+	CloudConfigID uint                `json:"cloud_config_id"`
+	Regions       []string            `json:"regions"`
+	Instances     []InventoryInstance `json:"instances"`
+	Errors        map[string]string   `json:"errors,omitempty"` // 区域 -> 失败原因
+	RefreshedAt   time.Time           `json:"refreshed_at"`
+
+	configUpdatedAt time.Time // 查询时配置的更新时间，配置修改后缓存失效
+}
+
+func (s *cloudInstanceService) Inventory(ctx context.Context, cloudConfigID uint, refresh bool) (*Inventory, error) {
+	config, err := s.configService.GetCloudConfigByID(cloudConfigID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cloud config: %v", err)
+	}
+
+	inventory := s.cachedInventory(cloudConfigID, config.UpdatedAt)
+	if inventory == nil || refresh {
+		s.refreshMu.Lock()
+		defer s.refreshMu.Unlock()
+		// 等待期间其他请求可能已经查询过
+		if inventory = s.cachedInventory(cloudConfigID, config.UpdatedAt); inventory == nil || refresh {
+			if inventory, err = s.loadInventory(ctx, config); err != nil {
+				return nil, err
+			}
+			inventory.configUpdatedAt = config.UpdatedAt
+
+			s.inventoryMu.Lock()
+			s.inventories[cloudConfigID] = inventory
+			s.inventoryMu.Unlock()
+		}
+	}
+
+	return s.markManaged(inventory)
+}
+
+func (s *cloudInstanceService) InvalidateInventory(cloudConfigID uint) {
+	s.inventoryMu.Lock()
+	defer s.inventoryMu.Unlock()
+	delete(s.inventories, cloudConfigID)
+}
+
+// cachedInventory 返回未过期且配置未修改的缓存清单，过期的清单从缓存中移除
+func (s *cloudInstanceService) cachedInventory(cloudConfigID uint, configUpdatedAt time.Time) *Inventory {
+	s.inventoryMu.Lock()
+	defer s.inventoryMu.Unlock()
+	inventory, ok := s.inventories[cloudConfigID]
+	if !ok {
+		return nil
+	}
+	if !inventory.configUpdatedAt.Equal(configUpdatedAt) || time.Since(inventory.RefreshedAt) > inventoryCacheTTL {
+		delete(s.inventories, cloudConfigID)
+		return nil
+	}
+	return inventory
+}
+
+// markManaged 返回清单的副本，按当前的实例登记标记已管理的实例
+func (s *cloudInstanceService) markManaged(inventory *Inventory) (*Inventory, error) {
+	managed, err := s.repo.List(inventory.CloudConfigID)
+	if err != nil {
+		return nil, err
+	}
+	managedIDs := make(map[string]bool, len(managed))
+	for _, instance := range managed {
+		managedIDs[instance.InstanceID] = true
+	}
+
+	result := *inventory
+	result.Instances = make([]InventoryInstance, len(inventory.Instances))
+	for i, instance := range inventory.Instances {
+		instance.Managed = managedIDs[instance.InstanceID]
+		result.Instances[i] = instance
+	}
+	return &result, nil
+}
+
+// loadInventory 查询配置的凭证在所有区域下的实例及其防火墙规则数
+func (s *cloudInstanceService) loadInventory(ctx context.Context, config *model.CloudProviderConfig) (*Inventory, error) {
+	cloudConfigID := config.ID
+	provider, err := newCloudProvider(config, "")
+	if err != nil {
+		return nil, err
+	}
+	regions, err := provider.ListRegions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &Inventory{CloudConfigID: cloudConfigID, Regions: regions, Instances: []InventoryInstance{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, inventoryConcurrency)
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			provider, err := newCloudProvider(config, region)
+			var instances []InventoryInstance
+			if err == nil {
+				instances, err = regionInventory(ctx, provider, cloudConfigID)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			inventory.Instances = append(inventory.Instances, instances...)
+			if err != nil {
+				if inventory.Errors == nil {
+					inventory.Errors = make(map[string]string)
+				}
+				inventory.Errors[region] = err.Error()
+			}
+		}(region)
+	}
+	wg.Wait()
+
+	sort.Slice(inventory.Instances, func(i, j int) bool {
+		a, b := inventory.Instances[i], inventory.Instances[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.InstanceID < b.InstanceID
+	})
+	inventory.RefreshedAt = time.Now()
+	return inventory, nil
+}
+
+// regionInventory 列出区域下的实例并统计各实例的云端防火墙规则数
+func regionInventory(ctx context.Context, provider cloud.CloudProvider, cloudConfigID uint) ([]InventoryInstance, error) {
+	instances, err := provider.ListAllInstances(ctx)
+	result := make([]InventoryInstance, 0, len(instances))
+	for _, instance := range instances {
+		item := InventoryInstance{InstanceInfo: *instance, CloudConfigID: cloudConfigID}
+		if instance.FirewallSupported {
+			rules, err := provider.ListFirewallRules(ctx, instance.InstanceID)
+			if err != nil {
+				item.FirewallRuleError = err.Error()
+			} else {
+				count := len(rules)
+				item.FirewallRuleCount = &count
+			}
+		}
+		result = append(result, item)
+	}
+	return result, err
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+)
+
+// SetCloudInstanceService 设置云服务配置下的实例登记，用于确定实例所在区域
+func (s *FirewallService) SetCloudInstanceService(instances CloudInstanceService) {
+	s.instances = instances
+}
+
+// instanceProvider 获取实例所在区域的云服务客户端。区域依次取自实例登记、
+// 按标签选择实例时查询到的区域，都没有时使用配置的默认区域。
+func (s *FirewallService) instanceProvider(providerName string, cloudConfigID uint, instanceID string) (cloud.CloudProvider, error) {
+	region, err := s.instanceRegion(providerName, cloudConfigID, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.regionProvider(providerName, cloudConfigID, region)
+}
+
+// instanceRegion 返回实例所在区域，未知时返回空字符串
+func (s *FirewallService) instanceRegion(providerName string, cloudConfigID uint, instanceID string) (string, error) {
+	if cloudConfigID == 0 {
+		return "", nil
+	}
+	if s.instances != nil {
+		region, err := s.instances.InstanceRegion(cloudConfigID, instanceID)
+		if err != nil || region != "" {
+			return region, err
+		}
+	}
+
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	return s.discoveredRegions[instanceKey{cloudConfigID: cloudConfigID, provider: providerName, instanceID: instanceID}], nil
+}
+
+// accountRegions 返回按标签选择实例时需要查询的区域：配置的默认区域（空字符串）
+// 和已登记实例所在的其他区域
+func (s *FirewallService) accountRegions(cloudConfigID uint) ([]string, error) {
+	regions := []string{""}
+	if cloudConfigID == 0 || s.instances == nil || s.configService == nil {
+		return regions, nil
+	}
+	cloudConfig, err := s.configService.GetCloudConfigByID(cloudConfigID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cloud config: %v", err)
+	}
+	instances, err := s.instances.ListInstances(cloudConfigID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud instances: %v", err)
+	}
+
+	seen := map[string]bool{"": true, cloudConfig.Region: true}
+	for _, instance := range instances {
+		if !seen[instance.Region] {
+			seen[instance.Region] = true
+			regions = append(regions, instance.Region)
+		}
+	}
+	return regions, nil
+}
+
+// listAccountInstances 列出配置在各个区域下的实例，并记住各实例所在区域
+func (s *FirewallService) listAccountInstances(ctx context.Context, providerName string, cloudConfigID uint) ([]*cloud.InstanceInfo, error) {
+	regions, err := s.accountRegions(cloudConfigID)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*cloud.InstanceInfo
+	for _, region := range regions {
+		provider, err := s.regionProvider(providerName, cloudConfigID, region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cloud client: %v", err)
+		}
+		instances, err := provider.ListInstances(ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, instances...)
+	}
+
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	for _, instance := range all {
+		key := instanceKey{cloudConfigID: cloudConfigID, provider: providerName, instanceID: instance.InstanceID}
+		s.discoveredRegions[key] = instance.Region
+	}
+	return all, nil
+}
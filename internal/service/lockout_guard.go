@@ -0,0 +1,126 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SetSafeguardService 设置实例防锁死保护配置，未设置时不做保护
+func (s *FirewallService) SetSafeguardService(safeguards SafeguardService) {
+	s.safeguards = safeguards
+}
+
+// instanceGuard 返回实例的保护配置，未配置保护时返回nil
+func (s *FirewallService) instanceGuard(cloudConfigID uint, instanceID string) (*InstanceGuard, error) {
+	if s.safeguards == nil {
+		return nil, nil
+	}
+	return s.safeguards.Guard(cloudConfigID, instanceID)
+}
+
+// verifyCriticalPorts 实例规则变更后重新查询云端规则。变更前有ACCEPT规则放行的关键端口
+// 在变更后不再放行时，回滚本次任务在该实例上的变更，返回 ErrLockoutPrevented 和被回滚的规则ID。
+// 调用方需持有实例锁。
+func (s *FirewallService) verifyCriticalPorts(ctx context.Context, provider cloud.CloudProvider, key instanceKey, guard *InstanceGuard, before []*cloud.FirewallRuleResult, actor Actor, runID string) ([]uint, error) {
+	if !guard.hasCriticalPorts() || s.journal == nil {
+		return nil, nil
+	}
+	logger := runLogger(actor, runID).With("provider", key.provider, "instance_id", key.instanceID)
+
+	ops, err := s.journal.ListAppliedByRun(runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load operation journal: %v", err)
+	}
+	var changes []model.RuleOperation
+	for _, op := range ops {
+		if op.CloudConfigID == key.cloudConfigID && op.Provider == key.provider && op.InstanceID == key.instanceID {
+			changes = append(changes, op)
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	// 变更已经生效，即使任务被取消也要完成验证
+	ctx = context.WithoutCancel(ctx)
+	current, err := provider.ListFirewallRules(ctx, key.instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify critical ports: %v", err)
+	}
+
+	// 变更前就没有放行的端口不由本次变更负责
+	previouslyMissing := make(map[criticalPort]bool)
+	for _, p := range guard.uncoveredPorts(before) {
+		previouslyMissing[p] = true
+	}
+	var lost []string
+	for _, p := range guard.uncoveredPorts(current) {
+		if !previouslyMissing[p] {
+			lost = append(lost, p.String())
+		}
+	}
+	if len(lost) == 0 {
+		return nil, nil
+	}
+
+	logger.Error("critical ports lost ACCEPT rules, rolling back changes on instance", "ports", lost, "changes", len(changes))
+	rolledBack := s.revertInstanceChanges(ctx, provider, guard, changes, actor, runID)
+	err = fmt.Errorf("%w: no ACCEPT rule left on %s of instance %s, rolled back %d rule(s)", ErrLockoutPrevented, strings.Join(lost, ","), key.instanceID, len(rolledBack))
+	s.record(actor, AuditEntry{
+		Action:     "safeguard.rollback",
+		EntityType: "instance",
+		EntityID:   key.instanceID,
+		RunID:      runID,
+		After:      map[string]any{"cloud_config_id": key.cloudConfigID, "lost_ports": lost, "rolled_back_rules": rolledBack},
+		Err:        err,
+	})
+	return rolledBack, err
+}
+
+// revertInstanceChanges 将本次任务在实例上修改过的规则恢复为第一次修改前的来源和动作，后修改的先恢复。
+// 恢复成功的规则被固定，之后的同步跳过这些规则，不会每次同步都修改后再回滚，直到运维人员处理后手动执行。
+// 返回恢复成功的规则ID。
+func (s *FirewallService) revertInstanceChanges(ctx context.Context, provider cloud.CloudProvider, guard *InstanceGuard, changes []model.RuleOperation, actor Actor, runID string) []uint {
+	logger := runLogger(actor, runID)
+
+	var order []*model.RuleOperation
+	seen := make(map[uint]bool)
+	for i := range changes {
+		if !seen[changes[i].RuleID] {
+			seen[changes[i].RuleID] = true
+			order = append(order, &changes[i])
+		}
+	}
+
+	var reverted []uint
+	for i := len(order) - 1; i >= 0; i-- {
+		op := order[i]
+		rule, err := s.repo.GetByID(op.RuleID)
+		if err != nil {
+			logger.Error("failed to load rule to roll back", "rule_id", op.RuleID, "error", err)
+			continue
+		}
+		// 多实例规则只恢复该实例
+		rule.CloudConfigID = op.CloudConfigID
+		rule.Provider = op.Provider
+		rule.InstanceID = op.InstanceID
+		rule.Action = op.PreviousAction()
+		existing, err := provider.ListFirewallRules(ctx, op.InstanceID)
+		if err == nil {
+			err = s.applyRuleSource(ctx, provider, guard, rule, op.OldCidrBlock, existing, actor, runID)
+		}
+		if err != nil {
+			logger.Error("failed to roll back rule", "rule_id", rule.ID, "cidr_block", op.OldCidrBlock, "error", err)
+			continue
+		}
+		logger.Info("rolled back rule", "rule_id", rule.ID, "cidr_block", op.OldCidrBlock)
+		reverted = append(reverted, rule.ID)
+		if err := s.repo.SetPinned(rule.ID, true); err != nil {
+			logger.Error("failed to pin rolled back rule", "rule_id", rule.ID, "error", err)
+		}
+	}
+	return reverted
+}
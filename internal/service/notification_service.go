@@ -0,0 +1,313 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/internal/secret"
+	"FireFlow/pkg/notify"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// 同一事件（如同一规则持续失败）在冷却时间内只通知一次
+const notifyCooldown = 30 * time.Minute
+
+// 各事件的默认通知模板，模板数据为事件字段以及 event、time
+var defaultNotificationTemplates = map[string][2]string{
+	model.EventIPChanged: {
+		"[FireFlow] 公网IP已变更",
+		"公网IP由 {{.old_ip}} 变更为 {{.new_ip}}，正在同步防火墙规则。\n时间：{{.time}}",
+	},
+	model.EventRuleFailed: {
+		"[FireFlow] 规则更新失败：{{.remark}}",
+		"规则 #{{.rule_id}}（{{.remark}}，{{.protocol}}:{{.port}}）在实例 {{.instance_id}} 上更新失败：{{.error}}\n时间：{{.time}}",
+	},
+	model.EventInstanceUnreachable: {
+		"[FireFlow] 无法访问实例 {{.instance_id}}",
+		"无法访问 {{.provider}} 实例 {{.instance_id}}，该实例上的规则未更新：{{.error}}\n时间：{{.time}}",
+	},
+	model.EventCredentialInvalid: {
+		"[FireFlow] 云服务凭证无效",
+		"云服务配置 #{{.cloud_config_id}}（{{.provider}}）的访问密钥无效或权限不足：{{.error}}\n时间：{{.time}}",
+	},
+	model.EventTest: {
+		"[FireFlow] 测试通知",
+		"这是一条来自 FireFlow 的测试通知，收到说明渠道「{{.channel}}」配置正确。\n时间：{{.time}}",
+	},
+}
+
+// NotificationEvent 待通知的事件
+type NotificationEvent struct {
+	Type string
+	Data map[string]any
+	// DedupKey 非空时，同一事件类型和键在冷却时间内只通知一次
+	DedupKey string
+}
+
+// NotificationTypeInfo 前端展示的渠道类型和可订阅事件
+type NotificationTypeInfo struct {
+	Types        []string `json:"types"`
+	Events       []string `json:"events"`
+	SecretFields []string `json:"secret_fields"`
+}
+
+type NotificationService interface {
+	// Notify 异步发送事件通知到所有订阅该事件的渠道
+	Notify(event NotificationEvent)
+	// SendTest 立即向指定渠道发送测试通知
+	SendTest(id uint) error
+
+	ListChannels() ([]model.NotificationChannel, error)
+	GetChannel(id uint) (*model.NotificationChannel, error)
+	CreateChannel(channel *model.NotificationChannel) error
+	UpdateChannel(channel *model.NotificationChannel) error
+	DeleteChannel(id uint) error
+	TypeInfo() NotificationTypeInfo
+}
+
+type notificationService struct {
+	repo repository.NotificationRepository
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+}
+
+func NewNotificationService(repo repository.NotificationRepository) NotificationService {
+	return &notificationService{
+		repo:     repo,
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+func (s *notificationService) Notify(event NotificationEvent) {
+	if !s.shouldSend(event) {
+		return
+	}
+
+	go func() {
+		channels, err := s.repo.ListEnabledChannels()
+		if err != nil {
+			slog.Error("failed to load notification channels", "event", event.Type, "error", err)
+			return
+		}
+		for i := range channels {
+			channel := &channels[i]
+			if !subscribed(channel, event.Type) {
+				continue
+			}
+			if err := s.send(channel, event); err != nil {
+				slog.Error("failed to send notification", "event", event.Type, "channel_id", channel.ID, "channel_type", channel.Type, "error", err)
+			}
+		}
+	}()
+}
+
+// shouldSend 检查事件是否处于冷却时间内
+func (s *notificationService) shouldSend(event NotificationEvent) bool {
+	if event.DedupKey == "" {
+		return true
+	}
+
+	key := event.Type + "/" + event.DedupKey
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.lastSent[key]; ok && now.Sub(last) < notifyCooldown {
+		return false
+	}
+	s.lastSent[key] = now
+	return true
+}
+
+func (s *notificationService) SendTest(id uint) error {
+	channel, err := s.repo.GetChannelByID(id)
+	if err != nil {
+		return err
+	}
+	return s.send(channel, NotificationEvent{
+		Type: model.EventTest,
+		Data: map[string]any{"channel": channel.Name},
+	})
+}
+
+// send 渲染模板并通过渠道发送
+func (s *notificationService) send(channel *model.NotificationChannel, event NotificationEvent) error {
+	config, err := notify.ParseConfig(channel.Config)
+	if err != nil {
+		return err
+	}
+	notifier, err := notify.New(channel.Type, config)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	data := make(map[string]any, len(event.Data)+2)
+	for key, value := range event.Data {
+		data[key] = value
+	}
+	data["event"] = event.Type
+	data["time"] = now.Format("2006-01-02 15:04:05")
+
+	defaults := defaultNotificationTemplates[event.Type]
+	title, err := renderTemplate(channel.TitleTemplate, defaults[0], data)
+	if err != nil {
+		return fmt.Errorf("invalid title template: %v", err)
+	}
+	body, err := renderTemplate(channel.BodyTemplate, defaults[1], data)
+	if err != nil {
+		return fmt.Errorf("invalid body template: %v", err)
+	}
+
+	return notifier.Send(&notify.Message{
+		Event: event.Type,
+		Title: title,
+		Body:  body,
+		Time:  now,
+		Data:  event.Data,
+	})
+}
+
+func (s *notificationService) ListChannels() ([]model.NotificationChannel, error) {
+	return s.repo.ListChannels()
+}
+
+func (s *notificationService) GetChannel(id uint) (*model.NotificationChannel, error) {
+	return s.repo.GetChannelByID(id)
+}
+
+func (s *notificationService) CreateChannel(channel *model.NotificationChannel) error {
+	if err := validateChannel(channel); err != nil {
+		return err
+	}
+	return s.repo.CreateChannel(channel)
+}
+
+// UpdateChannel 更新渠道，配置中仍为掩码的敏感字段保留原有的值
+func (s *notificationService) UpdateChannel(channel *model.NotificationChannel) error {
+	existing, err := s.repo.GetChannelByID(channel.ID)
+	if err != nil {
+		return err
+	}
+	if channel.Config, err = mergeMaskedSecrets(channel.Config, existing.Config); err != nil {
+		return err
+	}
+	if err := validateChannel(channel); err != nil {
+		return err
+	}
+	channel.CreatedAt = existing.CreatedAt
+	return s.repo.UpdateChannel(channel)
+}
+
+func (s *notificationService) DeleteChannel(id uint) error {
+	return s.repo.DeleteChannel(id)
+}
+
+func (s *notificationService) TypeInfo() NotificationTypeInfo {
+	return NotificationTypeInfo{
+		Types:        notify.Types,
+		Events:       model.NotificationEvents,
+		SecretFields: notify.SecretFields,
+	}
+}
+
+// MaskChannelConfig 掩码渠道配置中的敏感字段，用于API响应
+func MaskChannelConfig(config string) string {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(config), &fields); err != nil {
+		return ""
+	}
+	for _, key := range notify.SecretFields {
+		if value, ok := fields[key].(string); ok && value != "" {
+			fields[key] = secret.Mask(value)
+		}
+	}
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// mergeMaskedSecrets 将提交配置中未修改（为空或仍是掩码）的敏感字段替换为原有的值
+func mergeMaskedSecrets(submitted, existing string) (string, error) {
+	var fields, old map[string]any
+	if err := json.Unmarshal([]byte(submitted), &fields); err != nil {
+		return "", fmt.Errorf("invalid channel config: %v", err)
+	}
+	if err := json.Unmarshal([]byte(existing), &old); err != nil {
+		return submitted, nil
+	}
+
+	for _, key := range notify.SecretFields {
+		value, _ := fields[key].(string)
+		if value == "" || secret.IsMasked(value) {
+			if oldValue, ok := old[key]; ok {
+				fields[key] = oldValue
+			}
+		}
+	}
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+// validateChannel 校验渠道类型、订阅事件、模板和必填配置
+func validateChannel(channel *model.NotificationChannel) error {
+	if strings.TrimSpace(channel.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, event := range splitEvents(channel.Events) {
+		if !slices.Contains(model.NotificationEvents, event) {
+			return fmt.Errorf("unknown event: %s", event)
+		}
+	}
+	for _, text := range []string{channel.TitleTemplate, channel.BodyTemplate} {
+		if _, err := template.New("").Parse(text); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+	}
+
+	config, err := notify.ParseConfig(channel.Config)
+	if err != nil {
+		return err
+	}
+	_, err = notify.New(channel.Type, config)
+	return err
+}
+
+// subscribed 判断渠道是否订阅了事件，测试通知始终发送
+func subscribed(channel *model.NotificationChannel, event string) bool {
+	return event == model.EventTest || slices.Contains(splitEvents(channel.Events), event)
+}
+
+func splitEvents(events string) []string {
+	var result []string
+	for _, event := range strings.Split(events, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// renderTemplate 使用自定义模板渲染，为空时使用默认模板
+func renderTemplate(custom, fallback string, data map[string]any) (string, error) {
+	text := custom
+	if strings.TrimSpace(text) == "" {
+		text = fallback
+	}
+	tmpl, err := template.New("notification").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(buf.String(), "<no value>", ""), nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"errors"
+	"slices"
+)
+
+// Permission 接口操作权限
+type Permission string
+
+const (
+	PermView         Permission = "view"          // 查看规则、云服务配置（密钥已掩码）、定时任务和系统配置
+	PermExecute      Permission = "execute"       // 执行规则、触发IP同步、取消同步
+	PermManageRules  Permission = "manage_rules"  // 创建、修改、删除防火墙规则
+	PermManageCloud  Permission = "manage_cloud"  // 管理云服务凭证配置
+	PermManageSystem Permission = "manage_system" // 修改系统配置和定时任务
+	PermManageUsers  Permission = "manage_users"  // 管理用户、角色和访问范围
+	PermViewAudit    Permission = "view_audit"    // 查看审计日志
+)
+
+// ErrForbidden 权限不足
+var ErrForbidden = errors.New("permission denied")
+
+// 各角色拥有的权限
+var rolePermissions = map[string][]Permission{
+	model.RoleAdmin:    {PermView, PermExecute, PermManageRules, PermManageCloud, PermManageSystem, PermManageUsers, PermViewAudit},
+	model.RoleOperator: {PermView, PermExecute, PermManageRules, PermManageCloud, PermViewAudit},
+	model.RoleViewer:   {PermView},
+}
+
+// ValidRole 判断角色名是否有效
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can 判断调用方是否拥有指定权限
+func (p *Principal) Can(perm Permission) bool {
+	if p == nil {
+		return false
+	}
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+// Unrestricted 调用方是否可访问全部云服务配置（管理员或未设置访问范围的用户）
+func (p *Principal) Unrestricted() bool {
+	return p != nil && (p.Role == model.RoleAdmin || len(p.CloudConfigIDs) == 0)
+}
+
+// CanAccessCloudConfig 判断调用方是否可访问指定的云服务配置
+func (p *Principal) CanAccessCloudConfig(cloudConfigID uint) bool {
+	if p == nil {
+		return false
+	}
+	if p.Unrestricted() {
+		return true
+	}
+	return slices.Contains(p.CloudConfigIDs, cloudConfigID)
+}
+
+// RolePermissions 返回各角色拥有的权限，供前端展示
+func RolePermissions() map[string][]Permission {
+	result := make(map[string][]Permission, len(rolePermissions))
+	for role, perms := range rolePermissions {
+		result[role] = slices.Clone(perms)
+	}
+	return result
+}
@@ -0,0 +1,487 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrRuleManagedByGroup 成员规则由规则组维护，只能通过规则组修改或删除
+	ErrRuleManagedByGroup = errors.New("rule is managed by a rule group")
+	// ErrTemplateInUse 模板仍被规则组使用，不能删除
+	ErrTemplateInUse = errors.New("rule template is used by rule groups")
+	// ErrRuleGroupDisabled 规则组已停用，不能同步
+	ErrRuleGroupDisabled = errors.New("rule group is disabled")
+)
+
+// RuleGroupDetail 规则组及其成员规则
+type RuleGroupDetail struct {
+	model.RuleGroup
+	Members []model.FirewallRule `json:"members"`
+}
+
+type RuleGroupService interface {
+	ListTemplates() ([]model.RuleTemplate, error)
+	GetTemplate(id uint) (*model.RuleTemplate, error)
+	CreateTemplate(template *model.RuleTemplate, actor Actor) error
+	// UpdateTemplate 更新模板，并重新应用到使用该模板的所有规则组
+	UpdateTemplate(template *model.RuleTemplate, actor Actor) error
+	DeleteTemplate(id uint, actor Actor) error
+
+	ListGroups() ([]model.RuleGroup, error)
+	GetGroup(id uint) (*RuleGroupDetail, error)
+	CreateGroup(group *model.RuleGroup, actor Actor) error
+	UpdateGroup(group *model.RuleGroup, actor Actor) error
+	// DeleteGroup 删除规则组及其成员规则，云端规则保留
+	DeleteGroup(id uint, actor Actor) error
+	// SetGroupEnabled 启用或停用规则组及其所有成员规则
+	SetGroupEnabled(id uint, enabled bool, actor Actor) error
+	// SyncGroup 立即将规则组的成员规则同步为当前公网IP
+	SyncGroup(ctx context.Context, id uint, actor Actor) (*SyncResult, error)
+}
+
+type ruleGroupService struct {
+	templates     repository.RuleTemplateRepository
+	groups        repository.RuleGroupRepository
+	configService ConfigService
+	firewall      *FirewallService
+}
+
+// NewRuleGroupService 创建规则组服务，成员规则的增删改和同步通过firewall完成并记录审计日志
+func NewRuleGroupService(templates repository.RuleTemplateRepository, groups repository.RuleGroupRepository, configService ConfigService, firewall *FirewallService) RuleGroupService {
+	return &ruleGroupService{
+		templates:     templates,
+		groups:        groups,
+		configService: configService,
+		firewall:      firewall,
+	}
+}
+
+func (s *ruleGroupService) ListTemplates() ([]model.RuleTemplate, error) {
+	return s.templates.List()
+}
+
+func (s *ruleGroupService) GetTemplate(id uint) (*model.RuleTemplate, error) {
+	return s.templates.GetByID(id)
+}
+
+func (s *ruleGroupService) CreateTemplate(template *model.RuleTemplate, actor Actor) error {
+	if err := normalizeTemplate(template); err != nil {
+		return err
+	}
+	for i := range template.Items {
+		template.Items[i].ID = 0
+	}
+	err := s.templates.Create(template)
+	s.firewall.record(actor, AuditEntry{Action: "rule_template.create", EntityType: "rule_template", EntityID: fmt.Sprint(template.ID), After: template, Err: err})
+	return err
+}
+
+func (s *ruleGroupService) UpdateTemplate(template *model.RuleTemplate, actor Actor) error {
+	before, err := s.templates.GetByID(template.ID)
+	if err != nil {
+		return err
+	}
+	if err := normalizeTemplate(template); err != nil {
+		return err
+	}
+	// 保留ID的模板规则更新对应的成员规则，不能引用其他模板的规则
+	owned := make(map[uint]bool, len(before.Items))
+	for _, item := range before.Items {
+		owned[item.ID] = true
+	}
+	for _, item := range template.Items {
+		if item.ID != 0 && !owned[item.ID] {
+			return fmt.Errorf("template rule %d does not belong to this template", item.ID)
+		}
+	}
+
+	// 修改后的模板在各规则组中不能与实例上的其他规则冲突
+	groups, err := s.groups.ListByTemplate(template.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load rule groups: %v", err)
+	}
+	for i := range groups {
+		if _, err := s.memberRules(&groups[i], template); err != nil {
+			return fmt.Errorf("rule group %q: %v", groups[i].Name, err)
+		}
+	}
+
+	template.CreatedAt = before.CreatedAt
+	err = s.templates.Update(template)
+	s.firewall.record(actor, AuditEntry{Action: "rule_template.update", EntityType: "rule_template", EntityID: fmt.Sprint(template.ID), Before: before, After: template, Err: err})
+	if err != nil {
+		return err
+	}
+
+	for i := range groups {
+		if err := s.applyGroup(&groups[i], template, actor); err != nil {
+			return fmt.Errorf("failed to apply template to rule group %q: %v", groups[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *ruleGroupService) DeleteTemplate(id uint, actor Actor) error {
+	before, err := s.templates.GetByID(id)
+	if err != nil {
+		return err
+	}
+	groups, err := s.groups.ListByTemplate(id)
+	if err != nil {
+		return fmt.Errorf("failed to load rule groups: %v", err)
+	}
+	if len(groups) > 0 {
+		return ErrTemplateInUse
+	}
+	err = s.templates.Delete(id)
+	s.firewall.record(actor, AuditEntry{Action: "rule_template.delete", EntityType: "rule_template", EntityID: fmt.Sprint(id), Before: before, Err: err})
+	return err
+}
+
+func (s *ruleGroupService) ListGroups() ([]model.RuleGroup, error) {
+	return s.groups.List()
+}
+
+func (s *ruleGroupService) GetGroup(id uint) (*RuleGroupDetail, error) {
+	group, err := s.groups.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.firewall.repo.GetByGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return &RuleGroupDetail{RuleGroup: *group, Members: members}, nil
+}
+
+func (s *ruleGroupService) CreateGroup(group *model.RuleGroup, actor Actor) error {
+	// 新建的规则组总是启用，通过 SetGroupEnabled 停用
+	group.ID = 0
+	group.Enabled = true
+	template, err := s.validateGroup(group)
+	if err != nil {
+		return err
+	}
+	if _, err := s.memberRules(group, template); err != nil {
+		return err
+	}
+
+	err = s.groups.Create(group)
+	s.firewall.record(actor, AuditEntry{Action: "rule_group.create", EntityType: "rule_group", EntityID: fmt.Sprint(group.ID), After: group, Err: err})
+	if err != nil {
+		return err
+	}
+	return s.applyGroup(group, template, actor)
+}
+
+func (s *ruleGroupService) UpdateGroup(group *model.RuleGroup, actor Actor) error {
+	before, err := s.groups.GetByID(group.ID)
+	if err != nil {
+		return err
+	}
+	template, err := s.validateGroup(group)
+	if err != nil {
+		return err
+	}
+	if _, err := s.memberRules(group, template); err != nil {
+		return err
+	}
+
+	group.CreatedAt = before.CreatedAt
+	group.Enabled = before.Enabled
+	err = s.groups.Update(group)
+	s.firewall.record(actor, AuditEntry{Action: "rule_group.update", EntityType: "rule_group", EntityID: fmt.Sprint(group.ID), Before: before, After: group, Err: err})
+	if err != nil {
+		return err
+	}
+	return s.applyGroup(group, template, actor)
+}
+
+func (s *ruleGroupService) DeleteGroup(id uint, actor Actor) error {
+	before, err := s.groups.GetByID(id)
+	if err != nil {
+		return err
+	}
+	members, err := s.firewall.repo.GetByGroup(id)
+	if err != nil {
+		return fmt.Errorf("failed to load group members: %v", err)
+	}
+	for i := range members {
+		if err := s.firewall.deleteRule(members[i].ID, &members[i], actor); err != nil {
+			return fmt.Errorf("failed to delete member rule %d: %v", members[i].ID, err)
+		}
+	}
+	err = s.groups.Delete(id)
+	s.firewall.record(actor, AuditEntry{Action: "rule_group.delete", EntityType: "rule_group", EntityID: fmt.Sprint(id), Before: before, Err: err})
+	return err
+}
+
+func (s *ruleGroupService) SetGroupEnabled(id uint, enabled bool, actor Actor) error {
+	group, err := s.groups.GetByID(id)
+	if err != nil {
+		return err
+	}
+	template, err := s.templates.GetByID(group.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to load rule template: %v", err)
+	}
+
+	action := "rule_group.disable"
+	if enabled {
+		action = "rule_group.enable"
+	}
+	err = s.groups.SetEnabled(id, enabled)
+	s.firewall.record(actor, AuditEntry{Action: action, EntityType: "rule_group", EntityID: fmt.Sprint(id), Err: err})
+	if err != nil {
+		return err
+	}
+	group.Enabled = enabled
+	return s.applyGroup(group, template, actor)
+}
+
+func (s *ruleGroupService) SyncGroup(ctx context.Context, id uint, actor Actor) (*SyncResult, error) {
+	group, err := s.groups.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !group.Enabled {
+		return nil, ErrRuleGroupDisabled
+	}
+	return s.firewall.syncGroup(ctx, id, actor)
+}
+
+// normalizeTemplate 校验模板并补全默认值
+func normalizeTemplate(template *model.RuleTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(template.Items) == 0 {
+		return fmt.Errorf("template must contain at least one rule")
+	}
+
+	seen := make(map[string]bool, len(template.Items))
+	for i := range template.Items {
+		item := &template.Items[i]
+		item.Protocol = strings.ToUpper(strings.TrimSpace(item.Protocol))
+		if item.Protocol == "" {
+			item.Protocol = "TCP"
+		}
+		switch item.Protocol {
+		case "ICMP", "ALL":
+			item.Port = "ALL"
+		case "TCP", "UDP":
+			port, err := NormalizePort(item.Port)
+			if err != nil {
+				return fmt.Errorf("invalid port for %s rule %q: %v", item.Protocol, item.Remark, err)
+			}
+			item.Port = port
+		default:
+			return fmt.Errorf("unsupported protocol: %s", item.Protocol)
+		}
+
+		item.Action = normalizeAction(item.Action)
+		if !slices.Contains(ruleActions, item.Action) {
+			return fmt.Errorf("unsupported action: %s", item.Action)
+		}
+		source, err := NormalizeSource(item.Source)
+		if err != nil {
+			return fmt.Errorf("invalid source for rule %q: %v", item.Remark, err)
+		}
+		item.Source = source
+		if item.Priority < 0 {
+			return fmt.Errorf("priority must not be negative for rule %q", item.Remark)
+		}
+
+		item.Remark = strings.TrimSpace(item.Remark)
+		if item.Remark == "" {
+			return fmt.Errorf("remark is required for every template rule")
+		}
+		key := item.Protocol + "|" + item.Port + "|" + item.Remark
+		if seen[key] {
+			return fmt.Errorf("duplicate template rule: %s %s %q", item.Protocol, item.Port, item.Remark)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// validateGroup 校验规则组并返回使用的模板
+func (s *ruleGroupService) validateGroup(group *model.RuleGroup) (*model.RuleTemplate, error) {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	template, err := s.templates.GetByID(group.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template_id %d: %v", group.TemplateID, err)
+	}
+	for i := range group.Targets {
+		group.Targets[i].InstanceID = strings.TrimSpace(group.Targets[i].InstanceID)
+	}
+	return template, nil
+}
+
+// memberKey 成员规则的身份：模板规则+实例
+type memberKey struct {
+	itemID        uint
+	cloudConfigID uint
+	instanceID    string
+}
+
+// memberRule 规则组应有的一条成员规则
+type memberRule struct {
+	key  memberKey
+	rule model.FirewallRule
+}
+
+// memberRules 按实例和模板规则顺序计算规则组应有的成员规则，并检查与实例上其他规则的冲突
+// （规则通过协议、端口和备注匹配云端规则，必须在实例上唯一）
+func (s *ruleGroupService) memberRules(group *model.RuleGroup, template *model.RuleTemplate) ([]memberRule, error) {
+	existing, err := s.firewall.repo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %v", err)
+	}
+	taken := make(map[string]uint, len(existing))
+	for _, rule := range existing {
+		if group.ID != 0 && rule.GroupID == group.ID {
+			continue
+		}
+		taken[ruleIdentity(rule.CloudConfigID, rule.InstanceID, rule.Protocol, rule.Port, rule.Remark)] = rule.ID
+	}
+
+	var members []memberRule
+	seen := make(map[instanceKey]bool)
+	for _, target := range group.Targets {
+		cloudConfig, err := s.configService.GetCloudConfigByID(target.CloudConfigID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cloud_config_id %d: %v", target.CloudConfigID, err)
+		}
+		instanceID := target.InstanceID
+		if instanceID == "" {
+			instanceID = cloudConfig.InstanceId
+		}
+		if instanceID == "" {
+			return nil, fmt.Errorf("instance_id is required for cloud config %d", target.CloudConfigID)
+		}
+		instance := instanceKey{cloudConfig.ID, cloudConfig.Provider, instanceID}
+		if seen[instance] {
+			return nil, fmt.Errorf("duplicate target: cloud config %d instance %s", cloudConfig.ID, instanceID)
+		}
+		seen[instance] = true
+
+		for _, item := range template.Items {
+			key := memberKey{item.ID, cloudConfig.ID, instanceID}
+			rule := model.FirewallRule{
+				Provider:       cloudConfig.Provider,
+				CloudConfigID:  cloudConfig.ID,
+				InstanceID:     instanceID,
+				Port:           item.Port,
+				Protocol:       item.Protocol,
+				Action:         item.Action,
+				Source:         item.Source,
+				Priority:       item.Priority,
+				Enabled:        group.Enabled,
+				Remark:         expandRemark(item, group, instanceID),
+				GroupID:        group.ID,
+				TemplateItemID: item.ID,
+			}
+			identity := ruleIdentity(rule.CloudConfigID, rule.InstanceID, rule.Protocol, rule.Port, rule.Remark)
+			if ruleID, ok := taken[identity]; ok {
+				return nil, fmt.Errorf("rule %q on instance %s conflicts with existing rule %d", rule.Remark, instanceID, ruleID)
+			}
+			taken[identity] = 0
+			members = append(members, memberRule{key: key, rule: rule})
+		}
+	}
+	return members, nil
+}
+
+// ruleIdentity 同一实例上用于匹配云端规则的字段
+func ruleIdentity(cloudConfigID uint, instanceID, protocol, port, remark string) string {
+	return fmt.Sprintf("%d|%s|%s|%s|%s", cloudConfigID, instanceID, strings.ToUpper(protocol), port, remark)
+}
+
+// expandRemark 展开模板规则的备注占位符
+func expandRemark(item model.RuleTemplateItem, group *model.RuleGroup, instanceID string) string {
+	return strings.NewReplacer(
+		"{group}", group.Name,
+		"{instance}", instanceID,
+		"{protocol}", item.Protocol,
+		"{port}", item.Port,
+	).Replace(item.Remark)
+}
+
+// applyGroup 按模板和实例列表创建、更新、删除成员规则。
+// 成员规则保留云端规则ID和上次同步的IP，修改后在下次同步时生效。
+func (s *ruleGroupService) applyGroup(group *model.RuleGroup, template *model.RuleTemplate, actor Actor) error {
+	rules, err := s.memberRules(group, template)
+	if err != nil {
+		return err
+	}
+	desired := make(map[memberKey]model.FirewallRule, len(rules))
+	for _, m := range rules {
+		desired[m.key] = m.rule
+	}
+	members, err := s.firewall.repo.GetByGroup(group.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load group members: %v", err)
+	}
+
+	for i := range members {
+		before := members[i]
+		key := memberKey{before.TemplateItemID, before.CloudConfigID, before.InstanceID}
+		want, ok := desired[key]
+		if !ok {
+			if err := s.firewall.deleteRule(before.ID, &before, actor); err != nil {
+				return fmt.Errorf("failed to delete member rule %d: %v", before.ID, err)
+			}
+			continue
+		}
+		delete(desired, key)
+
+		if before.Provider == want.Provider && before.Protocol == want.Protocol && before.Port == want.Port &&
+			before.Action == want.Action && before.Source == want.Source && before.Priority == want.Priority &&
+			before.Remark == want.Remark && before.Enabled == want.Enabled {
+			continue
+		}
+		rule := before
+		rule.Provider = want.Provider
+		rule.Protocol = want.Protocol
+		rule.Port = want.Port
+		rule.Action = want.Action
+		rule.Source = want.Source
+		rule.Priority = want.Priority
+		rule.Remark = want.Remark
+		rule.Enabled = want.Enabled
+		if err := s.firewall.updateRule(&rule, &before, actor); err != nil {
+			return fmt.Errorf("failed to update member rule %d: %v", rule.ID, err)
+		}
+	}
+
+	// 按实例和模板规则顺序创建缺少的成员规则
+	for _, m := range rules {
+		if _, ok := desired[m.key]; !ok {
+			continue
+		}
+		rule := m.rule
+		if err := s.firewall.createRule(&rule, actor); err != nil {
+			return fmt.Errorf("failed to create member rule %q: %v", rule.Remark, err)
+		}
+		// Enabled有默认值true，创建时false会被忽略
+		if !m.rule.Enabled {
+			rule.Enabled = false
+			if err := s.firewall.repo.Update(&rule); err != nil {
+				return fmt.Errorf("failed to disable member rule %d: %v", rule.ID, err)
+			}
+		}
+	}
+	s.firewall.refreshRuleGauges()
+	return nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SetRuleOperationRepository 设置云端规则操作日志存储，未设置时不记录操作日志
+func (s *FirewallService) SetRuleOperationRepository(journal repository.RuleOperationRepository) {
+	s.journal = journal
+}
+
+// journalOperation 持久化操作的当前状态，必须在执行对应的云服务调用前成功
+func (s *FirewallService) journalOperation(op *model.RuleOperation) error {
+	if s.journal == nil {
+		return nil
+	}
+
+	var err error
+	if op.ID == 0 {
+		err = s.journal.Create(op)
+	} else {
+		err = s.journal.Update(op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write operation journal: %v", err)
+	}
+	return nil
+}
+
+// newRuleOperation 创建一条待执行的规则更新操作，oldRule为空表示仅创建
+func newRuleOperation(rule *model.FirewallRule, runID string, spec *cloud.FirewallRuleSpec, oldRule *cloud.FirewallRuleResult) *model.RuleOperation {
+	op := &model.RuleOperation{
+		RuleID:        rule.ID,
+		RunID:         runID,
+		CloudConfigID: rule.CloudConfigID,
+		Provider:      rule.Provider,
+		InstanceID:    rule.InstanceID,
+		Protocol:      spec.Protocol,
+		Port:          spec.Port,
+		Action:        spec.Action,
+		Description:   spec.Description,
+		NewCidrBlock:  spec.CidrBlock,
+		State:         model.RuleOpPendingCreate,
+	}
+	if oldRule != nil {
+		op.OldCidrBlock = oldRule.CidrBlock
+		op.OldAction = oldRule.Action
+	}
+	return op
+}
+
+// findOperationRule 在云端规则列表中查找与操作相同协议、端口、备注且来源为cidrBlock、动作为action的规则
+func findOperationRule(rules []*cloud.FirewallRuleResult, op *model.RuleOperation, cidrBlock, action string) *cloud.FirewallRuleResult {
+	for _, r := range rules {
+		if strings.EqualFold(r.Protocol, op.Protocol) &&
+			r.Port == op.Port &&
+			r.Description == op.Description &&
+			r.CidrBlock == cidrBlock &&
+			strings.EqualFold(r.Action, action) {
+			return r
+		}
+	}
+	return nil
+}
+
+// abandonInstanceOperations 将规则在当前实例上尚未结束的操作标记为放弃，规则不再以该实例为目标时使用
+func (s *FirewallService) abandonInstanceOperations(rule *model.FirewallRule) error {
+	if s.journal == nil {
+		return nil
+	}
+	ops, err := s.journal.ListUnfinished(rule.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load operation journal: %v", err)
+	}
+	for i := range ops {
+		op := &ops[i]
+		if op.CloudConfigID != rule.CloudConfigID || op.Provider != rule.Provider || op.InstanceID != rule.InstanceID {
+			continue
+		}
+		op.State = model.RuleOpAbandoned
+		if err := s.journalOperation(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeOperations 同步规则前处理其未完成的操作，结果只取决于云端当前状态：
+// pending_create 时云端没有新规则则视为未执行并回滚，否则继续；
+// created / pending_delete 时删除仍然存在的旧规则。返回更新后的云端规则列表。
+func (s *FirewallService) resumeOperations(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule, existing []*cloud.FirewallRuleResult, actor Actor, runID string) ([]*cloud.FirewallRuleResult, error) {
+	if s.journal == nil {
+		return existing, nil
+	}
+
+	ops, err := s.journal.ListUnfinished(rule.ID)
+	if err != nil {
+		return existing, fmt.Errorf("failed to load operation journal: %v", err)
+	}
+
+	for i := range ops {
+		op := &ops[i]
+		logger := runLogger(actor, runID).With("rule_id", rule.ID, "operation_id", op.ID, "operation_run_id", op.RunID, "state", op.State)
+
+		// 多实例规则在其他实例上的操作，在同步该实例时处理
+		if rule.MultiInstance() && op.CloudConfigID == rule.CloudConfigID && op.Provider == rule.Provider && op.InstanceID != rule.InstanceID {
+			continue
+		}
+		// 规则已改到其他实例时，原实例上的操作无法在这里恢复
+		if op.CloudConfigID != rule.CloudConfigID || op.Provider != rule.Provider || op.InstanceID != rule.InstanceID {
+			op.State = model.RuleOpAbandoned
+			if err := s.journalOperation(op); err != nil {
+				return existing, err
+			}
+			logger.Warn("abandoned unfinished operation for previous instance", "instance_id", op.InstanceID)
+			continue
+		}
+
+		if op.State == model.RuleOpPendingCreate {
+			created := findOperationRule(existing, op, op.NewCidrBlock, op.Action)
+			if created == nil {
+				op.State = model.RuleOpRolledBack
+				if err := s.journalOperation(op); err != nil {
+					return existing, err
+				}
+				logger.Info("rolled back unfinished rule update, new rule was not created", "cidr_block", op.NewCidrBlock)
+				continue
+			}
+			op.State = model.RuleOpCreated
+			op.CloudRuleID = created.RuleID
+			if err := s.journalOperation(op); err != nil {
+				return existing, err
+			}
+		}
+
+		if old := findOperationRule(existing, op, op.OldCidrBlock, op.PreviousAction()); op.OldCidrBlock != "" && old != nil {
+			op.State = model.RuleOpPendingDelete
+			if err := s.journalOperation(op); err != nil {
+				return existing, err
+			}
+			err := provider.DeleteFirewallRuleBySpec(ctx, rule.InstanceID, old)
+			s.record(actor, cloudAuditEntry("cloud.delete", rule, runID, cloudRuleAudit(rule, nil, old), nil, err))
+			if err != nil {
+				op.Error = err.Error()
+				if journalErr := s.journalOperation(op); journalErr != nil {
+					logger.Error("failed to record operation error", "error", journalErr)
+				}
+				return existing, fmt.Errorf("failed to delete old rule %s left by run %s: %v", op.OldCidrBlock, op.RunID, err)
+			}
+			existing = removeCloudRule(existing, old)
+		}
+
+		op.State = model.RuleOpDone
+		op.Error = ""
+		if err := s.journalOperation(op); err != nil {
+			return existing, err
+		}
+		logger.Info("resumed unfinished rule update", "old_cidr_block", op.OldCidrBlock, "new_cidr_block", op.NewCidrBlock)
+	}
+	return existing, nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// orderInstanceRules 同步完成后按规则优先级调整实例上云端规则的顺序。只移动 FireFlow 管理的规则，
+// 它们在彼此之间按Priority从小到大排列（相同优先级保持云端原有顺序），受保护的规则和其他规则位置不变。
+// 所有规则优先级都为0或云服务不支持规则顺序时不做任何调用。调用方需持有实例锁。
+func (s *FirewallService) orderInstanceRules(ctx context.Context, provider cloud.CloudProvider, group instanceRules, guard *InstanceGuard, actor Actor, runID string) error {
+	if !limitsFor(group.key.provider).ordering ||
+		!slices.ContainsFunc(group.rules, func(r model.FirewallRule) bool { return r.Priority != 0 }) {
+		return nil
+	}
+
+	current, err := provider.ListFirewallRules(ctx, group.key.instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to list firewall rules: %v", err)
+	}
+	ordered := orderedRules(current, group.rules, guard)
+	if ordered == nil {
+		return nil
+	}
+
+	// 调整顺序后DROP规则可能排到关键端口的ACCEPT规则之前
+	previouslyMissing := make(map[criticalPort]bool)
+	for _, p := range guard.uncoveredPorts(current) {
+		previouslyMissing[p] = true
+	}
+	for _, p := range guard.uncoveredPorts(ordered) {
+		if !previouslyMissing[p] {
+			return fmt.Errorf("%w: reordering would block %s of instance %s", ErrLockoutPrevented, p, group.key.instanceID)
+		}
+	}
+
+	err = provider.ReorderFirewallRules(ctx, group.key.instanceID, ordered)
+	s.record(actor, AuditEntry{
+		Action:     "cloud.reorder",
+		EntityType: "instance",
+		EntityID:   group.key.instanceID,
+		RunID:      runID,
+		Before:     map[string]any{"cloud_config_id": group.key.cloudConfigID, "rules": ruleOrder(current)},
+		After:      map[string]any{"cloud_config_id": group.key.cloudConfigID, "rules": ruleOrder(ordered)},
+		Err:        err,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reorder firewall rules: %v", err)
+	}
+	return nil
+}
+
+// orderedRules 返回按优先级重排后的云端规则列表，顺序无需调整时返回nil
+func orderedRules(current []*cloud.FirewallRuleResult, rules []model.FirewallRule, guard *InstanceGuard) []*cloud.FirewallRuleResult {
+	var slots []int
+	var managed []*cloud.FirewallRuleResult
+	priority := make(map[*cloud.FirewallRuleResult]int)
+	for i, r := range current {
+		if guard.protects(r) {
+			continue
+		}
+		for j := range rules {
+			if matchesRule(r, &rules[j]) {
+				slots = append(slots, i)
+				managed = append(managed, r)
+				priority[r] = rules[j].Priority
+				break
+			}
+		}
+	}
+
+	sorted := slices.Clone(managed)
+	slices.SortStableFunc(sorted, func(a, b *cloud.FirewallRuleResult) int {
+		return cmp.Compare(priority[a], priority[b])
+	})
+	if slices.Equal(sorted, managed) {
+		return nil
+	}
+	ordered := slices.Clone(current)
+	for k, i := range slots {
+		ordered[i] = sorted[k]
+	}
+	return ordered
+}
+
+// ruleOrder 审计日志中记录的规则顺序
+func ruleOrder(rules []*cloud.FirewallRuleResult) []string {
+	order := make([]string, len(rules))
+	for i, r := range rules {
+		order[i] = strings.Join([]string{r.Action, r.Protocol, r.Port, r.CidrBlock, r.Description}, " ")
+	}
+	return order
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"FireFlow/internal/metrics"
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"FireFlow/pkg/probe"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrProbeFailed 规则已更新，但更新后的连通性探测失败
+	ErrProbeFailed = errors.New("connectivity probe failed")
+	// ErrProbeNotConfigured 规则未配置探测或未启用探测器
+	ErrProbeNotConfigured = errors.New("probe is not configured for this rule")
+)
+
+// ProbeAddressResolver 返回探测规则时连接的地址
+type ProbeAddressResolver func(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule) (string, error)
+
+// SetProber 设置规则更新后的连通性探测器，未设置时不探测
+func (s *FirewallService) SetProber(prober *probe.Prober) {
+	s.prober = prober
+}
+
+// SetProbeAddressResolver 设置探测地址的解析方式，默认使用云服务返回的实例公网IP。
+// 测试时可指向本地监听地址。
+func (s *FirewallService) SetProbeAddressResolver(resolve ProbeAddressResolver) {
+	s.probeAddress = resolve
+}
+
+// instancePublicIP 默认的探测地址：实例的公网IP
+func instancePublicIP(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule) (string, error) {
+	instance, err := provider.GetInstance(ctx, rule.InstanceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get instance: %v", err)
+	}
+	if instance.PublicIP == "" {
+		return "", fmt.Errorf("instance %s has no public IP", rule.InstanceID)
+	}
+	return instance.PublicIP, nil
+}
+
+// validateProbe 校验规则的探测配置，TCP/HTTP探测需要单个端口
+func validateProbe(rule *model.FirewallRule, verr *ValidationError) {
+	if rule.ProbeType == "" {
+		return
+	}
+	if !slices.Contains(probe.Types, rule.ProbeType) {
+		verr.add("probe_type", "unsupported probe type: %s", rule.ProbeType)
+		return
+	}
+	if rule.ProbeType == probe.TypeICMP {
+		return
+	}
+	if _, err := probePort(rule); err != nil {
+		verr.add("probe_port", "%v", err)
+	}
+}
+
+// probePort TCP/HTTP探测的端口，未指定时使用规则端口（必须是单个端口）
+func probePort(rule *model.FirewallRule) (string, error) {
+	port := strings.TrimSpace(rule.ProbePort)
+	if port == "" {
+		port = rule.Port
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("probe_port is required when the rule port is %q", rule.Port)
+	}
+	return port, nil
+}
+
+// ProbeRule 立即探测规则，保存并返回探测结果。多实例规则依次探测各实例，
+// 返回第一个失败的结果。
+func (s *FirewallService) ProbeRule(ctx context.Context, id uint) (*probe.Result, error) {
+	rule, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule: %v", err)
+	}
+	if rule.ProbeType == "" || s.prober == nil {
+		return nil, ErrProbeNotConfigured
+	}
+	targets, err := s.newTargetResolver().expand(ctx, rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve rule targets: %v", err)
+	}
+
+	var result *probe.Result
+	for i := range targets {
+		var provider cloud.CloudProvider
+		provider, err = s.instanceProvider(targets[i].Provider, targets[i].CloudConfigID, targets[i].InstanceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cloud client: %v", err)
+		}
+		result, err = s.probeRule(ctx, provider, &targets[i])
+		if err != nil {
+			break
+		}
+	}
+	s.setRuleDegraded(rule.ID, err != nil)
+	s.refreshRuleGauges()
+	return result, err
+}
+
+// probeRule 规则更新后探测端口是否可以访问并保存结果。未配置探测时返回nil，
+// 探测失败时返回 ErrProbeFailed。
+func (s *FirewallService) probeRule(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule) (*probe.Result, error) {
+	if rule.ProbeType == "" || s.prober == nil {
+		return nil, nil
+	}
+
+	target := probe.Target{Type: rule.ProbeType, Path: rule.ProbePath}
+	result := &probe.Result{}
+	resolve := s.probeAddress
+	if resolve == nil {
+		resolve = instancePublicIP
+	}
+	host, err := resolve(ctx, provider, rule)
+	if err == nil && target.Type != probe.TypeICMP {
+		target.Port, err = probePort(rule)
+	}
+	if err == nil {
+		target.Host = host
+		*result = s.prober.Probe(ctx, target)
+	} else {
+		result.Error = err.Error()
+	}
+
+	status := model.ProbeHealthy
+	if !result.Healthy {
+		status = model.ProbeUnhealthy
+	}
+	metrics.RuleProbes.WithLabelValues(rule.ProbeType, status).Inc()
+	if result.CheckedAt.IsZero() {
+		result.CheckedAt = time.Now()
+	}
+	if err := s.repo.UpdateProbeResult(rule.ID, status, result.Error, result.Latency.Milliseconds(), result.CheckedAt); err != nil {
+		slog.Warn("failed to save probe result", "rule_id", rule.ID, "error", err)
+	}
+	rule.ProbeStatus = status
+	rule.ProbeError = result.Error
+	rule.ProbeLatencyMs = result.Latency.Milliseconds()
+	rule.ProbedAt = &result.CheckedAt
+
+	if !result.Healthy {
+		return result, fmt.Errorf("%w: %s", ErrProbeFailed, result.Error)
+	}
+	return result, nil
+}
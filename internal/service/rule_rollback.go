@@ -0,0 +1,403 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoRollbackTarget 没有可恢复的历史来源，或指定的操作不属于该规则
+	ErrNoRollbackTarget = errors.New("no rollback target")
+	// ErrJournalDisabled 未设置操作日志存储，无法查询历史和回滚
+	ErrJournalDisabled = errors.New("operation journal is not configured")
+)
+
+// 规则历史默认返回的记录数
+const defaultRuleHistoryLimit = 50
+
+// RuleRollback 单条规则的回滚结果
+type RuleRollback struct {
+	RuleID        uint   `json:"rule_id"`
+	Remark        string `json:"remark"`
+	InstanceID    string `json:"instance_id"`
+	FromCidrBlock string `json:"from_cidr_block,omitempty"` // 回滚前云端的来源
+	ToCidrBlock   string `json:"to_cidr_block,omitempty"`   // 恢复的来源，为空表示删除云端规则
+	Verified      bool   `json:"verified"`                  // 回滚后重新查询云端规则确认结果
+	Pinned        bool   `json:"pinned"`                    // 回滚成功后规则被固定，同步时跳过，手动执行后解除
+	Error         string `json:"error,omitempty"`
+}
+
+// RollbackResult 回滚任务的结果
+type RollbackResult struct {
+	RunID       string         `json:"run_id"`
+	TargetRunID string         `json:"target_run_id,omitempty"` // 按同步任务回滚时，被回滚的任务ID
+	Rules       []RuleRollback `json:"rules"`
+	Failed      int            `json:"failed"`
+	Message     string         `json:"message,omitempty"`
+}
+
+// pinnedRulesMessage 回滚结果中关于固定规则的说明
+const pinnedRulesMessage = "rolled back rules are pinned: syncs skip them until they are executed manually"
+
+// setMessage 有规则被固定时在结果中说明
+func (r *RollbackResult) setMessage() {
+	for _, rule := range r.Rules {
+		if rule.Pinned {
+			r.Message = pinnedRulesMessage
+			return
+		}
+	}
+}
+
+// GetRuleHistory 按时间倒序返回规则的来源变更记录，limit<=0 时使用默认数量
+func (s *FirewallService) GetRuleHistory(ruleID uint, limit int) ([]model.RuleOperation, error) {
+	if s.journal == nil {
+		return nil, ErrJournalDisabled
+	}
+	if limit <= 0 {
+		limit = defaultRuleHistoryLimit
+	}
+	return s.journal.ListByRule(ruleID, limit)
+}
+
+// RollbackRule 将规则的云端来源恢复为指定操作之前的值。operationID为0时回滚规则最近一次生效的变更。
+// 回滚期间占用全量同步：同步开始后才固定规则时，同步已读取的规则列表中该规则仍未固定，会把来源改回当前公网IP。
+func (s *FirewallService) RollbackRule(ctx context.Context, id, operationID uint, actor Actor) (*RollbackResult, error) {
+	if s.journal == nil {
+		return nil, ErrJournalDisabled
+	}
+
+	rule, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule: %v", err)
+	}
+	op, err := s.ruleRollbackTarget(rule.ID, operationID)
+	if err != nil {
+		return nil, err
+	}
+
+	info, runCtx, err := s.runs.BeginFullSync(ctx, actor.trigger())
+	if err != nil {
+		return nil, err
+	}
+	defer s.runs.EndFullSync(info.RunID)
+
+	run := &model.SyncRun{
+		ID:        info.RunID,
+		Kind:      model.SyncRunRollback,
+		RuleID:    id,
+		Trigger:   info.Trigger,
+		Status:    model.SyncRunRunning,
+		StartedAt: info.StartedAt,
+	}
+	s.saveRun(run, true)
+
+	rollback := s.rollbackRule(runCtx, rule, op, actor, info.RunID)
+	result := &RollbackResult{RunID: info.RunID, Rules: []RuleRollback{rollback}}
+	result.setMessage()
+	outcome := model.SyncRunSuccess
+	if rollback.Error != "" {
+		err = errors.New(rollback.Error)
+		result.Failed = 1
+		outcome = model.SyncRunFailed
+	}
+	s.finishRun(run, outcome, nil, err)
+	s.markRuleResult(id, err)
+	s.refreshRuleGauges()
+	return result, err
+}
+
+// ruleRollbackTarget 查找规则要回滚的操作
+func (s *FirewallService) ruleRollbackTarget(ruleID, operationID uint) (*model.RuleOperation, error) {
+	if operationID != 0 {
+		op, err := s.journal.GetByID(operationID)
+		if err != nil || op.RuleID != ruleID {
+			return nil, ErrNoRollbackTarget
+		}
+		return op, nil
+	}
+
+	ops, err := s.journal.ListByRule(ruleID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load operation journal: %v", err)
+	}
+	for i := range ops {
+		switch ops[i].State {
+		case model.RuleOpCreated, model.RuleOpPendingDelete, model.RuleOpDone:
+			// 最近一次生效的变更是首次创建时没有之前的来源可恢复
+			if ops[i].OldCidrBlock == "" {
+				return nil, ErrNoRollbackTarget
+			}
+			return &ops[i], nil
+		}
+	}
+	return nil, ErrNoRollbackTarget
+}
+
+// RollbackRun 将指定同步任务修改过的规则恢复为该任务执行前的来源：
+// 每条规则（多实例规则按实例）取任务中第一条生效操作的旧来源，任务中新建的规则会被删除。
+// 回滚期间占用全量同步，避免与同步同时修改规则。
+func (s *FirewallService) RollbackRun(ctx context.Context, targetRunID string, actor Actor) (*RollbackResult, error) {
+	if s.journal == nil {
+		return nil, ErrJournalDisabled
+	}
+
+	ops, err := s.journal.ListAppliedByRun(targetRunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load operation journal: %v", err)
+	}
+	type rollbackKey struct {
+		ruleID     uint
+		instanceID string
+	}
+	targets := make(map[rollbackKey]*model.RuleOperation)
+	var keys []rollbackKey
+	for i := range ops {
+		key := rollbackKey{ops[i].RuleID, ops[i].InstanceID}
+		if _, ok := targets[key]; !ok {
+			targets[key] = &ops[i]
+			keys = append(keys, key)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, ErrNoRollbackTarget
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ruleID != keys[j].ruleID {
+			return keys[i].ruleID < keys[j].ruleID
+		}
+		return keys[i].instanceID < keys[j].instanceID
+	})
+
+	info, runCtx, err := s.runs.BeginFullSync(ctx, actor.trigger())
+	if err != nil {
+		return nil, err
+	}
+	defer s.runs.EndFullSync(info.RunID)
+
+	run := &model.SyncRun{
+		ID:        info.RunID,
+		Kind:      model.SyncRunRollback,
+		Trigger:   info.Trigger,
+		Status:    model.SyncRunRunning,
+		StartedAt: info.StartedAt,
+	}
+	s.saveRun(run, true)
+	logger := runLogger(actor, info.RunID).With("target_run_id", targetRunID)
+	logger.Info("rolling back sync run", "rules", len(keys))
+
+	result := &RollbackResult{RunID: info.RunID, TargetRunID: targetRunID}
+	for _, key := range keys {
+		if runCtx.Err() != nil {
+			err = context.Cause(runCtx)
+			break
+		}
+
+		op := targets[key]
+		var rollback RuleRollback
+		rule, getErr := s.repo.GetByID(key.ruleID)
+		if getErr != nil {
+			rollback = RuleRollback{RuleID: key.ruleID, InstanceID: op.InstanceID, ToCidrBlock: op.OldCidrBlock, Error: "rule no longer exists"}
+		} else {
+			rollback = s.rollbackRule(runCtx, rule, op, actor, info.RunID)
+		}
+		if rollback.Error != "" {
+			result.Failed++
+		}
+		result.Rules = append(result.Rules, rollback)
+	}
+
+	result.setMessage()
+	outcome := model.SyncRunSuccess
+	switch {
+	case err != nil:
+		outcome = model.SyncRunCancelled
+	case result.Failed == len(keys):
+		outcome = model.SyncRunFailed
+	case result.Failed > 0:
+		outcome = model.SyncRunPartial
+	}
+	s.finishRun(run, outcome, &SyncResult{Updated: len(result.Rules) - result.Failed, Failed: result.Failed, Skipped: len(keys) - len(result.Rules)}, err)
+	s.record(actor, AuditEntry{Action: "sync.rollback", EntityType: "sync_run", EntityID: targetRunID, RunID: info.RunID, After: result, Err: err})
+	s.refreshRuleGauges()
+	logger.Info("sync run rollback finished", "outcome", outcome, "failed", result.Failed)
+	return result, err
+}
+
+// rollbackRule 将规则恢复为op之前的来源并记录审计日志
+func (s *FirewallService) rollbackRule(ctx context.Context, rule *model.FirewallRule, op *model.RuleOperation, actor Actor, runID string) RuleRollback {
+	rollback := RuleRollback{
+		RuleID:      rule.ID,
+		Remark:      rule.Remark,
+		InstanceID:  rule.InstanceID,
+		ToCidrBlock: op.OldCidrBlock,
+	}
+
+	var err error
+	if op.CloudConfigID != rule.CloudConfigID || op.Provider != rule.Provider || (op.InstanceID != rule.InstanceID && !rule.MultiInstance()) {
+		err = fmt.Errorf("rule has moved from instance %s since operation %d", op.InstanceID, op.ID)
+	} else {
+		// 多实例规则只恢复操作所在的实例，动作同时恢复为操作之前的动作
+		target := *rule
+		target.InstanceID = op.InstanceID
+		target.Action = op.PreviousAction()
+		rollback.InstanceID = op.InstanceID
+		rollback.FromCidrBlock, err = s.restoreRuleSource(ctx, &target, op.OldCidrBlock, actor, runID)
+	}
+	if err != nil {
+		rollback.Error = err.Error()
+		runLogger(actor, runID).Warn("failed to roll back rule", "rule_id", rule.ID, "operation_id", op.ID, "error", err)
+	} else {
+		rollback.Verified = true
+		// 固定规则，避免下一次同步又把来源改回当前公网IP
+		if pinErr := s.repo.SetPinned(rule.ID, true); pinErr != nil {
+			runLogger(actor, runID).Warn("failed to pin rolled back rule", "rule_id", rule.ID, "error", pinErr)
+		} else {
+			rollback.Pinned = true
+		}
+	}
+
+	s.record(actor, AuditEntry{
+		Action:     "rule.rollback",
+		EntityType: "firewall_rule",
+		EntityID:   fmt.Sprint(rule.ID),
+		RunID:      runID,
+		Before:     map[string]any{"cidr_block": rollback.FromCidrBlock},
+		After:      map[string]any{"cidr_block": rollback.ToCidrBlock, "operation_id": op.ID, "operation_run_id": op.RunID, "pinned": rollback.Pinned},
+		Err:        err,
+	})
+	return rollback
+}
+
+// restoreRuleSource 将规则的云端来源改为cidrBlock，cidrBlock为空时删除云端规则，
+// 完成后重新查询云端规则确认结果并验证实例的关键端口。返回回滚前云端的来源。
+func (s *FirewallService) restoreRuleSource(ctx context.Context, rule *model.FirewallRule, cidrBlock string, actor Actor, runID string) (string, error) {
+	provider, err := s.instanceProvider(rule.Provider, rule.CloudConfigID, rule.InstanceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cloud client: %v", err)
+	}
+	guard, err := s.instanceGuard(rule.CloudConfigID, rule.InstanceID)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := s.runs.LockInstance(ctx, rule.Provider, rule.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	done, err := s.runs.BeginMutation()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	existing, err := provider.ListFirewallRules(ctx, rule.InstanceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list existing rules: %v", err)
+	}
+	var before []string
+	for _, r := range guard.unprotected(existing) {
+		if matchesRule(r, rule) {
+			before = append(before, r.CidrBlock)
+		}
+	}
+
+	err = s.applyRuleSource(ctx, provider, guard, rule, cidrBlock, existing, actor, runID)
+	key := instanceKey{rule.CloudConfigID, rule.Provider, rule.InstanceID}
+	if _, verifyErr := s.verifyCriticalPorts(ctx, provider, key, guard, existing, actor, runID); verifyErr != nil {
+		err = verifyErr
+	}
+	return strings.Join(before, ","), err
+}
+
+// applyRuleSource 将规则的云端来源改为cidrBlock（为空时删除），受保护的云端规则不受影响，
+// 完成后重新查询云端规则，确认只剩下一条来源为cidrBlock的规则（删除时不再有匹配规则）。调用方需持有实例锁。
+func (s *FirewallService) applyRuleSource(ctx context.Context, provider cloud.CloudProvider, guard *InstanceGuard, rule *model.FirewallRule, cidrBlock string, existing []*cloud.FirewallRuleResult, actor Actor, runID string) error {
+	var err error
+	if cidrBlock == "" {
+		err = s.removeRuleFromCloud(ctx, provider, rule, guard.unprotected(existing), actor, runID)
+	} else {
+		_, err = s.reconcileRule(ctx, provider, rule, cidrBlock, guard.unprotected(existing), actor, runID)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := provider.ListFirewallRules(ctx, rule.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to verify rollback: %v", err)
+	}
+	var after []string
+	for _, r := range guard.unprotected(current) {
+		if matchesRule(r, rule) {
+			after = append(after, r.CidrBlock)
+		}
+	}
+	if (cidrBlock == "" && len(after) != 0) || (cidrBlock != "" && (len(after) != 1 || after[0] != cidrBlock)) {
+		return fmt.Errorf("rollback verification failed: expected source %q, found %q", cidrBlock, strings.Join(after, ","))
+	}
+	return nil
+}
+
+// removeRuleFromCloud 删除规则在云端的所有匹配规则，回滚到规则创建之前时使用
+func (s *FirewallService) removeRuleFromCloud(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule, existing []*cloud.FirewallRuleResult, actor Actor, runID string) error {
+	existing, err := s.resumeOperations(ctx, provider, rule, existing, actor, runID)
+	if err != nil {
+		return err
+	}
+	if err := s.deleteCloudRules(ctx, provider, rule, existing, actor, runID); err != nil {
+		return err
+	}
+
+	if err := s.clearCloudState(rule); err != nil {
+		runLogger(actor, runID).Warn("rule removed from cloud but failed to update database", "rule_id", rule.ID, "error", err)
+	}
+	return nil
+}
+
+// deleteCloudRules 逐条记录操作日志并删除规则在当前实例上的所有匹配规则
+func (s *FirewallService) deleteCloudRules(ctx context.Context, provider cloud.CloudProvider, rule *model.FirewallRule, existing []*cloud.FirewallRuleResult, actor Actor, runID string) error {
+	for _, r := range existing {
+		if !matchesRule(r, rule) {
+			continue
+		}
+		op := &model.RuleOperation{
+			RuleID:        rule.ID,
+			RunID:         runID,
+			CloudConfigID: rule.CloudConfigID,
+			Provider:      rule.Provider,
+			InstanceID:    rule.InstanceID,
+			Protocol:      r.Protocol,
+			Port:          r.Port,
+			Action:        r.Action,
+			OldAction:     r.Action,
+			Description:   r.Description,
+			OldCidrBlock:  r.CidrBlock,
+			State:         model.RuleOpPendingDelete,
+		}
+		if err := s.journalOperation(op); err != nil {
+			return err
+		}
+		err := provider.DeleteFirewallRuleBySpec(ctx, rule.InstanceID, r)
+		s.record(actor, cloudAuditEntry("cloud.delete", rule, runID, cloudRuleAudit(rule, nil, r), nil, err))
+		if err != nil {
+			op.Error = err.Error()
+			if journalErr := s.journalOperation(op); journalErr != nil {
+				runLogger(actor, runID).Error("failed to record operation error", "error", journalErr)
+			}
+			return fmt.Errorf("failed to delete cloud rule %s: %v", r.CidrBlock, err)
+		}
+		op.State = model.RuleOpDone
+		if err := s.journalOperation(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
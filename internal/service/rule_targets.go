@@ -0,0 +1,275 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// tagRequirement 标签选择器中的一项：key=value 要求标签值相等，只有key时要求存在该标签
+type tagRequirement struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// parseSelector 解析逗号分隔的标签选择器，所有条件都满足的实例才匹配
+func parseSelector(selector string) ([]tagRequirement, error) {
+	var requirements []tagRequirement
+	for _, item := range splitList(selector) {
+		key, value, hasValue := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid instance selector %q: empty tag key", selector)
+		}
+		requirements = append(requirements, tagRequirement{key: key, value: strings.TrimSpace(value), hasValue: hasValue})
+	}
+	return requirements, nil
+}
+
+// selectorMatches 实例标签是否满足所有条件
+func selectorMatches(requirements []tagRequirement, tags map[string]string) bool {
+	for _, r := range requirements {
+		value, ok := tags[r.key]
+		if !ok || (r.hasValue && value != r.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTargets 规范化并校验规则的实例目标，至少需要一个实例ID或标签选择器
+func validateTargets(rule *model.FirewallRule, verr *ValidationError) {
+	rule.InstanceID = strings.TrimSpace(rule.InstanceID)
+	rule.InstanceIDs = strings.Join(splitList(rule.InstanceIDs), ",")
+	rule.InstanceSelector = strings.TrimSpace(rule.InstanceSelector)
+	if _, err := parseSelector(rule.InstanceSelector); err != nil {
+		verr.add("instance_selector", "%v", err)
+	}
+	if rule.InstanceID == "" && !rule.MultiInstance() {
+		verr.add("instance_id", "instance_id, instance_ids or instance_selector is required")
+	}
+}
+
+// targetResolver 解析规则的目标实例，每个云服务配置只查询一次实例列表
+type targetResolver struct {
+	s         *FirewallService
+	instances map[uint][]*cloud.InstanceInfo
+}
+
+func (s *FirewallService) newTargetResolver() *targetResolver {
+	return &targetResolver{s: s, instances: make(map[uint][]*cloud.InstanceInfo)}
+}
+
+// targets 合并InstanceID、InstanceIDs和标签选择器匹配的实例，去重并保持顺序
+func (r *targetResolver) targets(ctx context.Context, rule *model.FirewallRule) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	add(rule.InstanceID)
+	for _, id := range splitList(rule.InstanceIDs) {
+		add(id)
+	}
+	if rule.InstanceSelector == "" {
+		return ids, nil
+	}
+
+	requirements, err := parseSelector(rule.InstanceSelector)
+	if err != nil {
+		return nil, err
+	}
+	instances, ok := r.instances[rule.CloudConfigID]
+	if !ok {
+		var err error
+		instances, err = r.s.listAccountInstances(ctx, rule.Provider, rule.CloudConfigID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances for selector %q: %v", rule.InstanceSelector, err)
+		}
+		r.instances[rule.CloudConfigID] = instances
+	}
+	for _, instance := range instances {
+		if selectorMatches(requirements, instance.Tags) {
+			add(instance.InstanceID)
+		}
+	}
+	return ids, nil
+}
+
+// expand 将多实例规则展开为每个目标实例一条，单实例规则原样返回。
+// 展开后的规则带有该实例上保存的云端规则ID和来源
+func (r *targetResolver) expand(ctx context.Context, rule *model.FirewallRule) ([]model.FirewallRule, error) {
+	if !rule.MultiInstance() {
+		return []model.FirewallRule{*rule}, nil
+	}
+	ids, err := r.targets(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		slog.Warn("rule matches no instances", "rule_id", rule.ID, "instance_selector", rule.InstanceSelector)
+	}
+	expanded := make([]model.FirewallRule, 0, len(ids))
+	for _, id := range ids {
+		target := *rule
+		target.InstanceID = id
+		target.RuleID, target.LastIP = "", ""
+		for _, state := range rule.Targets {
+			if state.CloudConfigID == rule.CloudConfigID && state.InstanceID == id {
+				target.RuleID, target.LastIP = state.CloudRuleID, state.LastIP
+			}
+		}
+		expanded = append(expanded, target)
+	}
+	return expanded, nil
+}
+
+// unresolvedRule 无法解析目标实例的规则
+type unresolvedRule struct {
+	rule model.FirewallRule
+	err  error
+}
+
+// expandTargets 将同步范围内的规则展开为逐实例的规则
+func (s *FirewallService) expandTargets(ctx context.Context, rules []model.FirewallRule) ([]model.FirewallRule, []unresolvedRule) {
+	resolver := s.newTargetResolver()
+	var expanded []model.FirewallRule
+	var unresolved []unresolvedRule
+	for i := range rules {
+		targets, err := resolver.expand(ctx, &rules[i])
+		if err != nil {
+			unresolved = append(unresolved, unresolvedRule{rule: rules[i], err: err})
+			continue
+		}
+		expanded = append(expanded, targets...)
+	}
+	return expanded, unresolved
+}
+
+// saveCloudState 保存规则在当前实例上的云端规则ID和来源。多实例规则的各实例共用一条规则记录，
+// 状态只保存在该实例的目标记录中，避免各实例互相覆盖
+func (s *FirewallService) saveCloudState(rule *model.FirewallRule) error {
+	err := s.repo.SaveTarget(&model.FirewallRuleTarget{
+		RuleID:        rule.ID,
+		CloudConfigID: rule.CloudConfigID,
+		Provider:      rule.Provider,
+		InstanceID:    rule.InstanceID,
+		CloudRuleID:   rule.RuleID,
+		LastIP:        rule.LastIP,
+	})
+	if err != nil || rule.MultiInstance() {
+		return err
+	}
+	return s.repo.UpdateCloudState(rule.ID, rule.RuleID, rule.LastIP)
+}
+
+// clearCloudState 规则已从当前实例上删除，清除该实例的云端状态
+func (s *FirewallService) clearCloudState(rule *model.FirewallRule) error {
+	rule.RuleID = ""
+	rule.LastIP = ""
+	err := s.repo.DeleteTarget(rule.ID, rule.CloudConfigID, rule.InstanceID)
+	if err != nil || rule.MultiInstance() {
+		return err
+	}
+	return s.repo.UpdateCloudState(rule.ID, rule.RuleID, rule.LastIP)
+}
+
+// pruneTargets 规则不再以某个实例为目标时（修改了实例列表、标签选择器或实例标签），
+// 删除规则在该实例上的云端规则和状态，并放弃该实例上未完成的操作。
+// 无法解析目标实例的规则不处理；删除失败时保留状态，下次同步时重试
+func (s *FirewallService) pruneTargets(ctx context.Context, rules, expanded []model.FirewallRule, unresolved []unresolvedRule, actor Actor, runID string) {
+	type targetKey struct {
+		ruleID        uint
+		cloudConfigID uint
+		instanceID    string
+	}
+	current := make(map[targetKey]bool)
+	for i := range expanded {
+		current[targetKey{expanded[i].ID, expanded[i].CloudConfigID, expanded[i].InstanceID}] = true
+	}
+	skip := make(map[uint]bool)
+	for i := range unresolved {
+		skip[unresolved[i].rule.ID] = true
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if skip[rule.ID] {
+			continue
+		}
+		for _, target := range rule.Targets {
+			if ctx.Err() != nil {
+				return
+			}
+			if current[targetKey{rule.ID, target.CloudConfigID, target.InstanceID}] {
+				continue
+			}
+			logger := runLogger(actor, runID).With("rule_id", rule.ID, "cloud_config_id", target.CloudConfigID, "instance_id", target.InstanceID)
+			if err := s.removeTarget(ctx, rule, target, actor, runID); err != nil {
+				logger.Error("failed to remove rule from instance no longer targeted", "error", err)
+				continue
+			}
+			logger.Info("removed rule from instance no longer targeted")
+		}
+	}
+}
+
+// removeTarget 删除规则在一个不再是其目标的实例上的所有匹配规则，放弃该实例上未完成的操作
+func (s *FirewallService) removeTarget(ctx context.Context, rule *model.FirewallRule, target model.FirewallRuleTarget, actor Actor, runID string) error {
+	stale := *rule
+	stale.CloudConfigID = target.CloudConfigID
+	stale.Provider = target.Provider
+	stale.InstanceID = target.InstanceID
+	stale.RuleID = target.CloudRuleID
+	stale.LastIP = target.LastIP
+
+	provider, err := s.instanceProvider(stale.Provider, stale.CloudConfigID, stale.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get cloud client: %v", err)
+	}
+	guard, err := s.instanceGuard(stale.CloudConfigID, stale.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.runs.LockInstance(ctx, stale.Provider, stale.InstanceID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	done, err := s.runs.BeginMutation()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// 该实例上中断的更新不再恢复，匹配的云端规则无论新旧都会被删除
+	if err := s.abandonInstanceOperations(&stale); err != nil {
+		return err
+	}
+
+	existing, err := provider.ListFirewallRules(ctx, stale.InstanceID)
+	if cloud.IsInstanceNotFound(err) {
+		// 实例已销毁，没有需要删除的云端规则
+		return s.repo.DeleteTarget(rule.ID, target.CloudConfigID, target.InstanceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list firewall rules: %v", err)
+	}
+	if err := s.deleteCloudRules(ctx, provider, &stale, guard.unprotected(existing), actor, runID); err != nil {
+		return err
+	}
+	key := instanceKey{stale.CloudConfigID, stale.Provider, stale.InstanceID}
+	if _, err := s.verifyCriticalPorts(ctx, provider, key, guard, existing, actor, runID); err != nil {
+		return err
+	}
+	return s.repo.DeleteTarget(rule.ID, target.CloudConfigID, target.InstanceID)
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/pkg/cloud"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError 单个字段的校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError 规则校验失败，Fields 列出所有不合法的字段
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// NewFieldError 返回只包含一个字段错误的校验错误
+func NewFieldError(field, message string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid rule: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// has 字段是否已有错误
+func (e *ValidationError) has(field string) bool {
+	return slices.ContainsFunc(e.Fields, func(f FieldError) bool { return f.Field == field })
+}
+
+// providerLimits 云服务商对防火墙规则的限制，0表示不限制
+type providerLimits struct {
+	protocols        []string
+	actions          []string
+	maxDescription   int  // 规则描述（即规则备注）的最大字符数
+	rulesPerInstance int  // 每个实例的最大规则数
+	ordering         bool // 云端规则按列表顺序匹配，同步时按优先级调整顺序
+}
+
+// 支持的协议，ICMP和ALL规则的端口固定为ALL
+var ruleProtocols = []string{"TCP", "UDP", "ICMP", "ALL"}
+
+// 支持的动作
+var ruleActions = []string{"ACCEPT", "DROP"}
+
+// 各云服务商的限制，未列出的云服务商只校验协议和动作，不支持优先级
+var providerCapabilities = map[string]providerLimits{
+	// Lighthouse：描述最长64个字符，每个实例最多100条防火墙规则，规则按列表顺序匹配
+	"TencentCloud": {protocols: ruleProtocols, actions: ruleActions, maxDescription: 64, rulesPerInstance: 100, ordering: true},
+	// 模拟云服务与Lighthouse的限制相同
+	cloud.ProviderFake: {protocols: ruleProtocols, actions: ruleActions, maxDescription: 64, rulesPerInstance: 100, ordering: true},
+}
+
+func limitsFor(provider string) providerLimits {
+	if limits, ok := providerCapabilities[provider]; ok {
+		return limits
+	}
+	return providerLimits{protocols: ruleProtocols, actions: ruleActions}
+}
+
+// normalizeAction 动作转为大写，默认ACCEPT
+func normalizeAction(action string) string {
+	action = strings.ToUpper(strings.TrimSpace(action))
+	if action == "" {
+		return "ACCEPT"
+	}
+	return action
+}
+
+// NormalizeSource 校验固定来源：IPv4地址转为/32，CIDR转为网络地址形式（如 10.0.0.1/8 转为 10.0.0.0/8），
+// 空字符串表示使用当前公网IP
+func NormalizeSource(source string) (string, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return "", nil
+	}
+	if !strings.Contains(source, "/") {
+		source += "/32"
+	}
+	ip, ipNet, err := net.ParseCIDR(source)
+	if err != nil || ip.To4() == nil {
+		return "", fmt.Errorf("invalid source %q, expected an IPv4 address or CIDR such as 0.0.0.0/0", source)
+	}
+	return ipNet.String(), nil
+}
+
+// NormalizePort 去掉空格并校验端口：ALL、单个端口、逗号分隔的离散端口或减号分隔的端口范围，
+// 离散端口和范围不能混用
+func NormalizePort(port string) (string, error) {
+	port = strings.ReplaceAll(strings.TrimSpace(port), " ", "")
+	if port == "" {
+		return "", fmt.Errorf("port is required")
+	}
+	if strings.EqualFold(port, "ALL") {
+		return "ALL", nil
+	}
+
+	if low, high, isRange := strings.Cut(port, "-"); isRange {
+		if !canonicalPort(low) || !canonicalPort(high) {
+			return "", fmt.Errorf("invalid port range %q, expected a range such as 8000-9000", port)
+		}
+		from, _ := strconv.Atoi(low)
+		to, _ := strconv.Atoi(high)
+		if from >= to {
+			return "", fmt.Errorf("port range %q must start below its end", port)
+		}
+		return port, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range strings.Split(port, ",") {
+		if !canonicalPort(p) {
+			return "", fmt.Errorf("invalid port %q, expected 1-65535, ALL, a comma-separated list or a range", p)
+		}
+		if seen[p] {
+			return "", fmt.Errorf("port %s is listed twice", p)
+		}
+		seen[p] = true
+	}
+	return port, nil
+}
+
+// canonicalPort 是否为1-65535之间且没有前导零的端口号，与云端返回的端口字符串一致
+func canonicalPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && validPort(port) && strconv.Itoa(n) == port
+}
+
+// ValidateRule 规范化并校验规则：云服务配置是否存在、协议、端口、动作、来源、优先级、备注长度、实例目标和探测配置，
+// 以及同一实例上的重复规则和云服务商的规则数上限。校验失败时返回 *ValidationError。
+func (s *FirewallService) ValidateRule(rule *model.FirewallRule) error {
+	verr := &ValidationError{}
+
+	switch {
+	case rule.CloudConfigID != 0:
+		if s.configService == nil {
+			return fmt.Errorf("config service not available")
+		}
+		if config, err := s.configService.GetCloudConfigByID(rule.CloudConfigID); err != nil {
+			verr.add("cloud_config_id", "cloud config %d does not exist", rule.CloudConfigID)
+		} else {
+			rule.Provider = config.Provider
+		}
+	case s.tencentClient == nil || rule.Provider != "TencentCloud":
+		// 只有配置文件中的全局腾讯云客户端可以不指定云服务配置
+		verr.add("cloud_config_id", "cloud_config_id is required")
+	}
+	limits := limitsFor(rule.Provider)
+
+	rule.Protocol = strings.ToUpper(strings.TrimSpace(rule.Protocol))
+	if rule.Protocol == "" {
+		rule.Protocol = "TCP"
+	}
+	switch {
+	case !slices.Contains(limits.protocols, rule.Protocol):
+		verr.add("protocol", "unsupported protocol %q, expected one of %s", rule.Protocol, strings.Join(limits.protocols, ", "))
+	case rule.Protocol == "ICMP" || rule.Protocol == "ALL":
+		rule.Port = "ALL"
+	default:
+		if port, err := NormalizePort(rule.Port); err != nil {
+			verr.add("port", "%v", err)
+		} else {
+			rule.Port = port
+		}
+	}
+
+	rule.Action = normalizeAction(rule.Action)
+	if !slices.Contains(limits.actions, rule.Action) {
+		verr.add("action", "unsupported action %q, expected one of %s", rule.Action, strings.Join(limits.actions, ", "))
+	}
+	if source, err := NormalizeSource(rule.Source); err != nil {
+		verr.add("source", "%v", err)
+	} else {
+		rule.Source = source
+	}
+	switch {
+	case rule.Priority < 0:
+		verr.add("priority", "priority must not be negative")
+	case rule.Priority != 0 && !limits.ordering:
+		verr.add("priority", "%s does not support rule ordering", rule.Provider)
+	}
+
+	rule.Remark = strings.TrimSpace(rule.Remark)
+	if rule.Remark == "" {
+		verr.add("remark", "remark is required")
+	} else if limits.maxDescription > 0 && utf8.RuneCountInString(rule.Remark) > limits.maxDescription {
+		verr.add("remark", "remark must be at most %d characters for %s", limits.maxDescription, rule.Provider)
+	}
+
+	validateTargets(rule, verr)
+	validateProbe(rule, verr)
+
+	// CVM安全组的规则顺序（策略索引）暂未实现
+	if rule.Priority != 0 && rule.Provider == "TencentCloud" {
+		for _, instanceID := range staticTargets(rule) {
+			if cloud.IsCVMInstance(instanceID) {
+				verr.add("priority", "rule ordering is not supported for CVM instance %s, priority must be 0", instanceID)
+				break
+			}
+		}
+	}
+
+	// 重复和数量检查依赖规范化后的协议、端口和实例
+	if !verr.has("protocol") && !verr.has("port") && !verr.has("instance_id") {
+		if err := s.checkInstanceRules(rule, limits, verr); err != nil {
+			return err
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// staticTargets 规则直接指定的实例，标签选择器匹配的实例每次同步时才能确定，不在其中
+func staticTargets(rule *model.FirewallRule) []string {
+	var targets []string
+	if rule.InstanceID != "" {
+		targets = append(targets, rule.InstanceID)
+	}
+	for _, id := range splitList(rule.InstanceIDs) {
+		if !slices.Contains(targets, id) {
+			targets = append(targets, id)
+		}
+	}
+	return targets
+}
+
+// checkInstanceRules 检查规则直接指定的实例上是否已有协议、端口和备注都相同的规则（云端按这些字段匹配规则，
+// 重复的规则会互相覆盖），以及保存后实例上的规则数是否超过云服务商的上限。规则数包括云端不由 FireFlow
+// 管理的规则（如在控制台创建的规则）；无法查询云端规则时只按 FireFlow 管理的规则计算。
+// 标签选择器匹配的实例在同步时才能确定，不参与检查。
+func (s *FirewallService) checkInstanceRules(rule *model.FirewallRule, limits providerLimits, verr *ValidationError) error {
+	targets := staticTargets(rule)
+	if len(targets) == 0 {
+		return nil
+	}
+	existing, err := s.repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %v", err)
+	}
+
+	counts := make(map[string]int, len(targets))
+	for i := range existing {
+		other := &existing[i]
+		if other.ID == rule.ID || other.CloudConfigID != rule.CloudConfigID {
+			continue
+		}
+		for _, instanceID := range staticTargets(other) {
+			if !slices.Contains(targets, instanceID) {
+				continue
+			}
+			counts[instanceID]++
+			if strings.EqualFold(other.Protocol, rule.Protocol) && other.Port == rule.Port && other.Remark == rule.Remark {
+				verr.add("remark", "rule %d on instance %s already uses protocol %s, port %s and this remark", other.ID, instanceID, rule.Protocol, rule.Port)
+			}
+		}
+	}
+
+	if limits.rulesPerInstance > 0 {
+		for _, instanceID := range targets {
+			unmanaged, err := s.unmanagedCloudRules(rule, instanceID, existing)
+			if err != nil {
+				slog.Warn("failed to list cloud rules, checking the rule limit against FireFlow rules only", "instance_id", instanceID, "error", err)
+			}
+			if total := counts[instanceID] + unmanaged; total >= limits.rulesPerInstance {
+				verr.add("instance_id", "instance %s already has %d rules (%d not managed by FireFlow), the %s limit is %d", instanceID, total, unmanaged, rule.Provider, limits.rulesPerInstance)
+			}
+		}
+	}
+	return nil
+}
+
+// unmanagedCloudRules 统计实例上不对应任何 FireFlow 规则的云端规则数。按标签选择器匹配到该实例的
+// 规则同样计入，它们不在按规则直接指定的实例统计的数量中。
+func (s *FirewallService) unmanagedCloudRules(rule *model.FirewallRule, instanceID string, existing []model.FirewallRule) (int, error) {
+	provider, err := s.instanceProvider(rule.Provider, rule.CloudConfigID, instanceID)
+	if err != nil {
+		return 0, err
+	}
+	cloudRules, err := provider.ListFirewallRules(context.Background(), instanceID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, r := range cloudRules {
+		managed := false
+		for i := range existing {
+			other := &existing[i]
+			// 规则修改前的云端规则将被替换，同样不计入
+			if other.CloudConfigID == rule.CloudConfigID && slices.Contains(staticTargets(other), instanceID) && matchesRule(r, other) {
+				managed = true
+				break
+			}
+		}
+		if !managed {
+			count++
+		}
+	}
+	return count, nil
+}
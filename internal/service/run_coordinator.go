@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRunCancelled 全量同步被手动取消
+	ErrRunCancelled = errors.New("sync run cancelled")
+	// ErrRunNotFound 指定的全量同步不存在或已结束
+	ErrRunNotFound = errors.New("sync run not found")
+	// ErrShuttingDown 服务正在关闭，不再开始新的同步和规则变更
+	ErrShuttingDown = errors.New("service is shutting down")
+)
+
+// 关闭超时后取消同步，再等待其记录结果的最长时间
+const drainGracePeriod = 5 * time.Second
+
+// RunInProgressError 已有全量同步正在运行时返回
+type RunInProgressError struct {
+	RunID     string
+	StartedAt time.Time
+}
+
+func (e *RunInProgressError) Error() string {
+	return fmt.Sprintf("sync run %s is already running (started at %s)", e.RunID, e.StartedAt.Format(time.RFC3339))
+}
+
+// RunInfo 正在运行的同步任务信息
+type RunInfo struct {
+	RunID     string    `json:"run_id"`
+	Trigger   string    `json:"trigger"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// RunCoordinator 协调定时任务、手动同步和单条规则执行：
+// 同一时间只允许一个全量同步，同一实例上的规则变更串行执行。
+// 服务关闭时拒绝新的同步和变更，并等待进行中的变更完成。
+type RunCoordinator struct {
+	mu        sync.Mutex
+	active    *RunInfo
+	cancel    context.CancelCauseFunc
+	instances map[string]chan struct{}
+
+	// 服务关闭中，inflight 为进行中的全量同步和云端规则变更
+	draining bool
+	inflight sync.WaitGroup
+}
+
+func NewRunCoordinator() *RunCoordinator {
+	return &RunCoordinator{
+		instances: make(map[string]chan struct{}),
+	}
+}
+
+// BeginFullSync 开始一次全量同步，返回的context在同步被取消或ctx结束时结束。
+// 已有同步在运行时返回 RunInProgressError。
+func (rc *RunCoordinator) BeginFullSync(ctx context.Context, trigger string) (*RunInfo, context.Context, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.draining {
+		return nil, nil, ErrShuttingDown
+	}
+	if rc.active != nil {
+		return nil, nil, &RunInProgressError{RunID: rc.active.RunID, StartedAt: rc.active.StartedAt}
+	}
+
+	rc.inflight.Add(1)
+	runCtx, cancel := context.WithCancelCause(ctx)
+	rc.active = &RunInfo{
+		RunID:     newRunID(),
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+	rc.cancel = cancel
+	return rc.active, runCtx, nil
+}
+
+// EndFullSync 结束指定的全量同步并释放其context
+func (rc *RunCoordinator) EndFullSync(runID string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.active != nil && rc.active.RunID == runID {
+		rc.cancel(nil)
+		rc.active = nil
+		rc.cancel = nil
+		rc.inflight.Done()
+	}
+}
+
+// CancelFullSync 取消正在运行的全量同步，正在进行的云服务API调用随之中止。
+// 指定的同步不存在或已结束时返回 ErrRunNotFound。
+func (rc *RunCoordinator) CancelFullSync(runID string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.active == nil || rc.active.RunID != runID {
+		return ErrRunNotFound
+	}
+	rc.cancel(ErrRunCancelled)
+	return nil
+}
+
+// BeginMutation 登记一次云端规则变更（如先建后删的更新），服务关闭时返回 ErrShuttingDown。
+// 变更完成后须调用返回的函数。
+func (rc *RunCoordinator) BeginMutation() (func(), error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.draining {
+		return nil, ErrShuttingDown
+	}
+	rc.inflight.Add(1)
+	return rc.inflight.Done, nil
+}
+
+// Draining 服务是否正在关闭
+func (rc *RunCoordinator) Draining() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.draining
+}
+
+// Drain 拒绝新的同步和规则变更，等待进行中的变更及全量同步结束。
+// ctx先结束时以 ErrShuttingDown 取消正在运行的全量同步，并返回ctx的错误。
+func (rc *RunCoordinator) Drain(ctx context.Context) error {
+	rc.mu.Lock()
+	rc.draining = true
+	rc.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		rc.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		rc.mu.Lock()
+		if rc.cancel != nil {
+			rc.cancel(ErrShuttingDown)
+		}
+		rc.mu.Unlock()
+		// 给被取消的同步留出记录结果的时间
+		select {
+		case <-done:
+		case <-time.After(drainGracePeriod):
+		}
+		return ctx.Err()
+	}
+}
+
+// ActiveRun 返回当前正在运行的全量同步
+func (rc *RunCoordinator) ActiveRun() (RunInfo, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.active == nil {
+		return RunInfo{}, false
+	}
+	return *rc.active, true
+}
+
+// LockInstance 获取实例的变更锁，其他对同一实例的变更会排队等待，返回释放函数。
+// 等待期间ctx结束时放弃加锁并返回错误。
+func (rc *RunCoordinator) LockInstance(ctx context.Context, provider, instanceID string) (func(), error) {
+	key := provider + "/" + instanceID
+
+	rc.mu.Lock()
+	lock, ok := rc.instances[key]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		rc.instances[key] = lock
+	}
+	rc.mu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// newRunID 生成同步任务ID，如 run-20250101-120000-1a2b3c
+func newRunID() string {
+	buf := make([]byte, 3)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("run-%s", time.Now().Format("20060102-150405.000000"))
+	}
+	return fmt.Sprintf("run-%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(buf))
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"FireFlow/pkg/cloud"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrLockoutPrevented 变更后关键端口不再有ACCEPT规则，变更已中止并回滚
+var ErrLockoutPrevented = errors.New("change would remove access to critical ports")
+
+// protectedSource 受保护的来源，Port为空表示该来源的所有端口
+type protectedSource struct {
+	CidrBlock string
+	Port      string
+}
+
+// criticalPort 必须保持可访问的端口
+type criticalPort struct {
+	Protocol string
+	Port     string
+}
+
+func (p criticalPort) String() string {
+	return p.Protocol + ":" + p.Port
+}
+
+// InstanceGuard 解析后的实例保护配置，nil表示未配置保护
+type InstanceGuard struct {
+	protected []protectedSource
+	critical  []criticalPort
+}
+
+type SafeguardService interface {
+	ListSafeguards() ([]model.InstanceSafeguard, error)
+	GetSafeguard(id uint) (*model.InstanceSafeguard, error)
+	CreateSafeguard(safeguard *model.InstanceSafeguard) error
+	UpdateSafeguard(safeguard *model.InstanceSafeguard) error
+	DeleteSafeguard(id uint) error
+	// Guard 返回实例的保护配置，未配置时返回nil
+	Guard(cloudConfigID uint, instanceID string) (*InstanceGuard, error)
+}
+
+type safeguardService struct {
+	repo repository.SafeguardRepository
+}
+
+func NewSafeguardService(repo repository.SafeguardRepository) SafeguardService {
+	return &safeguardService{repo: repo}
+}
+
+func (s *safeguardService) ListSafeguards() ([]model.InstanceSafeguard, error) {
+	return s.repo.List()
+}
+
+func (s *safeguardService) GetSafeguard(id uint) (*model.InstanceSafeguard, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *safeguardService) CreateSafeguard(safeguard *model.InstanceSafeguard) error {
+	if _, err := parseSafeguard(safeguard); err != nil {
+		return err
+	}
+	return s.repo.Create(safeguard)
+}
+
+func (s *safeguardService) UpdateSafeguard(safeguard *model.InstanceSafeguard) error {
+	existing, err := s.repo.GetByID(safeguard.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := parseSafeguard(safeguard); err != nil {
+		return err
+	}
+	safeguard.CreatedAt = existing.CreatedAt
+	return s.repo.Update(safeguard)
+}
+
+func (s *safeguardService) DeleteSafeguard(id uint) error {
+	return s.repo.Delete(id)
+}
+
+func (s *safeguardService) Guard(cloudConfigID uint, instanceID string) (*InstanceGuard, error) {
+	safeguard, err := s.repo.GetByInstance(cloudConfigID, instanceID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load instance safeguard: %v", err)
+	}
+	return parseSafeguard(safeguard)
+}
+
+// parseSafeguard 校验并解析保护配置
+func parseSafeguard(safeguard *model.InstanceSafeguard) (*InstanceGuard, error) {
+	safeguard.InstanceID = strings.TrimSpace(safeguard.InstanceID)
+	if safeguard.CloudConfigID == 0 || safeguard.InstanceID == "" {
+		return nil, fmt.Errorf("cloud_config_id and instance_id are required")
+	}
+
+	guard := &InstanceGuard{}
+	for _, entry := range splitList(safeguard.ProtectedSources) {
+		cidr, port, _ := strings.Cut(entry, ":")
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid protected source %q: %v", entry, err)
+		}
+		if port != "" && !validPort(port) {
+			return nil, fmt.Errorf("invalid port in protected source %q", entry)
+		}
+		guard.protected = append(guard.protected, protectedSource{CidrBlock: cidr, Port: port})
+	}
+	for _, entry := range splitList(safeguard.CriticalPorts) {
+		protocol, port, found := strings.Cut(entry, ":")
+		if !found {
+			protocol, port = "TCP", entry
+		}
+		protocol = strings.ToUpper(protocol)
+		if protocol != "TCP" && protocol != "UDP" {
+			return nil, fmt.Errorf("invalid protocol in critical port %q", entry)
+		}
+		if !validPort(port) {
+			return nil, fmt.Errorf("invalid critical port %q", entry)
+		}
+		guard.critical = append(guard.critical, criticalPort{Protocol: protocol, Port: port})
+	}
+	return guard, nil
+}
+
+// splitList 拆分逗号分隔的配置，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+// portCovers 云端规则的端口（ALL、单个端口、逗号分隔的端口或a-b范围）是否包含port
+func portCovers(rulePort, port string) bool {
+	if strings.EqualFold(rulePort, "ALL") {
+		return true
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(rulePort, ",") {
+		part = strings.TrimSpace(part)
+		low, high, isRange := strings.Cut(part, "-")
+		if !isRange {
+			high = low
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(low))
+		to, err2 := strconv.Atoi(strings.TrimSpace(high))
+		if err1 == nil && err2 == nil && from <= n && n <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// protects 云端规则是否受保护，受保护的规则不参与规则匹配，FireFlow 不会删除或修改它们
+func (g *InstanceGuard) protects(r *cloud.FirewallRuleResult) bool {
+	if g == nil {
+		return false
+	}
+	for _, p := range g.protected {
+		if r.CidrBlock == p.CidrBlock && (p.Port == "" || portCovers(r.Port, p.Port)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unprotected 返回去掉受保护规则后的云端规则列表。总是返回新的切片，
+// 之后对其增删不影响调用方保留的变更前列表。
+func (g *InstanceGuard) unprotected(rules []*cloud.FirewallRuleResult) []*cloud.FirewallRuleResult {
+	visible := make([]*cloud.FirewallRuleResult, 0, len(rules))
+	for _, r := range rules {
+		if !g.protects(r) {
+			visible = append(visible, r)
+		}
+	}
+	return visible
+}
+
+// hasCriticalPorts 是否配置了需要在变更后验证的关键端口
+func (g *InstanceGuard) hasCriticalPorts() bool {
+	return g != nil && len(g.critical) > 0
+}
+
+// uncoveredPorts 返回没有任何ACCEPT规则放行的关键端口。规则按列表顺序匹配，
+// 排在所有ACCEPT规则之前、来源为 0.0.0.0/0 的DROP规则会拒绝所有来源，端口同样视为未放行。
+func (g *InstanceGuard) uncoveredPorts(rules []*cloud.FirewallRuleResult) []criticalPort {
+	if g == nil {
+		return nil
+	}
+	var missing []criticalPort
+	for _, p := range g.critical {
+		covered := false
+		for _, r := range rules {
+			if !(strings.EqualFold(r.Protocol, p.Protocol) || strings.EqualFold(r.Protocol, "ALL")) || !portCovers(r.Port, p.Port) {
+				continue
+			}
+			if strings.EqualFold(r.Action, "ACCEPT") {
+				covered = true
+				break
+			}
+			if r.CidrBlock == "0.0.0.0/0" {
+				break
+			}
+		}
+		if !covered {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"FireFlow/internal/model"
+	"FireFlow/internal/repository"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidRole     = errors.New("invalid role, must be one of admin, operator, viewer")
+	ErrLastAdmin       = errors.New("at least one enabled admin is required")
+	ErrCannotEditSelf  = errors.New("cannot change your own role, status or delete yourself")
+	ErrUsernameInvalid = errors.New("username is required")
+)
+
+// UserInfo 用户及其可访问的云服务配置范围
+type UserInfo struct {
+	model.User
+	CloudConfigIDs []uint `json:"cloud_config_ids"` // 为空表示可访问全部云服务配置
+}
+
+// UserInput 创建或修改用户的参数，修改时为nil的字段保持不变
+type UserInput struct {
+	Username       string  `json:"username"`
+	Password       string  `json:"password"`
+	Role           string  `json:"role"`
+	IsEnabled      *bool   `json:"is_enabled"`
+	CloudConfigIDs *[]uint `json:"cloud_config_ids"`
+}
+
+type UserService interface {
+	ListUsers() ([]UserInfo, error)
+	GetUser(id uint) (*UserInfo, error)
+	CreateUser(input *UserInput) (*UserInfo, error)
+	UpdateUser(actorID, id uint, input *UserInput) (*UserInfo, error)
+	DeleteUser(actorID, id uint) error
+}
+
+type userService struct {
+	userRepo      repository.UserRepository
+	configService ConfigService
+}
+
+func NewUserService(userRepo repository.UserRepository, configService ConfigService) UserService {
+	return &userService{
+		userRepo:      userRepo,
+		configService: configService,
+	}
+}
+
+func (s *userService) ListUsers() ([]UserInfo, error) {
+	users, err := s.userRepo.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]UserInfo, 0, len(users))
+	for _, user := range users {
+		scopes, err := s.userRepo.ListUserScopes(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, UserInfo{User: user, CloudConfigIDs: scopes})
+	}
+	return result, nil
+}
+
+func (s *userService) GetUser(id uint) (*UserInfo, error) {
+	user, err := s.userRepo.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := s.userRepo.ListUserScopes(id)
+	if err != nil {
+		return nil, err
+	}
+	return &UserInfo{User: *user, CloudConfigIDs: scopes}, nil
+}
+
+func (s *userService) CreateUser(input *UserInput) (*UserInfo, error) {
+	username := strings.TrimSpace(input.Username)
+	if username == "" {
+		return nil, ErrUsernameInvalid
+	}
+	role := input.Role
+	if role == "" {
+		role = model.RoleViewer
+	}
+	if !ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	scopes := []uint{}
+	if input.CloudConfigIDs != nil {
+		var err error
+		if scopes, err = s.normalizeScopes(*input.CloudConfigIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := hashPassword(input.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		IsEnabled:    input.IsEnabled == nil || *input.IsEnabled,
+	}
+	if err := s.userRepo.CreateUser(user); err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.SetUserScopes(user.ID, scopes); err != nil {
+		return nil, err
+	}
+
+	return &UserInfo{User: *user, CloudConfigIDs: scopes}, nil
+}
+
+// UpdateUser 修改用户角色、状态、访问范围或重置密码，不允许修改自己的角色和状态，也不允许移除最后一个管理员
+func (s *userService) UpdateUser(actorID, id uint, input *UserInput) (*UserInfo, error) {
+	user, err := s.userRepo.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	newRole := user.Role
+	if input.Role != "" {
+		if !ValidRole(input.Role) {
+			return nil, ErrInvalidRole
+		}
+		newRole = input.Role
+	}
+	newEnabled := user.IsEnabled
+	if input.IsEnabled != nil {
+		newEnabled = *input.IsEnabled
+	}
+
+	if actorID == id && (newRole != user.Role || newEnabled != user.IsEnabled) {
+		return nil, ErrCannotEditSelf
+	}
+	if user.Role == model.RoleAdmin && user.IsEnabled && (newRole != model.RoleAdmin || !newEnabled) {
+		if err := s.ensureAnotherAdmin(); err != nil {
+			return nil, err
+		}
+	}
+
+	var scopes []uint
+	if input.CloudConfigIDs != nil {
+		if scopes, err = s.normalizeScopes(*input.CloudConfigIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	revokeSessions := !newEnabled && user.IsEnabled
+	user.Role = newRole
+	user.IsEnabled = newEnabled
+	if input.Password != "" {
+		hash, err := hashPassword(input.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+		revokeSessions = true
+	}
+
+	if err := s.userRepo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	if input.CloudConfigIDs != nil {
+		if err := s.userRepo.SetUserScopes(user.ID, scopes); err != nil {
+			return nil, err
+		}
+	}
+	// 禁用用户或重置密码后使其已登录会话失效
+	if revokeSessions {
+		if err := s.userRepo.DeleteSessionsByUser(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if scopes, err = s.userRepo.ListUserScopes(user.ID); err != nil {
+		return nil, err
+	}
+	return &UserInfo{User: *user, CloudConfigIDs: scopes}, nil
+}
+
+func (s *userService) DeleteUser(actorID, id uint) error {
+	if actorID == id {
+		return ErrCannotEditSelf
+	}
+
+	user, err := s.userRepo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == model.RoleAdmin && user.IsEnabled {
+		if err := s.ensureAnotherAdmin(); err != nil {
+			return err
+		}
+	}
+	return s.userRepo.DeleteUser(id)
+}
+
+// ensureAnotherAdmin 确认移除一个管理员后仍有其他已启用的管理员
+func (s *userService) ensureAnotherAdmin() error {
+	admins, err := s.userRepo.CountUsersByRole(model.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// normalizeScopes 去重并校验云服务配置是否存在
+func (s *userService) normalizeScopes(ids []uint) ([]uint, error) {
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if slices.Contains(result, id) {
+			continue
+		}
+		if _, err := s.configService.GetCloudConfigByID(id); err != nil {
+			return nil, fmt.Errorf("cloud config %d not found", id)
+		}
+		result = append(result, id)
+	}
+	slices.Sort(result)
+	return result, nil
+}
@@ -1,32 +1,52 @@
-package utils
-
-import (
-	"io"
-	"net/http"
-	"strings"
-)
-
-// GetPublicIP fetches the public IP from an external service.
-func GetPublicIP() (string, error) {
-	return GetPublicIPWithURL("https://4.ipw.cn")
-}
-
-// GetPublicIPWithURL fetches the public IP from a specified URL.
-func GetPublicIPWithURL(url string) (string, error) {
-	if url == "" {
-		url = "https://4.ipw.cn" // 默认URL
-	}
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	ip, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return strings.TrimSpace(string(ip)), nil
-}
+package utils
+
+import (
+	"FireFlow/internal/metrics"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 单次公网IP查询的超时
+const ipFetchTimeout = 10 * time.Second
+
+// GetPublicIP fetches the public IP from an external service.
+func GetPublicIP(ctx context.Context) (string, error) {
+	return GetPublicIPWithURL(ctx, "https://4.ipw.cn")
+}
+
+// GetPublicIPWithURL fetches the public IP from a specified URL.
+// ctx取消或超过 ipFetchTimeout 时请求中止。
+func GetPublicIPWithURL(ctx context.Context, url string) (string, error) {
+	if url == "" {
+		url = "https://4.ipw.cn" // 默认URL
+	}
+
+	start := time.Now()
+	defer metrics.ObserveSince(metrics.IPFetchDuration, start)
+
+	ctx, cancel := context.WithTimeout(ctx, ipFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		metrics.IPFetchFailures.WithLabelValues(metrics.IPFetchRequest).Inc()
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		metrics.IPFetchFailures.WithLabelValues(metrics.IPFetchRequest).Inc()
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	ip, err := io.ReadAll(resp.Body)
+	if err != nil {
+		metrics.IPFetchFailures.WithLabelValues(metrics.IPFetchRequest).Inc()
+		return "", err
+	}
+
+	return strings.TrimSpace(string(ip)), nil
+}
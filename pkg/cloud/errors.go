@@ -0,0 +1,57 @@
+package cloud
+
+import (
+	stderrors "errors"
+	"strings"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// 表示访问密钥无效或权限不足的错误码前缀
+var credentialErrorCodes = []string{"AuthFailure", "UnauthorizedOperation"}
+
+// IsCredentialError 判断错误是否由访问密钥无效或权限不足引起
+func IsCredentialError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var sdkErr *errors.TencentCloudSDKError
+	if stderrors.As(err, &sdkErr) {
+		return hasCredentialCode(sdkErr.GetCode())
+	}
+
+	// 客户端返回的错误多为格式化后的文本，如 "Code=AuthFailure.SecretIdNotFound"
+	message := err.Error()
+	for _, code := range credentialErrorCodes {
+		if strings.Contains(message, "Code="+code) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCredentialCode(code string) bool {
+	for _, prefix := range credentialErrorCodes {
+		if strings.HasPrefix(code, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 表示实例不存在的错误码
+const instanceNotFoundCode = "ResourceNotFound.InstanceIdNotFound"
+
+// IsInstanceNotFound 判断错误是否由实例不存在（已销毁或不在该区域）引起
+func IsInstanceNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var sdkErr *errors.TencentCloudSDKError
+	if stderrors.As(err, &sdkErr) {
+		return sdkErr.GetCode() == instanceNotFoundCode
+	}
+	return strings.Contains(err.Error(), "Code="+instanceNotFoundCode)
+}
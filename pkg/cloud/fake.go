@@ -0,0 +1,424 @@
+package cloud
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// ProviderFake 内存中的模拟云服务，用于本地演示和集成测试，不访问网络
+const ProviderFake = "Fake"
+
+// 模拟云服务每个实例最多的防火墙规则数，与Lighthouse一致
+const fakeRulesPerInstance = 100
+
+// FakeConfig 模拟云服务的配置，保存在云服务配置的 Extra 字段（JSON）中
+type FakeConfig struct {
+	Instances []FakeInstance `json:"instances"` // 账号下的实例，云服务配置的默认实例总会存在
+	Faults    FakeFaults     `json:"faults"`
+}
+
+// FakeInstance 模拟的实例
+type FakeInstance struct {
+	InstanceID string            `json:"instance_id"`
+	Name       string            `json:"name"`
+	Region     string            `json:"region"`    // 为空时为云服务配置的默认区域
+	PublicIP   string            `json:"public_ip"` // 为空时按实例ID生成一个文档用地址（203.0.113.0/24）
+	Product    string            `json:"product"`   // lighthouse（默认）或 cvm，cvm实例不支持管理防火墙规则
+	Tags       map[string]string `json:"tags"`
+}
+
+// FakeFaults 故障注入。Operations 和 Instances 为空时对所有操作和实例生效
+type FakeFaults struct {
+	Latency          string   `json:"latency"`            // 每次调用的延迟，如 "500ms"
+	ErrorRate        float64  `json:"error_rate"`         // 注入错误的概率，0-1
+	ErrorCode        string   `json:"error_code"`         // 注入的错误码，默认 InternalError；AuthFailure 开头的错误码视为凭证失效
+	Operations       []string `json:"operations"`         // 注入错误的操作，如 CreateFirewallRule、DeleteFirewallRule
+	Instances        []string `json:"instances"`          // 注入错误的实例，用于模拟部分实例失败
+	ApplyBeforeError bool     `json:"apply_before_error"` // 先执行变更再返回错误，模拟超时但云端已生效
+
+	latency time.Duration
+}
+
+// ParseFakeConfig 解析云服务配置的 Extra 字段，空字符串表示默认配置
+func ParseFakeConfig(extra string) (*FakeConfig, error) {
+	config := &FakeConfig{}
+	if strings.TrimSpace(extra) != "" {
+		if err := json.Unmarshal([]byte(extra), config); err != nil {
+			return nil, fmt.Errorf("invalid fake provider config: %v", err)
+		}
+	}
+	faults := &config.Faults
+	if faults.Latency != "" {
+		latency, err := time.ParseDuration(faults.Latency)
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("invalid fake provider latency %q", faults.Latency)
+		}
+		faults.latency = latency
+	}
+	if faults.ErrorRate < 0 || faults.ErrorRate > 1 {
+		return nil, fmt.Errorf("fake provider error_rate must be between 0 and 1")
+	}
+	if faults.ErrorCode == "" {
+		faults.ErrorCode = "InternalError"
+	}
+	for _, instance := range config.Instances {
+		if instance.InstanceID == "" {
+			return nil, fmt.Errorf("fake provider instances require instance_id")
+		}
+		if instance.Product != "" && instance.Product != ProductLighthouse && instance.Product != ProductCVM {
+			return nil, fmt.Errorf("unsupported fake instance product %q", instance.Product)
+		}
+	}
+	return config, nil
+}
+
+// fakeAccount 一个模拟账号的状态，同一账号的所有客户端共享，配置更新后重建客户端时规则不会丢失
+type fakeAccount struct {
+	mu        sync.Mutex
+	instances map[string]*FakeInstance
+	firewalls map[string][]*FirewallRuleResult
+}
+
+var (
+	fakeAccountsMu sync.Mutex
+	fakeAccounts   = make(map[string]*fakeAccount)
+)
+
+// FakeProvider 模拟云服务在一个区域的客户端，实现 CloudProvider
+type FakeProvider struct {
+	account *fakeAccount
+	region  string
+	faults  FakeFaults
+}
+
+// NewFakeProvider 创建模拟云服务在region的客户端。account标识账号，相同账号共享实例和防火墙规则；
+// config中的实例加入账号（已有的实例更新名称、标签等属性），防火墙规则保持不变。
+// 未指定区域的实例属于region。
+func NewFakeProvider(account, region string, config *FakeConfig) *FakeProvider {
+	fakeAccountsMu.Lock()
+	state, ok := fakeAccounts[account]
+	if !ok {
+		state = &fakeAccount{
+			instances: make(map[string]*FakeInstance),
+			firewalls: make(map[string][]*FirewallRuleResult),
+		}
+		fakeAccounts[account] = state
+	}
+	fakeAccountsMu.Unlock()
+
+	state.mu.Lock()
+	for _, instance := range config.Instances {
+		if instance.Region == "" {
+			instance.Region = region
+		}
+		if instance.Product == "" {
+			instance.Product = ProductLighthouse
+		}
+		if instance.PublicIP == "" {
+			sum := md5.Sum([]byte(instance.InstanceID))
+			instance.PublicIP = fmt.Sprintf("203.0.113.%d", sum[0]%254+1)
+		}
+		state.instances[instance.InstanceID] = &instance
+	}
+	state.mu.Unlock()
+
+	return &FakeProvider{account: state, region: region, faults: config.Faults}
+}
+
+// call 执行一次模拟的API调用：等待延迟，按配置注入错误，apply在账号锁内修改或读取状态
+func (p *FakeProvider) call(ctx context.Context, operation, instanceID string, apply func() error) error {
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+
+	start := time.Now()
+	err := p.invoke(callCtx, operation, instanceID, apply)
+	observeAPICall(ProviderFake, "fake:"+operation, start, err)
+	return err
+}
+
+func (p *FakeProvider) invoke(ctx context.Context, operation, instanceID string, apply func() error) error {
+	if p.faults.latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.faults.latency):
+		}
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fault := p.fault(operation, instanceID)
+	if fault != nil && !p.faults.ApplyBeforeError {
+		return fault
+	}
+
+	p.account.mu.Lock()
+	err := apply()
+	p.account.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return fault
+}
+
+// fault 按故障注入配置决定本次调用是否失败
+func (p *FakeProvider) fault(operation, instanceID string) error {
+	f := p.faults
+	if f.ErrorRate <= 0 {
+		return nil
+	}
+	if len(f.Operations) > 0 && !slices.Contains(f.Operations, operation) {
+		return nil
+	}
+	if len(f.Instances) > 0 && (instanceID == "" || !slices.Contains(f.Instances, instanceID)) {
+		return nil
+	}
+	if rand.Float64() >= f.ErrorRate {
+		return nil
+	}
+	return fakeError(f.ErrorCode, fmt.Sprintf("injected failure for %s", operation))
+}
+
+func fakeError(code, message string) error {
+	return errors.NewTencentCloudSDKError(code, message, "fake")
+}
+
+// instance 返回当前区域内的实例，调用方需持有账号锁
+func (p *FakeProvider) instance(instanceID string) (*FakeInstance, error) {
+	instance, ok := p.account.instances[instanceID]
+	if !ok || instance.Region != p.region {
+		return nil, fakeError("ResourceNotFound.InstanceIdNotFound", fmt.Sprintf("instance %s not found in region %s", instanceID, p.region))
+	}
+	return instance, nil
+}
+
+// firewallInstance 返回可以管理防火墙规则的实例，调用方需持有账号锁
+func (p *FakeProvider) firewallInstance(instanceID string) error {
+	instance, err := p.instance(instanceID)
+	if err != nil {
+		return err
+	}
+	if instance.Product != ProductLighthouse {
+		return fakeError("UnsupportedOperation", fmt.Sprintf("firewall rules of %s instance %s are not supported", instance.Product, instanceID))
+	}
+	return nil
+}
+
+func (p *FakeProvider) instanceInfo(instance *FakeInstance) *InstanceInfo {
+	return &InstanceInfo{
+		InstanceID:        instance.InstanceID,
+		InstanceName:      instance.Name,
+		Status:            "RUNNING",
+		PublicIP:          instance.PublicIP,
+		Provider:          ProviderFake,
+		Product:           instance.Product,
+		Region:            instance.Region,
+		Tags:              instance.Tags,
+		FirewallSupported: instance.Product == ProductLighthouse,
+	}
+}
+
+// regionInstances 当前区域的实例，按实例ID排序，调用方需持有账号锁
+func (p *FakeProvider) regionInstances(firewallOnly bool) []*InstanceInfo {
+	var instances []*InstanceInfo
+	for _, instance := range p.account.instances {
+		if instance.Region != p.region || (firewallOnly && instance.Product != ProductLighthouse) {
+			continue
+		}
+		instances = append(instances, p.instanceInfo(instance))
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+	return instances
+}
+
+func (p *FakeProvider) GetInstance(ctx context.Context, instanceID string) (*InstanceInfo, error) {
+	var info *InstanceInfo
+	err := p.call(ctx, "GetInstance", instanceID, func() error {
+		instance, err := p.instance(instanceID)
+		if err != nil {
+			return err
+		}
+		info = p.instanceInfo(instance)
+		return nil
+	})
+	return info, err
+}
+
+func (p *FakeProvider) ListInstances(ctx context.Context) ([]*InstanceInfo, error) {
+	var instances []*InstanceInfo
+	err := p.call(ctx, "ListInstances", "", func() error {
+		instances = p.regionInstances(true)
+		return nil
+	})
+	return instances, err
+}
+
+func (p *FakeProvider) ListAllInstances(ctx context.Context) ([]*InstanceInfo, error) {
+	var instances []*InstanceInfo
+	err := p.call(ctx, "ListAllInstances", "", func() error {
+		instances = p.regionInstances(false)
+		return nil
+	})
+	return instances, err
+}
+
+func (p *FakeProvider) ListRegions(ctx context.Context) ([]string, error) {
+	var regions []string
+	err := p.call(ctx, "ListRegions", "", func() error {
+		regions = []string{p.region}
+		for _, instance := range p.account.instances {
+			if !slices.Contains(regions, instance.Region) {
+				regions = append(regions, instance.Region)
+			}
+		}
+		sort.Strings(regions)
+		return nil
+	})
+	return regions, err
+}
+
+// fakeRuleID 使用规则内容生成稳定的ID
+func fakeRuleID(r *FirewallRuleResult) string {
+	content := fmt.Sprintf("%s-%s-%s-%s", r.Protocol, r.Port, r.CidrBlock, r.Action)
+	return fmt.Sprintf("fake-%x", md5.Sum([]byte(content)))
+}
+
+// sameRule 规则内容是否相同（协议和动作不区分大小写）
+func sameRule(a, b *FirewallRuleResult) bool {
+	return strings.EqualFold(a.Protocol, b.Protocol) && a.Port == b.Port && a.CidrBlock == b.CidrBlock &&
+		strings.EqualFold(a.Action, b.Action) && a.Description == b.Description
+}
+
+func (p *FakeProvider) CreateFirewallRule(ctx context.Context, instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
+	created := &FirewallRuleResult{
+		Port:        rule.Port,
+		Protocol:    strings.ToUpper(rule.Protocol),
+		CidrBlock:   rule.CidrBlock,
+		Action:      strings.ToUpper(rule.Action),
+		Description: rule.Description,
+		Provider:    ProviderFake,
+		InstanceID:  instanceID,
+	}
+	created.RuleID = fakeRuleID(created)
+
+	err := p.call(ctx, "CreateFirewallRule", instanceID, func() error {
+		if err := p.firewallInstance(instanceID); err != nil {
+			return err
+		}
+		rules := p.account.firewalls[instanceID]
+		if len(rules) >= fakeRulesPerInstance {
+			return fakeError("LimitExceeded.FirewallRulesLimitExceeded", fmt.Sprintf("instance %s already has %d firewall rules", instanceID, len(rules)))
+		}
+		for _, r := range rules {
+			if sameRule(r, created) {
+				return fakeError("InvalidParameter.FirewallRulesExist", "firewall rule already exists")
+			}
+		}
+		p.account.firewalls[instanceID] = append(rules, created)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("created fake firewall rule", "provider", ProviderFake, "instance_id", instanceID, "protocol", created.Protocol, "port", created.Port, "cidr_block", created.CidrBlock, "action", created.Action)
+	result := *created
+	return &result, nil
+}
+
+// deleteRule 删除第一条满足match的规则，调用方需持有账号锁
+func (p *FakeProvider) deleteRule(instanceID string, match func(*FirewallRuleResult) bool) error {
+	if err := p.firewallInstance(instanceID); err != nil {
+		return err
+	}
+	rules := p.account.firewalls[instanceID]
+	for i, r := range rules {
+		if match(r) {
+			p.account.firewalls[instanceID] = slices.Delete(slices.Clone(rules), i, i+1)
+			return nil
+		}
+	}
+	return fakeError("ResourceNotFound.FirewallRulesNotFound", fmt.Sprintf("firewall rule not found on instance %s", instanceID))
+}
+
+func (p *FakeProvider) DeleteFirewallRule(ctx context.Context, instanceID, ruleID string) error {
+	return p.call(ctx, "DeleteFirewallRule", instanceID, func() error {
+		return p.deleteRule(instanceID, func(r *FirewallRuleResult) bool { return r.RuleID == ruleID })
+	})
+}
+
+func (p *FakeProvider) DeleteFirewallRuleBySpec(ctx context.Context, instanceID string, rule *FirewallRuleResult) error {
+	return p.call(ctx, "DeleteFirewallRule", instanceID, func() error {
+		return p.deleteRule(instanceID, func(r *FirewallRuleResult) bool { return sameRule(r, rule) })
+	})
+}
+
+func (p *FakeProvider) UpdateFirewallRule(ctx context.Context, instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error) {
+	var updated FirewallRuleResult
+	err := p.call(ctx, "UpdateFirewallRule", instanceID, func() error {
+		if err := p.firewallInstance(instanceID); err != nil {
+			return err
+		}
+		for _, r := range p.account.firewalls[instanceID] {
+			if r.RuleID == ruleID || (strings.EqualFold(r.Protocol, ruleSpec.Protocol) && r.Port == ruleSpec.Port && r.Description == ruleSpec.Description) {
+				r.CidrBlock = newIP + "/32"
+				r.RuleID = fakeRuleID(r)
+				updated = *r
+				return nil
+			}
+		}
+		return fakeError("ResourceNotFound.FirewallRulesNotFound", fmt.Sprintf("firewall rule %s not found on instance %s", ruleID, instanceID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (p *FakeProvider) ListFirewallRules(ctx context.Context, instanceID string) ([]*FirewallRuleResult, error) {
+	var rules []*FirewallRuleResult
+	err := p.call(ctx, "ListFirewallRules", instanceID, func() error {
+		if err := p.firewallInstance(instanceID); err != nil {
+			return err
+		}
+		for _, r := range p.account.firewalls[instanceID] {
+			copied := *r
+			rules = append(rules, &copied)
+		}
+		return nil
+	})
+	return rules, err
+}
+
+func (p *FakeProvider) ReorderFirewallRules(ctx context.Context, instanceID string, rules []*FirewallRuleResult) error {
+	return p.call(ctx, "ReorderFirewallRules", instanceID, func() error {
+		if err := p.firewallInstance(instanceID); err != nil {
+			return err
+		}
+		remaining := slices.Clone(p.account.firewalls[instanceID])
+		if len(remaining) != len(rules) {
+			return fakeError("FailedOperation.FirewallVersionMismatch", fmt.Sprintf("firewall rules of instance %s changed", instanceID))
+		}
+		ordered := make([]*FirewallRuleResult, 0, len(rules))
+		for _, want := range rules {
+			i := slices.IndexFunc(remaining, func(r *FirewallRuleResult) bool { return sameRule(r, want) })
+			if i < 0 {
+				return fakeError("FailedOperation.FirewallVersionMismatch", fmt.Sprintf("firewall rules of instance %s changed", instanceID))
+			}
+			ordered = append(ordered, remaining[i])
+			remaining = slices.Delete(remaining, i, i+1)
+		}
+		p.account.firewalls[instanceID] = ordered
+		return nil
+	})
+}
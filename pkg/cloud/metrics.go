@@ -0,0 +1,46 @@
+package cloud
+
+import (
+	stderrors "errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// 云服务API调用指标，注册在Prometheus默认Registry中
+var (
+	apiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fireflow",
+		Subsystem: "cloud",
+		Name:      "api_calls_total",
+		Help:      "Cloud API calls by provider, action and error code (OK on success).",
+	}, []string{"provider", "action", "code"})
+
+	apiCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "fireflow",
+		Subsystem: "cloud",
+		Name:      "api_call_duration_seconds",
+		Help:      "Latency of cloud API calls.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"provider", "action"})
+)
+
+// observeAPICall 记录一次云服务API调用的耗时和结果
+func observeAPICall(provider, action string, start time.Time, err error) {
+	apiCallDuration.WithLabelValues(provider, action).Observe(time.Since(start).Seconds())
+	apiCalls.WithLabelValues(provider, action, errorCode(err)).Inc()
+}
+
+// errorCode 提取云服务返回的错误码，非云服务错误统一为 ClientError
+func errorCode(err error) string {
+	if err == nil {
+		return "OK"
+	}
+	var sdkErr *errors.TencentCloudSDKError
+	if stderrors.As(err, &sdkErr) && sdkErr.GetCode() != "" {
+		return sdkErr.GetCode()
+	}
+	return "ClientError"
+}
@@ -0,0 +1,88 @@
+package cloud
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// 各云服务商API的默认QPS限制（同一账号下同一接口的频率限制）
+var defaultProviderQPS = map[string]float64{
+	"TencentCloud": 10,
+}
+
+// 未知云服务商使用的保守限制
+const fallbackProviderQPS = 5
+
+// limiterKey 云服务商按账号和接口分别限流，一个账号的频繁调用不影响其他账号
+type limiterKey struct {
+	provider string
+	account  string
+	action   string
+}
+
+var (
+	limitersMu    sync.Mutex
+	limiters      = make(map[limiterKey]*rate.Limiter)
+	providerQPS   = make(map[string]rate.Limit)
+	providerBurst = make(map[string]int)
+)
+
+// LimiterFor 返回云服务商账号下某个API接口共享的令牌桶限流器，同一进程内所有客户端共用。
+// account为账号标识（如SecretId），action为接口名（如 "lighthouse:DescribeFirewallRules"）
+func LimiterFor(provider, account, action string) *rate.Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	key := limiterKey{provider: provider, account: account, action: action}
+	if limiter, ok := limiters[key]; ok {
+		return limiter
+	}
+
+	limit, burst := providerLimit(provider)
+	limiter := rate.NewLimiter(limit, burst)
+	limiters[key] = limiter
+	return limiter
+}
+
+// providerLimit 云服务商的QPS限制，调用方需持有锁
+func providerLimit(provider string) (rate.Limit, int) {
+	if limit, ok := providerQPS[provider]; ok {
+		return limit, providerBurst[provider]
+	}
+	qps, ok := defaultProviderQPS[provider]
+	if !ok {
+		qps = fallbackProviderQPS
+	}
+	return rate.Limit(qps), int(qps)
+}
+
+// SetProviderQPS 调整指定云服务商每个账号每个接口的QPS限制，对已创建的限流器同样生效
+func SetProviderQPS(provider string, qps float64, burst int) {
+	if qps <= 0 {
+		return
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	providerQPS[provider] = rate.Limit(qps)
+	providerBurst[provider] = burst
+	for key, limiter := range limiters {
+		if key.provider == provider {
+			limiter.SetLimit(rate.Limit(qps))
+			limiter.SetBurst(burst)
+		}
+	}
+}
+
+// waitLimiter 阻塞直到限流器允许下一次API调用，ctx取消或超时时返回错误
+func waitLimiter(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
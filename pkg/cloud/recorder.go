@@ -0,0 +1,212 @@
+package cloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 录制文件的模式
+const (
+	RecordMode = "record" // 调用真实API，并把请求和响应追加到录制文件
+	ReplayMode = "replay" // 只从录制文件返回响应，不访问网络
+)
+
+// Interaction 一次录制的API调用。请求签名、时间戳等每次都会变化的请求头不录制，
+// 回放时按产品版本、接口、区域和请求体匹配。
+type Interaction struct {
+	Version  string          `json:"version"` // 产品API版本，区分同名接口，如Lighthouse和CVM的DescribeInstances
+	Action   string          `json:"action"`
+	Region   string          `json:"region"`
+	Request  json.RawMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+func (i *Interaction) key() string {
+	return i.Version + "|" + i.Action + "|" + i.Region + "|" + string(i.Request)
+}
+
+// Recorder 录制或回放云服务API调用的HTTP传输，作为 Transport.RoundTripper 使用。
+// 回放时相同的请求按录制顺序依次返回各自的响应，用完后重复返回最后一次的响应，
+// 因此先建后删的更新前后查询规则列表能得到不同的结果。
+type Recorder struct {
+	mode string
+	path string
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecorder 创建录制或回放传输。录制时已有的录制文件会被保留并在其后追加，
+// next为空时使用 http.DefaultTransport；回放时录制文件必须存在。
+func NewRecorder(mode, path string, next http.RoundTripper) (*Recorder, error) {
+	if mode != RecordMode && mode != ReplayMode {
+		return nil, fmt.Errorf("unsupported recorder mode %q, expected %s or %s", mode, RecordMode, ReplayMode)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{mode: mode, path: path, next: next}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &r.interactions); err != nil {
+			return nil, fmt.Errorf("failed to parse recording %s: %v", path, err)
+		}
+		for i := range r.interactions {
+			r.interactions[i].Request = canonicalJSON(r.interactions[i].Request)
+		}
+	case os.IsNotExist(err) && mode == RecordMode:
+	default:
+		return nil, fmt.Errorf("failed to read recording %s: %v", path, err)
+	}
+	r.used = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// Interactions 返回已录制（或已加载）的API调用
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	interaction := Interaction{
+		Version: sdkHeader(req, "X-TC-Version"),
+		Action:  sdkHeader(req, "X-TC-Action"),
+		Region:  sdkHeader(req, "X-TC-Region"),
+		Request: canonicalJSON(body),
+	}
+
+	if r.mode == ReplayMode {
+		return r.replay(req, &interaction)
+	}
+	return r.record(req, body, &interaction)
+}
+
+func (r *Recorder) replay(req *http.Request, interaction *Interaction) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := interaction.key()
+	last := -1
+	for i := range r.interactions {
+		if r.interactions[i].key() != key {
+			continue
+		}
+		last = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("no recorded response for %s %s in region %s with request %s", interaction.Version, interaction.Action, interaction.Region, interaction.Request)
+	}
+	r.used[last] = true
+	recorded := r.interactions[last]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(recorded.Response)),
+		ContentLength: int64(len(recorded.Response)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Recorder) record(req *http.Request, body []byte, interaction *Interaction) (*http.Response, error) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction.Status = resp.StatusCode
+	interaction.Response = respBody
+	if !json.Valid(respBody) {
+		// 非JSON响应（如网关错误页面）按字符串保存
+		interaction.Response, _ = json.Marshal(string(respBody))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, *interaction)
+	r.used = append(r.used, true)
+	// 每次调用后立即写入，服务异常退出时已录制的调用不会丢失
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// save 先写入临时文件再替换录制文件，调用方需持有锁
+func (r *Recorder) save() error {
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to save recording: %v", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save recording: %v", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("failed to save recording: %v", err)
+	}
+	return nil
+}
+
+// sdkHeader 读取请求头。SDK直接写入 http.Header 的map，键名没有规范化
+func sdkHeader(req *http.Request, name string) string {
+	if values := req.Header[name]; len(values) > 0 {
+		return values[0]
+	}
+	return req.Header.Get(name)
+}
+
+// canonicalJSON 重新编码JSON使字段按名称排序，录制和回放的请求体可以直接比较
+func canonicalJSON(data []byte) json.RawMessage {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return json.RawMessage("{}")
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		encoded, _ := json.Marshal(string(data))
+		return encoded
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+	return encoded
+}
@@ -1,487 +1,827 @@
-package cloud
-
-import (
-	"crypto/md5"
-	"fmt"
-	"log"
-	"strings"
-
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
-	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
-	lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
-)
-
-type TencentConfig struct {
-	SecretId   string `json:"secretId"`
-	SecretKey  string `json:"secretKey"`
-	Region     string `json:"region"`
-	InstanceId string `json:"instanceId"` // 实例ID
-}
-
-type TencentClient struct {
-	config           TencentConfig
-	cvmClient        *cvm.Client
-	lighthouseClient *lighthouse.Client
-}
-
-// CloudProvider 接口定义
-type CloudProvider interface {
-	// 获取实例信息
-	GetInstance(instanceID string) (*InstanceInfo, error)
-
-	// 创建防火墙规则
-	CreateFirewallRule(instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error)
-
-	// 删除防火墙规则
-	DeleteFirewallRule(instanceID, ruleID string) error
-
-	// 更新防火墙规则 - 通过规则规格匹配，返回更新后的规则信息
-	UpdateFirewallRule(instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error)
-
-	// 获取防火墙规则列表
-	ListFirewallRules(instanceID string) ([]*FirewallRuleResult, error)
-}
-
-// 实例信息
-type InstanceInfo struct {
-	InstanceID   string `json:"instance_id"`
-	InstanceName string `json:"instance_name"`
-	Status       string `json:"status"`
-	PublicIP     string `json:"public_ip"`
-	PrivateIP    string `json:"private_ip"`
-	Provider     string `json:"provider"`
-	Region       string `json:"region"`
-}
-
-// 防火墙规则规格
-type FirewallRuleSpec struct {
-	Port          string `json:"port"`                      // 端口或端口范围，如 "80" 或 "8000-9000"
-	Protocol      string `json:"protocol"`                  // 协议：TCP/UDP/ICMP
-	CidrBlock     string `json:"cidr_block"`                // CIDR块，如 "0.0.0.0/0"
-	Ipv6CidrBlock string `json:"ipv6_cidr_block,omitempty"` // IPv6 CIDR块，如 "::/0" 和 CIDR块二选一
-	Action        string `json:"action"`                    // 动作：ACCEPT/DROP
-	Description   string `json:"description"`               // 备注
-}
-
-// 防火墙规则结果
-type FirewallRuleResult struct {
-	RuleID      string `json:"rule_id"`
-	Port        string `json:"port"`
-	Protocol    string `json:"protocol"`
-	CidrBlock   string `json:"cidr_block"`
-	Action      string `json:"action"`
-	Description string `json:"description"`
-	Provider    string `json:"provider"`
-	InstanceID  string `json:"instance_id"`
-}
-
-func NewTencentClient(config TencentConfig) (*TencentClient, error) {
-	// 验证配置
-	if config.SecretId == "" || config.SecretKey == "" {
-		return nil, fmt.Errorf("secret_id and secret_key are required")
-	}
-
-	if config.Region == "" {
-		config.Region = "ap-beijing" // 默认北京区域
-	}
-
-	log.Printf("Initializing Tencent Cloud client with SecretId: %s, Region: %s",
-		maskSecretId(config.SecretId), config.Region)
-
-	// 创建认证信息
-	credential := common.NewCredential(config.SecretId, config.SecretKey)
-
-	// 创建客户端配置
-	cpf := profile.NewClientProfile()
-	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
-
-	// 初始化CVM客户端
-	cvmClient, err := cvm.NewClient(credential, config.Region, cpf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create CVM client: %v", err)
-	}
-
-	// 初始化Lighthouse客户端
-	cpfLighthouse := profile.NewClientProfile()
-	cpfLighthouse.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
-	lighthouseClient, err := lighthouse.NewClient(credential, config.Region, cpfLighthouse)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Lighthouse client: %v", err)
-	}
-
-	return &TencentClient{
-		config:           config,
-		cvmClient:        cvmClient,
-		lighthouseClient: lighthouseClient,
-	}, nil
-}
-
-// 实现 CloudProvider 接口
-func (tc *TencentClient) GetInstance(instanceID string) (*InstanceInfo, error) {
-	// 先尝试从CVM获取实例信息
-	if info, err := tc.getCVMInstance(instanceID); err == nil {
-		return info, nil
-	}
-
-	// 如果CVM中没有找到，尝试从Lighthouse获取
-	if info, err := tc.getLighthouseInstance(instanceID); err == nil {
-		return info, nil
-	}
-
-	return nil, fmt.Errorf("instance %s not found in CVM or Lighthouse", instanceID)
-}
-
-func (tc *TencentClient) CreateFirewallRule(instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
-	// 先判断是CVM还是Lighthouse实例
-	if tc.isCVMInstance(instanceID) {
-		return nil, fmt.Errorf("CVM firewall rule management not implemented yet")
-		// return tc.createCVMFirewallRule(instanceID, rule)
-	} else {
-		return tc.createLighthouseFirewallRule(instanceID, rule)
-	}
-}
-
-func (tc *TencentClient) DeleteFirewallRule(instanceID, ruleID string) error {
-	if tc.isCVMInstance(instanceID) {
-		return fmt.Errorf("CVM firewall rule management not implemented yet")
-		// return tc.deleteCVMFirewallRule(instanceID, ruleID)
-	} else {
-		return tc.deleteLighthouseFirewallRule(instanceID, ruleID)
-	}
-}
-
-func (tc *TencentClient) UpdateFirewallRule(instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error) {
-	if tc.isCVMInstance(instanceID) {
-		return nil, fmt.Errorf("CVM firewall rule management not implemented yet")
-		// return tc.updateCVMFirewallRule(instanceID, ruleID, ruleSpec, newIP)
-	} else {
-		return tc.updateLighthouseFirewallRule(instanceID, ruleID, ruleSpec, newIP)
-	}
-}
-
-func (tc *TencentClient) ListFirewallRules(instanceID string) ([]*FirewallRuleResult, error) {
-	if tc.isCVMInstance(instanceID) {
-		return nil, fmt.Errorf("CVM firewall rule management not implemented yet")
-		// return tc.listCVMFirewallRules(instanceID)
-	} else {
-		return tc.listLighthouseFirewallRules(instanceID)
-	}
-}
-
-// CVM 相关实现 - 暂未实现
-func (tc *TencentClient) getCVMInstance(instanceID string) (*InstanceInfo, error) {
-	request := cvm.NewDescribeInstancesRequest()
-	request.InstanceIds = common.StringPtrs([]string{instanceID})
-
-	response, err := tc.cvmClient.DescribeInstances(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to describe CVM instance: %v", err)
-	}
-
-	if len(response.Response.InstanceSet) == 0 {
-		return nil, fmt.Errorf("cVM instance %s not found", instanceID)
-	}
-
-	instance := response.Response.InstanceSet[0]
-	info := &InstanceInfo{
-		InstanceID:   *instance.InstanceId,
-		InstanceName: *instance.InstanceName,
-		Status:       *instance.InstanceState,
-		Provider:     "TencentCloud",
-		Region:       tc.config.Region,
-	}
-
-	if len(instance.PublicIpAddresses) > 0 {
-		info.PublicIP = *instance.PublicIpAddresses[0]
-	}
-	if len(instance.PrivateIpAddresses) > 0 {
-		info.PrivateIP = *instance.PrivateIpAddresses[0]
-	}
-
-	return info, nil
-}
-
-// CVM防火墙规则相关方法暂未实现，使用安全组管理
-/*
-func (tc *TencentClient) createCVMFirewallRule(instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
-	// CVM 使用安全组来管理防火墙规则
-	// 这里需要先获取实例的安全组，然后添加规则
-	// 为了简化，这里返回一个模拟的结果
-	log.Printf("Creating CVM firewall rule for instance %s: %+v", instanceID, rule)
-
-	result := &FirewallRuleResult{
-		RuleID:      fmt.Sprintf("cvm-rule-%s-%s", instanceID, rule.Port),
-		Port:        rule.Port,
-		Protocol:    rule.Protocol,
-		CidrBlock:   rule.CidrBlock,
-		Action:      rule.Action,
-		Description: rule.Description,
-		Provider:    "TencentCloud",
-		InstanceID:  instanceID,
-	}
-
-	return result, nil
-}
-
-func (tc *TencentClient) deleteCVMFirewallRule(instanceID, ruleID string) error {
-	log.Printf("Deleting CVM firewall rule %s for instance %s", ruleID, instanceID)
-	return nil
-}
-
-func (tc *TencentClient) updateCVMFirewallRule(instanceID, ruleID string, newIP string) error {
-	log.Printf("Updating CVM firewall rule %s for instance %s with new IP %s", ruleID, instanceID, newIP)
-	return nil
-}
-
-func (tc *TencentClient) listCVMFirewallRules(instanceID string) ([]*FirewallRuleResult, error) {
-	log.Printf("Listing CVM firewall rules for instance %s", instanceID)
-	return []*FirewallRuleResult{}, nil
-}
-*/
-
-// Lighthouse 相关实现
-func (tc *TencentClient) getLighthouseInstance(instanceID string) (*InstanceInfo, error) {
-	request := lighthouse.NewDescribeInstancesRequest()
-	request.InstanceIds = common.StringPtrs([]string{instanceID})
-
-	response, err := tc.lighthouseClient.DescribeInstances(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to describe Lighthouse instance: %v", err)
-	}
-
-	if len(response.Response.InstanceSet) == 0 {
-		return nil, fmt.Errorf("lighthouse instance %s not found", instanceID)
-	}
-
-	instance := response.Response.InstanceSet[0]
-	info := &InstanceInfo{
-		InstanceID:   *instance.InstanceId,
-		InstanceName: *instance.InstanceName,
-		Status:       *instance.InstanceState,
-		Provider:     "TencentCloud",
-		Region:       tc.config.Region,
-	}
-
-	if len(instance.PublicAddresses) > 0 {
-		info.PublicIP = *instance.PublicAddresses[0]
-	}
-	if len(instance.PrivateAddresses) > 0 {
-		info.PrivateIP = *instance.PrivateAddresses[0]
-	}
-
-	return info, nil
-}
-
-func (tc *TencentClient) createLighthouseFirewallRule(instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
-	request := lighthouse.NewCreateFirewallRulesRequest()
-	request.InstanceId = common.StringPtr(instanceID)
-
-	// 构建防火墙规则
-	firewallRule := &lighthouse.FirewallRule{
-		Protocol:                common.StringPtr(strings.ToUpper(rule.Protocol)),
-		Port:                    common.StringPtr(rule.Port),
-		CidrBlock:               common.StringPtr(rule.CidrBlock),
-		Action:                  common.StringPtr(strings.ToUpper(rule.Action)),
-		FirewallRuleDescription: common.StringPtr(rule.Description),
-	}
-
-	request.FirewallRules = []*lighthouse.FirewallRule{firewallRule}
-
-	_, err := tc.lighthouseClient.CreateFirewallRules(request)
-	if err != nil {
-		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
-			return nil, fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
-				sdkError.Code, sdkError.Message)
-		}
-		return nil, fmt.Errorf("failed to create Lighthouse firewall rule: %v", err)
-	}
-
-	// 使用与列表规则相同的哈希算法生成规则ID
-	ruleContent := fmt.Sprintf("%s-%s-%s-%s",
-		strings.ToUpper(rule.Protocol),
-		rule.Port,
-		rule.CidrBlock,
-		strings.ToUpper(rule.Action))
-	ruleID := fmt.Sprintf("lh-%x", md5.Sum([]byte(ruleContent)))
-
-	result := &FirewallRuleResult{
-		RuleID:      ruleID,
-		Port:        rule.Port,
-		Protocol:    rule.Protocol,
-		CidrBlock:   rule.CidrBlock,
-		Action:      rule.Action,
-		Description: rule.Description,
-		Provider:    "TencentCloud",
-		InstanceID:  instanceID,
-	}
-
-	log.Printf("Created Lighthouse firewall rule: %+v", result)
-	return result, nil
-}
-
-func (tc *TencentClient) deleteLighthouseFirewallRule(instanceID, ruleID string) error {
-	request := lighthouse.NewDeleteFirewallRulesRequest()
-	request.InstanceId = common.StringPtr(instanceID)
-	request.FirewallRules = []*lighthouse.FirewallRule{
-		{
-			// 这里需要根据实际的规则ID来删除，可能需要先查询规则详情
-		},
-	}
-
-	_, err := tc.lighthouseClient.DeleteFirewallRules(request)
-	if err != nil {
-		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
-			return fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
-				sdkError.Code, sdkError.Message)
-		}
-		return fmt.Errorf("failed to delete Lighthouse firewall rule: %v", err)
-	}
-
-	log.Printf("Deleted Lighthouse firewall rule %s for instance %s", ruleID, instanceID)
-	return nil
-}
-
-// 根据规则规格删除防火墙规则
-func (tc *TencentClient) deleteLighthouseFirewallRuleBySpec(instanceID string, rule *FirewallRuleResult) error {
-	request := lighthouse.NewDeleteFirewallRulesRequest()
-	request.InstanceId = common.StringPtr(instanceID)
-
-	// 构建要删除的规则
-	firewallRule := &lighthouse.FirewallRule{
-		Protocol:                common.StringPtr(rule.Protocol),
-		Port:                    common.StringPtr(rule.Port),
-		CidrBlock:               common.StringPtr(rule.CidrBlock),
-		Action:                  common.StringPtr(rule.Action),
-		FirewallRuleDescription: common.StringPtr(rule.Description),
-	}
-
-	request.FirewallRules = []*lighthouse.FirewallRule{firewallRule}
-
-	_, err := tc.lighthouseClient.DeleteFirewallRules(request)
-	if err != nil {
-		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
-			return fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
-				sdkError.Code, sdkError.Message)
-		}
-		return fmt.Errorf("failed to delete Lighthouse firewall rule: %v", err)
-	}
-
-	log.Printf("Deleted Lighthouse firewall rule (proto:%s, port:%s, cidr:%s) for instance %s",
-		rule.Protocol, rule.Port, rule.CidrBlock, instanceID)
-	return nil
-}
-
-func (tc *TencentClient) updateLighthouseFirewallRule(instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error) {
-	log.Printf("Updating Lighthouse firewall rule for instance %s with new IP %s", instanceID, newIP)
-	log.Printf("Rule spec: Protocol=%s, Port=%s, Description=%s", ruleSpec.Protocol, ruleSpec.Port, ruleSpec.Description)
-
-	// 获取所有现有规则
-	rules, err := tc.listLighthouseFirewallRules(instanceID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list existing rules: %v", err)
-	}
-
-	// 通过备注、协议、端口匹配规则，而不是依赖RuleID
-	var targetRule *FirewallRuleResult
-	for _, rule := range rules {
-		if rule.Protocol == strings.ToUpper(ruleSpec.Protocol) &&
-			rule.Port == ruleSpec.Port &&
-			rule.Description == ruleSpec.Description {
-			targetRule = rule
-			log.Printf("Found matching rule by spec: RuleID=%s, CidrBlock=%s", rule.RuleID, rule.CidrBlock)
-			break
-		}
-	}
-
-	if targetRule == nil {
-		log.Printf("No matching rule found for Protocol=%s, Port=%s, Description=%s",
-			ruleSpec.Protocol, ruleSpec.Port, ruleSpec.Description)
-		return nil, fmt.Errorf("rule not found with protocol=%s, port=%s, description=%s",
-			ruleSpec.Protocol, ruleSpec.Port, ruleSpec.Description)
-	}
-
-	// 构建新的CIDR块
-	newCidrBlock := fmt.Sprintf("%s/32", newIP) // 如果IP已经是最新的，就不需要更新
-	if targetRule.CidrBlock == newCidrBlock {
-		log.Printf("Rule %s already has the correct IP %s", ruleID, newIP)
-		return targetRule, nil
-	}
-
-	// 删除旧规则并创建新规则（Lighthouse不支持直接更新）
-	// 首先创建新规则
-	newRuleSpec := &FirewallRuleSpec{
-		Protocol:    targetRule.Protocol,
-		Port:        targetRule.Port,
-		CidrBlock:   newCidrBlock,
-		Action:      targetRule.Action,
-		Description: targetRule.Description,
-	}
-
-	newRule, err := tc.createLighthouseFirewallRule(instanceID, newRuleSpec)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new rule: %v", err)
-	}
-
-	// 删除旧规则
-	err = tc.deleteLighthouseFirewallRuleBySpec(instanceID, targetRule)
-	if err != nil {
-		log.Printf("Warning: Created new rule but failed to delete old rule: %v", err)
-		// 不返回错误，因为新规则已经创建成功
-	}
-
-	log.Printf("Successfully updated Lighthouse firewall rule for instance %s", instanceID)
-	return newRule, nil
-}
-
-func (tc *TencentClient) listLighthouseFirewallRules(instanceID string) ([]*FirewallRuleResult, error) {
-	request := lighthouse.NewDescribeFirewallRulesRequest()
-	request.InstanceId = common.StringPtr(instanceID)
-
-	response, err := tc.lighthouseClient.DescribeFirewallRules(request)
-	if err != nil {
-		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
-			return nil, fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
-				sdkError.Code, sdkError.Message)
-		}
-		return nil, fmt.Errorf("failed to list Lighthouse firewall rules: %v", err)
-	}
-
-	var results []*FirewallRuleResult
-	for _, rule := range response.Response.FirewallRuleSet {
-		// 使用规则内容生成稳定的ID
-		ruleContent := fmt.Sprintf("%s-%s-%s-%s", *rule.Protocol, *rule.Port, *rule.CidrBlock, *rule.Action)
-		ruleID := fmt.Sprintf("lh-%x", md5.Sum([]byte(ruleContent)))
-
-		result := &FirewallRuleResult{
-			RuleID:      ruleID,
-			Port:        *rule.Port,
-			Protocol:    *rule.Protocol,
-			CidrBlock:   *rule.CidrBlock,
-			Action:      *rule.Action,
-			Description: *rule.FirewallRuleDescription,
-			Provider:    "TencentCloud",
-			InstanceID:  instanceID,
-		}
-		results = append(results, result)
-	}
-
-	return results, nil
-}
-
-// 工具函数
-func (tc *TencentClient) isCVMInstance(instanceID string) bool {
-	// 根据实例ID格式判断是否为CVM实例
-	// CVM实例ID通常以 "ins-" 开头
-	// Lighthouse实例ID通常以 "lhins-" 开头
-	return strings.HasPrefix(instanceID, "ins-")
-}
-
-// 掩码SecretId用于日志输出
-func maskSecretId(secretId string) string {
-	if len(secretId) <= 8 {
-		return "****"
-	}
-	return secretId[:4] + "****" + secretId[len(secretId)-4:]
-}
+package cloud
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
+)
+
+type TencentConfig struct {
+	SecretId   string `json:"secretId"`
+	SecretKey  string `json:"secretKey"`
+	Region     string `json:"region"`
+	InstanceId string `json:"instanceId"` // 实例ID
+
+	// Transport 替换API地址和HTTP传输，为空时使用 SetDefaultTransport 设置的传输层
+	Transport *Transport `json:"-"`
+}
+
+type TencentClient struct {
+	config           TencentConfig
+	cvmClient        *cvm.Client
+	lighthouseClient *lighthouse.Client
+}
+
+// CloudProvider 接口定义。
+// 所有方法都接收ctx，ctx取消时正在进行的API调用会中止；每次API调用另有独立的超时（见 SetCallTimeout）。
+type CloudProvider interface {
+	// 获取实例信息
+	GetInstance(ctx context.Context, instanceID string) (*InstanceInfo, error)
+
+	// 列出账号在当前区域下可管理防火墙规则的实例（含标签），用于按标签选择实例
+	ListInstances(ctx context.Context) ([]*InstanceInfo, error)
+
+	// 列出账号在当前区域下的所有实例，包括暂不支持管理防火墙规则的实例，用于浏览实例清单。
+	// 部分产品查询失败时仍返回已查询到的实例，同时返回错误。
+	ListAllInstances(ctx context.Context) ([]*InstanceInfo, error)
+
+	// 列出账号可用的区域，用于跨区域发现实例
+	ListRegions(ctx context.Context) ([]string, error)
+
+	// 创建防火墙规则
+	CreateFirewallRule(ctx context.Context, instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error)
+
+	// 删除防火墙规则
+	DeleteFirewallRule(ctx context.Context, instanceID, ruleID string) error
+
+	// 根据已查询到的规则内容删除防火墙规则，避免再次查询规则列表
+	DeleteFirewallRuleBySpec(ctx context.Context, instanceID string, rule *FirewallRuleResult) error
+
+	// 更新防火墙规则 - 通过规则规格匹配，返回更新后的规则信息
+	UpdateFirewallRule(ctx context.Context, instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error)
+
+	// 获取防火墙规则列表，按云端的匹配顺序返回
+	ListFirewallRules(ctx context.Context, instanceID string) ([]*FirewallRuleResult, error)
+
+	// 按rules的顺序重排实例的防火墙规则，靠前的规则先匹配。rules必须是 ListFirewallRules
+	// 返回的全部规则，只改变顺序；云端规则在此期间发生变化时返回错误，不做修改
+	ReorderFirewallRules(ctx context.Context, instanceID string, rules []*FirewallRuleResult) error
+}
+
+// 实例所属的产品
+const (
+	ProductLighthouse = "lighthouse" // 轻量应用服务器
+	ProductCVM        = "cvm"        // 云服务器，防火墙规则暂未实现
+)
+
+// 实例信息
+type InstanceInfo struct {
+	InstanceID        string            `json:"instance_id"`
+	InstanceName      string            `json:"instance_name"`
+	Status            string            `json:"status"`
+	PublicIP          string            `json:"public_ip"`
+	PrivateIP         string            `json:"private_ip"`
+	Provider          string            `json:"provider"`
+	Product           string            `json:"product"`
+	Region            string            `json:"region"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	FirewallSupported bool              `json:"firewall_supported"` // 是否可以通过 FireFlow 管理防火墙规则
+}
+
+// 防火墙规则规格
+type FirewallRuleSpec struct {
+	Port          string `json:"port"`                      // 端口或端口范围，如 "80" 或 "8000-9000"
+	Protocol      string `json:"protocol"`                  // 协议：TCP/UDP/ICMP
+	CidrBlock     string `json:"cidr_block"`                // CIDR块，如 "0.0.0.0/0"
+	Ipv6CidrBlock string `json:"ipv6_cidr_block,omitempty"` // IPv6 CIDR块，如 "::/0" 和 CIDR块二选一
+	Action        string `json:"action"`                    // 动作：ACCEPT/DROP
+	Description   string `json:"description"`               // 备注
+}
+
+// 防火墙规则结果
+type FirewallRuleResult struct {
+	RuleID      string `json:"rule_id"`
+	Port        string `json:"port"`
+	Protocol    string `json:"protocol"`
+	CidrBlock   string `json:"cidr_block"`
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	Provider    string `json:"provider"`
+	InstanceID  string `json:"instance_id"`
+}
+
+func NewTencentClient(config TencentConfig) (*TencentClient, error) {
+	// 验证配置
+	if config.SecretId == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("secret_id and secret_key are required")
+	}
+
+	if config.Region == "" {
+		config.Region = "ap-beijing" // 默认北京区域
+	}
+
+	slog.Debug("initializing Tencent Cloud client", "provider", "TencentCloud", "secret_id", maskSecretId(config.SecretId), "region", config.Region)
+
+	// 创建认证信息
+	credential := common.NewCredential(config.SecretId, config.SecretKey)
+
+	transport := transportFor(config.Transport)
+
+	// 初始化CVM客户端
+	cvmClient, err := cvm.NewClient(credential, config.Region, transport.clientProfile("cvm.tencentcloudapi.com"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CVM client: %v", err)
+	}
+
+	// 初始化Lighthouse客户端
+	lighthouseClient, err := lighthouse.NewClient(credential, config.Region, transport.clientProfile("lighthouse.tencentcloudapi.com"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Lighthouse client: %v", err)
+	}
+
+	if transport.RoundTripper != nil {
+		cvmClient.WithHttpTransport(transport.RoundTripper)
+		lighthouseClient.WithHttpTransport(transport.RoundTripper)
+	}
+
+	return &TencentClient{
+		config:           config,
+		cvmClient:        cvmClient,
+		lighthouseClient: lighthouseClient,
+	}, nil
+}
+
+// 实现 CloudProvider 接口
+func (tc *TencentClient) GetInstance(ctx context.Context, instanceID string) (*InstanceInfo, error) {
+	// 先尝试从CVM获取实例信息
+	if info, err := tc.getCVMInstance(ctx, instanceID); err == nil {
+		return info, nil
+	}
+
+	// 如果CVM中没有找到，尝试从Lighthouse获取
+	if info, err := tc.getLighthouseInstance(ctx, instanceID); err == nil {
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("instance %s not found in CVM or Lighthouse", instanceID)
+}
+
+func (tc *TencentClient) CreateFirewallRule(ctx context.Context, instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
+	// 先判断是CVM还是Lighthouse实例
+	if tc.isCVMInstance(instanceID) {
+		return nil, fmt.Errorf("CVM firewall rule management not implemented yet")
+		// return tc.createCVMFirewallRule(instanceID, rule)
+	} else {
+		return tc.createLighthouseFirewallRule(ctx, instanceID, rule)
+	}
+}
+
+func (tc *TencentClient) DeleteFirewallRule(ctx context.Context, instanceID, ruleID string) error {
+	if tc.isCVMInstance(instanceID) {
+		return fmt.Errorf("CVM firewall rule management not implemented yet")
+		// return tc.deleteCVMFirewallRule(instanceID, ruleID)
+	} else {
+		return tc.deleteLighthouseFirewallRule(ctx, instanceID, ruleID)
+	}
+}
+
+func (tc *TencentClient) DeleteFirewallRuleBySpec(ctx context.Context, instanceID string, rule *FirewallRuleResult) error {
+	if tc.isCVMInstance(instanceID) {
+		return fmt.Errorf("CVM firewall rule management not implemented yet")
+	}
+	return tc.deleteLighthouseFirewallRuleBySpec(ctx, instanceID, rule)
+}
+
+func (tc *TencentClient) UpdateFirewallRule(ctx context.Context, instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error) {
+	if tc.isCVMInstance(instanceID) {
+		return nil, fmt.Errorf("CVM firewall rule management not implemented yet")
+		// return tc.updateCVMFirewallRule(instanceID, ruleID, ruleSpec, newIP)
+	} else {
+		return tc.updateLighthouseFirewallRule(ctx, instanceID, ruleID, ruleSpec, newIP)
+	}
+}
+
+func (tc *TencentClient) ListFirewallRules(ctx context.Context, instanceID string) ([]*FirewallRuleResult, error) {
+	if tc.isCVMInstance(instanceID) {
+		return nil, fmt.Errorf("CVM firewall rule management not implemented yet")
+		// return tc.listCVMFirewallRules(instanceID)
+	} else {
+		return tc.listLighthouseFirewallRules(ctx, instanceID)
+	}
+}
+
+func (tc *TencentClient) ReorderFirewallRules(ctx context.Context, instanceID string, rules []*FirewallRuleResult) error {
+	if tc.isCVMInstance(instanceID) {
+		return fmt.Errorf("CVM firewall rule management not implemented yet")
+	}
+	return tc.reorderLighthouseFirewallRules(ctx, instanceID, rules)
+}
+
+// CVM 相关实现 - 暂未实现
+func (tc *TencentClient) getCVMInstance(ctx context.Context, instanceID string) (*InstanceInfo, error) {
+	request := cvm.NewDescribeInstancesRequest()
+	request.InstanceIds = common.StringPtrs([]string{instanceID})
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "cvm:DescribeInstances"); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := tc.cvmClient.DescribeInstancesWithContext(callCtx, request)
+	observeAPICall("TencentCloud", "cvm:DescribeInstances", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe CVM instance: %v", err)
+	}
+
+	if len(response.Response.InstanceSet) == 0 {
+		return nil, fmt.Errorf("cVM instance %s not found", instanceID)
+	}
+
+	return tc.cvmInstanceInfo(response.Response.InstanceSet[0]), nil
+}
+
+// 每次查询CVM实例列表的最大数量
+const cvmInstancePageSize = 100
+
+// listCVMInstances 分页列出CVM实例
+func (tc *TencentClient) listCVMInstances(ctx context.Context) ([]*InstanceInfo, error) {
+	var instances []*InstanceInfo
+	for offset := int64(0); ; offset += cvmInstancePageSize {
+		request := cvm.NewDescribeInstancesRequest()
+		request.Offset = common.Int64Ptr(offset)
+		request.Limit = common.Int64Ptr(cvmInstancePageSize)
+
+		callCtx, cancel := callContext(ctx)
+		if err := tc.wait(callCtx, "cvm:DescribeInstances"); err != nil {
+			cancel()
+			return nil, err
+		}
+		start := time.Now()
+		response, err := tc.cvmClient.DescribeInstancesWithContext(callCtx, request)
+		observeAPICall("TencentCloud", "cvm:DescribeInstances", start, err)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list CVM instances: %v", err)
+		}
+
+		for _, instance := range response.Response.InstanceSet {
+			instances = append(instances, tc.cvmInstanceInfo(instance))
+		}
+		total := int64(len(instances))
+		if response.Response.TotalCount != nil {
+			total = *response.Response.TotalCount
+		}
+		if len(response.Response.InstanceSet) == 0 || int64(len(instances)) >= total {
+			return instances, nil
+		}
+	}
+}
+
+func (tc *TencentClient) cvmInstanceInfo(instance *cvm.Instance) *InstanceInfo {
+	info := &InstanceInfo{
+		InstanceID:   *instance.InstanceId,
+		InstanceName: *instance.InstanceName,
+		Status:       *instance.InstanceState,
+		Provider:     "TencentCloud",
+		Product:      ProductCVM,
+		Region:       tc.config.Region,
+	}
+
+	if len(instance.PublicIpAddresses) > 0 {
+		info.PublicIP = *instance.PublicIpAddresses[0]
+	}
+	if len(instance.PrivateIpAddresses) > 0 {
+		info.PrivateIP = *instance.PrivateIpAddresses[0]
+	}
+	if len(instance.Tags) > 0 {
+		info.Tags = make(map[string]string, len(instance.Tags))
+		for _, tag := range instance.Tags {
+			if tag.Key != nil && tag.Value != nil {
+				info.Tags[*tag.Key] = *tag.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// CVM防火墙规则相关方法暂未实现，使用安全组管理
+/*
+func (tc *TencentClient) createCVMFirewallRule(instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
+	// CVM 使用安全组来管理防火墙规则
+	// 这里需要先获取实例的安全组，然后添加规则
+	// 为了简化，这里返回一个模拟的结果
+	log.Printf("Creating CVM firewall rule for instance %s: %+v", instanceID, rule)
+
+	result := &FirewallRuleResult{
+		RuleID:      fmt.Sprintf("cvm-rule-%s-%s", instanceID, rule.Port),
+		Port:        rule.Port,
+		Protocol:    rule.Protocol,
+		CidrBlock:   rule.CidrBlock,
+		Action:      rule.Action,
+		Description: rule.Description,
+		Provider:    "TencentCloud",
+		InstanceID:  instanceID,
+	}
+
+	return result, nil
+}
+
+func (tc *TencentClient) deleteCVMFirewallRule(instanceID, ruleID string) error {
+	log.Printf("Deleting CVM firewall rule %s for instance %s", ruleID, instanceID)
+	return nil
+}
+
+func (tc *TencentClient) updateCVMFirewallRule(instanceID, ruleID string, newIP string) error {
+	log.Printf("Updating CVM firewall rule %s for instance %s with new IP %s", ruleID, instanceID, newIP)
+	return nil
+}
+
+func (tc *TencentClient) listCVMFirewallRules(instanceID string) ([]*FirewallRuleResult, error) {
+	log.Printf("Listing CVM firewall rules for instance %s", instanceID)
+	return []*FirewallRuleResult{}, nil
+}
+*/
+
+// Lighthouse 相关实现
+func (tc *TencentClient) getLighthouseInstance(ctx context.Context, instanceID string) (*InstanceInfo, error) {
+	request := lighthouse.NewDescribeInstancesRequest()
+	request.InstanceIds = common.StringPtrs([]string{instanceID})
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "lighthouse:DescribeInstances"); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := tc.lighthouseClient.DescribeInstancesWithContext(callCtx, request)
+	observeAPICall("TencentCloud", "lighthouse:DescribeInstances", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe Lighthouse instance: %v", err)
+	}
+
+	if len(response.Response.InstanceSet) == 0 {
+		return nil, fmt.Errorf("lighthouse instance %s not found", instanceID)
+	}
+
+	return tc.lighthouseInstanceInfo(response.Response.InstanceSet[0]), nil
+}
+
+// 每次查询Lighthouse实例列表的最大数量
+const lighthouseInstancePageSize = 100
+
+// ListInstances 分页列出Lighthouse实例。CVM实例的防火墙规则暂未实现，不在列表中
+func (tc *TencentClient) ListInstances(ctx context.Context) ([]*InstanceInfo, error) {
+	var instances []*InstanceInfo
+	for offset := int64(0); ; offset += lighthouseInstancePageSize {
+		request := lighthouse.NewDescribeInstancesRequest()
+		request.Offset = common.Int64Ptr(offset)
+		request.Limit = common.Int64Ptr(lighthouseInstancePageSize)
+
+		callCtx, cancel := callContext(ctx)
+		if err := tc.wait(callCtx, "lighthouse:DescribeInstances"); err != nil {
+			cancel()
+			return nil, err
+		}
+		start := time.Now()
+		response, err := tc.lighthouseClient.DescribeInstancesWithContext(callCtx, request)
+		observeAPICall("TencentCloud", "lighthouse:DescribeInstances", start, err)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Lighthouse instances: %v", err)
+		}
+
+		for _, instance := range response.Response.InstanceSet {
+			instances = append(instances, tc.lighthouseInstanceInfo(instance))
+		}
+		total := int64(len(instances))
+		if response.Response.TotalCount != nil {
+			total = *response.Response.TotalCount
+		}
+		if len(response.Response.InstanceSet) == 0 || int64(len(instances)) >= total {
+			return instances, nil
+		}
+	}
+}
+
+// ListAllInstances 列出Lighthouse和CVM实例，其中一种查询失败时返回另一种的结果和错误
+func (tc *TencentClient) ListAllInstances(ctx context.Context) ([]*InstanceInfo, error) {
+	instances, lighthouseErr := tc.ListInstances(ctx)
+	cvmInstances, cvmErr := tc.listCVMInstances(ctx)
+	instances = append(instances, cvmInstances...)
+	switch {
+	case lighthouseErr != nil && cvmErr != nil:
+		return instances, fmt.Errorf("%v; %v", lighthouseErr, cvmErr)
+	case lighthouseErr != nil:
+		return instances, lighthouseErr
+	default:
+		return instances, cvmErr
+	}
+}
+
+// ListRegions 列出Lighthouse或CVM可用的区域
+func (tc *TencentClient) ListRegions(ctx context.Context) ([]string, error) {
+	var regions []string
+	seen := make(map[string]bool)
+	add := func(region, state *string) {
+		if region == nil || seen[*region] {
+			return
+		}
+		if state != nil && *state != "AVAILABLE" {
+			return
+		}
+		seen[*region] = true
+		regions = append(regions, *region)
+	}
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "lighthouse:DescribeRegions"); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	lighthouseRegions, err := tc.lighthouseClient.DescribeRegionsWithContext(callCtx, lighthouse.NewDescribeRegionsRequest())
+	observeAPICall("TencentCloud", "lighthouse:DescribeRegions", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Lighthouse regions: %v", err)
+	}
+	for _, region := range lighthouseRegions.Response.RegionSet {
+		add(region.Region, region.RegionState)
+	}
+
+	if err := tc.wait(callCtx, "cvm:DescribeRegions"); err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	cvmRegions, err := tc.cvmClient.DescribeRegionsWithContext(callCtx, cvm.NewDescribeRegionsRequest())
+	observeAPICall("TencentCloud", "cvm:DescribeRegions", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CVM regions: %v", err)
+	}
+	for _, region := range cvmRegions.Response.RegionSet {
+		add(region.Region, region.RegionState)
+	}
+
+	sort.Strings(regions)
+	return regions, nil
+}
+
+func (tc *TencentClient) lighthouseInstanceInfo(instance *lighthouse.Instance) *InstanceInfo {
+	info := &InstanceInfo{
+		InstanceID:        *instance.InstanceId,
+		InstanceName:      *instance.InstanceName,
+		Status:            *instance.InstanceState,
+		Provider:          "TencentCloud",
+		Product:           ProductLighthouse,
+		Region:            tc.config.Region,
+		FirewallSupported: true,
+	}
+
+	if len(instance.PublicAddresses) > 0 {
+		info.PublicIP = *instance.PublicAddresses[0]
+	}
+	if len(instance.PrivateAddresses) > 0 {
+		info.PrivateIP = *instance.PrivateAddresses[0]
+	}
+	if len(instance.Tags) > 0 {
+		info.Tags = make(map[string]string, len(instance.Tags))
+		for _, tag := range instance.Tags {
+			if tag.Key != nil && tag.Value != nil {
+				info.Tags[*tag.Key] = *tag.Value
+			}
+		}
+	}
+
+	return info
+}
+
+func (tc *TencentClient) createLighthouseFirewallRule(ctx context.Context, instanceID string, rule *FirewallRuleSpec) (*FirewallRuleResult, error) {
+	request := lighthouse.NewCreateFirewallRulesRequest()
+	request.InstanceId = common.StringPtr(instanceID)
+
+	// 构建防火墙规则
+	firewallRule := &lighthouse.FirewallRule{
+		Protocol:                common.StringPtr(strings.ToUpper(rule.Protocol)),
+		Port:                    common.StringPtr(rule.Port),
+		CidrBlock:               common.StringPtr(rule.CidrBlock),
+		Action:                  common.StringPtr(strings.ToUpper(rule.Action)),
+		FirewallRuleDescription: common.StringPtr(rule.Description),
+	}
+
+	request.FirewallRules = []*lighthouse.FirewallRule{firewallRule}
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "lighthouse:CreateFirewallRules"); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	_, err := tc.lighthouseClient.CreateFirewallRulesWithContext(callCtx, request)
+	observeAPICall("TencentCloud", "lighthouse:CreateFirewallRules", start, err)
+	if err != nil {
+		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
+			return nil, fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
+				sdkError.Code, sdkError.Message)
+		}
+		return nil, fmt.Errorf("failed to create Lighthouse firewall rule: %v", err)
+	}
+
+	// 使用与列表规则相同的哈希算法生成规则ID
+	ruleContent := fmt.Sprintf("%s-%s-%s-%s",
+		strings.ToUpper(rule.Protocol),
+		rule.Port,
+		rule.CidrBlock,
+		strings.ToUpper(rule.Action))
+	ruleID := fmt.Sprintf("lh-%x", md5.Sum([]byte(ruleContent)))
+
+	result := &FirewallRuleResult{
+		RuleID:      ruleID,
+		Port:        rule.Port,
+		Protocol:    rule.Protocol,
+		CidrBlock:   rule.CidrBlock,
+		Action:      rule.Action,
+		Description: rule.Description,
+		Provider:    "TencentCloud",
+		InstanceID:  instanceID,
+	}
+
+	slog.Info("created Lighthouse firewall rule",
+		"provider", "TencentCloud",
+		"instance_id", instanceID,
+		"cloud_rule_id", ruleID,
+		"protocol", rule.Protocol,
+		"port", rule.Port,
+		"cidr_block", rule.CidrBlock)
+	return result, nil
+}
+
+func (tc *TencentClient) deleteLighthouseFirewallRule(ctx context.Context, instanceID, ruleID string) error {
+	// Lighthouse的规则没有ID，需要先查询规则列表找到对应的规则内容再删除
+	rules, err := tc.listLighthouseFirewallRules(ctx, instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to list existing rules: %v", err)
+	}
+
+	for _, rule := range rules {
+		if rule.RuleID == ruleID {
+			return tc.deleteLighthouseFirewallRuleBySpec(ctx, instanceID, rule)
+		}
+	}
+
+	return fmt.Errorf("rule %s not found on instance %s", ruleID, instanceID)
+}
+
+// 根据规则规格删除防火墙规则
+func (tc *TencentClient) deleteLighthouseFirewallRuleBySpec(ctx context.Context, instanceID string, rule *FirewallRuleResult) error {
+	request := lighthouse.NewDeleteFirewallRulesRequest()
+	request.InstanceId = common.StringPtr(instanceID)
+
+	// 构建要删除的规则
+	firewallRule := &lighthouse.FirewallRule{
+		Protocol:                common.StringPtr(rule.Protocol),
+		Port:                    common.StringPtr(rule.Port),
+		CidrBlock:               common.StringPtr(rule.CidrBlock),
+		Action:                  common.StringPtr(rule.Action),
+		FirewallRuleDescription: common.StringPtr(rule.Description),
+	}
+
+	request.FirewallRules = []*lighthouse.FirewallRule{firewallRule}
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "lighthouse:DeleteFirewallRules"); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err := tc.lighthouseClient.DeleteFirewallRulesWithContext(callCtx, request)
+	observeAPICall("TencentCloud", "lighthouse:DeleteFirewallRules", start, err)
+	if err != nil {
+		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
+			return fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
+				sdkError.Code, sdkError.Message)
+		}
+		return fmt.Errorf("failed to delete Lighthouse firewall rule: %v", err)
+	}
+
+	slog.Info("deleted Lighthouse firewall rule",
+		"provider", "TencentCloud",
+		"instance_id", instanceID,
+		"cloud_rule_id", rule.RuleID,
+		"protocol", rule.Protocol,
+		"port", rule.Port,
+		"cidr_block", rule.CidrBlock)
+	return nil
+}
+
+func (tc *TencentClient) updateLighthouseFirewallRule(ctx context.Context, instanceID, ruleID string, ruleSpec *FirewallRuleSpec, newIP string) (*FirewallRuleResult, error) {
+	logger := slog.With("provider", "TencentCloud", "instance_id", instanceID, "protocol", ruleSpec.Protocol, "port", ruleSpec.Port)
+	logger.Debug("updating Lighthouse firewall rule", "new_ip", newIP, "description", ruleSpec.Description)
+
+	// 获取所有现有规则
+	rules, err := tc.listLighthouseFirewallRules(ctx, instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list existing rules: %v", err)
+	}
+
+	// 通过备注、协议、端口匹配规则，而不是依赖RuleID
+	var targetRule *FirewallRuleResult
+	for _, rule := range rules {
+		if strings.EqualFold(rule.Protocol, ruleSpec.Protocol) &&
+			rule.Port == ruleSpec.Port &&
+			rule.Description == ruleSpec.Description {
+			targetRule = rule
+			logger.Debug("found matching rule by spec", "cloud_rule_id", rule.RuleID, "cidr_block", rule.CidrBlock)
+			break
+		}
+	}
+
+	if targetRule == nil {
+		logger.Warn("no matching rule found", "description", ruleSpec.Description)
+		return nil, fmt.Errorf("rule not found with protocol=%s, port=%s, description=%s",
+			ruleSpec.Protocol, ruleSpec.Port, ruleSpec.Description)
+	}
+
+	// 构建新的CIDR块
+	newCidrBlock := fmt.Sprintf("%s/32", newIP) // 如果IP已经是最新的，就不需要更新
+	if targetRule.CidrBlock == newCidrBlock {
+		logger.Debug("rule already has the correct IP", "cloud_rule_id", ruleID, "new_ip", newIP)
+		return targetRule, nil
+	}
+
+	// 删除旧规则并创建新规则（Lighthouse不支持直接更新）
+	// 首先创建新规则
+	newRuleSpec := &FirewallRuleSpec{
+		Protocol:    targetRule.Protocol,
+		Port:        targetRule.Port,
+		CidrBlock:   newCidrBlock,
+		Action:      targetRule.Action,
+		Description: targetRule.Description,
+	}
+
+	newRule, err := tc.createLighthouseFirewallRule(ctx, instanceID, newRuleSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new rule: %v", err)
+	}
+
+	// 删除旧规则
+	err = tc.deleteLighthouseFirewallRuleBySpec(ctx, instanceID, targetRule)
+	if err != nil {
+		logger.Warn("created new rule but failed to delete old rule", "error", err)
+		// 不返回错误，因为新规则已经创建成功
+	}
+
+	logger.Info("updated Lighthouse firewall rule", "cloud_rule_id", newRule.RuleID, "cidr_block", newCidrBlock)
+	return newRule, nil
+}
+
+func (tc *TencentClient) listLighthouseFirewallRules(ctx context.Context, instanceID string) ([]*FirewallRuleResult, error) {
+	response, err := tc.describeLighthouseFirewallRules(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*FirewallRuleResult
+	for _, rule := range response.FirewallRuleSet {
+		results = append(results, lighthouseRuleResult(instanceID, rule))
+	}
+
+	return results, nil
+}
+
+// describeLighthouseFirewallRules 查询实例的全部防火墙规则和防火墙版本。
+// 每个实例最多100条规则，一次查询即可返回全部规则
+func (tc *TencentClient) describeLighthouseFirewallRules(ctx context.Context, instanceID string) (*lighthouse.DescribeFirewallRulesResponseParams, error) {
+	request := lighthouse.NewDescribeFirewallRulesRequest()
+	request.InstanceId = common.StringPtr(instanceID)
+	request.Limit = common.Int64Ptr(100)
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "lighthouse:DescribeFirewallRules"); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := tc.lighthouseClient.DescribeFirewallRulesWithContext(callCtx, request)
+	observeAPICall("TencentCloud", "lighthouse:DescribeFirewallRules", start, err)
+	if err != nil {
+		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
+			return nil, fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
+				sdkError.Code, sdkError.Message)
+		}
+		return nil, fmt.Errorf("failed to list Lighthouse firewall rules: %v", err)
+	}
+	return response.Response, nil
+}
+
+// lighthouseRuleResult 转换云端规则，使用规则内容生成稳定的ID
+func lighthouseRuleResult(instanceID string, rule *lighthouse.FirewallRuleInfo) *FirewallRuleResult {
+	ruleContent := fmt.Sprintf("%s-%s-%s-%s", *rule.Protocol, *rule.Port, *rule.CidrBlock, *rule.Action)
+	return &FirewallRuleResult{
+		RuleID:      fmt.Sprintf("lh-%x", md5.Sum([]byte(ruleContent))),
+		Port:        *rule.Port,
+		Protocol:    *rule.Protocol,
+		CidrBlock:   *rule.CidrBlock,
+		Action:      *rule.Action,
+		Description: *rule.FirewallRuleDescription,
+		Provider:    "TencentCloud",
+		InstanceID:  instanceID,
+	}
+}
+
+// reorderLighthouseFirewallRules 使用ModifyFirewallRules按给定顺序重置实例的全部规则。
+// 重置的规则取自云端当前的规则（保留IPv6来源等未在FirewallRuleResult中体现的字段），
+// 并带上查询到的防火墙版本，规则在查询后被其他人修改时云端拒绝本次重置。
+func (tc *TencentClient) reorderLighthouseFirewallRules(ctx context.Context, instanceID string, rules []*FirewallRuleResult) error {
+	response, err := tc.describeLighthouseFirewallRules(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+
+	// 同一内容的规则可能有多条，按出现顺序依次取用
+	key := func(r *FirewallRuleResult) string {
+		return strings.Join([]string{strings.ToUpper(r.Protocol), r.Port, r.CidrBlock, strings.ToUpper(r.Action), r.Description}, "|")
+	}
+	current := make(map[string][]*lighthouse.FirewallRuleInfo)
+	for _, rule := range response.FirewallRuleSet {
+		k := key(lighthouseRuleResult(instanceID, rule))
+		current[k] = append(current[k], rule)
+	}
+	if len(rules) != len(response.FirewallRuleSet) {
+		return fmt.Errorf("firewall rules of instance %s changed, expected %d rules but found %d", instanceID, len(rules), len(response.FirewallRuleSet))
+	}
+
+	ordered := make([]*lighthouse.FirewallRule, 0, len(rules))
+	for _, r := range rules {
+		k := key(r)
+		if len(current[k]) == 0 {
+			return fmt.Errorf("firewall rules of instance %s changed, rule %s %s from %s not found", instanceID, r.Protocol, r.Port, r.CidrBlock)
+		}
+		info := current[k][0]
+		current[k] = current[k][1:]
+		ordered = append(ordered, &lighthouse.FirewallRule{
+			Protocol:                info.Protocol,
+			Port:                    info.Port,
+			CidrBlock:               info.CidrBlock,
+			Ipv6CidrBlock:           info.Ipv6CidrBlock,
+			Action:                  info.Action,
+			FirewallRuleDescription: info.FirewallRuleDescription,
+		})
+	}
+
+	request := lighthouse.NewModifyFirewallRulesRequest()
+	request.InstanceId = common.StringPtr(instanceID)
+	request.FirewallRules = ordered
+	request.FirewallVersion = response.FirewallVersion
+
+	callCtx, cancel := callContext(ctx)
+	defer cancel()
+	if err := tc.wait(callCtx, "lighthouse:ModifyFirewallRules"); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err = tc.lighthouseClient.ModifyFirewallRulesWithContext(callCtx, request)
+	observeAPICall("TencentCloud", "lighthouse:ModifyFirewallRules", start, err)
+	if err != nil {
+		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
+			return fmt.Errorf("TencentCloud API Error: Code=%s, Message=%s",
+				sdkError.Code, sdkError.Message)
+		}
+		return fmt.Errorf("failed to reorder Lighthouse firewall rules: %v", err)
+	}
+
+	slog.Info("reordered Lighthouse firewall rules",
+		"provider", "TencentCloud",
+		"instance_id", instanceID,
+		"rules", len(ordered))
+	return nil
+}
+
+// wait 等待账号下该接口的限流器，action与API调用指标中的接口名相同
+func (tc *TencentClient) wait(ctx context.Context, action string) error {
+	return waitLimiter(ctx, LimiterFor("TencentCloud", tc.config.SecretId, action))
+}
+
+// 工具函数
+func (tc *TencentClient) isCVMInstance(instanceID string) bool {
+	return IsCVMInstance(instanceID)
+}
+
+// IsCVMInstance 根据实例ID格式判断是否为腾讯云CVM实例。
+// CVM实例ID通常以 "ins-" 开头，Lighthouse实例ID通常以 "lhins-" 开头
+func IsCVMInstance(instanceID string) bool {
+	return strings.HasPrefix(instanceID, "ins-")
+}
+
+// 掩码SecretId用于日志输出
+func maskSecretId(secretId string) string {
+	if len(secretId) <= 8 {
+		return "****"
+	}
+	return secretId[:4] + "****" + secretId[len(secretId)-4:]
+}
@@ -0,0 +1,96 @@
+package cloud
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// newReplayClient 创建从录制文件回放请求的腾讯云客户端
+func newReplayClient(t *testing.T, fixture string) *TencentClient {
+	t.Helper()
+	rec, err := NewRecorder(ReplayMode, fixture, nil)
+	if err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+	client, err := NewTencentClient(TencentConfig{
+		SecretId:  "AKIDtest",
+		SecretKey: "test",
+		Region:    "ap-guangzhou",
+		Transport: &Transport{RoundTripper: rec},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+func ruleKeys(rules []*FirewallRuleResult) []string {
+	keys := make([]string, len(rules))
+	for i, r := range rules {
+		keys[i] = r.Protocol + "/" + r.Port + "/" + r.CidrBlock + "/" + r.Action + "/" + r.Description
+	}
+	return keys
+}
+
+func TestTencentLighthouseReplay(t *testing.T) {
+	const instanceID = "lhins-replay"
+	client := newReplayClient(t, "testdata/lighthouse_firewall_rules.json")
+	ctx := context.Background()
+
+	consoleRule := "TCP/22/0.0.0.0/0/ACCEPT/console ssh"
+	httpsRule := "TCP/443/198.51.100.7/32/ACCEPT/fireflow https"
+	spec := &FirewallRuleSpec{Protocol: "TCP", Port: "443", CidrBlock: "198.51.100.7/32", Action: "ACCEPT", Description: "fireflow https"}
+
+	list := func(want ...string) []*FirewallRuleResult {
+		t.Helper()
+		rules, err := client.ListFirewallRules(ctx, instanceID)
+		if err != nil {
+			t.Fatalf("ListFirewallRules: %v", err)
+		}
+		if got := strings.Join(ruleKeys(rules), ", "); got != strings.Join(want, ", ") {
+			t.Fatalf("ListFirewallRules = [%s], want [%s]", got, strings.Join(want, ", "))
+		}
+		for _, r := range rules {
+			if r.InstanceID != instanceID || !strings.HasPrefix(r.RuleID, "lh-") {
+				t.Fatalf("unexpected rule identity %+v", r)
+			}
+		}
+		return rules
+	}
+
+	list(consoleRule)
+
+	created, err := client.CreateFirewallRule(ctx, instanceID, spec)
+	if err != nil {
+		t.Fatalf("CreateFirewallRule: %v", err)
+	}
+	if created.Port != "443" || created.CidrBlock != "198.51.100.7/32" || created.Action != "ACCEPT" {
+		t.Fatalf("CreateFirewallRule = %+v", created)
+	}
+
+	// 列出的规则ID与创建时返回的规则ID一致，同步时才能按ID找到规则
+	rules := list(consoleRule, httpsRule)
+	if rules[1].RuleID != created.RuleID {
+		t.Fatalf("listed rule ID %s, created rule ID %s", rules[1].RuleID, created.RuleID)
+	}
+
+	if err := client.DeleteFirewallRule(ctx, instanceID, created.RuleID); err != nil {
+		t.Fatalf("DeleteFirewallRule: %v", err)
+	}
+	list(consoleRule)
+
+	_, err = client.CreateFirewallRule(ctx, instanceID, &FirewallRuleSpec{Protocol: "TCP", Port: "8080", CidrBlock: "198.51.100.7/32", Action: "ACCEPT", Description: "fireflow web"})
+	if err == nil || !strings.Contains(err.Error(), "LimitExceeded.FirewallRulesLimitExceeded") {
+		t.Fatalf("CreateFirewallRule error = %v, want the recorded limit error", err)
+	}
+}
+
+func TestTencentReplayUnknownRequest(t *testing.T) {
+	client := newReplayClient(t, "testdata/lighthouse_firewall_rules.json")
+
+	// 录制文件中没有的请求直接失败，不会访问真实的云API
+	if _, err := client.ListFirewallRules(context.Background(), "lhins-unknown"); err == nil {
+		t.Fatal("ListFirewallRules succeeded for a request that is not in the fixture")
+	}
+}
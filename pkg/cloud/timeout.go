@@ -0,0 +1,29 @@
+package cloud
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCallTimeout 单次云服务API调用（含限流等待）的默认超时
+const DefaultCallTimeout = 30 * time.Second
+
+var callTimeout atomic.Int64
+
+func init() {
+	callTimeout.Store(int64(DefaultCallTimeout))
+}
+
+// SetCallTimeout 调整单次云服务API调用的超时，小于等于0时忽略
+func SetCallTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	callTimeout.Store(int64(timeout))
+}
+
+// callContext 为单次API调用派生带超时的context，调用方取消时API调用随之中止
+func callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(callTimeout.Load()))
+}
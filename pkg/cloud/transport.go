@@ -0,0 +1,51 @@
+package cloud
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+)
+
+// Transport 云服务API的传输层：替换API地址和发送请求的HTTP传输，
+// 用于指向本地模拟服务，或通过 Recorder 录制、回放API调用
+type Transport struct {
+	// Endpoint 替换所有产品的API地址（如 "127.0.0.1:8080"），为空时使用各产品的默认地址
+	Endpoint string
+	// Scheme 请求协议 HTTP 或 HTTPS，为空时为 HTTPS
+	Scheme string
+	// RoundTripper 发送请求的HTTP传输，为空时使用SDK默认的传输
+	RoundTripper http.RoundTripper
+}
+
+var defaultTransport atomic.Pointer[Transport]
+
+// SetDefaultTransport 设置之后创建的云服务客户端默认使用的传输层，TencentConfig.Transport 优先
+func SetDefaultTransport(transport Transport) {
+	defaultTransport.Store(&transport)
+}
+
+// transportFor 客户端使用的传输层，未配置时返回零值（SDK默认行为）
+func transportFor(transport *Transport) Transport {
+	if transport != nil {
+		return *transport
+	}
+	if t := defaultTransport.Load(); t != nil {
+		return *t
+	}
+	return Transport{}
+}
+
+// clientProfile 产品的SDK客户端配置，endpoint为产品的默认API地址
+func (t Transport) clientProfile(endpoint string) *profile.ClientProfile {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = endpoint
+	if t.Endpoint != "" {
+		cpf.HttpProfile.Endpoint = t.Endpoint
+	}
+	if t.Scheme != "" {
+		cpf.HttpProfile.Scheme = strings.ToUpper(t.Scheme)
+	}
+	return cpf
+}
@@ -0,0 +1,274 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhook 通用Webhook，以JSON格式POST完整的通知内容
+type webhook struct {
+	config *Config
+}
+
+func newWebhook(config *Config) (Notifier, error) {
+	if err := required("url", config.URL); err != nil {
+		return nil, err
+	}
+	return &webhook{config: config}, nil
+}
+
+func (w *webhook) Send(msg *Message) error {
+	target := w.config.URL
+	if w.config.BaseURL != "" {
+		// 只替换协议和主机部分，保留原地址的路径和查询参数
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		target = baseURL(w.config, "") + parsed.RequestURI()
+	}
+	return postJSON(target, w.config.Headers, msg, nil)
+}
+
+// telegram Telegram Bot sendMessage
+type telegram struct {
+	config *Config
+}
+
+func newTelegram(config *Config) (Notifier, error) {
+	if err := required("bot_token", config.BotToken); err != nil {
+		return nil, err
+	}
+	if err := required("chat_id", config.ChatID); err != nil {
+		return nil, err
+	}
+	return &telegram{config: config}, nil
+}
+
+func (t *telegram) Send(msg *Message) error {
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", baseURL(t.config, "https://api.telegram.org"), t.config.BotToken)
+	payload := map[string]any{
+		"chat_id": t.config.ChatID,
+		"text":    msg.Title + "\n\n" + msg.Body,
+	}
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := postJSON(endpoint, nil, payload, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram error: %s", result.Description)
+	}
+	return nil
+}
+
+// dingTalk 钉钉自定义机器人，配置secret时使用加签
+type dingTalk struct {
+	config *Config
+}
+
+func newDingTalk(config *Config) (Notifier, error) {
+	if err := required("access_token", config.AccessToken); err != nil {
+		return nil, err
+	}
+	return &dingTalk{config: config}, nil
+}
+
+func (d *dingTalk) Send(msg *Message) error {
+	query := url.Values{"access_token": {d.config.AccessToken}}
+	if d.config.Secret != "" {
+		timestamp := fmt.Sprint(time.Now().UnixMilli())
+		query.Set("timestamp", timestamp)
+		query.Set("sign", hmacSign(d.config.Secret, timestamp+"\n"+d.config.Secret))
+	}
+	endpoint := baseURL(d.config, "https://oapi.dingtalk.com") + "/robot/send?" + query.Encode()
+
+	payload := map[string]any{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": msg.Title,
+			"text":  "### " + msg.Title + "\n\n" + msg.Body,
+		},
+	}
+	return postWithErrCode(endpoint, payload)
+}
+
+// weCom 企业微信群机器人
+type weCom struct {
+	config *Config
+}
+
+func newWeCom(config *Config) (Notifier, error) {
+	if err := required("key", config.Key); err != nil {
+		return nil, err
+	}
+	return &weCom{config: config}, nil
+}
+
+func (w *weCom) Send(msg *Message) error {
+	endpoint := baseURL(w.config, "https://qyapi.weixin.qq.com") + "/cgi-bin/webhook/send?key=" + url.QueryEscape(w.config.Key)
+	payload := map[string]any{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"content": "### " + msg.Title + "\n" + msg.Body,
+		},
+	}
+	return postWithErrCode(endpoint, payload)
+}
+
+// postWithErrCode 发送请求并检查钉钉、企业微信返回的errcode
+func postWithErrCode(endpoint string, payload any) error {
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := postJSON(endpoint, nil, payload, &result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("errcode %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
+
+// feishu 飞书自定义机器人，配置secret时使用签名校验
+type feishu struct {
+	config *Config
+}
+
+func newFeishu(config *Config) (Notifier, error) {
+	if err := required("token", config.Token); err != nil {
+		return nil, err
+	}
+	return &feishu{config: config}, nil
+}
+
+func (f *feishu) Send(msg *Message) error {
+	endpoint := baseURL(f.config, "https://open.feishu.cn") + "/open-apis/bot/v2/hook/" + f.config.Token
+	payload := map[string]any{
+		"msg_type": "text",
+		"content": map[string]string{
+			"text": msg.Title + "\n" + msg.Body,
+		},
+	}
+	if f.config.Secret != "" {
+		timestamp := fmt.Sprint(time.Now().Unix())
+		payload["timestamp"] = timestamp
+		payload["sign"] = hmacSign(timestamp+"\n"+f.config.Secret, "")
+	}
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := postJSON(endpoint, nil, payload, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("feishu error %d: %s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// slack Slack Incoming Webhook，token为webhook地址 /services/ 之后的部分
+type slack struct {
+	config *Config
+}
+
+func newSlack(config *Config) (Notifier, error) {
+	if err := required("token", config.Token); err != nil {
+		return nil, err
+	}
+	return &slack{config: config}, nil
+}
+
+func (s *slack) Send(msg *Message) error {
+	endpoint := baseURL(s.config, "https://hooks.slack.com") + "/services/" + strings.TrimPrefix(s.config.Token, "/")
+	payload := map[string]string{
+		"text": "*" + msg.Title + "*\n" + msg.Body,
+	}
+	return postJSON(endpoint, nil, payload, nil)
+}
+
+// ntfy 发布到ntfy主题，配置token时使用Bearer认证
+type ntfy struct {
+	config *Config
+}
+
+func newNtfy(config *Config) (Notifier, error) {
+	if err := required("topic", config.Topic); err != nil {
+		return nil, err
+	}
+	return &ntfy{config: config}, nil
+}
+
+func (n *ntfy) Send(msg *Message) error {
+	endpoint := baseURL(n.config, "https://ntfy.sh") + "/" + url.PathEscape(n.config.Topic)
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	// HTTP头不支持非ASCII字符，标题使用RFC 2047编码
+	req.Header.Set("Title", "=?UTF-8?B?"+base64.StdEncoding.EncodeToString([]byte(msg.Title))+"?=")
+	req.Header.Set("Tags", msg.Event)
+	if n.config.Priority != "" {
+		req.Header.Set("Priority", n.config.Priority)
+	}
+	if n.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.config.Token)
+	}
+	return do(req, nil)
+}
+
+// bark Bark iOS推送
+type bark struct {
+	config *Config
+}
+
+func newBark(config *Config) (Notifier, error) {
+	if err := required("device_key", config.DeviceKey); err != nil {
+		return nil, err
+	}
+	return &bark{config: config}, nil
+}
+
+func (b *bark) Send(msg *Message) error {
+	group := b.config.Group
+	if group == "" {
+		group = "FireFlow"
+	}
+	payload := map[string]string{
+		"device_key": b.config.DeviceKey,
+		"title":      msg.Title,
+		"body":       msg.Body,
+		"group":      group,
+	}
+
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := postJSON(baseURL(b.config, "https://api.day.app")+"/push", nil, payload, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 && result.Code != http.StatusOK {
+		return fmt.Errorf("bark error %d: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// hmacSign 钉钉、飞书机器人签名：HmacSHA256后Base64编码
+func hmacSign(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
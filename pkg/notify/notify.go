@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 支持的通知渠道类型
+const (
+	TypeWebhook  = "webhook"
+	TypeSMTP     = "smtp"
+	TypeTelegram = "telegram"
+	TypeDingTalk = "dingtalk"
+	TypeWeCom    = "wecom"
+	TypeFeishu   = "feishu"
+	TypeSlack    = "slack"
+	TypeNtfy     = "ntfy"
+	TypeBark     = "bark"
+)
+
+// Types 所有支持的渠道类型
+var Types = []string{TypeWebhook, TypeSMTP, TypeTelegram, TypeDingTalk, TypeWeCom, TypeFeishu, TypeSlack, TypeNtfy, TypeBark}
+
+// SecretFields 渠道配置中的敏感字段，API返回时需要掩码
+var SecretFields = []string{"password", "bot_token", "access_token", "secret", "key", "token", "device_key"}
+
+// 发送请求的默认超时时间
+const defaultTimeout = 10 * time.Second
+
+// Message 待发送的通知
+type Message struct {
+	Event string         `json:"event"`
+	Title string         `json:"title"`
+	Body  string         `json:"message"`
+	Time  time.Time      `json:"time"`
+	Data  map[string]any `json:"data,omitempty"`
+}
+
+// Notifier 通知渠道接口
+type Notifier interface {
+	Send(msg *Message) error
+}
+
+// Config 渠道配置，各渠道只使用与其相关的字段。
+// HTTP类渠道的 BaseURL 可覆盖默认服务地址（如自建ntfy/Bark服务，或测试时指向本地服务器）。
+type Config struct {
+	BaseURL string `json:"base_url,omitempty"`
+
+	// webhook
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// smtp
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	TLS      string   `json:"tls,omitempty"` // starttls（默认）、ssl、none
+
+	// telegram
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+
+	// dingtalk / feishu 加签密钥
+	AccessToken string `json:"access_token,omitempty"`
+	Secret      string `json:"secret,omitempty"`
+
+	// wecom 机器人key，feishu/slack 为webhook地址中的令牌部分
+	Key   string `json:"key,omitempty"`
+	Token string `json:"token,omitempty"`
+
+	// ntfy
+	Topic    string `json:"topic,omitempty"`
+	Priority string `json:"priority,omitempty"`
+
+	// bark
+	DeviceKey string `json:"device_key,omitempty"`
+	Group     string `json:"group,omitempty"`
+}
+
+// ParseConfig 解析JSON格式的渠道配置
+func ParseConfig(data string) (*Config, error) {
+	var config Config
+	if strings.TrimSpace(data) == "" {
+		return &config, nil
+	}
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return nil, fmt.Errorf("invalid channel config: %v", err)
+	}
+	return &config, nil
+}
+
+// New 根据渠道类型创建通知渠道，并校验必填配置
+func New(channelType string, config *Config) (Notifier, error) {
+	switch channelType {
+	case TypeWebhook:
+		return newWebhook(config)
+	case TypeSMTP:
+		return newSMTP(config)
+	case TypeTelegram:
+		return newTelegram(config)
+	case TypeDingTalk:
+		return newDingTalk(config)
+	case TypeWeCom:
+		return newWeCom(config)
+	case TypeFeishu:
+		return newFeishu(config)
+	case TypeSlack:
+		return newSlack(config)
+	case TypeNtfy:
+		return newNtfy(config)
+	case TypeBark:
+		return newBark(config)
+	default:
+		return nil, fmt.Errorf("unsupported notification channel type: %s", channelType)
+	}
+}
+
+// 发送通知使用的HTTP客户端
+var httpClient = &http.Client{Timeout: defaultTimeout}
+
+// baseURL 返回覆盖后的服务地址，未配置时使用默认地址
+func baseURL(config *Config, defaultURL string) string {
+	if config.BaseURL != "" {
+		return strings.TrimRight(config.BaseURL, "/")
+	}
+	return defaultURL
+}
+
+// postJSON 发送JSON请求，非2xx响应返回错误，响应体写入result（可为nil）
+func postJSON(url string, headers map[string]string, payload any, result any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return do(req, result)
+}
+
+// do 执行请求并检查响应状态码
+func do(req *http.Request, result any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+	}
+	return nil
+}
+
+func required(name, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// base_url 把Webhook请求发到本地服务器，保留原地址的路径和查询参数
+func TestWebhookSendsToBaseURL(t *testing.T) {
+	type received struct {
+		path, query, header, contentType string
+		msg                              Message
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := received{
+			path:        r.URL.Path,
+			query:       r.URL.RawQuery,
+			header:      r.Header.Get("X-FireFlow-Token"),
+			contentType: r.Header.Get("Content-Type"),
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got.msg); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		requests <- got
+	}))
+	defer server.Close()
+
+	config, err := ParseConfig(`{"url": "https://hooks.example.com/fireflow?channel=ops", "headers": {"X-FireFlow-Token": "abc"}, "base_url": "` + server.URL + `/"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := New(TypeWebhook, config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	sent := &Message{
+		Event: "rule_failed",
+		Title: "规则更新失败",
+		Body:  "rule 1 failed",
+		Time:  time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+		Data:  map[string]any{"rule_id": float64(1)},
+	}
+	if err := notifier.Send(sent); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := <-requests
+	if got.path != "/fireflow" || got.query != "channel=ops" {
+		t.Errorf("webhook request to %s?%s, want /fireflow?channel=ops", got.path, got.query)
+	}
+	if got.header != "abc" || got.contentType != "application/json" {
+		t.Errorf("webhook headers: token %q, content type %q", got.header, got.contentType)
+	}
+	if got.msg.Event != sent.Event || got.msg.Title != sent.Title || got.msg.Body != sent.Body ||
+		!got.msg.Time.Equal(sent.Time) || got.msg.Data["rule_id"] != float64(1) {
+		t.Errorf("webhook body = %+v, want %+v", got.msg, *sent)
+	}
+}
+
+func TestWebhookReportsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "hook disabled", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	notifier, err := New(TypeWebhook, &Config{URL: "https://hooks.example.com/fireflow", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	err = notifier.Send(&Message{Event: "test", Title: "test", Body: "test", Time: time.Now()})
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "hook disabled") {
+		t.Fatalf("Send error = %v, want status 503 with response body", err)
+	}
+}
@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtpMailer 通过SMTP发送邮件通知
+type smtpMailer struct {
+	config *Config
+}
+
+func newSMTP(config *Config) (Notifier, error) {
+	if err := required("host", config.Host); err != nil {
+		return nil, err
+	}
+	if err := required("from", config.From); err != nil {
+		return nil, err
+	}
+	if len(config.To) == 0 {
+		return nil, fmt.Errorf("to is required")
+	}
+	switch config.TLS {
+	case "", "starttls", "ssl", "none":
+	default:
+		return nil, fmt.Errorf("tls must be one of starttls, ssl, none")
+	}
+	return &smtpMailer{config: config}, nil
+}
+
+func (m *smtpMailer) Send(msg *Message) error {
+	port := m.config.Port
+	if port == 0 {
+		port = 587
+		if m.config.TLS == "ssl" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(m.config.Host, fmt.Sprint(port))
+
+	client, err := m.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.config.TLS == "" || m.config.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+				return err
+			}
+		} else if m.config.TLS == "starttls" {
+			return fmt.Errorf("smtp server does not support STARTTLS")
+		}
+	}
+
+	if m.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.config.From); err != nil {
+		return err
+	}
+	for _, to := range m.config.To {
+		if err := client.Rcpt(strings.TrimSpace(to)); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(m.buildMessage(msg)); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial 建立SMTP连接，ssl模式下直接使用TLS连接
+func (m *smtpMailer) dial(addr string) (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: defaultTimeout}
+	var conn net.Conn
+	var err error
+	if m.config.TLS == "ssl" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: m.config.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(defaultTimeout * 3))
+	return smtp.NewClient(conn, m.config.Host)
+}
+
+func (m *smtpMailer) buildMessage(msg *Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + m.config.From + "\r\n")
+	b.WriteString("To: " + strings.Join(m.config.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Title) + "\r\n")
+	b.WriteString("Date: " + msg.Time.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,215 @@
+// Package probe 检查规则更新后端口是否真的可以从本机访问
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// 支持的探测类型
+const (
+	TypeTCP  = "tcp"  // 建立TCP连接
+	TypeHTTP = "http" // 发送HTTP GET，5xx以外的响应视为可访问
+	TypeICMP = "icmp" // 发送ICMP Echo，需要系统允许非特权ping或以root运行
+)
+
+// Types 所有支持的探测类型
+var Types = []string{TypeTCP, TypeHTTP, TypeICMP}
+
+// 单次探测的默认超时时间
+const DefaultTimeout = 5 * time.Second
+
+// 多次尝试之间的间隔，等待云端防火墙规则生效
+const retryInterval = 2 * time.Second
+
+// ErrICMPNotPermitted 当前环境不允许发送ICMP
+var ErrICMPNotPermitted = errors.New("icmp probes are not permitted on this host")
+
+// Target 探测目标
+type Target struct {
+	Type string
+	Host string
+	Port string // TCP/HTTP 端口
+	Path string // HTTP 路径，默认 /
+}
+
+// Result 探测结果
+type Result struct {
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"-"`
+	LatencyMs int64         `json:"latency_ms"`
+	Attempts  int           `json:"attempts"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Prober 执行探测。Dial 可替换为自定义拨号函数，测试时可将所有连接指向本地监听地址。
+type Prober struct {
+	Timeout  time.Duration
+	Attempts int
+	Dial     func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// New 创建探测器，attempts为失败时的最多尝试次数
+func New(timeout time.Duration, attempts int) *Prober {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if attempts <= 0 {
+		attempts = 1
+	}
+	return &Prober{
+		Timeout:  timeout,
+		Attempts: attempts,
+		Dial:     (&net.Dialer{}).DialContext,
+	}
+}
+
+// Probe 探测目标，失败时间隔一段时间重试，直到成功、用完尝试次数或ctx结束
+func (p *Prober) Probe(ctx context.Context, target Target) Result {
+	var result Result
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := p.probeOnce(ctx, target)
+		latency := time.Since(start)
+		result = Result{
+			Healthy:   err == nil,
+			Latency:   latency,
+			LatencyMs: latency.Milliseconds(),
+			Attempts:  attempt,
+			CheckedAt: time.Now(),
+		}
+		if err == nil {
+			return result
+		}
+		result.Error = err.Error()
+		if attempt >= p.Attempts || errors.Is(err, ErrICMPNotPermitted) {
+			return result
+		}
+
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (p *Prober) probeOnce(ctx context.Context, target Target) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	switch target.Type {
+	case TypeTCP:
+		conn, err := p.Dial(ctx, "tcp", net.JoinHostPort(target.Host, target.Port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case TypeHTTP:
+		return p.probeHTTP(ctx, target)
+	case TypeICMP:
+		return probeICMP(ctx, target.Host)
+	default:
+		return fmt.Errorf("unsupported probe type: %s", target.Type)
+	}
+}
+
+func (p *Prober) probeHTTP(ctx context.Context, target Target) error {
+	path := target.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := "http://" + net.JoinHostPort(target.Host, target.Port) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: p.Dial, DisableKeepAlives: true},
+		// 重定向目标可能不在防火墙规则范围内，只检查首个响应
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// probeICMP 发送一次ICMP Echo。优先使用非特权ping套接字，不可用时尝试原始套接字
+func probeICMP(ctx context.Context, host string) error {
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return fmt.Errorf("icmp probes require an IPv4 address, got %q", host)
+	}
+
+	network, address := "udp4", "0.0.0.0"
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		network = "ip4:icmp"
+		dst = &net.IPAddr{IP: ip}
+		conn, err = icmp.ListenPacket(network, address)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return ErrICMPNotPermitted
+		}
+		return fmt.Errorf("failed to open icmp socket: %v", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	id := os.Getpid() & 0xffff
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("fireflow")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(data, dst); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reply, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil {
+			continue
+		}
+		// 非特权套接字由内核改写ID，只能按类型和序号判断
+		if echo, ok := reply.Body.(*icmp.Echo); ok && reply.Type == ipv4.ICMPTypeEchoReply && echo.Seq == 1 {
+			if network == "udp4" || echo.ID == id {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redirectDial 将所有连接指向本地地址，并记录请求的目标地址
+type redirectDial struct {
+	local string
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *redirectDial) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, address)
+	d.mu.Unlock()
+	return (&net.Dialer{}).DialContext(ctx, network, d.local)
+}
+
+func TestTCPProbeFollowsListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dial := &redirectDial{local: listener.Addr().String()}
+	prober := New(time.Second, 1)
+	prober.Dial = dial.dial
+	target := Target{Type: TypeTCP, Host: "203.0.113.10", Port: "22"}
+
+	result := prober.Probe(context.Background(), target)
+	if !result.Healthy || result.Error != "" || result.Attempts != 1 {
+		t.Fatalf("probe with listener = %+v, want healthy after 1 attempt", result)
+	}
+	if len(dial.dialed) != 1 || dial.dialed[0] != "203.0.113.10:22" {
+		t.Errorf("dialed %v, want [203.0.113.10:22]", dial.dialed)
+	}
+
+	// 端口不再可访问
+	listener.Close()
+	result = prober.Probe(context.Background(), target)
+	if result.Healthy || result.Error == "" {
+		t.Fatalf("probe after listener closed = %+v, want unhealthy with an error", result)
+	}
+}
+
+func TestHTTPProbeStatus(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusNotFound
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path = r.URL.Path
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	prober := New(time.Second, 1)
+	prober.Dial = (&redirectDial{local: server.Listener.Addr().String()}).dial
+	target := Target{Type: TypeHTTP, Host: "203.0.113.10", Port: "8080", Path: "healthz"}
+
+	// 5xx以外的响应都说明端口可以访问
+	if result := prober.Probe(context.Background(), target); !result.Healthy {
+		t.Fatalf("probe with status 404 = %+v, want healthy", result)
+	}
+	mu.Lock()
+	if path != "/healthz" {
+		t.Errorf("probe requested %q, want /healthz", path)
+	}
+	status = http.StatusBadGateway
+	mu.Unlock()
+
+	if result := prober.Probe(context.Background(), target); result.Healthy || result.Error == "" {
+		t.Fatalf("probe with status 502 = %+v, want unhealthy", result)
+	}
+}